@@ -8,6 +8,7 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"sort"
 	"sync/atomic"
 	"time"
 
@@ -23,6 +24,7 @@ import (
 	"github.com/CodisLabs/codis/pkg/models"
 	"github.com/CodisLabs/codis/pkg/utils"
 	"github.com/CodisLabs/codis/pkg/utils/errors"
+	"github.com/CodisLabs/codis/pkg/utils/histogram"
 	"github.com/CodisLabs/codis/pkg/utils/log"
 )
 
@@ -127,6 +129,123 @@ func getAllProxyDebugVars() map[string]map[string]interface{} {
 	return ret
 }
 
+// 汇总所有proxy的延迟分布，按命令和redis-server分别合并之后重新计算百分位数
+func getAllProxyLatency() map[string]map[string]*histogram.Snapshot {
+	vars := getAllProxyDebugVars()
+	if vars == nil {
+		return nil
+	}
+
+	ret := map[string]map[string]*histogram.Snapshot{
+		"cmds":     make(map[string]*histogram.Snapshot),
+		"backends": make(map[string]*histogram.Snapshot),
+	}
+	merge := func(m map[string]*histogram.Snapshot, key string, s *histogram.Snapshot) {
+		if s == nil {
+			return
+		}
+		if m[key] == nil {
+			m[key] = &histogram.Snapshot{}
+		}
+		m[key].Merge(s)
+	}
+	for id, m := range vars {
+		var router struct {
+			Cmds []struct {
+				Cmd     string              `json:"cmd"`
+				Latency *histogram.Snapshot `json:"latency"`
+			} `json:"cmds"`
+			Backends []struct {
+				Addr    string              `json:"addr"`
+				Latency *histogram.Snapshot `json:"latency"`
+			} `json:"backends"`
+		}
+		b, _ := json.Marshal(m["router"])
+		if err := json.Unmarshal(b, &router); err != nil {
+			log.WarnErrorf(err, "parse latency of proxy %s failed", id)
+			continue
+		}
+		for _, x := range router.Cmds {
+			merge(ret["cmds"], x.Cmd, x.Latency)
+		}
+		for _, x := range router.Backends {
+			merge(ret["backends"], x.Addr, x.Latency)
+		}
+	}
+	return ret
+}
+
+// 汇总所有proxy的热点key和热点slot，相同key或者slot的次数相加
+func getAllProxyHotKeys() map[string]interface{} {
+	vars := getAllProxyDebugVars()
+	if vars == nil {
+		return nil
+	}
+
+	var keys = make(map[string]*hotKey)
+	var slots = make(map[int]*hotKey)
+	for id, m := range vars {
+		var hotkeys struct {
+			Keys  []hotKey `json:"keys"`
+			Slots []hotKey `json:"slots"`
+		}
+		b, _ := json.Marshal(m["hotkeys"])
+		if err := json.Unmarshal(b, &hotkeys); err != nil {
+			log.WarnErrorf(err, "parse hot keys of proxy %s failed", id)
+			continue
+		}
+		for _, x := range hotkeys.Keys {
+			if keys[x.Key] == nil {
+				keys[x.Key] = &hotKey{Key: x.Key, Slot: x.Slot}
+			}
+			keys[x.Key].Count += x.Count
+		}
+		for _, x := range hotkeys.Slots {
+			if slots[x.Slot] == nil {
+				slots[x.Slot] = &hotKey{Slot: x.Slot}
+			}
+			slots[x.Slot].Count += x.Count
+		}
+	}
+
+	var retKeys, retSlots = make([]*hotKey, 0, len(keys)), make([]*hotKey, 0, len(slots))
+	for _, x := range keys {
+		retKeys = append(retKeys, x)
+	}
+	for _, x := range slots {
+		retSlots = append(retSlots, x)
+	}
+	sort.Sort(hotKeysByCount(retKeys))
+	sort.Sort(hotKeysByCount(retSlots))
+	if len(retKeys) > 32 {
+		retKeys = retKeys[:32]
+	}
+	if len(retSlots) > 16 {
+		retSlots = retSlots[:16]
+	}
+	return map[string]interface{}{"keys": retKeys, "slots": retSlots}
+}
+
+type hotKey struct {
+	Key   string `json:"key,omitempty"`
+	Slot  int    `json:"slot"`
+	Count uint64 `json:"count"`
+}
+
+type hotKeysByCount []*hotKey
+
+func (b hotKeysByCount) Len() int {
+	return len(b)
+}
+
+func (b hotKeysByCount) Less(i, j int) bool {
+	return b[i].Count > b[j].Count
+}
+
+func (b hotKeysByCount) Swap(i, j int) {
+	b[i], b[j] = b[j], b[i]
+}
+
 func pageSlots(r render.Render) {
 	r.HTML(200, "slots", nil)
 }
@@ -233,6 +352,8 @@ func runDashboard(addr string, httpLogFile string) {
 	m.Get("/api/server_group/(?P<id>[0-9]+)", apiGetServerGroup)
 	// 将指定group_id中的指定地址的redis-server提升为master
 	m.Post("/api/server_group/(?P<id>[0-9]+)/promote", binding.Json(models.Server{}), apiPromoteServer)
+	// 获取自动故障切换的记录
+	m.Get("/api/ha/failovers", apiGetFailoverRecords)
 
 	// 获取集群当前的迁移信息，每次只能有一个slot处于迁移状态
 	m.Get("/api/migrate/status", apiMigrateStatus)
@@ -240,6 +361,12 @@ func runDashboard(addr string, httpLogFile string) {
 	m.Get("/api/migrate/tasks", apiGetMigrateTasks)
 	// 执行迁移slot的任务，依次迁移，每次迁移一个slot
 	m.Post("/api/migrate", binding.Json(migrateTaskForm{}), apiDoMigrate)
+	// 暂停、继续、取消迁移，取消时正在迁移的slot回滚到原来的group
+	m.Post("/api/migrate/pause", apiPauseMigrate)
+	m.Post("/api/migrate/resume", apiResumeMigrate)
+	m.Post("/api/migrate/cancel", apiCancelMigrate)
+	// 修改迁移限速，正在执行的任务立即生效
+	m.Post("/api/migrate/throttle", binding.Json(migrateThrottleForm{}), apiThrottleMigrate)
 
 	// 对slot进行负载均衡
 	m.Post("/api/rebalance", apiRebalance)
@@ -258,6 +385,10 @@ func runDashboard(addr string, httpLogFile string) {
 	m.Get("/api/proxy/list", apiGetProxyList)
 	// 获取所有proxy的状态信息
 	m.Get("/api/proxy/debug/vars", apiGetProxyDebugVars)
+	// 获取所有proxy汇总后的延迟分布
+	m.Get("/api/proxy/latency", apiGetProxyLatency)
+	// 获取所有proxy汇总后的热点key和热点slot
+	m.Get("/api/proxy/hotkeys", apiGetProxyHotKeys)
 	// 设置proxy状态
 	m.Post("/api/proxy", binding.Json(models.ProxyInfo{}), apiSetProxyStatus)
 
@@ -287,6 +418,11 @@ func runDashboard(addr string, httpLogFile string) {
 	// 这里会创建一个循环执行的协程，用于从 /zk/codis/db_xxx/migrate_tasks 读取迁移任务并执行
 	globalMigrateManager = NewMigrateManager(safeZkConn, globalEnv.ProductName())
 
+	// 开启自动故障切换时，创建定时探测所有master的协程
+	if n := globalEnv.HaMaxFailures(); n > 0 {
+		NewHaWatcher(safeZkConn, globalEnv.ProductName(), globalEnv.Password(), globalEnv.HaProbeInterval(), n)
+	}
+
 	go func() {
 		tick := time.Tick(time.Second)
 		var lastCnt, qps int64
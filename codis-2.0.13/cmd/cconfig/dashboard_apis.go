@@ -31,6 +31,38 @@ type RangeSetTask struct {
 	Status     string `json:"status"`
 }
 
+// 获取所有proxy汇总后的延迟分布，包括每个命令和每个redis-server的百分位数
+func apiGetProxyLatency() (int, string) {
+	m := getAllProxyLatency()
+	if m == nil {
+		return 500, "Error getting proxy debug vars"
+	}
+
+	b, err := json.MarshalIndent(m, " ", "  ")
+	if err != nil {
+		log.WarnErrorf(err, "to json failed")
+		return 500, err.Error()
+	}
+
+	return 200, string(b)
+}
+
+// 获取所有proxy汇总后的热点key和热点slot，次数是按采样比例换算后的估计值
+func apiGetProxyHotKeys() (int, string) {
+	m := getAllProxyHotKeys()
+	if m == nil {
+		return 500, "Error getting proxy debug vars"
+	}
+
+	b, err := json.MarshalIndent(m, " ", "  ")
+	if err != nil {
+		log.WarnErrorf(err, "to json failed")
+		return 500, err.Error()
+	}
+
+	return 200, string(b)
+}
+
 // 获取所有proxy的状态信息
 func apiGetProxyDebugVars() (int, string) {
 	m := getAllProxyDebugVars()
@@ -158,16 +190,22 @@ func apiDoMigrate(form migrateTaskForm) (int, string) {
 	return jsonRetSucc()
 }
 
-// 对slot进行负载均衡
-func apiRebalance(param martini.Params) (int, string) {
-	if len(globalMigrateManager.Tasks()) > 0 {
+// 对slot进行负载均衡，dry_run 参数为true时只返回迁移计划，不创建迁移任务
+func apiRebalance(r *http.Request) (int, string) {
+	r.ParseForm()
+	val := r.FormValue("dry_run")
+	dryRun := val == "1" || val == "true"
+	delay, _ := strconv.Atoi(r.FormValue("delay"))
+	if !dryRun && len(globalMigrateManager.Tasks()) > 0 {
 		return 500, "there are migration tasks running, you should wait them done"
 	}
-	if err := Rebalance(); err != nil {
+	plan, err := Rebalance(delay, dryRun)
+	if err != nil {
 		log.ErrorErrorf(err, "rebalance failed")
 		return 500, err.Error()
 	}
-	return jsonRetSucc()
+	b, _ := json.MarshalIndent(plan, " ", "  ")
+	return 200, string(b)
 }
 
 // 获取zk migrate_tasks节点下的所有迁移任务信息，每次只有一个slot处于迁移状态，其他任务都需要等待
@@ -177,6 +215,51 @@ func apiGetMigrateTasks() (int, string) {
 	return 200, string(b)
 }
 
+// 暂停迁移，正在迁移的slot停在迁移状态，proxy仍然可以正常访问
+func apiPauseMigrate() (int, string) {
+	if err := globalMigrateManager.Pause(); err != nil {
+		log.ErrorErrorf(err, "pause migration failed")
+		return 500, err.Error()
+	}
+	return jsonRetSucc()
+}
+
+func apiResumeMigrate() (int, string) {
+	if err := globalMigrateManager.Resume(); err != nil {
+		log.ErrorErrorf(err, "resume migration failed")
+		return 500, err.Error()
+	}
+	return jsonRetSucc()
+}
+
+// 取消所有迁移任务，正在迁移的slot回滚到原来的group
+func apiCancelMigrate() (int, string) {
+	n, err := globalMigrateManager.Cancel()
+	if err != nil {
+		log.ErrorErrorf(err, "cancel migration failed")
+		return 500, err.Error()
+	}
+	return jsonRet(map[string]interface{}{
+		"ret":     0,
+		"msg":     "OK",
+		"removed": n,
+	})
+}
+
+// 迁移限速，0表示不限制
+type migrateThrottleForm struct {
+	KeysPerSecond  int   `json:"keys_per_second"`
+	BytesPerSecond int64 `json:"bytes_per_second"`
+}
+
+func apiThrottleMigrate(form migrateThrottleForm) (int, string) {
+	if err := globalMigrateManager.SetThrottle(form.KeysPerSecond, form.BytesPerSecond); err != nil {
+		log.ErrorErrorf(err, "set migration throttle failed")
+		return 500, err.Error()
+	}
+	return jsonRetSucc()
+}
+
 // 获取指定id的group的信息，包括内部所有的redis-server
 func apiGetServerGroup(param martini.Params) (int, string) {
 	id := param["id"]
@@ -202,9 +285,18 @@ func apiMigrateStatus() (int, string) {
 		return 500, err.Error()
 	}
 
+	var runningTask *MigrateTaskInfo
+	globalMigrateManager.mu.Lock()
+	if t := globalMigrateManager.runningTask; t != nil {
+		info := t.MigrateTaskInfo
+		runningTask = &info
+	}
+	globalMigrateManager.mu.Unlock()
+
 	b, err := json.MarshalIndent(map[string]interface{}{
-		"migrate_slots": migrateSlots,
-		"migrate_task":  globalMigrateManager.runningTask,
+		"migrate_slots":   migrateSlots,
+		"migrate_task":    runningTask,
+		"migrate_control": globalMigrateManager.Control(),
 	}, " ", "  ")
 	return 200, string(b)
 }
@@ -405,6 +497,17 @@ func apiPromoteServer(server models.Server, param martini.Params) (int, string)
 	return jsonRetSucc()
 }
 
+// 获取自动故障切换的记录
+func apiGetFailoverRecords() (int, string) {
+	records, err := models.FailoverRecords(safeZkConn, globalEnv.ProductName())
+	if err != nil {
+		log.ErrorErrorf(err, "get failover records failed")
+		return 500, err.Error()
+	}
+	b, err := json.MarshalIndent(records, " ", "  ")
+	return 200, string(b)
+}
+
 // 从此group中删除指定地址的 redis-server
 func apiRemoveServerFromGroup(server models.Server, param martini.Params) (int, string) {
 	groupId, _ := strconv.Atoi(param["id"])
@@ -5,13 +5,13 @@ package main
 
 import (
 	"os"
-	"strings"
+	"time"
 
 	"github.com/c4pt0r/cfg"
 
 	"github.com/wandoulabs/zkhelper"
 
-	"github.com/CodisLabs/codis/pkg/utils/errors"
+	"github.com/CodisLabs/codis/pkg/utils/coordinator"
 	"github.com/CodisLabs/codis/pkg/utils/log"
 )
 
@@ -20,6 +20,8 @@ type Env interface {
 	Password() string
 	DashboardAddr() string
 	NewZkConn() (zkhelper.Conn, error) // 创建新的zk连接
+	HaMaxFailures() int                // 自动故障切换前master连续探测失败的次数，0表示不开启
+	HaProbeInterval() time.Duration    // 探测master的间隔
 }
 
 type CodisEnv struct {
@@ -27,7 +29,10 @@ type CodisEnv struct {
 	passwd        string // 连接后端redis的密码
 	dashboardAddr string // dashboard的地址
 	productName   string // 集群的名称
-	provider      string // zookeeper or etcd
+	provider      string // zookeeper、etcd 或者 embedded
+
+	haMaxFailures   int
+	haProbeInterval time.Duration
 }
 
 func LoadCodisEnv(cfg *cfg.Cfg) Env {
@@ -64,12 +69,21 @@ func LoadCodisEnv(cfg *cfg.Cfg) Env {
 	// 连接redis的密码
 	passwd, _ := cfg.ReadString("password", "")
 
+	// 自动故障切换
+	haMaxFailures, _ := cfg.ReadInt("ha_max_failures", 0)
+	haProbeInterval, _ := cfg.ReadInt("ha_probe_interval", 3)
+	if haProbeInterval <= 0 {
+		log.Panicf("config: invalid ha_probe_interval = %d", haProbeInterval)
+	}
+
 	return &CodisEnv{
-		zkAddr:        zkAddr,
-		passwd:        passwd,
-		dashboardAddr: dashboardAddr,
-		productName:   productName,
-		provider:      provider,
+		zkAddr:          zkAddr,
+		passwd:          passwd,
+		dashboardAddr:   dashboardAddr,
+		productName:     productName,
+		provider:        provider,
+		haMaxFailures:   haMaxFailures,
+		haProbeInterval: time.Second * time.Duration(haProbeInterval),
 	}
 }
 
@@ -85,19 +99,19 @@ func (e *CodisEnv) DashboardAddr() string {
 	return e.dashboardAddr
 }
 
+func (e *CodisEnv) HaMaxFailures() int {
+	return e.haMaxFailures
+}
+
+func (e *CodisEnv) HaProbeInterval() time.Duration {
+	return e.haProbeInterval
+}
+
 func (e *CodisEnv) NewZkConn() (zkhelper.Conn, error) {
-	switch e.provider {
-	case "zookeeper":
-		// 这里可以考虑从配置文件读取
-		// modify by fatedier
-		return zkhelper.ConnectToZk(e.zkAddr, 60000)
-		// end
-	case "etcd":
-		addr := strings.TrimSpace(e.zkAddr)
-		if !strings.HasPrefix(addr, "http://") {
-			addr = "http://" + addr
-		}
-		return zkhelper.NewEtcdConn(addr, 30)
+	// etcd 的会话超时时间单位是秒
+	timeout := 60000
+	if e.provider == "etcd" {
+		timeout = 30
 	}
-	return nil, errors.Errorf("need coordinator in config file, %s", e)
+	return coordinator.NewConn(e.provider, e.zkAddr, timeout)
 }
@@ -0,0 +1,178 @@
+// Copyright 2016 CodisLabs. All Rights Reserved.
+// Licensed under the MIT (MIT-LICENSE.txt) license.
+
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/wandoulabs/go-zookeeper/zk"
+	"github.com/wandoulabs/zkhelper"
+
+	"github.com/CodisLabs/codis/pkg/models"
+	"github.com/CodisLabs/codis/pkg/utils"
+	"github.com/CodisLabs/codis/pkg/utils/errors"
+	"github.com/CodisLabs/codis/pkg/utils/log"
+)
+
+// 自动故障切换，定时探测每个group的master，连续失败一定次数后提升数据最新的slave为master
+type HaWatcher struct {
+	zkConn      zkhelper.Conn
+	productName string
+	passwd      string
+
+	interval    time.Duration
+	maxFailures int
+
+	failures map[string]int // master地址 -> 连续探测失败的次数
+}
+
+func NewHaWatcher(zkConn zkhelper.Conn, pn, passwd string, interval time.Duration, maxFailures int) *HaWatcher {
+	w := &HaWatcher{
+		zkConn:      zkConn,
+		productName: pn,
+		passwd:      passwd,
+		interval:    interval,
+		maxFailures: maxFailures,
+		failures:    make(map[string]int),
+	}
+	log.Infof("ha watcher started: interval = %s, max failures = %d", interval, maxFailures)
+	go w.loop()
+	return w
+}
+
+func (w *HaWatcher) loop() {
+	for {
+		time.Sleep(w.interval)
+		if err := w.check(); err != nil {
+			log.WarnErrorf(err, "ha watcher check failed")
+		}
+	}
+}
+
+// 探测所有group的master
+func (w *HaWatcher) check() error {
+	groups, err := models.ServerGroups(w.zkConn, w.productName)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	var masters = make(map[string]bool)
+	for _, g := range groups {
+		master := groupMaster(g)
+		if master == nil {
+			continue
+		}
+		masters[master.Addr] = true
+
+		_, err := utils.GetRedisStat(master.Addr, w.passwd)
+		if err == nil {
+			delete(w.failures, master.Addr)
+			continue
+		}
+		failures := w.failures[master.Addr] + 1
+		log.WarnErrorf(err, "ha: probe master %s of group %d failed, %d/%d", master.Addr, g.Id, failures, w.maxFailures)
+		if failures < w.maxFailures {
+			w.failures[master.Addr] = failures
+			continue
+		}
+		// 无论切换是否成功都重新计数，避免每次探测都尝试切换
+		delete(w.failures, master.Addr)
+		if err := w.failover(g.Id, master.Addr, failures); err != nil {
+			log.ErrorErrorf(err, "ha: failover group %d failed", g.Id)
+		}
+	}
+	// 已经不是master的地址不再计数
+	for addr := range w.failures {
+		if !masters[addr] {
+			delete(w.failures, addr)
+		}
+	}
+	return nil
+}
+
+func groupMaster(g *models.ServerGroup) *models.Server {
+	for _, s := range g.Servers {
+		if s.Type == models.SERVER_TYPE_MASTER {
+			return s
+		}
+	}
+	return nil
+}
+
+// 提升数据最新的slave为master，其余slave改为复制新的master，结果记录到zk上
+func (w *HaWatcher) failover(groupId int, addr string, failures int) error {
+	lock := utils.GetZkLock(w.zkConn, w.productName)
+	if err := lock.LockWithTimeout(0, fmt.Sprintf("ha failover group %d, master %s", groupId, addr)); err != nil {
+		return errors.Trace(err)
+	}
+	defer func() {
+		err := lock.Unlock()
+		if err != nil && err != zk.ErrNoNode {
+			log.ErrorErrorf(err, "unlock node failed")
+		}
+	}()
+
+	// 拿到锁之后重新获取group，master可能已经被手动切换了
+	g, err := models.GetGroup(w.zkConn, w.productName, groupId)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if master := groupMaster(g); master == nil || master.Addr != addr {
+		log.Infof("ha: master of group %d has been changed, skip failover", groupId)
+		return nil
+	}
+
+	var slaves []*models.Server
+	var stats = make(map[string]map[string]string)
+	for _, s := range g.Servers {
+		if s.Type != models.SERVER_TYPE_SLAVE {
+			continue
+		}
+		slaves = append(slaves, s)
+		if m, err := utils.GetRedisStat(s.Addr, w.passwd); err != nil {
+			log.WarnErrorf(err, "ha: get stat of slave %s failed", s.Addr)
+		} else {
+			stats[s.Addr] = m
+		}
+	}
+
+	r := &models.FailoverRecord{
+		GroupId:   groupId,
+		OldMaster: addr,
+		Failures:  failures,
+		Ts:        strconv.FormatInt(time.Now().Unix(), 10),
+	}
+	err = w.promote(g, slaves, stats, r)
+	if err != nil {
+		r.Error = err.Error()
+	}
+	if err := models.NewFailoverRecord(w.zkConn, w.productName, r); err != nil {
+		log.ErrorErrorf(err, "ha: save failover record failed: %s", r)
+	}
+	return err
+}
+
+func (w *HaWatcher) promote(g *models.ServerGroup, slaves []*models.Server, stats map[string]map[string]string, r *models.FailoverRecord) error {
+	s, offset := models.PickPromotion(slaves, stats)
+	if s == nil {
+		return errors.Errorf("no available slave in group %d", g.Id)
+	}
+	r.NewMaster, r.Offset = s.Addr, offset
+	log.Warnf("ha: promote %s to master of group %d, offset = %d, old master = %s", s.Addr, g.Id, offset, r.OldMaster)
+
+	// Promote 会通知所有proxy并等待回复，proxy确认后才会使用新的master
+	if err := g.Promote(w.zkConn, s.Addr, w.passwd); err != nil {
+		return errors.Trace(err)
+	}
+	for _, x := range slaves {
+		if x.Addr == s.Addr {
+			continue
+		}
+		if err := utils.SlaveOf(x.Addr, w.passwd, s.Addr); err != nil {
+			log.WarnErrorf(err, "ha: slave %s of group %d follow new master %s failed", x.Addr, g.Id, s.Addr)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,523 @@
+// Copyright 2016 CodisLabs. All Rights Reserved.
+// Licensed under the MIT (MIT-LICENSE.txt) license.
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/docopt/docopt-go"
+	"github.com/garyburd/redigo/redis"
+
+	"github.com/CodisLabs/codis/pkg/models"
+	"github.com/CodisLabs/codis/pkg/proxy/router"
+	"github.com/CodisLabs/codis/pkg/utils"
+	"github.com/CodisLabs/codis/pkg/utils/errors"
+	"github.com/CodisLabs/codis/pkg/utils/log"
+	"github.com/CodisLabs/codis/pkg/utils/rdb"
+)
+
+// 集合类型的key拆成多条命令时，每条命令最多带的元素个数
+const importElementsPerCmd = 512
+
+func cmdImport(argv []string) (err error) {
+	usage := `usage: codis-config import [--rdb=<rdb_file>] [--aof=<aof_file>] [--db=<db>] [--batch=<n>] [--resume]
+
+options:
+	--rdb=<rdb_file>   import keys from a rdb file
+	--aof=<aof_file>   replay commands in an aof file, the rdb preamble is supported
+	--db=<db>          only import keys in this db [default: 0]
+	--batch=<n>        keys or commands sent in one pipelined batch, the progress is saved after each batch [default: 1000]
+	--resume           continue from the progress saved in <file>.checkpoint
+`
+	args, err := docopt.Parse(usage, argv, true, "", false)
+	if err != nil {
+		log.ErrorErrorf(err, "parse args failed")
+		return errors.Trace(err)
+	}
+	log.Debugf("parse args = {%+v}", args)
+
+	rdbFile, _ := args["--rdb"].(string)
+	aofFile, _ := args["--aof"].(string)
+	if rdbFile == "" && aofFile == "" {
+		return errors.Errorf("at least one of --rdb and --aof is required")
+	}
+	db, err := strconv.Atoi(args["--db"].(string))
+	if err != nil {
+		log.ErrorErrorf(err, "parse <db> failed")
+		return errors.Trace(err)
+	}
+	batch, err := strconv.Atoi(args["--batch"].(string))
+	if err != nil || batch <= 0 {
+		return errors.Errorf("invalid batch size %v", args["--batch"])
+	}
+	resume := args["--resume"].(bool)
+
+	im, err := newImporter(db, batch)
+	if err != nil {
+		return err
+	}
+	defer im.close()
+
+	if rdbFile != "" {
+		if err := im.importFile(rdbFile, false, resume); err != nil {
+			return err
+		}
+	}
+	if aofFile != "" {
+		if err := im.importFile(aofFile, true, resume); err != nil {
+			return err
+		}
+	}
+	if im.errs != 0 {
+		return errors.Errorf("%d commands failed, last error: %v", im.errs, im.lastErr)
+	}
+	return nil
+}
+
+// 导入的进度，每批数据写入成功之后保存在 <file>.checkpoint 中
+type importCheckpoint struct {
+	File    string `json:"file"`
+	Size    int64  `json:"size"`
+	Offset  int64  `json:"offset"`
+	Version int    `json:"rdb_version,omitempty"`
+	DB      int    `json:"db"`
+	AOF     bool   `json:"aof"` // 已经读完AOF开头的RDB部分，开始读取命令
+	Keys    int64  `json:"keys"`
+	Cmds    int64  `json:"commands"`
+	Done    bool   `json:"done"`
+}
+
+func loadImportCheckpoint(path string) (*importCheckpoint, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	cp := &importCheckpoint{}
+	if err := json.Unmarshal(b, cp); err != nil {
+		return nil, errors.Trace(err)
+	}
+	return cp, nil
+}
+
+// 先写临时文件再改名，中途退出也不会留下不完整的进度
+func (cp *importCheckpoint) save(path string) error {
+	b, err := json.MarshalIndent(cp, "", "  ")
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if err := ioutil.WriteFile(path+".tmp", b, 0644); err != nil {
+		return errors.Trace(err)
+	}
+	return errors.Trace(os.Rename(path+".tmp", path))
+}
+
+// 按照slot把命令发给对应group的master，同一个key的命令总是发往同一个连接，保证执行顺序
+type importer struct {
+	db      int
+	batch   int
+	masters [router.MaxSlotNum]string
+
+	conns   map[string]redis.Conn
+	pending map[string]int
+
+	keys, cmds, skipped, errs int64
+	lastErr                   error
+}
+
+func newImporter(db, batch int) (*importer, error) {
+	var slots []*models.Slot
+	if err := callApi(METHOD_GET, "/api/slots", nil, &slots); err != nil {
+		return nil, errors.Trace(err)
+	}
+	var groups []*models.ServerGroup
+	if err := callApi(METHOD_GET, "/api/server_groups", nil, &groups); err != nil {
+		return nil, errors.Trace(err)
+	}
+	var masters = make(map[int]string)
+	for _, g := range groups {
+		for _, s := range g.Servers {
+			if s.Type == models.SERVER_TYPE_MASTER {
+				masters[g.Id] = s.Addr
+			}
+		}
+	}
+
+	im := &importer{
+		db: db, batch: batch,
+		conns:   make(map[string]redis.Conn),
+		pending: make(map[string]int),
+	}
+	if len(slots) != router.MaxSlotNum {
+		return nil, errors.Errorf("expect %d slots, got %d, please init slots first", router.MaxSlotNum, len(slots))
+	}
+	for _, slot := range slots {
+		// 迁移过程中key可能在两个group中，导入的数据可能被覆盖或者丢失
+		if slot.State.Status != models.SLOT_STATUS_ONLINE {
+			return nil, errors.Errorf("slot %d is %s, import requires all slots online", slot.Id, slot.State.Status)
+		}
+		addr, ok := masters[slot.GroupId]
+		if !ok {
+			return nil, errors.Errorf("group %d of slot %d has no master", slot.GroupId, slot.Id)
+		}
+		im.masters[slot.Id] = addr
+	}
+	return im, nil
+}
+
+func (im *importer) close() {
+	for _, c := range im.conns {
+		c.Close()
+	}
+}
+
+func (im *importer) send(key []byte, cmd [][]byte) error {
+	addr := im.masters[router.HashSlot(key)]
+	c, ok := im.conns[addr]
+	if !ok {
+		var err error
+		if c, err = utils.DialToTimeout(addr, globalEnv.Password(), time.Minute, time.Minute); err != nil {
+			return errors.Trace(err)
+		}
+		im.conns[addr] = c
+	}
+	var args = make([]interface{}, len(cmd)-1)
+	for i, x := range cmd[1:] {
+		args[i] = x
+	}
+	if err := c.Send(string(cmd[0]), args...); err != nil {
+		return errors.Trace(err)
+	}
+	im.pending[addr]++
+	im.cmds++
+	return nil
+}
+
+// 等待这一批命令全部执行完，redis返回的错误只记录下来，连接出错时直接退出
+func (im *importer) flush() error {
+	for addr := range im.pending {
+		if err := im.conns[addr].Flush(); err != nil {
+			return errors.Trace(err)
+		}
+	}
+	for addr, n := range im.pending {
+		c := im.conns[addr]
+		for i := 0; i < n; i++ {
+			_, err := c.Receive()
+			if err == nil {
+				continue
+			}
+			if _, ok := err.(redis.Error); !ok {
+				return errors.Trace(err)
+			}
+			if im.errs < 10 {
+				log.Warnf("redis %s returns error: %s", addr, err)
+			}
+			im.errs++
+			im.lastErr = err
+		}
+		delete(im.pending, addr)
+	}
+	return nil
+}
+
+func (im *importer) importFile(path string, aof, resume bool) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return errors.Trace(err)
+	}
+
+	ckpath := path + ".checkpoint"
+	cp := &importCheckpoint{File: path, Size: info.Size()}
+	if resume {
+		saved, err := loadImportCheckpoint(ckpath)
+		switch {
+		case os.IsNotExist(errors.Cause(err)):
+			log.Infof("no checkpoint of %s, import from the beginning", path)
+		case err != nil:
+			return errors.Trace(err)
+		case saved.Size != info.Size() && !aof:
+			return errors.Errorf("size of %s changed from %d to %d, can not resume", path, saved.Size, info.Size())
+		case aof && saved.Offset > info.Size():
+			return errors.Errorf("%s is shorter than the saved offset %d, maybe rewritten, can not resume", path, saved.Offset)
+		case saved.Done && (!aof || saved.Offset == info.Size()):
+			log.Infof("%s has been imported, skip", path)
+			return nil
+		default:
+			// AOF可能在上次导入之后追加了新的命令，从上次的位置继续读取即可
+			cp = saved
+			cp.Size, cp.Done = info.Size(), false
+			log.Infof("resume importing %s from offset %d", path, cp.Offset)
+		}
+	}
+	if _, err := f.Seek(cp.Offset, 0); err != nil {
+		return errors.Trace(err)
+	}
+
+	p := &importProgress{im: im, cp: cp, path: path, ckpath: ckpath, start: time.Now(), last: time.Now()}
+	if !aof || !cp.AOF {
+		rdbPart := !aof || cp.Offset != 0
+		if !rdbPart {
+			// AOF 开头可能是 RDB 格式的数据
+			var magic = make([]byte, 5)
+			n, _ := io.ReadFull(f, magic)
+			rdbPart = bytes.Equal(magic[:n], []byte("REDIS"))
+			if _, err := f.Seek(0, 0); err != nil {
+				return errors.Trace(err)
+			}
+		}
+		if rdbPart {
+			if err := p.importRDB(f); err != nil {
+				return err
+			}
+			// RDB 部分已经导入完，之后的内容是AOF格式的命令，先保存下来，中途退出后继续导入时不再按RDB解析
+			if aof {
+				cp.AOF = true
+				if err := cp.save(ckpath); err != nil {
+					return err
+				}
+			}
+			if _, err := f.Seek(cp.Offset, 0); err != nil {
+				return errors.Trace(err)
+			}
+		}
+	}
+	if aof {
+		cp.AOF = true
+		if err := p.importAOF(f); err != nil {
+			return err
+		}
+	}
+	cp.Done = true
+	if err := p.finish(); err != nil {
+		return err
+	}
+	log.Infof("import %s done, %d keys, %d commands, %d skipped, %d errors, in %s",
+		path, cp.Keys, cp.Cmds, im.skipped, im.errs, time.Since(p.start))
+	return nil
+}
+
+type importProgress struct {
+	im     *importer
+	cp     *importCheckpoint
+	path   string
+	ckpath string
+
+	n           int
+	start, last time.Time
+}
+
+// 一个key或者一条命令读取完，凑满一批之后写入并保存进度
+func (p *importProgress) step(offset int64, db int) error {
+	if p.n++; p.n < p.im.batch {
+		return nil
+	}
+	p.cp.Offset, p.cp.DB = offset, db
+	return p.finish()
+}
+
+func (p *importProgress) finish() error {
+	keys, cmds := p.im.keys, p.im.cmds
+	if err := p.im.flush(); err != nil {
+		return err
+	}
+	p.cp.Keys += keys
+	p.cp.Cmds += cmds
+	p.im.keys, p.im.cmds = 0, 0
+	p.n = 0
+	if err := p.cp.save(p.ckpath); err != nil {
+		return err
+	}
+	if time.Since(p.last) >= time.Second*5 {
+		p.last = time.Now()
+		var percent float64
+		if p.cp.Size != 0 {
+			percent = float64(p.cp.Offset) * 100 / float64(p.cp.Size)
+		}
+		log.Infof("import %s: %d/%d bytes (%.1f%%), %d keys, %d commands, %d errors",
+			p.path, p.cp.Offset, p.cp.Size, percent, p.cp.Keys, p.cp.Cmds, p.im.errs)
+	}
+	return nil
+}
+
+func (p *importProgress) importRDB(f io.Reader) error {
+	var d *rdb.Decoder
+	if p.cp.Offset == 0 {
+		d = rdb.NewDecoder(f)
+		if err := d.ReadHeader(); err != nil {
+			return errors.Trace(err)
+		}
+		p.cp.Version = d.Version()
+	} else {
+		d = rdb.NewDecoderAt(f, p.cp.Offset, p.cp.Version, p.cp.DB)
+	}
+	now := time.Now().UnixNano() / int64(time.Millisecond)
+	for {
+		e, err := d.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return errors.Trace(err)
+		}
+		// 其他db的key和已经过期的key不需要导入
+		if e.DB != p.im.db || (e.ExpireAt != 0 && e.ExpireAt <= now) {
+			p.im.skipped++
+		} else {
+			for _, cmd := range e.Commands(importElementsPerCmd) {
+				if err := p.im.send(e.Key, cmd); err != nil {
+					return err
+				}
+			}
+			p.im.keys++
+		}
+		if err := p.step(d.Offset(), d.DB()); err != nil {
+			return err
+		}
+	}
+	p.cp.Offset, p.cp.DB = d.Offset(), 0
+	return p.finish()
+}
+
+func (p *importProgress) importAOF(f io.Reader) error {
+	a := rdb.NewAOFReaderAt(f, p.cp.Offset)
+	for {
+		args, err := a.Next()
+		if err == io.EOF {
+			break
+		}
+		if errors.Cause(err) == io.ErrUnexpectedEOF {
+			log.Warnf("%s is truncated at offset %d, the last incomplete command is ignored", p.path, a.Offset())
+			break
+		}
+		if err != nil {
+			return errors.Trace(err)
+		}
+		switch opstr := strings.ToUpper(string(args[0])); {
+		case opstr == "SELECT" && len(args) == 2:
+			db, err := strconv.Atoi(string(args[1]))
+			if err != nil {
+				return errors.Errorf("bad select %q at offset %d", args[1], a.Offset())
+			}
+			p.cp.DB = db
+		case p.cp.DB != p.im.db:
+			p.im.skipped++
+		default:
+			cmds, err := splitAOFCommand(opstr, args)
+			if err != nil {
+				return errors.Errorf("%s at offset %d", err, a.Offset())
+			}
+			if len(cmds) == 0 {
+				p.im.skipped++
+			}
+			for _, cmd := range cmds {
+				if err := p.im.send(aofRoutingKey(opstr, cmd), cmd); err != nil {
+					return err
+				}
+			}
+		}
+		if err := p.step(a.Offset(), p.cp.DB); err != nil {
+			return err
+		}
+	}
+	p.cp.Offset = a.Offset()
+	return nil
+}
+
+// AOF中的多key命令拆成单个key的命令，key不在同一个slot时无法在codis中执行
+func splitAOFCommand(opstr string, args [][]byte) ([][][]byte, error) {
+	var single = func(op string, keys [][]byte, step int) [][][]byte {
+		var cmds [][][]byte
+		for i := 0; i+step <= len(keys); i += step {
+			cmds = append(cmds, append([][]byte{[]byte(op)}, keys[i:i+step]...))
+		}
+		return cmds
+	}
+	var sameSlot = func(keys ...[]byte) error {
+		for _, key := range keys[1:] {
+			if router.HashSlot(key) != router.HashSlot(keys[0]) {
+				return errors.Errorf("keys of %s are not in the same slot", opstr)
+			}
+		}
+		return nil
+	}
+	switch opstr {
+	case "MULTI", "EXEC":
+		// 导入时不需要事务，命令仍然按顺序执行
+		return nil, nil
+	case "FLUSHALL", "FLUSHDB", "SWAPDB", "MOVE":
+		return nil, errors.Errorf("can not import %s", opstr)
+	case "MSET", "MSETNX":
+		return single("SET", args[1:], 2), nil
+	case "DEL", "UNLINK":
+		return single(opstr, args[1:], 1), nil
+	case "RENAME", "RENAMENX", "SMOVE", "RPOPLPUSH", "BRPOPLPUSH":
+		if len(args) < 3 {
+			break
+		}
+		if err := sameSlot(args[1], args[2]); err != nil {
+			return nil, err
+		}
+	case "SINTERSTORE", "SUNIONSTORE", "SDIFFSTORE", "PFMERGE":
+		if err := sameSlot(args[1:]...); err != nil {
+			return nil, err
+		}
+	case "ZUNIONSTORE", "ZINTERSTORE":
+		if len(args) < 4 {
+			break
+		}
+		n, err := strconv.Atoi(string(args[2]))
+		if err != nil || n < 0 || 3+n > len(args) {
+			break
+		}
+		if err := sameSlot(append([][]byte{args[1]}, args[3:3+n]...)...); err != nil {
+			return nil, err
+		}
+	case "BITOP":
+		if len(args) < 4 {
+			break
+		}
+		if err := sameSlot(args[2:]...); err != nil {
+			return nil, err
+		}
+	case "EVAL", "EVALSHA":
+		// 没有key的脚本无法确定发往哪个group
+		var n = 0
+		if len(args) >= 4 {
+			n, _ = strconv.Atoi(string(args[2]))
+		}
+		if n <= 0 || 3+n > len(args) {
+			return nil, errors.Errorf("can not import %s without keys", opstr)
+		}
+		if err := sameSlot(args[3 : 3+n]...); err != nil {
+			return nil, err
+		}
+	}
+	if len(args) < 2 {
+		return nil, nil
+	}
+	return [][][]byte{args}, nil
+}
+
+// 命令中用于路由的key
+func aofRoutingKey(opstr string, cmd [][]byte) []byte {
+	switch opstr {
+	case "BITOP":
+		return cmd[2]
+	case "EVAL", "EVALSHA":
+		return cmd[3]
+	}
+	return cmd[1]
+}
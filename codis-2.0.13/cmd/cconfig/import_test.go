@@ -0,0 +1,69 @@
+// Copyright 2016 CodisLabs. All Rights Reserved.
+// Licensed under the MIT (MIT-LICENSE.txt) license.
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/alicebob/miniredis"
+	"github.com/garyburd/redigo/redis"
+
+	"github.com/CodisLabs/codis/pkg/utils/assert"
+)
+
+// 只有一个string的RDB
+func testPreambleRDB() []byte {
+	b := []byte("REDIS0008")
+	b = append(b, 0xfe, 0)
+	b = append(b, 0, 2, 'k', '0', 2, 'v', '0')
+	b = append(b, 0xff)
+	return append(b, make([]byte, 8)...)
+}
+
+func TestImportResumePreambleAOF(t *testing.T) {
+	globalEnv = &CodisEnv{}
+	r, err := miniredis.Run()
+	assert.MustNoError(err)
+	defer r.Close()
+
+	dir, err := ioutil.TempDir("", "codis-import")
+	assert.MustNoError(err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "appendonly.aof")
+
+	newImporter := func() *importer {
+		im := &importer{db: 0, batch: 1000, conns: make(map[string]redis.Conn), pending: make(map[string]int)}
+		for i := range im.masters {
+			im.masters[i] = r.Addr()
+		}
+		return im
+	}
+
+	// 读完RDB部分之后，第一批命令还没有写入就出错了
+	aof := append(testPreambleRDB(), "*2\r\n$6\r\nSELECT\r\n$1\r\n0\r\n*1\r\n$8\r\nFLUSHALL\r\n"...)
+	assert.MustNoError(ioutil.WriteFile(path, aof, 0644))
+	im := newImporter()
+	assert.Must(im.importFile(path, true, false) != nil)
+	im.close()
+	v, err := r.Get("k0")
+	assert.Must(err == nil && v == "v0")
+
+	// 去掉出错的命令之后继续导入，从RDB之后的命令开始
+	aof = append(testPreambleRDB(), "*2\r\n$6\r\nSELECT\r\n$1\r\n0\r\n*3\r\n$3\r\nSET\r\n$2\r\nk1\r\n$2\r\nv1\r\n"...)
+	assert.MustNoError(ioutil.WriteFile(path, aof, 0644))
+	r.Del("k0")
+	im = newImporter()
+	assert.MustNoError(im.importFile(path, true, true))
+	im.close()
+	v, err = r.Get("k1")
+	assert.Must(err == nil && v == "v1")
+	assert.Must(!r.Exists("k0"))
+
+	cp, err := loadImportCheckpoint(path + ".checkpoint")
+	assert.MustNoError(err)
+	assert.Must(cp.AOF && cp.Done && cp.Offset == int64(len(aof)))
+}
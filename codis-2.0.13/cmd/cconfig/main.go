@@ -50,6 +50,8 @@ commands:
 	dashboard   启动 dashboard 服务
 	action      事件管理 (目前只有删除历史事件的日志)
 	proxy       proxy 管理
+	import      从 RDB/AOF 文件导入数据
+	topo        导出、导入和比较集群的布局
 `
 
 func init() {
@@ -90,6 +92,10 @@ func runCommand(cmd string, args []string) (err error) {
 		return errors.Trace(cmdProxy(argv))
 	case "slot":
 		return errors.Trace(cmdSlot(argv))
+	case "import":
+		return errors.Trace(cmdImport(argv))
+	case "topo":
+		return errors.Trace(cmdTopo(argv))
 	}
 	return errors.Errorf("%s is not a valid command. See 'codis-config -h'", cmd)
 }
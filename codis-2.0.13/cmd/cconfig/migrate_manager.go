@@ -8,11 +8,14 @@ import (
 	"fmt"
 	"path"
 	"sort"
+	"sync"
 	"time"
 
 	"github.com/wandoulabs/go-zookeeper/zk"
 	"github.com/wandoulabs/zkhelper"
 
+	"github.com/CodisLabs/codis/pkg/models"
+	"github.com/CodisLabs/codis/pkg/utils/errors"
 	"github.com/CodisLabs/codis/pkg/utils/log"
 )
 
@@ -26,6 +29,8 @@ const (
 	MIGRATE_TASK_MIGRATING string = "migrating"
 	MIGRATE_TASK_FINISHED  string = "finished"
 	MIGRATE_TASK_ERR       string = "error"
+	MIGRATE_TASK_PAUSED    string = "paused"
+	MIGRATE_TASK_CANCELING string = "canceling"
 )
 
 // check if migrate task is valid
@@ -34,16 +39,29 @@ type MigrateTaskCheckFunc func(t *MigrateTask) (bool, error)
 // migrate task will store on zk
 // 迁移任务管理，每一次操作信息都存储在zk上
 type MigrateManager struct {
+	mu          sync.Mutex
 	runningTask *MigrateTask
+	control     MigrateControl
 	zkConn      zkhelper.Conn
 	productName string
 }
 
+// 迁移的控制参数，可以在迁移过程中修改，保存在zk上，dashboard重启之后仍然有效
+type MigrateControl struct {
+	Paused         bool  `json:"paused"`           // 暂停之后正在迁移的slot停在迁移状态，等待的任务也不会开始
+	KeysPerSecond  int   `json:"keys_per_second"`  // 每秒最多迁移的key数，0表示不限制
+	BytesPerSecond int64 `json:"bytes_per_second"` // 每秒最多迁移的字节数，按照key的平均大小估算，0表示不限制
+}
+
 // 在zk中的存储路径
 func getMigrateTasksPath(product string) string {
 	return fmt.Sprintf("/zk/codis/db_%s/migrate_tasks", product)
 }
 
+func getMigrateControlPath(product string) string {
+	return fmt.Sprintf("/zk/codis/db_%s/migrate_control", product)
+}
+
 // 启动迁移管理协程，创建用于管理迁移信息的zk路径
 func NewMigrateManager(zkConn zkhelper.Conn, pn string) *MigrateManager {
 	m := &MigrateManager{
@@ -51,6 +69,9 @@ func NewMigrateManager(zkConn zkhelper.Conn, pn string) *MigrateManager {
 		productName: pn,
 	}
 	zkhelper.CreateRecursive(m.zkConn, getMigrateTasksPath(m.productName), "", 0, zkhelper.DefaultDirACLs())
+	if err := m.loadControl(); err != nil {
+		log.WarnErrorf(err, "load migrate control failed")
+	}
 	m.mayRecover()
 	// 开始循环检查此路径下的任务，并执行迁移任务，通过向redis发送命令，迁移slot
 	go m.loop()
@@ -72,17 +93,23 @@ func (m *MigrateManager) PostTask(info *MigrateTaskInfo) {
 	_, info.Id = path.Split(p)
 }
 
-// 每隔一秒钟获取一次迁移任务并执行
+// 每隔一秒钟获取一次迁移任务并执行，暂停时只执行正在取消的任务
 func (m *MigrateManager) loop() error {
 	for {
 		time.Sleep(time.Second)
+		m.mu.Lock()
 		info := m.NextTask()
-		if info == nil {
+		if info == nil || (m.control.Paused && info.Status != MIGRATE_TASK_CANCELING) {
+			m.mu.Unlock()
 			continue
 		}
 
 		// 构造 MigrateTask 对象
 		t := GetMigrateTask(*info)
+		t.manager = m
+		m.runningTask = t
+		m.mu.Unlock()
+
 		// 集群中每次只能有一个slot处于迁移状态，这里做一下检查，看迁移任务和slots下的对应slot的状态是否一致
 		err := t.preMigrateCheck()
 		if err != nil {
@@ -93,7 +120,133 @@ func (m *MigrateManager) loop() error {
 		if err != nil {
 			log.ErrorErrorf(err, "migrate failed")
 		}
+
+		m.mu.Lock()
+		m.runningTask = nil
+		m.mu.Unlock()
+	}
+}
+
+func (m *MigrateManager) loadControl() error {
+	data, _, err := m.zkConn.Get(getMigrateControlPath(m.productName))
+	if err != nil {
+		if zkhelper.ZkErrorEqual(err, zk.ErrNoNode) {
+			return nil
+		}
+		return errors.Trace(err)
+	}
+	var c MigrateControl
+	if err := json.Unmarshal(data, &c); err != nil {
+		return errors.Trace(err)
+	}
+	m.mu.Lock()
+	m.control = c
+	m.mu.Unlock()
+	if c.Paused {
+		log.Warnf("migration is paused, resume it to continue")
+	}
+	return nil
+}
+
+func (m *MigrateManager) Control() MigrateControl {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.control
+}
+
+// 修改控制参数，先写入zk再生效
+func (m *MigrateManager) updateControl(f func(c *MigrateControl)) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	c := m.control
+	f(&c)
+	b, _ := json.Marshal(c)
+	_, err := zkhelper.CreateOrUpdate(m.zkConn, getMigrateControlPath(m.productName), string(b), 0, zkhelper.DefaultFileACLs(), true)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	m.control = c
+	log.Infof("migrate control changed: %+v", c)
+	return nil
+}
+
+func (m *MigrateManager) Pause() error {
+	return m.updateControl(func(c *MigrateControl) {
+		c.Paused = true
+	})
+}
+
+func (m *MigrateManager) Resume() error {
+	return m.updateControl(func(c *MigrateControl) {
+		c.Paused = false
+	})
+}
+
+// 修改限速，正在执行的任务也会立即使用新的限速
+func (m *MigrateManager) SetThrottle(keysPerSecond int, bytesPerSecond int64) error {
+	if keysPerSecond < 0 || bytesPerSecond < 0 {
+		return errors.Errorf("invalid throttle, keys = %d, bytes = %d", keysPerSecond, bytesPerSecond)
+	}
+	return m.updateControl(func(c *MigrateControl) {
+		c.KeysPerSecond, c.BytesPerSecond = keysPerSecond, bytesPerSecond
+	})
+}
+
+// 删除所有等待中的任务，正在迁移的slot把已经迁移的key迁移回原来的group
+// 迁移到一半但是没有在执行的任务（比如dashboard重启过）标记为正在取消，由 loop 完成回滚
+// 返回删除的等待中的任务数
+func (m *MigrateManager) Cancel() (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var n int
+	for _, info := range m.Tasks() {
+		if m.runningTask != nil && m.runningTask.Id == info.Id {
+			continue
+		}
+		s, err := models.GetSlot(m.zkConn, m.productName, info.SlotId)
+		if err != nil {
+			return n, errors.Trace(err)
+		}
+		if s.State.Status == models.SLOT_STATUS_MIGRATE && s.State.MigrateStatus.To == info.NewGroupId {
+			t := GetMigrateTask(info)
+			t.UpdateStatus(MIGRATE_TASK_CANCELING)
+			continue
+		}
+		err = m.zkConn.Delete(getMigrateTasksPath(m.productName)+"/"+info.Id, -1)
+		if err != nil && !zkhelper.ZkErrorEqual(err, zk.ErrNoNode) {
+			return n, errors.Trace(err)
+		}
+		n++
+	}
+	if t := m.runningTask; t != nil {
+		log.Warnf("cancel running migration: %+v", t.MigrateTaskInfo)
+		t.canceled.Set(true)
+	}
+	return n, nil
+}
+
+// 迁移每一批key之前调用，暂停时一直等待，返回是否被取消
+func (m *MigrateManager) waitResume(t *MigrateTask) bool {
+	var paused bool
+	for {
+		if t.canceled.Get() {
+			return true
+		}
+		if !m.Control().Paused {
+			break
+		}
+		if !paused {
+			paused = true
+			log.Infof("migration paused: %+v", t.MigrateTaskInfo)
+			t.UpdateStatus(MIGRATE_TASK_PAUSED)
+		}
+		time.Sleep(time.Millisecond * 100)
+	}
+	if paused {
+		log.Infof("migration resumed: %+v", t.MigrateTaskInfo)
+		t.UpdateStatus(MIGRATE_TASK_MIGRATING)
 	}
+	return false
 }
 
 // 获取一个任务
@@ -6,12 +6,14 @@ package main
 import (
 	"encoding/json"
 	"fmt"
+	"strconv"
 	"time"
 
 	"github.com/wandoulabs/zkhelper"
 
 	"github.com/CodisLabs/codis/pkg/models"
 	"github.com/CodisLabs/codis/pkg/utils"
+	"github.com/CodisLabs/codis/pkg/utils/atomic2"
 	"github.com/CodisLabs/codis/pkg/utils/errors"
 	"github.com/CodisLabs/codis/pkg/utils/log"
 )
@@ -44,8 +46,13 @@ type MigrateTask struct {
 	zkConn       zkhelper.Conn
 	productName  string
 	progressChan chan SlotMigrateProgress
+
+	manager  *MigrateManager // 提供暂停和限速的控制，为nil时不受控制
+	canceled atomic2.Bool
 }
 
+var ErrMigrateCanceled = errors.New("migration canceled")
+
 // 返回一个封装后的 MigrateTask 对象
 func GetMigrateTask(info MigrateTaskInfo) *MigrateTask {
 	return &MigrateTask{
@@ -86,6 +93,11 @@ func (t *MigrateTask) migrateSingleSlot(slotId int, to int) error {
 		from = s.State.MigrateStatus.From
 	}
 
+	// 上一次回滚没有完成，slot 正在迁移回原来的group
+	if t.canceled.Get() && s.State.Status == models.SLOT_STATUS_MIGRATE && s.State.MigrateStatus.To != to {
+		return t.rollbackMigrate(s, s.State.MigrateStatus.To, s.State.MigrateStatus.From)
+	}
+
 	// make sure from group & target group exists
 	exists, err := models.GroupExists(t.zkConn, t.productName, from)
 	if err != nil {
@@ -110,6 +122,11 @@ func (t *MigrateTask) migrateSingleSlot(slotId int, to int) error {
 		return nil
 	}
 
+	// 还没有开始迁移就被取消
+	if t.canceled.Get() && s.State.Status != models.SLOT_STATUS_MIGRATE {
+		return ErrMigrateCanceled
+	}
+
 	// modify slot status
 	if err := s.SetMigrateStatus(t.zkConn, from, to); err != nil {
 		log.ErrorErrorf(err, "set migrate status failed")
@@ -123,6 +140,9 @@ func (t *MigrateTask) migrateSingleSlot(slotId int, to int) error {
 			log.Infof("%+v", p)
 		}
 	})
+	if err == ErrMigrateCanceled {
+		return t.rollbackMigrate(s, from, to)
+	}
 	if err != nil {
 		log.ErrorErrorf(err, "migrate slot failed")
 		return err
@@ -144,14 +164,29 @@ func (t *MigrateTask) migrateSingleSlot(slotId int, to int) error {
 func (t *MigrateTask) run() error {
 	log.Infof("migration start: %+v", t.MigrateTaskInfo)
 	to := t.NewGroupId
-	// 更新任务状态为迁移中
-	t.UpdateStatus(MIGRATE_TASK_MIGRATING)
+	if t.Status == MIGRATE_TASK_CANCELING {
+		// 上一次取消时没有完成回滚
+		t.canceled.Set(true)
+	} else {
+		// 更新任务状态为迁移中
+		t.UpdateStatus(MIGRATE_TASK_MIGRATING)
+	}
 	// 迁移单个slot数据
 	err := t.migrateSingleSlot(t.SlotId, to)
+	if err == ErrMigrateCanceled {
+		t.rollbackPremigrate()
+		t.UpdateFinish()
+		log.Warnf("migration canceled: %+v", t.MigrateTaskInfo)
+		return nil
+	}
 	if err != nil {
-		// 如果迁移出错，更新任务状态信息
+		// 如果迁移出错，更新任务状态信息，取消时回滚出错的任务下一次继续回滚
 		log.ErrorErrorf(err, "migrate single slot failed")
-		t.UpdateStatus(MIGRATE_TASK_ERR)
+		if t.canceled.Get() {
+			t.UpdateStatus(MIGRATE_TASK_CANCELING)
+		} else {
+			t.UpdateStatus(MIGRATE_TASK_ERR)
+		}
 		// 还原slot状态为ONLINE
 		t.rollbackPremigrate()
 		return err
@@ -175,6 +210,36 @@ func (t *MigrateTask) rollbackPremigrate() {
 	}
 }
 
+// 取消正在迁移的slot，把已经迁移到目标group的key迁移回来，slot回到原来的group
+// 回滚的过程不受暂停和限速的控制，也不能再被取消
+func (t *MigrateTask) rollbackMigrate(s *models.Slot, from, to int) error {
+	log.Warnf("rollback slot %d, migrate back from group %d to group %d", s.Id, to, from)
+	t.UpdateStatus(MIGRATE_TASK_CANCELING)
+	t.manager = nil
+
+	if err := s.SetMigrateStatus(t.zkConn, to, from); err != nil {
+		log.ErrorErrorf(err, "set migrate status failed")
+		return err
+	}
+	err := t.Migrate(s, to, from, func(p SlotMigrateProgress) {
+		if p.Remain%5000 == 0 {
+			log.Infof("rollback %+v", p)
+		}
+	})
+	if err != nil {
+		log.ErrorErrorf(err, "rollback slot failed")
+		return err
+	}
+	s.State.Status = models.SLOT_STATUS_ONLINE
+	s.State.MigrateStatus.From = models.INVALID_ID
+	s.State.MigrateStatus.To = models.INVALID_ID
+	if err := s.Update(t.zkConn); err != nil {
+		log.ErrorErrorf(err, "update zk status failed, should be: %+v", s)
+		return err
+	}
+	return ErrMigrateCanceled
+}
+
 var ErrGroupMasterNotFound = errors.New("group master not found")
 
 // will block until all keys are migrated
@@ -213,8 +278,13 @@ func (task *MigrateTask) Migrate(slot *models.Slot, fromGroup, toGroup int, onPr
 
 	defer c.Close()
 
+	var throttle = &migrateThrottle{addr: fromMaster.Addr}
+	if task.manager != nil && task.manager.waitResume(task) {
+		return ErrMigrateCanceled
+	}
+
 	// 通过向redis发送 SLOTSMGRTTAGSLOT 命令，执行迁移操作
-	_, remain, err := utils.SlotsMgrtTagSlot(c, slot.Id, toMaster.Addr)
+	succ, remain, err := utils.SlotsMgrtTagSlot(c, slot.Id, toMaster.Addr)
 	if err != nil {
 		return err
 	}
@@ -224,7 +294,13 @@ func (task *MigrateTask) Migrate(slot *models.Slot, fromGroup, toGroup int, onPr
 		if task.Delay > 0 {
 			time.Sleep(time.Duration(task.Delay) * time.Millisecond)
 		}
-		_, remain, err = utils.SlotsMgrtTagSlot(c, slot.Id, toMaster.Addr)
+		if task.manager != nil {
+			throttle.wait(task, succ)
+			if task.manager.waitResume(task) {
+				return ErrMigrateCanceled
+			}
+		}
+		succ, remain, err = utils.SlotsMgrtTagSlot(c, slot.Id, toMaster.Addr)
 		if remain >= 0 {
 			// 每5000个key打印一下日志
 			onProgress(SlotMigrateProgress{
@@ -262,3 +338,59 @@ func (t *MigrateTask) preMigrateCheck() error {
 	}
 	return nil
 }
+
+// 迁移限速，每迁移一批key之后按照当前的限速计算下一批可以开始的时间
+type migrateThrottle struct {
+	addr    string // 迁出的redis-server，用于估算key的平均大小
+	keySize int64  // key的平均大小，0表示还没有估算
+	next    time.Time
+}
+
+// 等待到下一批key可以开始迁移，限速在等待过程中被修改或者任务被取消时提前返回
+func (t *migrateThrottle) wait(task *MigrateTask, keys int) {
+	c := task.manager.Control()
+	var d time.Duration
+	if c.KeysPerSecond > 0 {
+		d = time.Duration(keys) * time.Second / time.Duration(c.KeysPerSecond)
+	}
+	if c.BytesPerSecond > 0 {
+		if t.keySize == 0 {
+			t.keySize = estimateKeySize(t.addr)
+		}
+		if b := time.Duration(int64(keys)*t.keySize) * time.Second / time.Duration(c.BytesPerSecond); b > d {
+			d = b
+		}
+	}
+	now := time.Now()
+	if t.next.Before(now) {
+		t.next = now
+	}
+	t.next = t.next.Add(d)
+	for time.Now().Before(t.next) {
+		if task.canceled.Get() || task.manager.Control() != c {
+			t.next = time.Now()
+			return
+		}
+		sleep := t.next.Sub(time.Now())
+		if sleep > time.Millisecond*100 {
+			sleep = time.Millisecond * 100
+		}
+		time.Sleep(sleep)
+	}
+}
+
+// SLOTSMGRTTAGSLOT 不返回迁移的字节数，按照 used_memory 和 key 的总数估算key的平均大小
+func estimateKeySize(addr string) int64 {
+	stat, err := utils.GetRedisStat(addr, globalEnv.Password())
+	if err != nil {
+		log.WarnErrorf(err, "get redis %s stat failed", addr)
+		return 1
+	}
+	mem, _ := strconv.ParseInt(stat["used_memory"], 10, 64)
+	keys := redisKeys(stat)
+	if mem <= 0 || keys <= 0 {
+		return 1
+	}
+	log.Infof("estimate average key size of %s: %d bytes", addr, mem/keys)
+	return mem / keys
+}
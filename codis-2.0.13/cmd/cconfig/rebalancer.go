@@ -5,6 +5,7 @@ package main
 
 import (
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/wandoulabs/zkhelper"
@@ -15,25 +16,49 @@ import (
 	"github.com/CodisLabs/codis/pkg/utils/log"
 )
 
-type NodeInfo struct {
-	GroupId   int
-	CurSlots  []int
-	MaxMemory int64
+// 和目标数据量相差在 5% 以内就不再迁移
+const rebalanceTolerance = 0.05
+
+// 从 INFO 的 keyspace 中统计所有 db 的 key 数量
+func redisKeys(stat map[string]string) int64 {
+	var keys int64
+	for k, v := range stat {
+		// db0:keys=100,expires=0,avg_ttl=0
+		if !strings.HasPrefix(k, "db") {
+			continue
+		}
+		for _, kv := range strings.Split(v, ",") {
+			if strings.HasPrefix(kv, "keys=") {
+				n, _ := strconv.ParseInt(kv[len("keys="):], 10, 64)
+				keys += n
+			}
+		}
+	}
+	return keys
 }
 
-func getLivingNodeInfos(zkConn zkhelper.Conn) ([]*NodeInfo, error) {
+// 获取每个group的负载，slot 中 key 的数量来自 master 的 SLOTSINFO，内存来自 INFO
+// 只有 online 的 slot 参与计算，正在迁移和 offline 的 slot 不会被迁移
+func getGroupLoads(zkConn zkhelper.Conn) ([]*models.GroupLoad, error) {
 	groups, err := models.ServerGroups(zkConn, globalEnv.ProductName())
 	if err != nil {
 		return nil, errors.Trace(err)
 	}
+	if len(groups) == 0 {
+		return nil, errors.Errorf("no server group")
+	}
 	slots, err := models.Slots(zkConn, globalEnv.ProductName())
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
 	slotMap := make(map[int][]int)
 	for _, slot := range slots {
 		if slot.State.Status == models.SLOT_STATUS_ONLINE {
 			slotMap[slot.GroupId] = append(slotMap[slot.GroupId], slot.Id)
 		}
 	}
-	var ret []*NodeInfo
+
+	var ret []*models.GroupLoad
 	for _, g := range groups {
 		master, err := g.Master(zkConn)
 		if err != nil {
@@ -42,96 +67,56 @@ func getLivingNodeInfos(zkConn zkhelper.Conn) ([]*NodeInfo, error) {
 		if master == nil {
 			return nil, errors.Errorf("group %d has no master", g.Id)
 		}
-		out, err := utils.GetRedisConfig(master.Addr, globalEnv.Password(), "maxmemory")
+		stat, err := utils.GetRedisStat(master.Addr, globalEnv.Password())
 		if err != nil {
 			return nil, errors.Trace(err)
 		}
-		maxMem, err := strconv.ParseInt(out, 10, 64)
+		infos, err := utils.SlotsInfo(master.Addr, globalEnv.Password(), 0, models.DEFAULT_SLOT_NUM-1)
 		if err != nil {
 			return nil, errors.Trace(err)
 		}
-		if maxMem <= 0 {
-			return nil, errors.Errorf("redis %s should set maxmemory", master.Addr)
+		load := &models.GroupLoad{
+			GroupId: g.Id,
+			Keys:    redisKeys(stat),
+			Slots:   make(map[int]int64),
 		}
-		node := &NodeInfo{
-			GroupId:   g.Id,
-			CurSlots:  slotMap[g.Id],
-			MaxMemory: maxMem,
+		// 没有设置 maxmemory 时为 ∞，按照 0 处理
+		load.MaxMemory, _ = strconv.ParseInt(stat["maxmemory"], 10, 64)
+		load.UsedMemory, _ = strconv.ParseInt(stat["used_memory"], 10, 64)
+		for _, id := range slotMap[g.Id] {
+			load.Slots[id] = int64(infos[id])
 		}
-		ret = append(ret, node)
-	}
-	cnt := 0
-	for _, info := range ret {
-		cnt += len(info.CurSlots)
-	}
-	if cnt != models.DEFAULT_SLOT_NUM {
-		return nil, errors.Errorf("not all slots are online")
+		ret = append(ret, load)
 	}
 	return ret, nil
 }
 
-func getQuotaMap(zkConn zkhelper.Conn) (map[int]int, error) {
-	nodes, err := getLivingNodeInfos(zkConn)
+// 根据每个 slot 实际的 key 数量和 key 的平均大小计算迁移计划
+// dryRun 时只返回计划，否则为每个需要迁移的 slot 创建迁移任务
+func Rebalance(delay int, dryRun bool) (*models.RebalancePlan, error) {
+	loads, err := getGroupLoads(safeZkConn)
 	if err != nil {
 		return nil, errors.Trace(err)
 	}
-
-	ret := make(map[int]int)
-	var totalMem int64
-	totalQuota := 0
-	for _, node := range nodes {
-		totalMem += node.MaxMemory
-	}
-
-	for _, node := range nodes {
-		quota := int(models.DEFAULT_SLOT_NUM * node.MaxMemory * 1.0 / totalMem)
-		ret[node.GroupId] = quota
-		totalQuota += quota
-	}
-
-	// round up
-	if totalQuota < models.DEFAULT_SLOT_NUM {
-		for k, _ := range ret {
-			ret[k] += models.DEFAULT_SLOT_NUM - totalQuota
-			break
-		}
-	}
-
-	return ret, nil
-}
-
-// experimental simple auto rebalance :)
-func Rebalance() error {
-	targetQuota, err := getQuotaMap(safeZkConn)
+	plan, err := models.PlanRebalance(loads, rebalanceTolerance)
 	if err != nil {
-		return errors.Trace(err)
+		return nil, errors.Trace(err)
 	}
-	livingNodes, err := getLivingNodeInfos(safeZkConn)
-	if err != nil {
-		return errors.Trace(err)
+	if dryRun {
+		return plan, nil
 	}
-	log.Infof("start rebalance")
-	for _, node := range livingNodes {
-		for len(node.CurSlots) > targetQuota[node.GroupId] {
-			for _, dest := range livingNodes {
-				if dest.GroupId != node.GroupId && len(dest.CurSlots) < targetQuota[dest.GroupId] {
-					slot := node.CurSlots[len(node.CurSlots)-1]
-					// create a migration task
-					info := &MigrateTaskInfo{
-						Delay:      0,
-						SlotId:     slot,
-						NewGroupId: dest.GroupId,
-						Status:     MIGRATE_TASK_PENDING,
-						CreateAt:   strconv.FormatInt(time.Now().Unix(), 10),
-					}
-					globalMigrateManager.PostTask(info)
-
-					node.CurSlots = node.CurSlots[0 : len(node.CurSlots)-1]
-					dest.CurSlots = append(dest.CurSlots, slot)
-				}
-			}
+	log.Infof("start rebalance, %d slots to migrate", len(plan.Moves))
+	for _, m := range plan.Moves {
+		// create a migration task
+		info := &MigrateTaskInfo{
+			Delay:      delay,
+			SlotId:     m.SlotId,
+			NewGroupId: m.To,
+			Status:     MIGRATE_TASK_PENDING,
+			CreateAt:   strconv.FormatInt(time.Now().Unix(), 10),
 		}
+		globalMigrateManager.PostTask(info)
 	}
 	log.Infof("rebalance tasks submit finish")
-	return nil
+	return plan, nil
 }
@@ -9,6 +9,7 @@ import (
 
 	"github.com/docopt/docopt-go"
 
+	"github.com/CodisLabs/codis/pkg/utils/bytesize"
 	"github.com/CodisLabs/codis/pkg/utils/errors"
 	"github.com/CodisLabs/codis/pkg/utils/log"
 )
@@ -21,7 +22,11 @@ func cmdSlot(argv []string) (err error) {
 	codis-config slot set <slot_id> <group_id> <status>
 	codis-config slot range-set <slot_from> <slot_to> <group_id> <status>
 	codis-config slot migrate <slot_from> <slot_to> <group_id> [--delay=<delay_time_in_ms>]
-	codis-config slot rebalance [--delay=<delay_time_in_ms>]
+	codis-config slot migrate-pause
+	codis-config slot migrate-resume
+	codis-config slot migrate-cancel
+	codis-config slot migrate-throttle [--keys=<keys_per_second>] [--bytes=<bytes_per_second>]
+	codis-config slot rebalance [--delay=<delay_time_in_ms>] [--dry-run]
 `
 
 	args, err := docopt.Parse(usage, argv, true, "", false)
@@ -56,6 +61,34 @@ func cmdSlot(argv []string) (err error) {
 		}
 		return runSlotMigrate(slotFrom, slotTo, groupId, delay)
 	}
+	if args["migrate-pause"].(bool) {
+		return runMigrateControl("/api/migrate/pause", nil)
+	}
+	if args["migrate-resume"].(bool) {
+		return runMigrateControl("/api/migrate/resume", nil)
+	}
+	if args["migrate-cancel"].(bool) {
+		return runMigrateControl("/api/migrate/cancel", nil)
+	}
+	if args["migrate-throttle"].(bool) {
+		// 没有指定的限速为0，表示不限制
+		var form migrateThrottleForm
+		if args["--keys"] != nil {
+			form.KeysPerSecond, err = strconv.Atoi(args["--keys"].(string))
+			if err != nil {
+				log.ErrorErrorf(err, "parse <keys_per_second> failed")
+				return errors.Trace(err)
+			}
+		}
+		if args["--bytes"] != nil {
+			form.BytesPerSecond, err = bytesize.Parse(args["--bytes"].(string))
+			if err != nil {
+				log.ErrorErrorf(err, "parse <bytes_per_second> failed")
+				return errors.Trace(err)
+			}
+		}
+		return runMigrateControl("/api/migrate/throttle", form)
+	}
 	if args["rebalance"].(bool) {
 		delay := 0
 		if args["--delay"] != nil {
@@ -65,7 +98,7 @@ func cmdSlot(argv []string) (err error) {
 				return errors.Trace(err)
 			}
 		}
-		return runRebalance(delay)
+		return runRebalance(delay, args["--dry-run"].(bool))
 	}
 
 	if args["init"].(bool) {
@@ -176,9 +209,25 @@ func runSlotMigrate(fromSlotId, toSlotId int, newGroupId int, delay int) error {
 	return nil
 }
 
-func runRebalance(delay int) error {
+// 暂停、继续、取消迁移或者修改限速
+func runMigrateControl(url string, form interface{}) error {
 	var v interface{}
-	err := callApi(METHOD_POST, "/api/rebalance", nil, &v)
+	err := callApi(METHOD_POST, url, form, &v)
+	if err != nil {
+		return err
+	}
+	fmt.Println(jsonify(v))
+	return nil
+}
+
+// dryRun 时只打印迁移计划
+func runRebalance(delay int, dryRun bool) error {
+	url := fmt.Sprintf("/api/rebalance?delay=%d", delay)
+	if dryRun {
+		url += "&dry_run=1"
+	}
+	var v interface{}
+	err := callApi(METHOD_POST, url, nil, &v)
 	if err != nil {
 		return err
 	}
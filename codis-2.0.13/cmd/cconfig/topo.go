@@ -0,0 +1,155 @@
+// Copyright 2016 CodisLabs. All Rights Reserved.
+// Licensed under the MIT (MIT-LICENSE.txt) license.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+
+	"github.com/docopt/docopt-go"
+	"github.com/wandoulabs/go-zookeeper/zk"
+
+	"github.com/CodisLabs/codis/pkg/models"
+	"github.com/CodisLabs/codis/pkg/utils"
+	"github.com/CodisLabs/codis/pkg/utils/errors"
+	"github.com/CodisLabs/codis/pkg/utils/log"
+)
+
+// 直接读写 zk，导入的目标集群通常还没有启动 dashboard
+func cmdTopo(argv []string) (err error) {
+	usage := `usage:
+	codis-config topo export [--output=<file>]
+	codis-config topo import <file> [--product=<name>]
+	codis-config topo diff <file> [<file2>]
+
+options:
+	--output=<file>    write the topology to a file instead of stdout
+	--product=<name>   import into this product instead of the one in config file, it must be empty
+`
+	args, err := docopt.Parse(usage, argv, true, "", false)
+	if err != nil {
+		log.ErrorErrorf(err, "parse args failed")
+		return errors.Trace(err)
+	}
+	log.Debugf("parse args = {%+v}", args)
+
+	if args["export"].(bool) {
+		output, _ := args["--output"].(string)
+		return runTopoExport(output)
+	}
+	if args["import"].(bool) {
+		productName, _ := args["--product"].(string)
+		if productName == "" {
+			productName = globalEnv.ProductName()
+		}
+		return runTopoImport(args["<file>"].(string), productName)
+	}
+	if args["diff"].(bool) {
+		file2, _ := args["<file2>"].(string)
+		return runTopoDiff(args["<file>"].(string), file2)
+	}
+	return nil
+}
+
+func exportTopology() (*models.Topology, error) {
+	conn, err := globalEnv.NewZkConn()
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	defer conn.Close()
+	return models.ExportTopology(conn, globalEnv.ProductName())
+}
+
+func loadTopology(file string) (*models.Topology, error) {
+	b, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	var t models.Topology
+	if err := json.Unmarshal(b, &t); err != nil {
+		return nil, errors.Errorf("parse topology %s failed: %s", file, err)
+	}
+	return &t, nil
+}
+
+func runTopoExport(output string) error {
+	t, err := exportTopology()
+	if err != nil {
+		return err
+	}
+	b, err := json.MarshalIndent(t, "", "  ")
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if output == "" {
+		fmt.Println(string(b))
+		return nil
+	}
+	if err := ioutil.WriteFile(output, append(b, '\n'), 0644); err != nil {
+		return errors.Trace(err)
+	}
+	log.Infof("export %d groups, %d slots, %d proxies of %s to %s", len(t.Groups), len(t.Slots), len(t.Proxies), t.ProductName, output)
+	return nil
+}
+
+func runTopoImport(file string, productName string) error {
+	t, err := loadTopology(file)
+	if err != nil {
+		return err
+	}
+	conn, err := globalEnv.NewZkConn()
+	if err != nil {
+		return errors.Trace(err)
+	}
+	defer conn.Close()
+
+	lock := utils.GetZkLock(conn, productName)
+	if err := lock.LockWithTimeout(0, fmt.Sprintf("topo import %s", file)); err != nil {
+		return errors.Trace(err)
+	}
+	defer func() {
+		err := lock.Unlock()
+		if err != nil && err != zk.ErrNoNode {
+			log.ErrorErrorf(err, "unlock node failed")
+		}
+	}()
+
+	for _, s := range t.Slots {
+		if s.State.Status == models.SLOT_STATUS_MIGRATE || s.State.Status == models.SLOT_STATUS_PRE_MIGRATE {
+			log.Warnf("slot %d is migrating from group %d to %d, it will be online on group %d", s.Id, s.State.MigrateStatus.From, s.GroupId, s.GroupId)
+		}
+	}
+	if err := models.ImportTopology(conn, productName, t); err != nil {
+		return err
+	}
+	log.Infof("import %d groups and %d slots from %s (product %s) into %s", len(t.Groups), len(t.Slots), file, t.ProductName, productName)
+	return nil
+}
+
+// 只给出一个文件时和当前的集群比较
+func runTopoDiff(file, file2 string) error {
+	a, err := loadTopology(file)
+	if err != nil {
+		return err
+	}
+	var b *models.Topology
+	if file2 != "" {
+		b, err = loadTopology(file2)
+	} else {
+		b, err = exportTopology()
+	}
+	if err != nil {
+		return err
+	}
+	diffs := models.DiffTopology(a, b)
+	for _, d := range diffs {
+		fmt.Println(d)
+	}
+	if len(diffs) != 0 {
+		os.Exit(1)
+	}
+	return nil
+}
@@ -6,6 +6,7 @@ package main
 import (
 	"encoding/json"
 	"fmt"
+	"net"
 	"net/http"
 	_ "net/http/pprof"
 	"os"
@@ -58,6 +59,58 @@ func init() {
 	log.SetLevel(log.LEVEL_INFO)
 }
 
+// 设置了这个环境变量时，从父进程继承监听socket，fd 3 是代理端口，fd 4 是http端口
+const inheritEnv = "CODIS_PROXY_INHERIT"
+
+// 继承旧的proxy进程交出的监听socket
+func inheritListeners() (net.Listener, net.Listener) {
+	l, err := net.FileListener(os.NewFile(3, "proxy"))
+	if err != nil {
+		log.PanicErrorf(err, "inherit proxy listener failed")
+	}
+	hl, err := net.FileListener(os.NewFile(4, "http"))
+	if err != nil {
+		log.PanicErrorf(err, "inherit http listener failed")
+	}
+	return l, hl
+}
+
+// 用同样的参数启动一个新的proxy进程，把监听socket交给它
+// 新进程在旧进程从zk下线后注册自己，期间新的连接在socket中排队，不会被拒绝
+func handoff(s *proxy.Server, hl net.Listener) error {
+	f1, err := s.ListenerFile()
+	if err != nil {
+		return err
+	}
+	defer f1.Close()
+	f2, err := hl.(*net.TCPListener).File()
+	if err != nil {
+		return err
+	}
+	defer f2.Close()
+
+	path, err := exec.LookPath(os.Args[0])
+	if err != nil {
+		return err
+	}
+	var env []string
+	for _, e := range os.Environ() {
+		if !strings.HasPrefix(e, inheritEnv+"=") {
+			env = append(env, e)
+		}
+	}
+	env = append(env, inheritEnv+"=1")
+	p, err := os.StartProcess(path, os.Args, &os.ProcAttr{
+		Env:   env,
+		Files: []*os.File{os.Stdin, os.Stdout, os.Stderr, f1, f2},
+	})
+	if err != nil {
+		return err
+	}
+	log.Infof("hand off listeners to new proxy, pid = %d", p.Pid)
+	return p.Release()
+}
+
 func setLogLevel(level string) {
 	level = strings.ToLower(level)
 	var l = log.LEVEL_INFO
@@ -93,6 +146,18 @@ func handleSetLogLevel(w http.ResponseWriter, r *http.Request) {
 	setLogLevel(r.Form.Get("level"))
 }
 
+// 通过http接口获取proxy的慢查询记录，参数n为记录数，默认全部返回
+func handleSlowLog(w http.ResponseWriter, r *http.Request) {
+	r.ParseForm()
+	n, err := strconv.Atoi(r.Form.Get("n"))
+	if err != nil {
+		n = -1
+	}
+	b, _ := json.MarshalIndent(router.GetSlowLog(n), "", "  ")
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(b)
+}
+
 // 检查 ulimit -n 是否大于min
 func checkUlimit(min int) {
 	ulimitN, err := exec.Command("/bin/sh", "-c", "ulimit -n").Output()
@@ -177,8 +242,17 @@ func main() {
 
 	// 可通过http请求动态调整日志级别
 	http.HandleFunc("/setloglevel", handleSetLogLevel)
+	// 获取慢查询记录
+	http.HandleFunc("/slowlog", handleSlowLog)
+
+	var l, hl net.Listener
+	if os.Getenv(inheritEnv) != "" {
+		l, hl = inheritListeners()
+	} else if hl, err = net.Listen("tcp", httpAddr); err != nil {
+		log.PanicErrorf(err, "open http listener failed")
+	}
 	go func() {
-		err := http.ListenAndServe(httpAddr, nil)
+		err := http.Serve(hl, nil)
 		log.PanicError(err, "http debug server quit")
 	}()
 	log.Info("running on ", addr)
@@ -192,10 +266,18 @@ func main() {
 	// 捕获 SIGTERM 信号
 	c := make(chan os.Signal, 1)
 	signal.Notify(c, os.Interrupt, syscall.SIGTERM, os.Kill)
+	// 捕获 SIGUSR2 信号，把监听socket交给新的proxy进程
+	u := make(chan os.Signal, 1)
+	signal.Notify(u, syscall.SIGUSR2)
 
 	// 建立一个新的proxy-server，会开启相关协程处理 redis-client 的请求，和后端 redis-server 建立连接
 	// 是主要的逻辑处理部分
-	s := proxy.New(addr, httpAddr, conf)
+	var s *proxy.Server
+	if l != nil {
+		s = proxy.NewWithListener(l, addr, httpAddr, conf)
+	} else {
+		s = proxy.New(addr, httpAddr, conf)
+	}
 	defer s.Close()
 
 	// stats包 提供了一个http接口获取相关信息  /debug/vars
@@ -203,6 +285,10 @@ func main() {
 		var m = make(map[string]interface{})
 		m["ops"] = router.OpCounts()
 		m["cmds"] = router.GetAllOpStats()
+		m["backends"] = router.GetAllBackendStats()
+		m["limits"] = router.GetAllLimitStats()
+		m["mirror"] = router.GetMirrorStats()
+		m["hotkeys"] = router.GetHotKeys()
 		m["info"] = s.Info()
 		m["build"] = map[string]interface{}{
 			"version": utils.Version,
@@ -213,9 +299,21 @@ func main() {
 	})
 
 	go func() {
-		<-c
-		log.Info("ctrl-c or SIGTERM found, bye bye...")
-		s.Close()
+		for {
+			select {
+			case <-c:
+				log.Info("ctrl-c or SIGTERM found, bye bye...")
+			case <-u:
+				log.Info("SIGUSR2 found, hand off listeners to new proxy")
+				if err := handoff(s, hl); err != nil {
+					log.ErrorErrorf(err, "hand off failed, keep serving")
+					continue
+				}
+			}
+			// 优雅退出，等待正在处理的请求返回
+			s.Drain()
+			return
+		}
 	}()
 
 	// 等待1秒后将自己的状态设置为online
@@ -0,0 +1,109 @@
+// Copyright 2016 CodisLabs. All Rights Reserved.
+// Licensed under the MIT (MIT-LICENSE.txt) license.
+
+package models
+
+import (
+	"encoding/json"
+	"fmt"
+	"path"
+	"sort"
+	"strconv"
+
+	"github.com/CodisLabs/codis/pkg/utils/errors"
+	"github.com/wandoulabs/go-zookeeper/zk"
+	"github.com/wandoulabs/zkhelper"
+)
+
+// 自动故障切换的一次决策，保存在zk上便于事后查看
+type FailoverRecord struct {
+	Id        string `json:"id"`
+	GroupId   int    `json:"group_id"`
+	OldMaster string `json:"old_master"`
+	NewMaster string `json:"new_master"`      // 为空表示没有找到可以提升的slave
+	Offset    int64  `json:"offset"`          // 新master的复制偏移量
+	Failures  int    `json:"failures"`        // 切换前连续探测失败的次数
+	Error     string `json:"error,omitempty"` // 切换失败的原因
+	Ts        string `json:"ts"`
+}
+
+func (self *FailoverRecord) String() string {
+	b, _ := json.MarshalIndent(self, "", "  ")
+	return string(b)
+}
+
+// zk上保存切换记录的路径
+func GetFailoverPath(productName string) string {
+	return fmt.Sprintf("/zk/codis/db_%s/failovers", productName)
+}
+
+// 在 failovers 下创建顺序节点记录这次切换
+func NewFailoverRecord(zkConn zkhelper.Conn, productName string, r *FailoverRecord) error {
+	prefix := GetFailoverPath(productName)
+	if err := CreateActionRootPath(zkConn, prefix); err != nil {
+		return errors.Trace(err)
+	}
+	b, err := json.Marshal(r)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	p, err := zkConn.Create(prefix+"/", b, int32(zk.FlagSequence), zkhelper.DefaultFileACLs())
+	if err != nil {
+		return errors.Trace(err)
+	}
+	r.Id = path.Base(p)
+	return nil
+}
+
+// 获取所有的切换记录，按时间先后排序
+func FailoverRecords(zkConn zkhelper.Conn, productName string) ([]*FailoverRecord, error) {
+	prefix := GetFailoverPath(productName)
+	exists, err := zkhelper.NodeExists(zkConn, prefix)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	if !exists {
+		return nil, nil
+	}
+	nodes, _, err := zkConn.Children(prefix)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	sort.Strings(nodes)
+
+	var ret []*FailoverRecord
+	for _, node := range nodes {
+		b, _, err := zkConn.Get(path.Join(prefix, node))
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		r := &FailoverRecord{}
+		if err := json.Unmarshal(b, r); err != nil {
+			return nil, errors.Trace(err)
+		}
+		r.Id = node
+		ret = append(ret, r)
+	}
+	return ret, nil
+}
+
+// 根据slave的 INFO 信息选择数据最新的slave，即复制偏移量最大的slave
+// stats 中没有的slave表示无法连接，不参与选择
+func PickPromotion(slaves []*Server, stats map[string]map[string]string) (*Server, int64) {
+	var best *Server
+	var offset int64 = -1
+	for _, s := range slaves {
+		info, ok := stats[s.Addr]
+		if !ok || info["role"] != "slave" {
+			continue
+		}
+		n, err := strconv.ParseInt(info["slave_repl_offset"], 10, 64)
+		if err != nil {
+			continue
+		}
+		if n > offset {
+			best, offset = s, n
+		}
+	}
+	return best, offset
+}
@@ -0,0 +1,51 @@
+// Copyright 2016 CodisLabs. All Rights Reserved.
+// Licensed under the MIT (MIT-LICENSE.txt) license.
+
+package models
+
+import (
+	"testing"
+
+	"github.com/CodisLabs/codis/pkg/utils/assert"
+	"github.com/wandoulabs/zkhelper"
+)
+
+func TestPickPromotion(t *testing.T) {
+	slaves := []*Server{
+		NewServer(SERVER_TYPE_SLAVE, "127.0.0.1:1111"),
+		NewServer(SERVER_TYPE_SLAVE, "127.0.0.1:2222"),
+		NewServer(SERVER_TYPE_SLAVE, "127.0.0.1:3333"),
+		NewServer(SERVER_TYPE_SLAVE, "127.0.0.1:4444"),
+	}
+	stats := map[string]map[string]string{
+		"127.0.0.1:1111": {"role": "slave", "slave_repl_offset": "100"},
+		"127.0.0.1:2222": {"role": "slave", "slave_repl_offset": "300"},
+		"127.0.0.1:3333": {"role": "master", "slave_repl_offset": "500"},
+	}
+	s, offset := PickPromotion(slaves, stats)
+	assert.Must(s == slaves[1] && offset == 300)
+
+	s, offset = PickPromotion(slaves, nil)
+	assert.Must(s == nil && offset == -1)
+}
+
+func TestFailoverRecord(t *testing.T) {
+	fakeZkConn := zkhelper.NewConn()
+
+	records, err := FailoverRecords(fakeZkConn, productName)
+	assert.MustNoError(err)
+	assert.Must(len(records) == 0)
+
+	for i := 1; i <= 3; i++ {
+		r := &FailoverRecord{GroupId: i, OldMaster: "127.0.0.1:1111", NewMaster: "127.0.0.1:2222"}
+		assert.MustNoError(NewFailoverRecord(fakeZkConn, productName, r))
+		assert.Must(r.Id != "")
+	}
+
+	records, err = FailoverRecords(fakeZkConn, productName)
+	assert.MustNoError(err)
+	assert.Must(len(records) == 3)
+	for i, r := range records {
+		assert.Must(r.GroupId == i+1 && r.NewMaster == "127.0.0.1:2222")
+	}
+}
@@ -0,0 +1,226 @@
+// Copyright 2016 CodisLabs. All Rights Reserved.
+// Licensed under the MIT (MIT-LICENSE.txt) license.
+
+package models
+
+import (
+	"sort"
+
+	"github.com/CodisLabs/codis/pkg/utils/errors"
+)
+
+// 一个group的负载，用于计算 rebalance 的迁移计划
+type GroupLoad struct {
+	GroupId    int
+	MaxMemory  int64         // 0 表示没有设置，所有group都设置时按照 maxmemory 的比例分配数据
+	UsedMemory int64         // master 的 used_memory
+	Keys       int64         // master 上所有 key 的数量，和 used_memory 一起估算 key 的平均大小
+	Slots      map[int]int64 // 可以迁移的 slot 以及其中 key 的数量
+}
+
+// 迁移计划中的一个 slot
+type SlotMove struct {
+	SlotId int   `json:"slot_id"`
+	From   int   `json:"from"`
+	To     int   `json:"to"`
+	Keys   int64 `json:"keys"`
+	Bytes  int64 `json:"bytes"` // 估算的数据量
+}
+
+// 迁移前后每个group估算的数据量
+type GroupBalance struct {
+	GroupId     int   `json:"group_id"`
+	Target      int64 `json:"target"` // 按照容量分配的数据量
+	Before      int64 `json:"before"`
+	After       int64 `json:"after"`
+	SlotsBefore int   `json:"slots_before"`
+	SlotsAfter  int   `json:"slots_after"`
+}
+
+type RebalancePlan struct {
+	Moves  []*SlotMove     `json:"moves"`
+	Groups []*GroupBalance `json:"groups"`
+}
+
+type groupState struct {
+	*GroupLoad
+	balance *GroupBalance
+	slots   map[int]int64 // 当前的 slot 以及估算的数据量
+	load    int64
+}
+
+func (g *groupState) diff() int64 {
+	return g.load - g.balance.Target
+}
+
+// 根据每个 slot 估算的数据量计算迁移计划，每次把一个 slot 从超出目标最多的group迁移到低于目标最多的group
+// 优先选择不会越过目标的最大的 slot，这样迁移的 slot 最少
+// 所有group和目标的差距都在 tolerance 比例以内，或者再迁移也不能改善时结束
+// 集群中没有数据时按照 slot 的个数分配
+func PlanRebalance(loads []*GroupLoad, tolerance float64) (*RebalancePlan, error) {
+	if len(loads) == 0 {
+		return nil, errors.New("no group to rebalance")
+	}
+
+	// 没有 key 的group使用整个集群的平均大小
+	var usedMemory, keys int64
+	for _, l := range loads {
+		if l.UsedMemory > 0 && l.Keys > 0 {
+			usedMemory += l.UsedMemory
+			keys += l.Keys
+		}
+	}
+	var avg int64 = 1
+	if keys != 0 && usedMemory/keys > 0 {
+		avg = usedMemory / keys
+	}
+
+	var groups []*groupState
+	var total int64
+	var byCount = true
+	for _, l := range loads {
+		g := &groupState{
+			GroupLoad: l,
+			balance:   &GroupBalance{GroupId: l.GroupId},
+			slots:     make(map[int]int64),
+		}
+		size := avg
+		if l.UsedMemory > 0 && l.Keys > 0 && l.UsedMemory/l.Keys > 0 {
+			size = l.UsedMemory / l.Keys
+		}
+		for id, n := range l.Slots {
+			g.slots[id] = n * size
+			if n != 0 {
+				byCount = false
+			}
+		}
+		groups = append(groups, g)
+	}
+	for _, g := range groups {
+		if byCount {
+			for id := range g.slots {
+				g.slots[id] = 1
+			}
+		}
+		for _, w := range g.slots {
+			g.load += w
+		}
+		g.balance.Before, g.balance.SlotsBefore = g.load, len(g.slots)
+		total += g.load
+	}
+
+	// 目标数据量按照 maxmemory 的比例分配，有一个group没有设置时平均分配
+	var capacity int64
+	var byMemory = true
+	for _, g := range groups {
+		if g.MaxMemory <= 0 {
+			byMemory = false
+		}
+		capacity += g.MaxMemory
+	}
+	var assigned int64
+	for i, g := range groups {
+		switch {
+		case i == len(groups)-1:
+			g.balance.Target = total - assigned
+		case byMemory:
+			g.balance.Target = int64(float64(total) * float64(g.MaxMemory) / float64(capacity))
+		default:
+			g.balance.Target = total / int64(len(groups))
+		}
+		assigned += g.balance.Target
+	}
+
+	var moves = make(map[int]*SlotMove)
+	var order []int
+	for i := 0; i < DEFAULT_SLOT_NUM; i++ {
+		over, under := groups[0], groups[0]
+		for _, g := range groups {
+			if g.diff() > over.diff() {
+				over = g
+			}
+			if g.diff() < under.diff() {
+				under = g
+			}
+		}
+		if over == under || (float64(over.diff()) <= tolerance*float64(over.balance.Target) &&
+			float64(-under.diff()) <= tolerance*float64(under.balance.Target)) {
+			break
+		}
+		id, ok := pickSlot(over, under)
+		if !ok {
+			break
+		}
+		w := over.slots[id]
+		delete(over.slots, id)
+		under.slots[id] = w
+		over.load -= w
+		under.load += w
+
+		// 同一个 slot 被迁移多次时只保留最终的结果
+		if m := moves[id]; m != nil {
+			m.To = under.GroupId
+		} else {
+			moves[id] = &SlotMove{SlotId: id, From: over.GroupId, To: under.GroupId, Keys: over.Slots[id], Bytes: w}
+			order = append(order, id)
+		}
+	}
+
+	plan := &RebalancePlan{Moves: []*SlotMove{}}
+	for _, id := range order {
+		if m := moves[id]; m.From != m.To {
+			plan.Moves = append(plan.Moves, m)
+		}
+	}
+	for _, g := range groups {
+		g.balance.After, g.balance.SlotsAfter = g.load, len(g.slots)
+		plan.Groups = append(plan.Groups, g.balance)
+	}
+	return plan, nil
+}
+
+// 优先选择不超过双方差距的最大的 slot，没有的话选择迁移之后差距最小的 slot，不能缩小差距时返回 false
+func pickSlot(over, under *groupState) (int, bool) {
+	want := over.diff()
+	if -under.diff() < want {
+		want = -under.diff()
+	}
+	var ids []int
+	for id, w := range over.slots {
+		if w > 0 {
+			ids = append(ids, id)
+		}
+	}
+	sort.Ints(ids)
+
+	var best = -1
+	for _, id := range ids {
+		if w := over.slots[id]; w <= want && (best < 0 || w > over.slots[best]) {
+			best = id
+		}
+	}
+	if best >= 0 {
+		return best, true
+	}
+
+	var cost = func(w int64) int64 {
+		a, b := over.diff()-w, under.diff()+w
+		if a < 0 {
+			a = -a
+		}
+		if b < 0 {
+			b = -b
+		}
+		if a > b {
+			return a
+		}
+		return b
+	}
+	var current = cost(0)
+	for _, id := range ids {
+		if c := cost(over.slots[id]); c < current && (best < 0 || c < cost(over.slots[best])) {
+			best = id
+		}
+	}
+	return best, best >= 0
+}
@@ -0,0 +1,92 @@
+// Copyright 2016 CodisLabs. All Rights Reserved.
+// Licensed under the MIT (MIT-LICENSE.txt) license.
+
+package models
+
+import (
+	"testing"
+
+	"github.com/CodisLabs/codis/pkg/utils/assert"
+)
+
+func slotKeys(from, to int, keys int64) map[int]int64 {
+	m := make(map[int]int64)
+	for i := from; i <= to; i++ {
+		m[i] = keys
+	}
+	return m
+}
+
+func balanceOf(plan *RebalancePlan, groupId int) *GroupBalance {
+	for _, g := range plan.Groups {
+		if g.GroupId == groupId {
+			return g
+		}
+	}
+	return nil
+}
+
+func TestPlanRebalanceByKeys(t *testing.T) {
+	// group1 的 slot 数量少但是数据多，新加入的 group3 没有数据
+	loads := []*GroupLoad{
+		{GroupId: 1, UsedMemory: 8000, Keys: 800, Slots: slotKeys(0, 3, 200)},
+		{GroupId: 2, UsedMemory: 2000, Keys: 200, Slots: slotKeys(4, 1023, 0)},
+		{GroupId: 3},
+	}
+	loads[1].Slots[4] = 200
+
+	plan, err := PlanRebalance(loads, 0.05)
+	assert.MustNoError(err)
+	assert.Must(len(plan.Moves) == 2)
+	for _, m := range plan.Moves {
+		assert.Must(m.From == 1 && m.To == 3 && m.Keys == 200 && m.Bytes == 2000)
+	}
+	for _, id := range []int{1, 2, 3} {
+		b := balanceOf(plan, id)
+		assert.Must(b.Target == 3333 || b.Target == 3334)
+	}
+	assert.Must(balanceOf(plan, 1).Before == 8000 && balanceOf(plan, 1).After == 4000)
+	assert.Must(balanceOf(plan, 1).SlotsAfter == 2)
+
+	// 已经在误差范围内时不需要迁移
+	plan, err = PlanRebalance(loads, 2)
+	assert.MustNoError(err)
+	assert.Must(len(plan.Moves) == 0)
+}
+
+func TestPlanRebalanceByMaxMemory(t *testing.T) {
+	loads := []*GroupLoad{
+		{GroupId: 1, MaxMemory: 1000, UsedMemory: 400, Keys: 40, Slots: slotKeys(0, 3, 10)},
+		{GroupId: 2, MaxMemory: 3000, Slots: map[int]int64{}},
+	}
+	plan, err := PlanRebalance(loads, 0)
+	assert.MustNoError(err)
+	assert.Must(len(plan.Moves) == 3)
+	assert.Must(balanceOf(plan, 1).Target == 100 && balanceOf(plan, 1).After == 100)
+	assert.Must(balanceOf(plan, 2).Target == 300 && balanceOf(plan, 2).After == 300)
+}
+
+func TestPlanRebalanceEmpty(t *testing.T) {
+	// 没有数据时按照 slot 的个数平均分配
+	loads := []*GroupLoad{
+		{GroupId: 1, Slots: slotKeys(0, DEFAULT_SLOT_NUM-1, 0)},
+		{GroupId: 2, Slots: map[int]int64{}},
+	}
+	plan, err := PlanRebalance(loads, 0)
+	assert.MustNoError(err)
+	assert.Must(len(plan.Moves) == DEFAULT_SLOT_NUM/2)
+	assert.Must(balanceOf(plan, 1).SlotsAfter == DEFAULT_SLOT_NUM/2)
+	assert.Must(balanceOf(plan, 2).SlotsAfter == DEFAULT_SLOT_NUM/2)
+
+	// 只有一个很大的 slot 时迁移不能改善
+	loads = []*GroupLoad{
+		{GroupId: 1, UsedMemory: 1000, Keys: 100, Slots: slotKeys(0, 0, 100)},
+		{GroupId: 2},
+	}
+	plan, err = PlanRebalance(loads, 0)
+	assert.MustNoError(err)
+	assert.Must(len(plan.Moves) == 0)
+
+	_, err = PlanRebalance(nil, 0)
+	assert.Must(err != nil)
+}
@@ -352,6 +352,11 @@ func (self *ServerGroup) AddServer(zkConn zkhelper.Conn, s *Server, passwd strin
 		if err != nil {
 			return errors.Trace(err)
 		}
+		// 通知proxies有新的slave，开启读写分离的proxy需要建立连接
+		err = NewAction(zkConn, self.ProductName, ACTION_TYPE_SERVER_GROUP_CHANGED, self, "", false)
+		if err != nil {
+			return errors.Trace(err)
+		}
 	}
 
 	return nil
@@ -0,0 +1,295 @@
+// Copyright 2016 CodisLabs. All Rights Reserved.
+// Licensed under the MIT (MIT-LICENSE.txt) license.
+
+package models
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/wandoulabs/go-zookeeper/zk"
+	"github.com/wandoulabs/zkhelper"
+
+	"github.com/CodisLabs/codis/pkg/utils/errors"
+)
+
+// 导出格式的版本，格式不兼容时增加
+const TOPO_VERSION = 1
+
+// 集群在 zk 上的完整布局：server group、redis-server、slot 和 proxy
+type Topology struct {
+	Version     int            `json:"version"`
+	ProductName string         `json:"product_name"`
+	CreateAt    string         `json:"create_at"`
+	Groups      []*ServerGroup `json:"groups"`
+	Slots       []*Slot        `json:"slots"`
+	Proxies     []ProxyInfo    `json:"proxies"`
+}
+
+type groupsById []*ServerGroup
+
+func (s groupsById) Len() int           { return len(s) }
+func (s groupsById) Less(i, j int) bool { return s[i].Id < s[j].Id }
+func (s groupsById) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+
+type serversByAddr []*Server
+
+func (s serversByAddr) Len() int           { return len(s) }
+func (s serversByAddr) Less(i, j int) bool { return s[i].Addr < s[j].Addr }
+func (s serversByAddr) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+
+type slotsById []*Slot
+
+func (s slotsById) Len() int           { return len(s) }
+func (s slotsById) Less(i, j int) bool { return s[i].Id < s[j].Id }
+func (s slotsById) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+
+type proxiesById []ProxyInfo
+
+func (s proxiesById) Len() int           { return len(s) }
+func (s proxiesById) Less(i, j int) bool { return s[i].Id < s[j].Id }
+func (s proxiesById) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+
+// 从 zk 读取集群的布局，按照 id 排序，相同的布局导出的内容相同
+func ExportTopology(zkConn zkhelper.Conn, productName string) (*Topology, error) {
+	t := &Topology{
+		Version:     TOPO_VERSION,
+		ProductName: productName,
+		CreateAt:    strconv.FormatInt(time.Now().Unix(), 10),
+		Groups:      []*ServerGroup{},
+		Slots:       []*Slot{},
+	}
+
+	// 新的集群可能还没有 servers 和 slots 节点
+	exists, err := zkhelper.NodeExists(zkConn, fmt.Sprintf("/zk/codis/db_%s/servers", productName))
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	if exists {
+		if t.Groups, err = ServerGroups(zkConn, productName); err != nil {
+			return nil, errors.Trace(err)
+		}
+	}
+	exists, err = zkhelper.NodeExists(zkConn, GetSlotBasePath(productName))
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	if exists {
+		if t.Slots, err = Slots(zkConn, productName); err != nil {
+			return nil, errors.Trace(err)
+		}
+	}
+	if t.Proxies, err = ProxyList(zkConn, productName, nil); err != nil {
+		return nil, errors.Trace(err)
+	}
+
+	for _, g := range t.Groups {
+		if g.Servers == nil {
+			g.Servers = []*Server{}
+		}
+		sort.Sort(serversByAddr(g.Servers))
+	}
+	sort.Sort(groupsById(t.Groups))
+	sort.Sort(slotsById(t.Slots))
+	sort.Sort(proxiesById(t.Proxies))
+	return t, nil
+}
+
+// 在一个空的集群中按照导出的布局创建 server group、redis-server 和 slot
+// 只写入 zk 上的信息，不会对 redis-server 执行 SLAVEOF，proxy 启动之后自己注册
+// 正在迁移的 slot 在迁移的目标 group 上 online，没有迁移完的 key 需要手动处理
+func ImportTopology(zkConn zkhelper.Conn, productName string, t *Topology) error {
+	if t.Version != TOPO_VERSION {
+		return errors.Errorf("unsupported topology version %d, expect %d", t.Version, TOPO_VERSION)
+	}
+	if len(t.Slots) != 0 && len(t.Slots) != DEFAULT_SLOT_NUM {
+		return errors.Errorf("expect %d slots, got %d", DEFAULT_SLOT_NUM, len(t.Slots))
+	}
+
+	// 只能导入到空的集群，避免覆盖已有的布局
+	for _, zkPath := range []string{fmt.Sprintf("/zk/codis/db_%s/servers", productName), GetSlotBasePath(productName)} {
+		children, _, err := zkConn.Children(zkPath)
+		if err != nil && !zkhelper.ZkErrorEqual(err, zk.ErrNoNode) {
+			return errors.Trace(err)
+		}
+		if len(children) != 0 {
+			return errors.Errorf("product %s is not empty, %s has %d children", productName, zkPath, len(children))
+		}
+	}
+
+	var groups = make(map[int]bool)
+	for _, g := range t.Groups {
+		if groups[g.Id] {
+			return errors.Errorf("duplicate server group %d", g.Id)
+		}
+		groups[g.Id] = true
+		if err := NewServerGroup(productName, g.Id).Create(zkConn); err != nil {
+			return errors.Trace(err)
+		}
+		for _, s := range g.Servers {
+			s := &Server{Type: s.Type, GroupId: g.Id, Addr: s.Addr}
+			val, err := json.Marshal(s)
+			if err != nil {
+				return errors.Trace(err)
+			}
+			zkPath := fmt.Sprintf("/zk/codis/db_%s/servers/group_%d/%s", productName, g.Id, s.Addr)
+			_, err = zkhelper.CreateOrUpdate(zkConn, zkPath, string(val), 0, zkhelper.DefaultFileACLs(), true)
+			if err != nil {
+				return errors.Trace(err)
+			}
+		}
+	}
+	if len(t.Slots) == 0 {
+		return nil
+	}
+
+	if err := InitSlotSet(zkConn, productName, DEFAULT_SLOT_NUM); err != nil {
+		return errors.Trace(err)
+	}
+	var slots = make([]*Slot, DEFAULT_SLOT_NUM)
+	for _, s := range t.Slots {
+		if s.Id < 0 || s.Id >= DEFAULT_SLOT_NUM || slots[s.Id] != nil {
+			return errors.Errorf("invalid or duplicate slot %d", s.Id)
+		}
+		if s.State.Status != SLOT_STATUS_OFFLINE && !groups[s.GroupId] {
+			return errors.Errorf("slot %d belongs to group %d which is not found", s.Id, s.GroupId)
+		}
+		slots[s.Id] = s
+	}
+
+	// 连续的 group 和状态相同的 slot 一起设置，减少通知的数量
+	status := func(s *Slot) SlotStatus {
+		if s.State.Status == SLOT_STATUS_OFFLINE {
+			return SLOT_STATUS_OFFLINE
+		}
+		return SLOT_STATUS_ONLINE
+	}
+	for from := 0; from < DEFAULT_SLOT_NUM; {
+		to := from
+		for to+1 < DEFAULT_SLOT_NUM && slots[to+1].GroupId == slots[from].GroupId && status(slots[to+1]) == status(slots[from]) {
+			to++
+		}
+		if groups[slots[from].GroupId] {
+			if err := SetSlotRange(zkConn, productName, from, to, slots[from].GroupId, status(slots[from])); err != nil {
+				return errors.Trace(err)
+			}
+		}
+		from = to + 1
+	}
+	return nil
+}
+
+// 比较两个布局，返回每一处不同，- 表示只在 a 中，+ 表示只在 b 中，~ 表示两边不同
+// 不比较集群名称和导出时间，proxy 只比较地址和状态
+func DiffTopology(a, b *Topology) []string {
+	var diffs []string
+	var add = func(format string, args ...interface{}) {
+		diffs = append(diffs, fmt.Sprintf(format, args...))
+	}
+
+	var groupsA, groupsB = make(map[int]*ServerGroup), make(map[int]*ServerGroup)
+	var ids []int
+	for _, g := range a.Groups {
+		groupsA[g.Id] = g
+		ids = append(ids, g.Id)
+	}
+	for _, g := range b.Groups {
+		groupsB[g.Id] = g
+		if groupsA[g.Id] == nil {
+			ids = append(ids, g.Id)
+		}
+	}
+	sort.Ints(ids)
+	for _, id := range ids {
+		ga, gb := groupsA[id], groupsB[id]
+		switch {
+		case gb == nil:
+			add("- group %d", id)
+			continue
+		case ga == nil:
+			add("+ group %d", id)
+			continue
+		}
+		var servers = make(map[string]*Server)
+		for _, s := range ga.Servers {
+			servers[s.Addr] = s
+		}
+		for _, s := range gb.Servers {
+			if sa := servers[s.Addr]; sa == nil {
+				add("+ group %d server %s %s", id, s.Addr, s.Type)
+			} else if sa.Type != s.Type {
+				add("~ group %d server %s %s -> %s", id, s.Addr, sa.Type, s.Type)
+			}
+			delete(servers, s.Addr)
+		}
+		var addrs []string
+		for addr := range servers {
+			addrs = append(addrs, addr)
+		}
+		sort.Strings(addrs)
+		for _, addr := range addrs {
+			add("- group %d server %s %s", id, addr, servers[addr].Type)
+		}
+	}
+
+	// 相邻的变化相同的 slot 合并成一行
+	var slotDesc = func(s *Slot) string {
+		if s.State.Status == SLOT_STATUS_MIGRATE || s.State.Status == SLOT_STATUS_PRE_MIGRATE {
+			return fmt.Sprintf("group %d %s from %d", s.GroupId, s.State.Status, s.State.MigrateStatus.From)
+		}
+		return fmt.Sprintf("group %d %s", s.GroupId, s.State.Status)
+	}
+	var descA, descB = make([]string, DEFAULT_SLOT_NUM), make([]string, DEFAULT_SLOT_NUM)
+	for i := 0; i < DEFAULT_SLOT_NUM; i++ {
+		descA[i], descB[i] = "none", "none"
+	}
+	for _, s := range a.Slots {
+		if s.Id >= 0 && s.Id < DEFAULT_SLOT_NUM {
+			descA[s.Id] = slotDesc(s)
+		}
+	}
+	for _, s := range b.Slots {
+		if s.Id >= 0 && s.Id < DEFAULT_SLOT_NUM {
+			descB[s.Id] = slotDesc(s)
+		}
+	}
+	for from := 0; from < DEFAULT_SLOT_NUM; {
+		to := from
+		for to+1 < DEFAULT_SLOT_NUM && descA[to+1] == descA[from] && descB[to+1] == descB[from] {
+			to++
+		}
+		if descA[from] != descB[from] {
+			if from == to {
+				add("~ slot %d: %s -> %s", from, descA[from], descB[from])
+			} else {
+				add("~ slot %d-%d: %s -> %s", from, to, descA[from], descB[from])
+			}
+		}
+		from = to + 1
+	}
+
+	var proxies = make(map[string]ProxyInfo)
+	for _, p := range a.Proxies {
+		proxies[p.Id] = p
+	}
+	for _, p := range b.Proxies {
+		if pa, ok := proxies[p.Id]; !ok {
+			add("+ proxy %s %s %s", p.Id, p.Addr, p.State)
+		} else if pa.Addr != p.Addr || pa.State != p.State {
+			add("~ proxy %s %s %s -> %s %s", p.Id, pa.Addr, pa.State, p.Addr, p.State)
+		}
+		delete(proxies, p.Id)
+	}
+	var names []string
+	for id := range proxies {
+		names = append(names, id)
+	}
+	sort.Strings(names)
+	for _, id := range names {
+		add("- proxy %s %s %s", id, proxies[id].Addr, proxies[id].State)
+	}
+	return diffs
+}
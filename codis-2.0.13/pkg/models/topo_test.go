@@ -0,0 +1,86 @@
+// Copyright 2016 CodisLabs. All Rights Reserved.
+// Licensed under the MIT (MIT-LICENSE.txt) license.
+
+package models
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/CodisLabs/codis/pkg/utils/assert"
+	"github.com/wandoulabs/zkhelper"
+)
+
+func TestTopology(t *testing.T) {
+	fakeZkConn := zkhelper.NewConn()
+
+	// 空的集群
+	topo, err := ExportTopology(fakeZkConn, productName)
+	assert.MustNoError(err)
+	assert.Must(topo.Version == TOPO_VERSION && len(topo.Groups) == 0 && len(topo.Slots) == 0)
+
+	// 添加 slave 时会执行 SLAVEOF，这里通过导入创建源集群
+	source := &Topology{
+		Version: TOPO_VERSION,
+		Groups: []*ServerGroup{
+			{Id: 2, Servers: []*Server{NewServer(SERVER_TYPE_MASTER, "localhost:2222")}},
+			{Id: 1, Servers: []*Server{NewServer(SERVER_TYPE_SLAVE, "localhost:1112"), NewServer(SERVER_TYPE_MASTER, "localhost:1111")}},
+		},
+	}
+	for i := 0; i < DEFAULT_SLOT_NUM; i++ {
+		s := NewSlot(productName, i)
+		s.GroupId, s.State.Status = 1+i/512, SLOT_STATUS_ONLINE
+		source.Slots = append(source.Slots, s)
+	}
+	assert.MustNoError(ImportTopology(fakeZkConn, productName, source))
+	_, err = CreateProxyInfo(fakeZkConn, productName, &ProxyInfo{Id: "proxy_1", Addr: "localhost:19000", State: PROXY_STATE_ONLINE})
+	assert.MustNoError(err)
+
+	topo, err = ExportTopology(fakeZkConn, productName)
+	assert.MustNoError(err)
+	assert.Must(len(topo.Groups) == 2 && len(topo.Slots) == DEFAULT_SLOT_NUM && len(topo.Proxies) == 1)
+	assert.Must(topo.Groups[0].Id == 1 && len(topo.Groups[0].Servers) == 2)
+	assert.Must(topo.Groups[0].Servers[1].Addr == "localhost:1112" && topo.Groups[0].Servers[1].Type == SERVER_TYPE_SLAVE)
+	assert.Must(topo.Slots[600].Id == 600 && topo.Slots[600].GroupId == 2)
+
+	// 经过 json 之后导入到另一个集群
+	b, err := json.Marshal(topo)
+	assert.MustNoError(err)
+	var clone Topology
+	assert.MustNoError(json.Unmarshal(b, &clone))
+	assert.MustNoError(ImportTopology(fakeZkConn, "clone", &clone))
+	assert.Must(ImportTopology(fakeZkConn, "clone", &clone) != nil)
+
+	imported, err := ExportTopology(fakeZkConn, "clone")
+	assert.MustNoError(err)
+	assert.Must(imported.ProductName == "clone" && len(imported.Proxies) == 0)
+	diffs := DiffTopology(topo, imported)
+	assert.Must(len(diffs) == 1 && diffs[0] == "- proxy proxy_1 localhost:19000 online")
+
+	// 修改之后的差异
+	g, err := GetGroup(fakeZkConn, "clone", 2)
+	assert.MustNoError(err)
+	assert.MustNoError(g.AddServer(fakeZkConn, NewServer(SERVER_TYPE_OFFLINE, "localhost:2223"), ""))
+	assert.MustNoError(NewServerGroup("clone", 3).Create(fakeZkConn))
+	s, err := GetSlot(fakeZkConn, "clone", 100)
+	assert.MustNoError(err)
+	assert.MustNoError(s.SetMigrateStatus(fakeZkConn, 1, 3))
+
+	imported, err = ExportTopology(fakeZkConn, "clone")
+	assert.MustNoError(err)
+	diffs = DiffTopology(topo, imported)
+	assert.Must(len(diffs) == 4)
+	assert.Must(diffs[0] == "+ group 2 server localhost:2223 offline")
+	assert.Must(diffs[1] == "+ group 3")
+	assert.Must(diffs[2] == "~ slot 100: group 1 online -> group 3 migrate from 1")
+	assert.Must(diffs[3] == "- proxy proxy_1 localhost:19000 online")
+
+	// 正在迁移的 slot 导入之后在目标 group 上 online
+	assert.MustNoError(ImportTopology(fakeZkConn, "clone2", imported))
+	s, err = GetSlot(fakeZkConn, "clone2", 100)
+	assert.MustNoError(err)
+	assert.Must(s.GroupId == 3 && s.State.Status == SLOT_STATUS_ONLINE)
+
+	imported.Version = TOPO_VERSION + 1
+	assert.Must(ImportTopology(fakeZkConn, "clone3", imported) != nil)
+}
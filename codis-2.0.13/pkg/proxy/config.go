@@ -4,8 +4,12 @@
 package proxy
 
 import (
+	"crypto/tls"
+	"io/ioutil"
+	"strconv"
 	"strings"
 
+	"github.com/CodisLabs/codis/pkg/proxy/router"
 	"github.com/CodisLabs/codis/pkg/utils/log"
 	"github.com/c4pt0r/cfg"
 )
@@ -25,6 +29,31 @@ type Config struct {
 	maxBufSize       int // 每个client连接的缓冲区大小
 	maxPipeline      int // pipeline最大值
 	zkSessionTimeout int // zk连接超时时间，单位 ms
+	pubsubGroup      int // pub/sub 命令转发到的group，0表示不支持
+	backendPoolSize  int // 每个redis-server建立的连接数
+	drainTimeout     int // seconds，下线时等待会话处理完请求的最长时间
+	backendTimeout   int // seconds，等待redis返回的超时时间
+	breakerFailures  int // 连续失败多少次后熔断，0表示不熔断
+	breakerCooldown  int // seconds，熔断之后多久探测后端是否恢复
+
+	readPolicy router.ReadPolicy // 读写分离策略
+	readMaxLag int               // seconds，slave允许落后master的时间
+
+	rateLimit router.RateLimitConfig // 限流配置
+
+	slowlogSlowerThan int // us，耗时超过这个值的请求记录为慢查询，0表示不记录
+	slowlogMaxLen     int // 最多保存的慢查询记录数
+
+	tls        *tls.Config // 客户端连接proxy使用的TLS配置，nil表示不使用TLS
+	backendTLS *tls.Config // proxy连接redis使用的TLS配置，nil表示不使用TLS
+
+	tenants []*router.Tenant // 租户列表
+
+	mirrorAddrs []string          // 流量复制到的影子集群地址，为空表示不复制
+	mirrorMode  router.MirrorMode // 复制哪些命令
+	mirrorRate  int               // 复制的请求百分比
+
+	hotkeySampleRate int // 统计热点key时采样的请求百分比
 }
 
 // 加载配置文件
@@ -71,6 +100,102 @@ func LoadConf(configFile string) (*Config, error) {
 	conf.maxBufSize = loadConfInt("session_max_bufsize", 131072)
 	conf.maxPipeline = loadConfInt("session_max_pipeline", 1024)
 	conf.zkSessionTimeout = loadConfInt("zk_session_timeout", 30000)
+	conf.pubsubGroup = loadConfInt("pubsub_group", 0)
+	conf.backendPoolSize = loadConfInt("backend_pool_size", 1)
+	if conf.backendPoolSize == 0 {
+		log.Panicf("invalid config: backend_pool_size = 0")
+	}
+	conf.drainTimeout = loadConfInt("drain_timeout", 30)
+	conf.backendTimeout = loadConfInt("backend_timeout", 60)
+	if conf.backendTimeout == 0 {
+		log.Panicf("invalid config: backend_timeout = 0")
+	}
+	conf.breakerFailures = loadConfInt("breaker_failures", 0)
+	conf.breakerCooldown = loadConfInt("breaker_cooldown", 5)
+
+	policy, _ := c.ReadString("read_policy", "master-only")
+	if p, err := router.ParseReadPolicy(policy); err != nil {
+		log.PanicErrorf(err, "invalid config: read_policy in %s", configFile)
+	} else {
+		conf.readPolicy = p
+	}
+	conf.readMaxLag = loadConfInt("read_max_lag", 15)
+
+	conf.slowlogSlowerThan = loadConfInt("slowlog_slower_than", 10000)
+	conf.slowlogMaxLen = loadConfInt("slowlog_max_len", 128)
+
+	conf.rateLimit.PerClient = loadConfInt("ratelimit_per_client", 0)
+	conf.rateLimit.PerAuth = loadConfInt("ratelimit_per_auth", 0)
+	classes, _ := c.ReadString("ratelimit_per_class", "")
+	if m, err := router.ParseClassLimits(classes); err != nil {
+		log.PanicErrorf(err, "invalid config: ratelimit_per_class in %s", configFile)
+	} else {
+		conf.rateLimit.PerClass = m
+	}
+
+	certFile, _ := c.ReadString("tls_cert_file", "")
+	keyFile, _ := c.ReadString("tls_key_file", "")
+	clientCAFile, _ := c.ReadString("tls_client_ca_file", "")
+	if certFile != "" || keyFile != "" {
+		if t, err := loadServerTLS(certFile, keyFile, clientCAFile); err != nil {
+			log.PanicErrorf(err, "invalid config: tls_cert_file/tls_key_file in %s", configFile)
+		} else {
+			conf.tls = t
+		}
+	}
+
+	backendTLS, _ := c.ReadString("backend_tls", "false")
+	if enabled, err := strconv.ParseBool(backendTLS); err != nil {
+		log.PanicErrorf(err, "invalid config: backend_tls in %s", configFile)
+	} else if enabled {
+		caFile, _ := c.ReadString("backend_tls_ca_file", "")
+		certFile, _ := c.ReadString("backend_tls_cert_file", "")
+		keyFile, _ := c.ReadString("backend_tls_key_file", "")
+		if t, err := loadBackendTLS(caFile, certFile, keyFile); err != nil {
+			log.PanicErrorf(err, "invalid config: backend_tls_* in %s", configFile)
+		} else {
+			conf.backendTLS = t
+		}
+	}
+
+	tenantsFile, _ := c.ReadString("tenants_file", "")
+	if tenantsFile != "" {
+		b, err := ioutil.ReadFile(tenantsFile)
+		if err != nil {
+			log.PanicErrorf(err, "invalid config: read tenants_file %s failed", tenantsFile)
+		}
+		if conf.tenants, err = router.ParseTenants(b); err != nil {
+			log.PanicErrorf(err, "invalid config: parse tenants_file %s failed", tenantsFile)
+		}
+		for _, t := range conf.tenants {
+			if t.Password == conf.passwd {
+				log.Panicf("invalid config: password of tenant %s is the same as password", t.Name)
+			}
+		}
+	}
+
+	mirrorAddrs, _ := c.ReadString("mirror_addrs", "")
+	for _, addr := range strings.Split(mirrorAddrs, ",") {
+		if addr = strings.TrimSpace(addr); addr != "" {
+			conf.mirrorAddrs = append(conf.mirrorAddrs, addr)
+		}
+	}
+	mode, _ := c.ReadString("mirror_mode", "all")
+	if m, err := router.ParseMirrorMode(mode); err != nil {
+		log.PanicErrorf(err, "invalid config: mirror_mode in %s", configFile)
+	} else {
+		conf.mirrorMode = m
+	}
+	conf.mirrorRate = loadConfInt("mirror_rate", 0)
+	if conf.mirrorRate > 100 {
+		log.Panicf("invalid config: mirror_rate = %d", conf.mirrorRate)
+	}
+
+	conf.hotkeySampleRate = loadConfInt("hotkey_sample_rate", 0)
+	if conf.hotkeySampleRate > 100 {
+		log.Panicf("invalid config: hotkey_sample_rate = %d", conf.hotkeySampleRate)
+	}
+
 	if conf.zkSessionTimeout <= 100 {
 		conf.zkSessionTimeout *= 1000
 		log.Warn("zkSessionTimeout is to small, it is ms not second")
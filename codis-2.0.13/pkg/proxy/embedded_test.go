@@ -0,0 +1,162 @@
+// Copyright 2016 CodisLabs. All Rights Reserved.
+// Licensed under the MIT (MIT-LICENSE.txt) license.
+
+package proxy
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/garyburd/redigo/redis"
+	"github.com/wandoulabs/zkhelper"
+
+	"github.com/CodisLabs/codis/pkg/models"
+	"github.com/CodisLabs/codis/pkg/proxy/router"
+	"github.com/CodisLabs/codis/pkg/utils"
+	"github.com/CodisLabs/codis/pkg/utils/assert"
+	"github.com/CodisLabs/codis/pkg/utils/coordinator"
+	"github.com/CodisLabs/codis/pkg/utils/fakeserver"
+)
+
+// 在内嵌的协调服务中创建集群，每个地址对应一个 group，所有 slot 都在 group1
+// 启动 proxy 并等待它上线，返回协调服务的连接、proxy 和一个连接到 proxy 的客户端
+func startEmbedded(productName string, port int, addrs ...string) (zkhelper.Conn, *Server, redis.Conn) {
+	var storeName = "proxy_" + productName
+	conn, err := coordinator.NewConn("embedded", storeName, 0)
+	assert.MustNoError(err)
+
+	assert.MustNoError(models.CreateActionRootPath(conn, models.GetWatchActionPath(productName)))
+	assert.MustNoError(models.InitSlotSet(conn, productName, 1024))
+	for i, addr := range addrs {
+		g := models.NewServerGroup(productName, i+1)
+		assert.MustNoError(g.Create(conn))
+		assert.MustNoError(g.AddServer(conn, models.NewServer(models.SERVER_TYPE_MASTER, addr), ""))
+	}
+	assert.MustNoError(models.SetSlotRange(conn, productName, 0, 1023, 1, models.SLOT_STATUS_ONLINE))
+
+	p := New(fmt.Sprintf(":%d", port), fmt.Sprintf(":%d", port-8000), &Config{
+		proxyId:          productName,
+		productName:      productName,
+		zkAddr:           storeName,
+		provider:         "embedded",
+		proto:            "tcp4",
+		zkSessionTimeout: 30000,
+	})
+	proxies, err := models.ProxyList(conn, productName, nil)
+	assert.MustNoError(err)
+	assert.Must(len(proxies) == 1 && proxies[0].State == models.PROXY_STATE_OFFLINE)
+	assert.MustNoError(models.SetProxyStatus(conn, productName, productName, models.PROXY_STATE_ONLINE))
+
+	for i := 0; ; i++ {
+		if c, err := redis.Dial("tcp", fmt.Sprintf("localhost:%d", port)); err == nil {
+			if _, err = c.Do("PING"); err == nil {
+				return conn, p, c
+			}
+			c.Close()
+		}
+		assert.Must(i < 100)
+		time.Sleep(time.Millisecond * 100)
+	}
+}
+
+// 下线之后 proxy 的节点被删除
+func stopEmbedded(conn zkhelper.Conn, productName string, p *Server) {
+	assert.MustNoError(models.SetProxyStatus(conn, productName, productName, models.PROXY_STATE_MARK_OFFLINE))
+	p.Join()
+	proxies, err := models.ProxyList(conn, productName, nil)
+	assert.MustNoError(err)
+	assert.Must(len(proxies) == 0)
+	fences, err := models.GetFenceProxyMap(conn, productName)
+	assert.MustNoError(err)
+	assert.Must(len(fences) == 0)
+}
+
+// proxy 通过内嵌的协调服务上线、响应 slot 变更的通知、下线
+func TestEmbeddedCoordinator(t *testing.T) {
+	const productName = "embedded"
+	conn, p, c := startEmbedded(productName, 19300, redis1.Addr(), redis2.Addr())
+	defer c.Close()
+
+	// key2 所在的 slot 之后会改到 group2
+	var key1, key2 string
+	for i := 0; key1 == "" || key2 == ""; i++ {
+		key := fmt.Sprintf("embedded_key%d", i)
+		if router.HashSlot([]byte(key)) < 512 {
+			key1 = key
+		} else {
+			key2 = key
+		}
+	}
+
+	_, err := c.Do("SET", key1, "1")
+	assert.MustNoError(err)
+	v, err := redis1.Get(key1)
+	assert.Must(err == nil && v == "1")
+
+	// 修改 slot 时等待 proxy 回复通知，和迁移完成时一样
+	slot, err := models.GetSlot(conn, productName, router.HashSlot([]byte(key2)))
+	assert.MustNoError(err)
+	slot.GroupId = 2
+	assert.MustNoError(slot.Update(conn))
+	_, err = c.Do("SET", key2, "2")
+	assert.MustNoError(err)
+	v, err = redis2.Get(key2)
+	assert.Must(err == nil && v == "2")
+
+	stopEmbedded(conn, productName, p)
+}
+
+// 迁移中的 slot，proxy 先把 key 从原来的 codis-server 迁移过来再执行命令
+func TestEmbeddedMigrate(t *testing.T) {
+	const productName = "embedded_migrate"
+	s1, err := fakeserver.Run()
+	assert.MustNoError(err)
+	defer s1.Close()
+	s2, err := fakeserver.Run()
+	assert.MustNoError(err)
+	defer s2.Close()
+
+	conn, p, c := startEmbedded(productName, 19301, s1.Addr(), s2.Addr())
+	defer c.Close()
+
+	const key = "{migrate}1"
+	_, err = c.Do("SET", key, "1")
+	assert.MustNoError(err)
+	_, err = c.Do("SADD", "{migrate}2", "a", "b")
+	assert.MustNoError(err)
+	_, err = c.Do("SET", "migrate", "3")
+	assert.MustNoError(err)
+	assert.Must(len(s1.Keys()) == 3)
+
+	slot, err := models.GetSlot(conn, productName, router.HashSlot([]byte(key)))
+	assert.MustNoError(err)
+	assert.MustNoError(slot.SetMigrateStatus(conn, 1, 2))
+
+	// 访问的 key 以及 hash tag 相同的 key 一起被迁移
+	v, err := redis.String(c.Do("GET", key))
+	assert.Must(err == nil && v == "1")
+	assert.Must(s2.Exists(key) && s2.Exists("{migrate}2") && !s1.Exists(key))
+
+	// 和 codis-config 一样迁移剩下的 key
+	m, err := utils.DialTo(s1.Addr(), "")
+	assert.MustNoError(err)
+	defer m.Close()
+	for {
+		_, remain, err := utils.SlotsMgrtTagSlot(m, slot.Id, s2.Addr())
+		assert.MustNoError(err)
+		if remain == 0 {
+			break
+		}
+	}
+	slot.State.Status = models.SLOT_STATUS_ONLINE
+	slot.State.MigrateStatus.From = models.INVALID_ID
+	slot.State.MigrateStatus.To = models.INVALID_ID
+	assert.MustNoError(slot.Update(conn))
+
+	assert.Must(len(s1.Keys()) == 0 && len(s2.Keys()) == 3)
+	n, err := redis.Int(c.Do("SCARD", "{migrate}2"))
+	assert.Must(err == nil && n == 2)
+
+	stopEmbedded(conn, productName, p)
+}
@@ -5,6 +5,7 @@ package proxy
 
 import (
 	"bytes"
+	"crypto/tls"
 	"encoding/json"
 	"errors"
 	"net"
@@ -21,6 +22,7 @@ import (
 	"github.com/CodisLabs/codis/pkg/utils/log"
 	"github.com/wandoulabs/go-zookeeper/zk"
 	topo "github.com/wandoulabs/go-zookeeper/zk"
+	"github.com/wandoulabs/zkhelper"
 )
 
 // proxy-server
@@ -35,14 +37,33 @@ type Server struct {
 	evtbus   chan interface{} // 用于监听zk节点，返回节点变更的事件
 	router   *router.Router   // 用于访问后端redis的路由
 	listener net.Listener
+	socket   net.Listener // 没有经过TLS封装的监听socket，可以交给新的proxy进程
 
-	kill chan interface{} // 通过此通道通知close消息
-	wait sync.WaitGroup   // 用于等待proxy结束
-	stop sync.Once
+	// 所有客户端会话，下线时需要逐个关闭
+	sessions struct {
+		sync.Mutex
+		m        map[*router.Session]bool
+		draining bool
+	}
+
+	kill   chan interface{} // 通过此通道通知close消息
+	wait   sync.WaitGroup   // 用于等待proxy结束
+	stop   sync.Once
+	killed sync.Once
 }
 
 // 创建一个 proxy-server
 func New(addr string, debugVarAddr string, conf *Config) *Server {
+	// 监听代理端口
+	l, err := net.Listen(conf.proto, addr)
+	if err != nil {
+		log.PanicErrorf(err, "open listener failed")
+	}
+	return NewWithListener(l, addr, debugVarAddr, conf)
+}
+
+// 使用已经打开的监听socket创建 proxy-server，例如从旧的proxy进程继承的socket
+func NewWithListener(l net.Listener, addr string, debugVarAddr string, conf *Config) *Server {
 	log.Infof("create proxy with config: %+v", conf)
 
 	proxyHost := strings.Split(addr, ":")[0]
@@ -62,6 +83,7 @@ func New(addr string, debugVarAddr string, conf *Config) *Server {
 	}
 
 	s := &Server{conf: conf, lastActionSeq: -1, groups: make(map[int]int)}
+	s.sessions.m = make(map[*router.Session]bool)
 
 	// 创建集群拓扑信息管理对象
 	s.topo = NewTopo(conf.productName, conf.zkAddr, conf.fact, conf.provider, conf.zkSessionTimeout)
@@ -76,14 +98,26 @@ func New(addr string, debugVarAddr string, conf *Config) *Server {
 
 	log.Infof("proxy info = %+v", s.info)
 
-	// 监听代理端口
-	if l, err := net.Listen(conf.proto, addr); err != nil {
-		log.PanicErrorf(err, "open listener failed")
+	s.socket = l
+	if conf.tls != nil {
+		s.listener = tls.NewListener(l, conf.tls)
 	} else {
 		s.listener = l
 	}
 	// 创建一个访问后端redis的路由
 	s.router = router.NewWithAuth(conf.passwd)
+	s.router.SetReadPolicy(conf.readPolicy, conf.readMaxLag)
+	s.router.SetPoolSize(conf.backendPoolSize)
+	router.SetBackendTLS(conf.backendTLS)
+	router.SetBreaker(conf.breakerFailures, time.Second*time.Duration(conf.breakerCooldown))
+	if conf.backendTimeout != 0 {
+		router.SetBackendTimeout(time.Second * time.Duration(conf.backendTimeout))
+	}
+	router.SetTenants(conf.tenants)
+	router.SetHotKeySampleRate(conf.hotkeySampleRate)
+	s.router.SetMirror(conf.mirrorAddrs, conf.mirrorMode, conf.mirrorRate)
+	router.SetRateLimit(conf.rateLimit)
+	router.SetSlowLog(int64(conf.slowlogSlowerThan), conf.slowlogMaxLen)
 	s.evtbus = make(chan interface{}, 1024)
 
 	// 在zk上注册自身的信息，包括proxy和fence节点
@@ -134,9 +168,12 @@ func (s *Server) serve() {
 	for i := 0; i < router.MaxSlotNum; i++ {
 		s.fillSlot(i)
 	}
+	s.fillPubSub()
+	s.fillClusterNodes()
 	log.Info("proxy is serving")
 	go func() {
-		defer s.close()
+		// 无法接受新的连接时下线
+		defer s.shutdown()
 		// 处理 redis 客户端的连接
 		s.handleConns()
 	}()
@@ -147,6 +184,9 @@ func (s *Server) serve() {
 	// 2. 检测到zk上有状态变更，进行相应的处理
 	// 3. 每隔指定间隔时间，向后端的redis-server发送心跳包
 	s.loopEvents()
+
+	// 已经下线，等待正在处理的请求返回后关闭所有会话
+	s.drainSessions(time.Second * time.Duration(s.conf.drainTimeout))
 }
 
 // 处理 redis 客户端的连接
@@ -158,8 +198,12 @@ func (s *Server) handleConns() {
 	go func() {
 		for c := range ch {
 			x := router.NewSessionSize(c, s.conf.passwd, s.conf.maxBufSize, s.conf.maxTimeout)
-			// 针对一个redis-client连接的处理函数，会将请求交由 s.router 转发给后端 redis-server
-			go x.Serve(s.router, s.conf.maxPipeline)
+			s.addSession(x)
+			go func() {
+				defer s.delSession(x)
+				// 针对一个redis-client连接的处理函数，会将请求交由 s.router 转发给后端 redis-server
+				x.Serve(s.router, s.conf.maxPipeline)
+			}()
 		}
 	}()
 
@@ -188,12 +232,32 @@ func (s *Server) Join() {
 	s.wait.Wait()
 }
 
+// 立即关闭proxy，正在处理的请求会失败
 func (s *Server) Close() error {
 	s.close()
 	s.wait.Wait()
 	return nil
 }
 
+// 优雅地关闭proxy：从zk上下线，不再接受新的连接，
+// 等待会话处理完已经收到的请求，超过 drain_timeout 之后强制关闭
+func (s *Server) Drain() error {
+	s.shutdown()
+	s.wait.Wait()
+	return nil
+}
+
+// 监听socket的一个副本，新启动的proxy进程可以继续使用这个socket接受连接，客户端不会被拒绝
+func (s *Server) ListenerFile() (*os.File, error) {
+	l, ok := s.socket.(interface {
+		File() (*os.File, error)
+	})
+	if !ok {
+		return nil, errors.New("listener can not be handed off")
+	}
+	return l.File()
+}
+
 func (s *Server) close() {
 	// 确保只执行一次
 	s.stop.Do(func() {
@@ -202,10 +266,68 @@ func (s *Server) close() {
 		if s.router != nil {
 			s.router.Close()
 		}
+		s.closeSessions()
+		s.shutdown()
+	})
+}
+
+// 通知proxy下线
+func (s *Server) shutdown() {
+	s.killed.Do(func() {
 		close(s.kill)
 	})
 }
 
+func (s *Server) addSession(x *router.Session) {
+	s.sessions.Lock()
+	defer s.sessions.Unlock()
+	s.sessions.m[x] = true
+	// 开始下线后才建立的会话也需要关闭
+	if s.sessions.draining {
+		x.Drain()
+	}
+}
+
+func (s *Server) delSession(x *router.Session) {
+	s.sessions.Lock()
+	defer s.sessions.Unlock()
+	delete(s.sessions.m, x)
+}
+
+func (s *Server) numSessions() int {
+	s.sessions.Lock()
+	defer s.sessions.Unlock()
+	return len(s.sessions.m)
+}
+
+func (s *Server) closeSessions() {
+	s.sessions.Lock()
+	defer s.sessions.Unlock()
+	for x := range s.sessions.m {
+		x.Close()
+	}
+}
+
+// 不再接受新的连接，空闲的会话立即关闭，其他会话在请求都返回后关闭，超时之后强制关闭所有会话
+func (s *Server) drainSessions(timeout time.Duration) {
+	s.listener.Close()
+	s.sessions.Lock()
+	s.sessions.draining = true
+	for x := range s.sessions.m {
+		x.Drain()
+	}
+	s.sessions.Unlock()
+
+	deadline := time.Now().Add(timeout)
+	for s.numSessions() != 0 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond * 10)
+	}
+	if n := s.numSessions(); n != 0 {
+		log.Warnf("drain timeout, force to close %d sessions", n)
+		s.closeSessions()
+	}
+}
+
 // 重新监听该proxy节点，关注自身的状态变更
 func (s *Server) rewatchProxy() {
 	// 监听zk上此proxy节点
@@ -227,8 +349,18 @@ func (s *Server) rewatchNodes() []string {
 // 在zk上注册自身的信息，包括proxy和fence节点
 func (s *Server) register() {
 	// 在zk上创建自身的proxy信息
-	if _, err := s.topo.CreateProxyInfo(&s.info); err != nil {
-		log.PanicErrorf(err, "create proxy node failed")
+	// 节点已经存在时，可能是交出监听socket的旧proxy进程还没有下线，或者是被强制杀掉的进程的zk会话还没有过期，等待节点被删除
+	deadline := time.Now().Add(time.Millisecond * time.Duration(s.conf.zkSessionTimeout))
+	for {
+		_, err := s.topo.CreateProxyInfo(&s.info)
+		if err == nil {
+			break
+		}
+		if !zkhelper.ZkErrorEqual(err, zk.ErrNodeExists) || time.Now().After(deadline) {
+			log.PanicErrorf(err, "create proxy node failed")
+		}
+		log.Warnf("proxy node %s exists, wait for it to be removed", s.info.Id)
+		time.Sleep(time.Second)
 	}
 	// 在fence节点上创建proxy信息
 	if _, err := s.topo.CreateProxyFenceNode(&s.info); err != nil && err != zk.ErrNodeExists {
@@ -327,6 +459,17 @@ func groupMaster(groupInfo models.ServerGroup) string {
 	return master
 }
 
+// 获取一个group中所有slave的地址
+func groupSlaves(groupInfo models.ServerGroup) []string {
+	var slaves []string
+	for _, server := range groupInfo.Servers {
+		if server.Type == models.SERVER_TYPE_SLAVE {
+			slaves = append(slaves, server.Addr)
+		}
+	}
+	return slaves
+}
+
 // 重置指定slot信息
 func (s *Server) resetSlot(i int) {
 	s.router.ResetSlot(i)
@@ -344,6 +487,7 @@ func (s *Server) fillSlot(i int) {
 	var from string
 	// 获取一个group中处于master身份的redis-server的地址
 	var addr = groupMaster(*slotGroup)
+	var slaves = groupSlaves(*slotGroup)
 	if slotInfo.State.Status == models.SLOT_STATUS_MIGRATE {
 		fromGroup, err := s.topo.GetGroup(slotInfo.State.MigrateStatus.From)
 		if err != nil {
@@ -358,10 +502,40 @@ func (s *Server) fillSlot(i int) {
 	// 将slot所在groupId加入到map中
 	s.groups[i] = slotInfo.GroupId
 	// 填充指定slot的信息，建立与所在redis-server的连接
-	s.router.FillSlot(i, addr, from,
+	s.router.FillSlot(i, addr, from, slaves,
 		slotInfo.State.Status == models.SLOT_STATUS_PRE_MIGRATE)
 }
 
+// 获取所有online状态的proxy，用于 CLUSTER 命令
+func (s *Server) fillClusterNodes() {
+	proxies, err := s.topo.ProxyList(func(pi *models.ProxyInfo) bool {
+		return pi.State == models.PROXY_STATE_ONLINE
+	})
+	if err != nil {
+		log.WarnErrorf(err, "get proxy list failed")
+		return
+	}
+	var addrs []string
+	for _, pi := range proxies {
+		addrs = append(addrs, pi.Addr)
+	}
+	s.router.SetClusterNodes(addrs, s.info.Addr)
+}
+
+// 获取 pub/sub 所在group的master地址，建立连接
+func (s *Server) fillPubSub() {
+	if s.conf.pubsubGroup == 0 {
+		return
+	}
+	group, err := s.topo.GetGroup(s.conf.pubsubGroup)
+	if err != nil {
+		log.WarnErrorf(err, "get pubsub group %d failed", s.conf.pubsubGroup)
+		s.router.FillPubSub("")
+		return
+	}
+	s.router.FillPubSub(groupMaster(*group))
+}
+
 // 批量更新slots状态信息
 func (s *Server) onSlotRangeChange(param *models.SlotMultiSetParam) {
 	log.Infof("slotRangeChange %+v", param)
@@ -385,6 +559,9 @@ func (s *Server) onGroupChange(groupId int) {
 			s.fillSlot(i)
 		}
 	}
+	if groupId == s.conf.pubsubGroup {
+		s.fillPubSub()
+	}
 }
 
 // 回复通知，就是在 ActionResponse 的 seq 节点下创建以自己 proxy_id 命名的节点
@@ -531,7 +708,7 @@ func (s *Server) loopEvents() {
 	ticker := time.NewTicker(time.Second)
 	defer ticker.Stop()
 
-	var tick int = 0
+	var tick, clusterTick int = 0, 0
 	for s.info.State == models.PROXY_STATE_ONLINE {
 		select {
 		case <-s.kill:
@@ -557,6 +734,10 @@ func (s *Server) loopEvents() {
 			// 处理 zk 上的 watch 节点变更的通知，主要有两种，一种是自身proxy的状态变更，一种是 action 通知消息的更新
 			s.processAction(e)
 		case <-ticker.C:
+			// 开启读写分离时，每秒检查一次slave的复制状态
+			if s.conf.readPolicy != router.ReadMasterOnly {
+				s.router.CheckReplicas()
+			}
 			// 每隔5秒钟向后端 redis-server 发送心跳包
 			if maxTick := s.conf.pingPeriod; maxTick != 0 {
 				if tick++; tick >= maxTick {
@@ -564,6 +745,11 @@ func (s *Server) loopEvents() {
 					tick = 0
 				}
 			}
+			// 每隔10秒钟更新一次 CLUSTER 命令展示的proxy列表
+			if clusterTick++; clusterTick >= 10 {
+				s.fillClusterNodes()
+				clusterTick = 0
+			}
 		}
 	}
 }
@@ -4,7 +4,10 @@
 package proxy
 
 import (
+	"fmt"
 	"io"
+	"net"
+	"strings"
 	"sync/atomic"
 	"testing"
 	"time"
@@ -14,6 +17,7 @@ import (
 	"github.com/wandoulabs/zkhelper"
 
 	"github.com/CodisLabs/codis/pkg/models"
+	"github.com/CodisLabs/codis/pkg/proxy/router"
 	"github.com/CodisLabs/codis/pkg/utils/assert"
 )
 
@@ -33,6 +37,7 @@ func init() {
 		zkAddr:      "localhost:2181",
 		fact:        func(string, int) (zkhelper.Conn, error) { return conn, nil },
 		proto:       "tcp4",
+		pubsubGroup: 1,
 	}
 
 	//init action path
@@ -176,6 +181,49 @@ func TestMultiKeyRedisCmd(t *testing.T) {
 	}
 }
 
+func TestCrossSlotRedisCmd(t *testing.T) {
+	c, err := redis.Dial("tcp", "localhost:19000")
+	assert.MustNoError(err)
+	defer c.Close()
+
+	// 这些key分布在不同的slot和group
+	c.Do("SADD", "xs_s1", "a", "b", "c")
+	c.Do("SADD", "xs_h1", "b", "c", "d")
+	c.Do("SADD", "xs_h2", "c", "x")
+
+	members, err := redis.Strings(c.Do("SINTER", "xs_s1", "xs_h1", "xs_h2"))
+	assert.Must(err == nil && strings.Join(members, " ") == "c")
+	members, err = redis.Strings(c.Do("SUNION", "xs_s1", "xs_h1", "xs_h2"))
+	assert.Must(err == nil && len(members) == 5)
+	members, err = redis.Strings(c.Do("SDIFF", "xs_s1", "xs_h1"))
+	assert.Must(err == nil && strings.Join(members, " ") == "a")
+
+	n, err := redis.Int(c.Do("EXISTS", "xs_s1", "xs_h1", "xs_nokey", "xs_s1"))
+	assert.Must(err == nil && n == 3)
+	n, err = redis.Int(c.Do("TOUCH", "xs_s1", "xs_h1", "xs_nokey"))
+	assert.Must(err == nil && n == 2)
+
+	// 有一个key已经存在时不设置任何key
+	n, err = redis.Int(c.Do("MSETNX", "xs_k1", "v1", "xs_z1", "v2"))
+	assert.Must(err == nil && n == 1)
+	n, err = redis.Int(c.Do("MSETNX", "xs_z1", "v3", "xs_z2", "v4"))
+	assert.Must(err == nil && n == 0)
+	values, err := redis.Strings(c.Do("MGET", "xs_k1", "xs_z1", "xs_z2"))
+	assert.Must(err == nil && values[0] == "v1" && values[1] == "v2" && values[2] == "")
+
+	n, err = redis.Int(c.Do("PFCOUNT", "xs_x1", "xs_x2"))
+	assert.Must(err == nil && n == 0)
+	_, err = c.Do("PFCOUNT", "xs_k1", "xs_z1")
+	assert.Must(err != nil && strings.HasPrefix(err.Error(), "WRONGTYPE"))
+
+	// 后端返回的错误不会断开连接
+	_, err = c.Do("SINTER", "xs_s1", "xs_z1")
+	assert.Must(err != nil && strings.HasPrefix(err.Error(), "WRONGTYPE"))
+
+	n, err = redis.Int(c.Do("UNLINK", "xs_s1", "xs_h1", "xs_h2", "xs_k1", "xs_z1"))
+	assert.Must(err == nil && n == 5)
+}
+
 func TestInvalidRedisCmdUnknown(t *testing.T) {
 	c, err := redis.Dial("tcp", "localhost:19000")
 	if err != nil {
@@ -227,6 +275,610 @@ func TestInvalidRedisCmdEcho(t *testing.T) {
 	}
 }
 
+func TestTransaction(t *testing.T) {
+	c, err := redis.Dial("tcp", "localhost:19000")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	c.Send("MULTI")
+	c.Send("SET", "{txn}key1", "1")
+	c.Send("INCR", "{txn}key1")
+	reply, err := redis.Values(c.Do("EXEC"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(reply) != 2 || reply[0] != "OK" || reply[1] != int64(2) {
+		t.Error("bad exec reply", reply)
+	}
+
+	if _, err := c.Do("WATCH", "{txn}key1"); err != nil {
+		t.Fatal(err)
+	}
+	c.Send("MULTI")
+	c.Send("GET", "{txn}key1")
+	if reply, err := redis.Strings(c.Do("EXEC")); err != nil || len(reply) != 1 || reply[0] != "2" {
+		t.Error("bad exec reply", reply, err)
+	}
+
+	if _, err := c.Do("MULTI"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.Do("SET", "{txn}key1", "1"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.Do("SET", "{txn2}key1", "1"); err == nil {
+		t.Fatal("should be crossslot error")
+	}
+	if _, err := c.Do("EXEC"); err == nil {
+		t.Fatal("should be execabort error")
+	}
+	if got, err := redis.String(c.Do("GET", "{txn}key1")); err != nil || got != "2" {
+		t.Error("'{txn}key1' has the wrong value", got, err)
+	}
+
+	// 参数个数不够的命令在排队时不能导致proxy崩溃
+	c.Send("MULTI")
+	c.Send("BLPOP")
+	c.Send("BRPOPLPUSH", "{txn}key1")
+	c.Send("MSET", "{txn}key1")
+	if _, err := c.Do("DISCARD"); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := redis.String(c.Do("GET", "{txn}key1")); err != nil || got != "2" {
+		t.Error("'{txn}key1' has the wrong value", got, err)
+	}
+}
+
+func TestPubSub(t *testing.T) {
+	c1, err := redis.Dial("tcp", "localhost:19000")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c1.Close()
+	c2, err := redis.Dial("tcp", "localhost:19000")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c2.Close()
+
+	psc := redis.PubSubConn{Conn: c1}
+	if err := psc.Subscribe("news"); err != nil {
+		t.Fatal(err)
+	}
+	if v, ok := psc.Receive().(redis.Subscription); !ok || v.Count != 1 {
+		t.Fatal("bad subscribe reply", v)
+	}
+	if _, err := c1.Do("GET", "foo"); err == nil {
+		t.Fatal("should be error in subscribe mode")
+	}
+
+	if n, err := redis.Int(c2.Do("PUBLISH", "news", "hello")); err != nil || n != 1 {
+		t.Fatal("bad publish reply", n, err)
+	}
+	if v, ok := psc.Receive().(redis.Message); !ok || v.Channel != "news" || string(v.Data) != "hello" {
+		t.Fatal("bad message", v)
+	}
+
+	if err := psc.Unsubscribe("news"); err != nil {
+		t.Fatal(err)
+	}
+	if v, ok := psc.Receive().(redis.Subscription); !ok || v.Count != 0 {
+		t.Fatal("bad unsubscribe reply", v)
+	}
+	if _, err := c1.Do("SET", "foo", "bar"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestBlockingPop(t *testing.T) {
+	c1, err := redis.Dial("tcp", "localhost:19000")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c1.Close()
+	c2, err := redis.Dial("tcp", "localhost:19000")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c2.Close()
+
+	if reply, err := c1.Do("BLPOP", "jobs", "1"); err != nil || reply != nil {
+		t.Fatal("should be timeout", reply, err)
+	}
+
+	go func() {
+		time.Sleep(500 * time.Millisecond)
+		c2.Do("RPUSH", "jobs", "job1")
+	}()
+	reply, err := redis.Strings(c1.Do("BLPOP", "jobs", "5"))
+	if err != nil || len(reply) != 2 || reply[0] != "jobs" || reply[1] != "job1" {
+		t.Fatal("bad blpop reply", reply, err)
+	}
+
+	if _, err := c1.Do("BLPOP", "jobs", "-1"); err == nil {
+		t.Fatal("should be negative timeout error")
+	}
+	if _, err := c1.Do("BRPOPLPUSH", "{a}jobs", "{b}jobs", "1"); err == nil {
+		t.Fatal("should be crossslot error")
+	}
+	if _, err := c1.Do("SET", "foo", "bar"); err != nil {
+		t.Fatal(err)
+	}
+
+	// pipeline 中的阻塞命令依次执行，回复的顺序和请求一致
+	c3, err := redis.Dial("tcp", "localhost:19000")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c3.Close()
+	if _, err := c3.Do("RPUSH", "pjobs_a", "a1", "a2"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c3.Do("RPUSH", "pjobs_b", "b1"); err != nil {
+		t.Fatal(err)
+	}
+	for _, key := range []string{"pjobs_a", "pjobs_b", "pjobs_a", "pjobs_b"} {
+		c1.Send("BLPOP", key, "1")
+	}
+	c1.Send("GET", "foo")
+	if err := c1.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	for _, expect := range [][]string{{"pjobs_a", "a1"}, {"pjobs_b", "b1"}, {"pjobs_a", "a2"}, nil} {
+		reply, err := redis.Strings(c1.Receive())
+		if expect == nil {
+			if err != redis.ErrNil {
+				t.Fatal("should be timeout", reply, err)
+			}
+			continue
+		}
+		if err != nil || len(reply) != 2 || reply[0] != expect[0] || reply[1] != expect[1] {
+			t.Fatal("bad pipelined blpop reply", reply, err)
+		}
+	}
+	if v, err := redis.String(c1.Receive()); err != nil || v != "bar" {
+		t.Fatal("bad get reply", v, err)
+	}
+}
+
+func TestKeyspace(t *testing.T) {
+	c, err := redis.Dial("tcp", "localhost:19000")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	for i := 0; i < 100; i++ {
+		if _, err := c.Do("SET", fmt.Sprintf("scan_%d", i), i); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	keys, err := redis.Strings(c.Do("KEYS", "scan_*"))
+	if err != nil || len(keys) != 100 {
+		t.Fatal("bad keys reply", len(keys), err)
+	}
+
+	var found = make(map[string]bool)
+	var cursor = "0"
+	for {
+		reply, err := redis.Values(c.Do("SCAN", cursor, "MATCH", "scan_*", "COUNT", 10))
+		if err != nil {
+			t.Fatal(err)
+		}
+		keys, _ := redis.Strings(reply[1], nil)
+		for _, key := range keys {
+			found[key] = true
+		}
+		if cursor, _ = redis.String(reply[0], nil); cursor == "0" {
+			break
+		}
+	}
+	if len(found) != 100 {
+		t.Fatal("scan should return all keys", len(found))
+	}
+
+	all, err := redis.Strings(c.Do("KEYS", "*"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n, err := redis.Int(c.Do("DBSIZE")); err != nil || n != len(all) {
+		t.Fatal("bad dbsize reply", n, len(all), err)
+	}
+	if key, err := redis.String(c.Do("RANDOMKEY")); err != nil || key == "" {
+		t.Fatal("bad randomkey reply", key, err)
+	}
+}
+
+func TestScript(t *testing.T) {
+	c, err := redis.Dial("tcp", "localhost:19000")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	v, err := redis.String(c.Do("EVAL", "redis.call('SET', KEYS[1], ARGV[1]); return redis.call('GET', KEYS[2])", 2, "{s}1", "{s}2", "v1"))
+	if err != redis.ErrNil {
+		t.Fatal("bad eval reply", v, err)
+	}
+	if v, err := redis.String(c.Do("GET", "{s}1")); err != nil || v != "v1" {
+		t.Fatal("bad get reply", v, err)
+	}
+	if _, err := c.Do("EVAL", "return 1", 2, "s1", "s2"); err == nil || !strings.HasPrefix(err.Error(), "CROSSSLOT") {
+		t.Fatal("eval should fail with CROSSSLOT", err)
+	}
+	if _, err := c.Do("EVAL", "return 1", 3, "s1"); err == nil {
+		t.Fatal("eval with bad numkeys should fail")
+	}
+
+	sha, err := redis.String(c.Do("SCRIPT", "LOAD", "return redis.call('GET', KEYS[1])"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	exists, err := redis.Ints(c.Do("SCRIPT", "EXISTS", sha, "0000000000000000000000000000000000000000"))
+	if err != nil || len(exists) != 2 || exists[0] != 1 || exists[1] != 0 {
+		t.Fatal("bad script exists reply", exists, err)
+	}
+	// 脚本加载到了所有redis-server上，任意slot的key都可以执行
+	for i := 0; i < 10; i++ {
+		key := fmt.Sprintf("script_%d", i)
+		if _, err := c.Do("SET", key, i); err != nil {
+			t.Fatal(err)
+		}
+		if v, err := redis.Int(c.Do("EVALSHA", sha, 1, key)); err != nil || v != i {
+			t.Fatal("bad evalsha reply", v, err)
+		}
+	}
+
+	if _, err := c.Do("SCRIPT", "FLUSH"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.Do("EVALSHA", sha, 1, "script_0"); err == nil || !strings.HasPrefix(err.Error(), "NOSCRIPT") {
+		t.Fatal("evalsha should fail with NOSCRIPT", err)
+	}
+}
+
+func TestRateLimit(t *testing.T) {
+	router.SetRateLimit(router.RateLimitConfig{PerClass: map[string]int{"keyspace": 1}})
+	defer router.SetRateLimit(router.RateLimitConfig{})
+
+	c, err := redis.Dial("tcp", "localhost:19000")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	if _, err := c.Do("DBSIZE"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.Do("DBSIZE"); err == nil || !strings.Contains(err.Error(), "rate limit exceeded") {
+		t.Fatal("dbsize should be rejected", err)
+	}
+	if _, err := c.Do("SET", "ratelimit", 1); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRateLimitTransaction(t *testing.T) {
+	router.SetRateLimit(router.RateLimitConfig{PerClass: map[string]int{"write": 1}})
+	defer router.SetRateLimit(router.RateLimitConfig{})
+
+	c, err := redis.Dial("tcp", "localhost:19000")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	if _, err := c.Do("SET", "{ratelimit_txn}0", 1); err != nil {
+		t.Fatal(err)
+	}
+	// 事务中有命令被限流时 EXEC 放弃整个事务
+	if _, err := c.Do("MULTI"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.Do("SET", "{ratelimit_txn}1", 1); err == nil || !strings.Contains(err.Error(), "rate limit exceeded") {
+		t.Fatal("set should be rejected", err)
+	}
+	time.Sleep(1100 * time.Millisecond)
+	if v, err := redis.String(c.Do("SET", "{ratelimit_txn}2", 2)); err != nil || v != "QUEUED" {
+		t.Fatal("set should be queued", v, err)
+	}
+	if _, err := c.Do("EXEC"); err == nil || !strings.HasPrefix(err.Error(), "EXECABORT") {
+		t.Fatal("exec should be aborted", err)
+	}
+	for _, key := range []string{"{ratelimit_txn}1", "{ratelimit_txn}2"} {
+		if v, err := c.Do("GET", key); err != nil || v != nil {
+			t.Fatal("key should not be set", key, v, err)
+		}
+	}
+}
+
+func TestSlowLog(t *testing.T) {
+	router.SetSlowLog(1, 128)
+	defer router.SetSlowLog(0, 0)
+
+	c, err := redis.Dial("tcp", "localhost:19000")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	if _, err := c.Do("SLOWLOG", "RESET"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.Do("SET", "slowlog", 1); err != nil {
+		t.Fatal(err)
+	}
+	entries, err := redis.Values(c.Do("SLOWLOG", "GET", 1))
+	if err != nil || len(entries) != 1 {
+		t.Fatal("bad slowlog get reply", entries, err)
+	}
+	e, _ := redis.Values(entries[0], nil)
+	args, _ := redis.Strings(e[3], nil)
+	if len(e) != 6 || len(args) != 3 || args[0] != "SET" || args[1] != "slowlog" {
+		t.Fatal("bad slowlog entry", e)
+	}
+	if backend, _ := redis.String(e[5], nil); backend == "" {
+		t.Fatal("slowlog entry should have backend address")
+	}
+	if n, err := redis.Int(c.Do("SLOWLOG", "LEN")); err != nil || n < 2 {
+		t.Fatal("bad slowlog len reply", n, err)
+	}
+}
+
+func TestCluster(t *testing.T) {
+	c, err := redis.Dial("tcp", "localhost:19000")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	slots, err := redis.Values(c.Do("CLUSTER", "SLOTS"))
+	if err != nil || len(slots) == 0 {
+		t.Fatal("bad cluster slots reply", slots, err)
+	}
+	first, _ := redis.Values(slots[0], nil)
+	last, _ := redis.Values(slots[len(slots)-1], nil)
+	if beg, _ := redis.Int(first[0], nil); beg != 0 {
+		t.Fatal("cluster slots should start from 0", first)
+	}
+	if end, _ := redis.Int(last[1], nil); end != 16383 {
+		t.Fatal("cluster slots should end at 16383", last)
+	}
+	nodes, err := redis.String(c.Do("CLUSTER", "NODES"))
+	if err != nil || !strings.Contains(nodes, "myself,master") {
+		t.Fatal("bad cluster nodes reply", nodes, err)
+	}
+	info, err := redis.String(c.Do("CLUSTER", "INFO"))
+	if err != nil || !strings.HasPrefix(info, "cluster_state:ok") {
+		t.Fatal("bad cluster info reply", info, err)
+	}
+	// KEYSLOT 返回的是codis的slot
+	slot, err := redis.Int(c.Do("CLUSTER", "KEYSLOT", "foo{bar}"))
+	if err != nil || slot < 0 || slot >= router.MaxSlotNum {
+		t.Fatal("bad cluster keyslot reply", slot, err)
+	}
+	if x, _ := redis.Int(c.Do("CLUSTER", "KEYSLOT", "bar")); x != slot {
+		t.Fatal("cluster keyslot should use hash tag", x, slot)
+	}
+	if _, err := c.Do("CLUSTER", "MEET", "127.0.0.1", "7000"); err == nil {
+		t.Fatal("cluster meet should fail")
+	}
+}
+
+func TestTenant(t *testing.T) {
+	tenants, err := router.ParseTenants([]byte(`[
+		{"name": "a", "password": "pa", "prefix": "tenant_a:"},
+		{"name": "b", "password": "pb", "prefix": "tenant_b:", "deny": ["DEL"]}
+	]`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	router.SetTenants(tenants)
+	defer router.SetTenants(nil)
+
+	dial := func(passwd string) redis.Conn {
+		c, err := redis.Dial("tcp", "localhost:19000")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if passwd != "" {
+			if _, err := c.Do("AUTH", passwd); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return c
+	}
+	a, b, c := dial("pa"), dial("pb"), dial("")
+	defer a.Close()
+	defer b.Close()
+	defer c.Close()
+
+	if _, err := c.Do("GET", "tenant_key"); err == nil {
+		t.Fatal("tenant requires auth")
+	}
+	if _, err := a.Do("SET", "tenant_key", "a"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := b.Do("SET", "tenant_key", "b"); err != nil {
+		t.Fatal(err)
+	}
+	if v, err := redis.String(a.Do("GET", "tenant_key")); err != nil || v != "a" {
+		t.Fatal("bad tenant get", v, err)
+	}
+	if keys, err := redis.Strings(b.Do("KEYS", "tenant_*")); err != nil || len(keys) != 1 || keys[0] != "tenant_key" {
+		t.Fatal("bad tenant keys", keys, err)
+	}
+	if _, err := b.Do("DEL", "tenant_key"); err == nil || !strings.HasPrefix(err.Error(), "NOPERM") {
+		t.Fatal("del should be denied", err)
+	}
+	if n, err := redis.Int(a.Do("DEL", "tenant_key")); err != nil || n != 1 {
+		t.Fatal("bad tenant del", n, err)
+	}
+	if v, err := redis.String(b.Do("GET", "tenant_key")); err != nil || v != "b" {
+		t.Fatal("bad tenant get", v, err)
+	}
+	if _, err := a.Do("SLOWLOG", "RESET"); err == nil || !strings.HasPrefix(err.Error(), "NOPERM") {
+		t.Fatal("slowlog should be denied", err)
+	}
+
+	// 事务中有命令被禁止时 EXEC 放弃整个事务
+	if _, err := b.Do("MULTI"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := b.Do("SET", "tenant_key", "txn"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := b.Do("DEL", "tenant_key"); err == nil || !strings.HasPrefix(err.Error(), "NOPERM") {
+		t.Fatal("del should be denied", err)
+	}
+	if _, err := b.Do("EXEC"); err == nil || !strings.HasPrefix(err.Error(), "EXECABORT") {
+		t.Fatal("exec should be aborted", err)
+	}
+	if v, err := redis.String(b.Do("GET", "tenant_key")); err != nil || v != "b" {
+		t.Fatal("bad tenant get", v, err)
+	}
+}
+
+func TestMirror(t *testing.T) {
+	shadow, err := miniredis.Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer shadow.Close()
+	shadow.Set("mirror_diff", "shadow")
+
+	s.router.SetMirror([]string{shadow.Addr()}, router.MirrorAll, 100)
+	defer s.router.SetMirror(nil, router.MirrorAll, 0)
+
+	c, err := redis.Dial("tcp", "localhost:19000")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	before := router.GetMirrorStats()
+	if _, err := c.Do("SET", "mirror_key", "v"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.Do("APPEND", "mirror_diff", "x"); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 100; i++ {
+		if router.GetMirrorStats().Total-before.Total == 2 && shadow.Exists("mirror_key") {
+			break
+		}
+		time.Sleep(time.Millisecond * 10)
+	}
+	if v, err := shadow.Get("mirror_key"); err != nil || v != "v" {
+		t.Fatal("request is not mirrored", v, err)
+	}
+	// 影子集群的数据不同，APPEND 的返回结果不一致
+	for i := 0; i < 100 && router.GetMirrorStats().Divergent == before.Divergent; i++ {
+		time.Sleep(time.Millisecond * 10)
+	}
+	after := router.GetMirrorStats()
+	if after.Divergent-before.Divergent != 1 || after.Commands["APPEND"]-before.Commands["APPEND"] != 1 {
+		t.Fatal("bad mirror stats", before, after)
+	}
+}
+
+func TestDrain(t *testing.T) {
+	c := *conf
+	c.proxyId = "proxy_drain"
+	c.drainTimeout = 10
+	c.zkSessionTimeout = 10000
+	s1 := New(":19100", ":11100", &c)
+	err := models.SetProxyStatus(conn, c.productName, c.proxyId, models.PROXY_STATE_ONLINE)
+	assert.MustNoError(err)
+
+	idle, err := redis.Dial("tcp", "localhost:19100")
+	assert.MustNoError(err)
+	defer idle.Close()
+	_, err = idle.Do("PING")
+	assert.MustNoError(err)
+
+	busy, err := redis.Dial("tcp", "localhost:19100")
+	assert.MustNoError(err)
+	defer busy.Close()
+	busy.Send("BLPOP", "drain_list", "1")
+	busy.Flush()
+	time.Sleep(100 * time.Millisecond)
+
+	// 交给新的proxy使用的socket
+	f, err := s1.ListenerFile()
+	assert.MustNoError(err)
+	l, err := net.FileListener(f)
+	assert.MustNoError(err)
+	f.Close()
+
+	// pipeline 中的请求，后端执行过的都要返回给客户端
+	pipe, err := redis.Dial("tcp", "localhost:19100")
+	assert.MustNoError(err)
+	defer pipe.Close()
+	for i := 0; i < 2000; i++ {
+		pipe.Send("INCR", "drain_counter")
+	}
+	pipe.Flush()
+
+	done := make(chan bool)
+	go func() {
+		s1.Drain()
+		close(done)
+	}()
+	time.Sleep(200 * time.Millisecond)
+
+	// 空闲的连接立即被关闭，正在执行的请求正常返回后关闭
+	_, err = idle.Do("PING")
+	assert.Must(err != nil)
+	reply, err := busy.Receive()
+	assert.Must(err == nil && reply == nil)
+	_, err = busy.Do("PING")
+	assert.Must(err != nil)
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("drain timeout")
+	}
+	_, err = models.GetProxyInfo(conn, c.productName, c.proxyId)
+	assert.Must(err != nil)
+
+	var replies int
+	for {
+		if _, err := pipe.Receive(); err != nil {
+			break
+		}
+		replies++
+	}
+	// 关闭前一个请求都没有处理时 key 不存在
+	counter, _ := redis1.Get("drain_counter")
+	if counter == "" {
+		counter, _ = redis2.Get("drain_counter")
+	}
+	if counter == "" {
+		counter = "0"
+	}
+	if counter != fmt.Sprint(replies) {
+		t.Fatal("executed requests are not replied", counter, replies)
+	}
+
+	// 新的连接在socket中排队，等新的proxy上线后处理
+	pending, err := redis.Dial("tcp", "localhost:19100")
+	assert.MustNoError(err)
+	defer pending.Close()
+
+	s2 := NewWithListener(l, ":19100", ":11100", &c)
+	defer s2.Close()
+	err = models.SetProxyStatus(conn, c.productName, c.proxyId, models.PROXY_STATE_ONLINE)
+	assert.MustNoError(err)
+
+	pong, err := redis.String(pending.Do("PING"))
+	assert.Must(err == nil && pong == "PONG")
+}
+
 func TestRedisRestart(t *testing.T) {
 	c, err := redis.Dial("tcp", "localhost:19000")
 	if err != nil {
@@ -4,6 +4,7 @@
 package redis
 
 import (
+	"crypto/tls"
 	"net"
 	"time"
 
@@ -29,6 +30,15 @@ func DialTimeout(addr string, bufsize int, timeout time.Duration) (*Conn, error)
 	return NewConnSize(c, bufsize), nil
 }
 
+// 建立一个TLS连接
+func DialTLS(addr string, bufsize int, timeout time.Duration, config *tls.Config) (*Conn, error) {
+	c, err := tls.DialWithDialer(&net.Dialer{Timeout: timeout}, "tcp", addr, config)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	return NewConnSize(c, bufsize), nil
+}
+
 func NewConn(sock net.Conn) *Conn {
 	return NewConnSize(sock, 1024*64)
 }
@@ -4,6 +4,7 @@
 package router
 
 import (
+	"crypto/tls"
 	"fmt"
 	"sync"
 	"time"
@@ -19,6 +20,8 @@ type BackendConn struct {
 	auth string // 连接redis的密码
 	stop sync.Once
 
+	breaker *breaker // 同一个地址的所有连接共享的熔断器
+
 	input chan *Request // 用于接收redis请求的通道
 }
 
@@ -28,6 +31,7 @@ func NewBackendConn(addr, auth string) *BackendConn {
 		addr: addr, auth: auth,
 		input: make(chan *Request, 1024),
 	}
+	bc.breaker = &GetBackendStats(addr, true).breaker
 	go bc.Run()
 	return bc
 }
@@ -67,18 +71,42 @@ func (bc *BackendConn) Close() {
 
 // 将redis请求加入等待队列
 func (bc *BackendConn) PushBack(r *Request) {
+	// 熔断期间直接返回错误，不再排队等待
+	if !bc.breaker.allow() {
+		bc.reject(r)
+		return
+	}
+	bc.push(r)
+}
+
+// 加入等待队列，不检查熔断，需要连续执行的一组请求由调用者统一检查
+func (bc *BackendConn) push(r *Request) {
+	r.backend = bc.addr
 	if r.Wait != nil {
 		r.Wait.Add(1)
 	}
 	bc.input <- r
 }
 
+// 熔断期间直接返回错误
+func (bc *BackendConn) reject(r *Request) {
+	r.backend = bc.addr
+	if r.Wait != nil {
+		r.Wait.Add(1)
+	}
+	bc.setResponse(r, bc.breaker.errorResp(), nil)
+}
+
 // 向redis发送心跳包
 func (bc *BackendConn) KeepAlive() bool {
 	// 如果当前有redis请求，则没必要发心跳包
 	if len(bc.input) != 0 {
 		return false
 	}
+	// 熔断期间心跳包作为探测请求，冷却时间过后才发送
+	if !bc.breaker.allow() {
+		return false
+	}
 	r := &Request{
 		Resp: redis.NewArray([]*redis.Resp{
 			redis.NewBulkBytes([]byte("PING")),
@@ -103,6 +131,7 @@ func (bc *BackendConn) loopWriter() error {
 		// 创建一个循环处理从redis返回内容的协程，向request中设置返回的信息
 		c, tasks, err := bc.newBackendReader()
 		if err != nil {
+			bc.breaker.record(err)
 			return bc.setResponse(r, nil, err)
 		}
 		defer close(tasks)
@@ -138,15 +167,15 @@ func (bc *BackendConn) loopWriter() error {
 // 创建一个循环处理从redis返回内容的协程，向request中设置返回的信息
 func (bc *BackendConn) newBackendReader() (*redis.Conn, chan<- *Request, error) {
 	// 建立和redis的连接
-	c, err := redis.DialTimeout(bc.addr, 1024*512, time.Second)
+	c, err := dialBackend(bc.addr, 1024*512)
 	if err != nil {
 		return nil, nil, err
 	}
 	// redis超时时间
-	c.ReaderTimeout = time.Minute
-	c.WriterTimeout = time.Minute
+	c.ReaderTimeout = backendTimeout()
+	c.WriterTimeout = backendTimeout()
 
-	if err := bc.verifyAuth(c); err != nil {
+	if err := verifyAuth(c, bc.auth); err != nil {
 		c.Close()
 		return nil, nil, err
 	}
@@ -154,9 +183,20 @@ func (bc *BackendConn) newBackendReader() (*redis.Conn, chan<- *Request, error)
 	tasks := make(chan *Request, 4096)
 	go func() {
 		defer c.Close()
+		var failed bool
 		for r := range tasks {
 			// 向redis发送命令
 			resp, err := c.Reader.Decode()
+			// 出错或者超时都算作一次失败，心跳也会计入
+			// 连接出错后排队中的请求都会失败，一个连接只算一次
+			if !failed {
+				bc.breaker.record(err)
+				failed = err != nil
+			}
+			// 心跳等proxy内部的请求不计入统计
+			if err == nil && r.Start != 0 {
+				incrBackendStats(bc.addr, microseconds()-r.Start)
+			}
 			// 设置redis返回的状态和信息，因为redis是单线程的，命令都是顺序执行，所以这里的 request 和 response 可以一一对应上
 			bc.setResponse(r, resp, err)
 			if err != nil {
@@ -168,14 +208,38 @@ func (bc *BackendConn) newBackendReader() (*redis.Conn, chan<- *Request, error)
 	return c, tasks, nil
 }
 
+// 连接redis使用的TLS配置，为nil时不使用TLS，整个proxy共享
+var backendTLS struct {
+	sync.RWMutex
+	config *tls.Config
+}
+
+// 设置连接redis使用的TLS配置，只对之后新建的连接生效
+func SetBackendTLS(config *tls.Config) {
+	backendTLS.Lock()
+	defer backendTLS.Unlock()
+	backendTLS.config = config
+}
+
+// 建立和redis的连接，配置了TLS时使用TLS连接
+func dialBackend(addr string, bufsize int) (*redis.Conn, error) {
+	backendTLS.RLock()
+	config := backendTLS.config
+	backendTLS.RUnlock()
+	if config != nil {
+		return redis.DialTLS(addr, bufsize, time.Second, config)
+	}
+	return redis.DialTimeout(addr, bufsize, time.Second)
+}
+
 // 验证redis密码
-func (bc *BackendConn) verifyAuth(c *redis.Conn) error {
-	if bc.auth == "" {
+func verifyAuth(c *redis.Conn, auth string) error {
+	if auth == "" {
 		return nil
 	}
 	resp := redis.NewArray([]*redis.Resp{
 		redis.NewBulkBytes([]byte("AUTH")),
-		redis.NewBulkBytes([]byte(bc.auth)),
+		redis.NewBulkBytes([]byte(auth)),
 	})
 
 	if err := c.Writer.Encode(resp, true); err != nil {
@@ -222,17 +286,47 @@ func (bc *BackendConn) setResponse(r *Request, resp *redis.Resp, err error) erro
 	return err
 }
 
-// 连接复用对象
+// 连接复用对象，和一个redis-server建立多个连接，所有会话共享
+// 同一个会话的请求总是使用同一个连接，保证请求的顺序
 type SharedBackendConn struct {
-	*BackendConn
-	mu sync.Mutex
+	addr  string
+	conns []*BackendConn
+	mu    sync.Mutex
 
 	refcnt int
 }
 
-// 创建连接复用对象
-func NewSharedBackendConn(addr, auth string) *SharedBackendConn {
-	return &SharedBackendConn{BackendConn: NewBackendConn(addr, auth), refcnt: 1}
+// 创建连接复用对象，size为连接数
+func NewSharedBackendConn(addr, auth string, size int) *SharedBackendConn {
+	if size <= 0 {
+		size = 1
+	}
+	s := &SharedBackendConn{addr: addr, refcnt: 1}
+	s.conns = make([]*BackendConn, size)
+	for i := range s.conns {
+		s.conns[i] = NewBackendConn(addr, auth)
+	}
+	return s
+}
+
+func (s *SharedBackendConn) Addr() string {
+	return s.addr
+}
+
+// 按请求所属的会话选择连接，将请求加入等待队列
+func (s *SharedBackendConn) PushBack(r *Request) {
+	s.conns[r.affinity%uint(len(s.conns))].PushBack(r)
+}
+
+// 向redis发送心跳包，每个连接都需要发送
+func (s *SharedBackendConn) KeepAlive() bool {
+	var ok = false
+	for _, bc := range s.conns {
+		if bc.KeepAlive() {
+			ok = true
+		}
+	}
+	return ok
 }
 
 func (s *SharedBackendConn) Close() bool {
@@ -242,7 +336,9 @@ func (s *SharedBackendConn) Close() bool {
 		log.Panicf("shared backend conn has been closed, close too many times")
 	}
 	if s.refcnt == 1 {
-		s.BackendConn.Close()
+		for _, bc := range s.conns {
+			bc.Close()
+		}
 	}
 	s.refcnt--
 	return s.refcnt == 0
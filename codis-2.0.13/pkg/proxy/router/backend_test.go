@@ -58,3 +58,69 @@ func TestBackend(t *testing.T) {
 	}
 	assert.Must(n == cap(reqc))
 }
+
+func TestSharedBackend(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.MustNoError(err)
+	defer l.Close()
+
+	// 每个连接返回 连接编号:请求参数
+	go func() {
+		for id := 0; ; id++ {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func(id int, c net.Conn) {
+				defer c.Close()
+				conn := redis.NewConn(c)
+				for {
+					req, err := conn.Reader.Decode()
+					if err != nil {
+						return
+					}
+					resp := redis.NewBulkBytes([]byte(strconv.Itoa(id) + ":" + string(req.Array[1].Value)))
+					if err := conn.Writer.Encode(resp, true); err != nil {
+						return
+					}
+				}
+			}(id, c)
+		}
+	}()
+
+	s := NewSharedBackendConn(l.Addr().String(), "", 4)
+	defer s.Close()
+	assert.Must(len(s.conns) == 4)
+
+	var reqs []*Request
+	for i := 0; i < 400; i++ {
+		r := &Request{
+			Resp: redis.NewArray([]*redis.Resp{
+				redis.NewBulkBytes([]byte("ECHO")),
+				redis.NewBulkBytes([]byte(strconv.Itoa(i))),
+			}),
+			Wait:     &sync.WaitGroup{},
+			affinity: uint(i % 8),
+		}
+		s.PushBack(r)
+		reqs = append(reqs, r)
+	}
+
+	// 同一个会话的请求使用同一个连接，不同的会话分散到不同的连接上
+	var conns = make(map[uint]string)
+	var used = make(map[string]bool)
+	for i, r := range reqs {
+		r.Wait.Wait()
+		assert.MustNoError(r.Response.Err)
+		v := string(r.Response.Resp.Value)
+		k := v[:len(v)-len(strconv.Itoa(i))-1]
+		assert.Must(v[len(k)+1:] == strconv.Itoa(i))
+		if id, ok := conns[r.affinity]; ok {
+			assert.Must(id == k)
+		}
+		conns[r.affinity] = k
+		used[k] = true
+	}
+	assert.Must(len(used) == 4)
+	assert.Must(conns[0] == conns[4] && conns[0] != conns[1])
+}
@@ -0,0 +1,257 @@
+// Copyright 2016 CodisLabs. All Rights Reserved.
+// Licensed under the MIT (MIT-LICENSE.txt) license.
+
+package router
+
+import (
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/CodisLabs/codis/pkg/proxy/redis"
+	"github.com/CodisLabs/codis/pkg/utils/errors"
+)
+
+// 阻塞命令使用的独占连接，每个会话一个，命令阻塞期间登记在slot上
+// slot切换或者开始迁移时连接会被关闭，阻塞中的命令随即返回错误
+type blockingConn struct {
+	addr string
+	auth string
+
+	mu     sync.Mutex
+	conn   *redis.Conn
+	closed bool
+	kicked bool // 是否是因为slot变化被关闭的
+
+	// 最后一个提交的命令执行完成时关闭，pipeline 中的阻塞命令在同一个连接上依次执行
+	last chan struct{}
+}
+
+func newBlockingConn(addr, auth string) *blockingConn {
+	return &blockingConn{addr: addr, auth: auth}
+}
+
+var errBlockingConnClosed = errors.New("use of closed blocking conn")
+
+// 排在之前提交的命令后面，返回等待前一个命令的通道和本命令完成时需要关闭的通道
+func (bc *blockingConn) enqueue() (prev <-chan struct{}, done chan struct{}) {
+	bc.mu.Lock()
+	defer bc.mu.Unlock()
+	prev, done = bc.last, make(chan struct{})
+	bc.last = done
+	return prev, done
+}
+
+// 发送阻塞命令并等待返回，timeout为0表示一直等待
+func (bc *blockingConn) do(resp *redis.Resp, timeout time.Duration) (*redis.Resp, error) {
+	c, err := bc.connect()
+	if err != nil {
+		return nil, err
+	}
+	// 多等待一段时间，避免和命令本身的超时同时触发
+	if timeout != 0 {
+		c.ReaderTimeout = timeout + time.Second*5
+	} else {
+		c.ReaderTimeout = 0
+	}
+	if err := c.Writer.Encode(resp, true); err != nil {
+		bc.close(false)
+		return nil, err
+	}
+	resp, err = c.Reader.Decode()
+	if err != nil {
+		bc.close(false)
+		return nil, err
+	}
+	return resp, nil
+}
+
+func (bc *blockingConn) connect() (*redis.Conn, error) {
+	bc.mu.Lock()
+	c, closed := bc.conn, bc.closed
+	bc.mu.Unlock()
+	if closed {
+		return nil, errBlockingConnClosed
+	}
+	if c != nil {
+		return c, nil
+	}
+
+	c, err := dialBackend(bc.addr, 1024*64)
+	if err != nil {
+		return nil, err
+	}
+	c.WriterTimeout = time.Minute
+	if err := verifyAuth(c, bc.auth); err != nil {
+		c.Close()
+		return nil, err
+	}
+
+	bc.mu.Lock()
+	defer bc.mu.Unlock()
+	// 建立连接的过程中可能已经被关闭了
+	if bc.closed {
+		c.Close()
+		return nil, errBlockingConnClosed
+	}
+	bc.conn = c
+	return c, nil
+}
+
+// 关闭连接，kick表示是否是因为slot变化被关闭
+func (bc *blockingConn) close(kick bool) {
+	bc.mu.Lock()
+	defer bc.mu.Unlock()
+	if bc.closed {
+		return
+	}
+	bc.closed, bc.kicked = true, kick
+	if bc.conn != nil {
+		bc.conn.Close()
+	}
+}
+
+func (bc *blockingConn) isClosed() bool {
+	bc.mu.Lock()
+	defer bc.mu.Unlock()
+	return bc.closed
+}
+
+func (bc *blockingConn) isKicked() bool {
+	bc.mu.Lock()
+	defer bc.mu.Unlock()
+	return bc.kicked
+}
+
+var ErrSlotIsMigrating = errors.New("slot is migrating")
+
+// 通过独占连接转发阻塞命令，bc为空、已经关闭或者slot已经切换时会新建连接
+func (s *Slot) forwardBlocking(r *Request, keys [][]byte, bc *blockingConn, auth string, timeout time.Duration) (*blockingConn, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	if s.backend.bc == nil {
+		return bc, ErrSlotIsNotReady
+	}
+	// 迁移中的key可能还在原来的redis-server上，阻塞在新的redis-server上可能永远等不到数据
+	if s.migrate.bc != nil {
+		return bc, ErrSlotIsMigrating
+	}
+	if bc == nil || bc.isClosed() || bc.addr != s.backend.addr {
+		if bc != nil {
+			bc.close(false)
+		}
+		bc = newBlockingConn(s.backend.addr, auth)
+	}
+
+	s.blocking.Lock()
+	if s.blocking.conns == nil {
+		s.blocking.conns = make(map[*blockingConn]bool)
+	}
+	s.blocking.conns[bc] = true
+	s.blocking.Unlock()
+
+	r.backend = bc.addr
+	r.Wait.Add(1)
+	prev, done := bc.enqueue()
+	go func() {
+		defer r.Wait.Done()
+		defer close(done)
+		if prev != nil {
+			<-prev
+		}
+		resp, err := bc.do(r.Resp, timeout)
+
+		s.blocking.Lock()
+		delete(s.blocking.conns, bc)
+		s.blocking.Unlock()
+
+		// 阻塞命令的失败不影响会话中的其他请求，直接返回错误信息给redis-client
+		if err != nil {
+			if bc.isKicked() {
+				resp = redis.NewError([]byte("ERR slot has been changed while blocking, please retry"))
+			} else {
+				resp = redis.NewError([]byte("ERR blocking command failed: " + errors.Cause(err).Error()))
+			}
+		}
+		r.Response.Resp = resp
+	}()
+	return bc, nil
+}
+
+// 关闭所有阻塞在这个slot上的独占连接
+func (s *Slot) kickBlocking() {
+	s.blocking.Lock()
+	defer s.blocking.Unlock()
+	for bc := range s.blocking.conns {
+		bc.close(true)
+	}
+	s.blocking.conns = nil
+}
+
+// 关闭会话独占的连接，阻塞中的命令会随之返回
+func (s *Session) closeBlocking() {
+	if s.blocking != nil {
+		s.blocking.close(false)
+	}
+}
+
+// 获取阻塞命令的超时时间，即最后一个参数
+func getBlockingTimeout(resp *redis.Resp) (time.Duration, error) {
+	n, err := strconv.Atoi(string(resp.Array[len(resp.Array)-1].Value))
+	if err != nil {
+		return 0, errors.New("ERR timeout is not an integer or out of range")
+	}
+	if n < 0 {
+		return 0, errors.New("ERR timeout is negative")
+	}
+	return time.Second * time.Duration(n), nil
+}
+
+// BLPOP/BRPOP/BRPOPLPUSH 命令，在会话独占的连接上执行，避免阻塞共享的连接
+func (s *Session) handleBlocking(r *Request, d Dispatcher) (*Request, error) {
+	var nargs = len(r.Resp.Array)
+	if (r.OpStr == "BRPOPLPUSH" && nargs != 4) || nargs < 3 {
+		r.Response.Resp = redis.NewError([]byte("ERR wrong number of arguments for '" + strings.ToLower(r.OpStr) + "' command"))
+		return r, nil
+	}
+	timeout, err := getBlockingTimeout(r.Resp)
+	if err != nil {
+		r.Response.Resp = redis.NewError([]byte(err.Error()))
+		return r, nil
+	}
+
+	// 阻塞时间不能超过会话的超时时间，否则等不到结果会话就会因为超时被关闭
+	if max := s.Conn.ReaderTimeout; max != 0 {
+		max -= time.Second + max%time.Second
+		if max < time.Second {
+			max = time.Second
+		}
+		if timeout == 0 || timeout > max {
+			timeout = max
+			array := append([]*redis.Resp{}, r.Resp.Array[:nargs-1]...)
+			array = append(array, redis.NewBulkBytes([]byte(strconv.Itoa(int(timeout/time.Second)))))
+			r.Resp = redis.NewArray(array)
+		}
+	}
+
+	keys := getHashKeys(r.Resp, r.OpStr)
+	for _, key := range keys[1:] {
+		if hashSlot(key) != hashSlot(keys[0]) {
+			r.Response.Resp = errCrossSlot
+			return r, nil
+		}
+	}
+
+	bc, err := d.DispatchBlocking(r, keys, s.blocking, timeout)
+	s.blocking = bc
+	switch err {
+	case nil:
+		return r, nil
+	case ErrSlotIsMigrating:
+		r.Response.Resp = redis.NewError([]byte("ERR slot is migrating, blocking command is not allowed"))
+		return r, nil
+	default:
+		return nil, err
+	}
+}
@@ -0,0 +1,158 @@
+// Copyright 2016 CodisLabs. All Rights Reserved.
+// Licensed under the MIT (MIT-LICENSE.txt) license.
+
+package router
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/CodisLabs/codis/pkg/proxy/redis"
+	"github.com/CodisLabs/codis/pkg/utils/atomic2"
+	"github.com/CodisLabs/codis/pkg/utils/log"
+)
+
+// 熔断器的状态
+type BreakerState int
+
+const (
+	BreakerClosed   BreakerState = iota // 正常转发请求
+	BreakerOpen                         // 熔断，请求直接返回错误
+	BreakerHalfOpen                     // 冷却时间已过，放行一个请求探测后端是否恢复
+)
+
+func (s BreakerState) String() string {
+	switch s {
+	case BreakerOpen:
+		return "open"
+	case BreakerHalfOpen:
+		return "half-open"
+	}
+	return "closed"
+}
+
+// 熔断配置，整个proxy共享
+var breakerconf struct {
+	failures atomic2.Int64 // 连续失败多少次后熔断，0表示不熔断
+	cooldown atomic2.Int64 // us，熔断之后多久放行一个请求探测后端是否恢复
+	timeout  atomic2.Int64 // us，等待redis返回的超时时间，超时算作一次失败
+}
+
+func init() {
+	SetBackendTimeout(time.Minute)
+}
+
+// 设置熔断的连续失败次数和冷却时间，failures为0表示不熔断
+func SetBreaker(failures int, cooldown time.Duration) {
+	breakerconf.failures.Set(int64(failures))
+	breakerconf.cooldown.Set(int64(cooldown / time.Microsecond))
+}
+
+// 设置等待redis返回的超时时间，只对之后新建的连接生效
+func SetBackendTimeout(timeout time.Duration) {
+	breakerconf.timeout.Set(int64(timeout / time.Microsecond))
+}
+
+func backendTimeout() time.Duration {
+	return time.Duration(breakerconf.timeout.Get()) * time.Microsecond
+}
+
+// 后端redis的熔断器，同一个地址的所有连接共享
+type breaker struct {
+	addr string
+
+	mu       sync.Mutex
+	state    BreakerState
+	failures int64 // 连续失败的次数
+	openAt   int64 // us，最近一次熔断的时间
+	probeAt  int64 // us，半开状态下放行探测请求的时间，0表示还没有放行
+	lastErr  error
+
+	trips    atomic2.Int64 // 熔断的次数
+	rejected atomic2.Int64 // 熔断期间被拒绝的请求数
+}
+
+// 是否可以向后端发送请求，熔断期间只在冷却时间过后放行一个探测请求
+func (b *breaker) allow() bool {
+	if breakerconf.failures.Get() == 0 {
+		return true
+	}
+	var now = microseconds()
+	var cooldown = breakerconf.cooldown.Get()
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	switch b.state {
+	case BreakerClosed:
+		return true
+	case BreakerOpen:
+		if now-b.openAt >= cooldown {
+			b.state, b.probeAt = BreakerHalfOpen, now
+			return true
+		}
+	case BreakerHalfOpen:
+		// 探测请求可能没有被发出去，超过冷却时间没有结果就再放行一个
+		if now-b.probeAt >= cooldown {
+			b.probeAt = now
+			return true
+		}
+	}
+	b.rejected.Incr()
+	return false
+}
+
+// 记录一次请求的结果，连续失败超过阈值或者探测失败时熔断，成功时恢复
+func (b *breaker) record(err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if err == nil {
+		if b.state != BreakerClosed {
+			log.Infof("backend %s recovered, circuit breaker is closed", b.addr)
+		}
+		b.state, b.failures = BreakerClosed, 0
+		return
+	}
+	b.failures++
+	b.lastErr = err
+	var threshold = breakerconf.failures.Get()
+	if threshold == 0 {
+		return
+	}
+	if b.state == BreakerHalfOpen || (b.state == BreakerClosed && b.failures >= threshold) {
+		log.Warnf("backend %s failed %d times, circuit breaker is open, last error = %s", b.addr, b.failures, err)
+		b.state, b.openAt = BreakerOpen, microseconds()
+		b.trips.Incr()
+	}
+}
+
+// 熔断期间返回给客户端的错误
+func (b *breaker) errorResp() *redis.Resp {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	msg := fmt.Sprintf("ERR backend %s is unavailable, circuit breaker is %s", b.addr, b.state)
+	if b.lastErr != nil {
+		msg += ", last error: " + b.lastErr.Error()
+	}
+	return redis.NewError([]byte(msg))
+}
+
+func (b *breaker) State() BreakerState {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.state
+}
+
+func (b *breaker) MarshalJSON() ([]byte, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	var m = make(map[string]interface{})
+	m["state"] = b.state.String()
+	m["failures"] = b.failures
+	m["trips"] = b.trips.Get()
+	m["rejected"] = b.rejected.Get()
+	if b.lastErr != nil {
+		m["error"] = b.lastErr.Error()
+	}
+	return json.Marshal(m)
+}
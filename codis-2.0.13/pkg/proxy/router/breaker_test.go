@@ -0,0 +1,184 @@
+// Copyright 2016 CodisLabs. All Rights Reserved.
+// Licensed under the MIT (MIT-LICENSE.txt) license.
+
+package router
+
+import (
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/CodisLabs/codis/pkg/proxy/redis"
+	"github.com/CodisLabs/codis/pkg/utils/assert"
+	"github.com/CodisLabs/codis/pkg/utils/errors"
+)
+
+func TestBreaker(t *testing.T) {
+	SetBreaker(3, time.Millisecond*50)
+	defer SetBreaker(0, 0)
+
+	b := &breaker{addr: "test:1000"}
+	err := errors.New("test error")
+	b.record(err)
+	b.record(err)
+	b.record(nil)
+	b.record(err)
+	b.record(err)
+	assert.Must(b.allow() && b.State() == BreakerClosed)
+	b.record(err)
+	assert.Must(!b.allow() && b.State() == BreakerOpen)
+	assert.Must(b.trips.Get() == 1 && b.rejected.Get() == 1)
+
+	// 冷却之后只放行一个探测请求，探测失败重新熔断
+	time.Sleep(time.Millisecond * 60)
+	assert.Must(b.allow() && b.State() == BreakerHalfOpen)
+	assert.Must(!b.allow())
+	b.record(err)
+	assert.Must(!b.allow() && b.State() == BreakerOpen && b.trips.Get() == 2)
+
+	time.Sleep(time.Millisecond * 60)
+	assert.Must(b.allow())
+	b.record(nil)
+	assert.Must(b.allow() && b.State() == BreakerClosed)
+
+	SetBreaker(0, 0)
+	for i := 0; i < 10; i++ {
+		b.record(err)
+	}
+	assert.Must(b.allow() && b.State() == BreakerClosed)
+}
+
+func TestBreakerTimeout(t *testing.T) {
+	SetBreaker(1, time.Hour)
+	SetBackendTimeout(time.Millisecond * 100)
+	defer SetBreaker(0, 0)
+	defer SetBackendTimeout(time.Minute)
+
+	// 接受连接但是不返回任何数据
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.MustNoError(err)
+	defer l.Close()
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			defer c.Close()
+		}
+	}()
+
+	bc := NewBackendConn(l.Addr().String(), "")
+	defer bc.Close()
+	ping := redis.NewArray([]*redis.Resp{redis.NewBulkBytes([]byte("PING"))})
+
+	r1 := &Request{Resp: ping, Wait: &sync.WaitGroup{}}
+	bc.PushBack(r1)
+	r1.Wait.Wait()
+	assert.Must(r1.Response.Err != nil)
+
+	// 熔断之后立即返回错误
+	start := time.Now()
+	r2 := &Request{Resp: ping, Wait: &sync.WaitGroup{}}
+	bc.PushBack(r2)
+	r2.Wait.Wait()
+	assert.Must(time.Since(start) < time.Millisecond*50)
+	assert.Must(r2.Response.Err == nil && r2.Response.Resp.IsError())
+	assert.Must(strings.Contains(string(r2.Response.Resp.Value), "circuit breaker is open"))
+	assert.Must(!bc.KeepAlive())
+
+	s := GetBackendStats(l.Addr().String(), false)
+	assert.Must(s != nil && s.BreakerState() == BreakerOpen)
+}
+
+func TestBreakerConnReset(t *testing.T) {
+	SetBreaker(3, time.Hour)
+	SetBackendTimeout(time.Millisecond * 100)
+	defer SetBreaker(0, 0)
+	defer SetBackendTimeout(time.Minute)
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.MustNoError(err)
+	defer l.Close()
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			defer c.Close()
+		}
+	}()
+
+	bc := NewBackendConn(l.Addr().String(), "")
+	defer bc.Close()
+	ping := redis.NewArray([]*redis.Resp{redis.NewBulkBytes([]byte("PING"))})
+
+	// 同一个连接上排队的请求因为超时全部失败，只算一次失败，不会熔断
+	wait := &sync.WaitGroup{}
+	for i := 0; i < 10; i++ {
+		bc.PushBack(&Request{Resp: ping, Wait: wait})
+	}
+	wait.Wait()
+
+	s := GetBackendStats(l.Addr().String(), false)
+	assert.Must(s != nil && s.BreakerState() == BreakerClosed)
+	assert.Must(s.breaker.trips.Get() == 0)
+}
+
+func TestBreakerPinned(t *testing.T) {
+	SetBreaker(1, time.Millisecond*50)
+	SetBackendTimeout(time.Millisecond * 100)
+	defer SetBreaker(0, 0)
+	defer SetBackendTimeout(time.Minute)
+
+	// 接受连接但是不返回任何数据
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.MustNoError(err)
+	defer l.Close()
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			defer c.Close()
+		}
+	}()
+
+	bc := NewBackendConn(l.Addr().String(), "")
+	defer bc.Close()
+	slot := &Slot{}
+	slot.backend.addr = bc.Addr()
+	slot.backend.bc = &SharedBackendConn{}
+
+	batch := func() []*Request {
+		wait := &sync.WaitGroup{}
+		var rs []*Request
+		for _, op := range []string{"MULTI", "SET", "EXEC"} {
+			rs = append(rs, &Request{OpStr: op, Resp: redis.NewArray([]*redis.Resp{redis.NewBulkBytes([]byte(op))}), Wait: wait})
+		}
+		assert.MustNoError(slot.forwardPinned(bc, nil, rs))
+		wait.Wait()
+		return rs
+	}
+	rejected := func(r *Request) bool {
+		return r.Response.Err == nil && r.Response.Resp.IsError() &&
+			strings.Contains(string(r.Response.Resp.Value), "circuit breaker")
+	}
+
+	// 熔断期间整组请求都被拒绝
+	bc.breaker.record(errors.New("test error"))
+	assert.Must(bc.breaker.State() == BreakerOpen)
+	for _, r := range batch() {
+		assert.Must(rejected(r))
+	}
+
+	// 冷却之后整组请求一起作为探测发往后端，不会只放行其中一个
+	time.Sleep(time.Millisecond * 60)
+	for _, r := range batch() {
+		assert.Must(!rejected(r) && r.Response.Err != nil)
+	}
+}
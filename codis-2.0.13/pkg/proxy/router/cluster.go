@@ -0,0 +1,117 @@
+// Copyright 2016 CodisLabs. All Rights Reserved.
+// Licensed under the MIT (MIT-LICENSE.txt) license.
+
+package router
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/CodisLabs/codis/pkg/proxy/redis"
+)
+
+// Redis Cluster 的slot数量，和codis的slot没有关系
+// 整个slot空间平均分给所有的proxy，任何一个proxy都可以处理所有的key，客户端按哪个slot路由都可以
+const clusterSlotNum = 16384
+
+// 设置 CLUSTER 命令中展示的proxy地址，self是当前proxy的地址
+func (s *Router) SetClusterNodes(addrs []string, self string) {
+	var nodes = []string{self}
+	for _, addr := range addrs {
+		if addr != self {
+			nodes = append(nodes, addr)
+		}
+	}
+	// 所有proxy的顺序一致，各个proxy返回的slot分配才能一致
+	sort.Strings(nodes)
+	s.cluster.Lock()
+	defer s.cluster.Unlock()
+	s.cluster.nodes, s.cluster.self = nodes, self
+}
+
+func (s *Router) ClusterNodes() ([]string, string) {
+	s.cluster.RLock()
+	defer s.cluster.RUnlock()
+	return s.cluster.nodes, s.cluster.self
+}
+
+// 第i个proxy负责的slot范围
+func clusterSlotRange(i, n int) (int, int) {
+	return i * clusterSlotNum / n, (i+1)*clusterSlotNum/n - 1
+}
+
+// 根据地址生成固定的40位node id
+func clusterNodeId(addr string) string {
+	b := sha1.Sum([]byte(addr))
+	return hex.EncodeToString(b[:])
+}
+
+// CLUSTER 命令，把所有proxy模拟成一个Redis Cluster，支持Redis Cluster协议的客户端可以直接连接proxy
+func (s *Session) handleCluster(r *Request, d Dispatcher) (*Request, error) {
+	var subcmd string
+	if len(r.Resp.Array) >= 2 {
+		subcmd = strings.ToUpper(string(r.Resp.Array[1].Value))
+	}
+	var nargs = len(r.Resp.Array)
+	nodes, self := d.ClusterNodes()
+	if subcmd != "KEYSLOT" && len(nodes) == 0 {
+		r.Response.Resp = redis.NewError([]byte("CLUSTERDOWN The cluster is down"))
+		return r, nil
+	}
+	switch {
+	case subcmd == "SLOTS" && nargs == 2:
+		var array = make([]*redis.Resp, len(nodes))
+		for i, addr := range nodes {
+			beg, end := clusterSlotRange(i, len(nodes))
+			host, port, err := net.SplitHostPort(addr)
+			if err != nil {
+				host, port = addr, "0"
+			}
+			array[i] = redis.NewArray([]*redis.Resp{
+				redis.NewInt([]byte(strconv.Itoa(beg))),
+				redis.NewInt([]byte(strconv.Itoa(end))),
+				redis.NewArray([]*redis.Resp{
+					redis.NewBulkBytes([]byte(host)),
+					redis.NewInt([]byte(port)),
+					redis.NewBulkBytes([]byte(clusterNodeId(addr))),
+				}),
+			})
+		}
+		r.Response.Resp = redis.NewArray(array)
+	case subcmd == "NODES" && nargs == 2:
+		var b bytes.Buffer
+		for i, addr := range nodes {
+			beg, end := clusterSlotRange(i, len(nodes))
+			flags := "master"
+			if addr == self {
+				flags = "myself,master"
+			}
+			fmt.Fprintf(&b, "%s %s %s - 0 0 %d connected %d-%d\n", clusterNodeId(addr), addr, flags, i+1, beg, end)
+		}
+		r.Response.Resp = redis.NewBulkBytes(b.Bytes())
+	case subcmd == "INFO" && nargs == 2:
+		var b bytes.Buffer
+		fmt.Fprintf(&b, "cluster_state:ok\r\n")
+		fmt.Fprintf(&b, "cluster_slots_assigned:%d\r\n", clusterSlotNum)
+		fmt.Fprintf(&b, "cluster_slots_ok:%d\r\n", clusterSlotNum)
+		fmt.Fprintf(&b, "cluster_slots_pfail:0\r\n")
+		fmt.Fprintf(&b, "cluster_slots_fail:0\r\n")
+		fmt.Fprintf(&b, "cluster_known_nodes:%d\r\n", len(nodes))
+		fmt.Fprintf(&b, "cluster_size:%d\r\n", len(nodes))
+		fmt.Fprintf(&b, "cluster_current_epoch:%d\r\n", len(nodes))
+		fmt.Fprintf(&b, "cluster_my_epoch:%d\r\n", sort.SearchStrings(nodes, self)+1)
+		r.Response.Resp = redis.NewBulkBytes(b.Bytes())
+	case subcmd == "KEYSLOT" && nargs == 3:
+		// 返回的是codis的slot，不是Redis Cluster的slot
+		r.Response.Resp = redis.NewInt([]byte(strconv.Itoa(hashSlot(r.Resp.Array[2].Value))))
+	default:
+		r.Response.Resp = redis.NewError([]byte("ERR Unknown CLUSTER subcommand or wrong number of arguments"))
+	}
+	return r, nil
+}
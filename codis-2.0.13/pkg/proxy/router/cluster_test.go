@@ -0,0 +1,42 @@
+// Copyright 2016 CodisLabs. All Rights Reserved.
+// Licensed under the MIT (MIT-LICENSE.txt) license.
+
+package router
+
+import (
+	"testing"
+
+	"github.com/CodisLabs/codis/pkg/utils/assert"
+)
+
+func TestClusterSlotRange(t *testing.T) {
+	for n := 1; n <= 7; n++ {
+		var next = 0
+		for i := 0; i < n; i++ {
+			beg, end := clusterSlotRange(i, n)
+			assert.Must(beg == next && end >= beg)
+			next = end + 1
+		}
+		assert.Must(next == clusterSlotNum)
+	}
+}
+
+func TestClusterNodes(t *testing.T) {
+	s := New()
+	defer s.Close()
+
+	nodes, self := s.ClusterNodes()
+	assert.Must(len(nodes) == 0 && self == "")
+
+	s.SetClusterNodes([]string{"10.0.0.3:19000", "10.0.0.1:19000"}, "10.0.0.2:19000")
+	nodes, self = s.ClusterNodes()
+	assert.Must(self == "10.0.0.2:19000")
+	assert.Must(len(nodes) == 3 && nodes[0] == "10.0.0.1:19000" && nodes[1] == self && nodes[2] == "10.0.0.3:19000")
+
+	s.SetClusterNodes([]string{"10.0.0.2:19000"}, "10.0.0.2:19000")
+	nodes, _ = s.ClusterNodes()
+	assert.Must(len(nodes) == 1)
+
+	id := clusterNodeId("10.0.0.1:19000")
+	assert.Must(len(id) == 40 && id == clusterNodeId("10.0.0.1:19000") && id != clusterNodeId("10.0.0.2:19000"))
+}
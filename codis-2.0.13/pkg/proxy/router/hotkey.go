@@ -0,0 +1,194 @@
+// Copyright 2016 CodisLabs. All Rights Reserved.
+// Licensed under the MIT (MIT-LICENSE.txt) license.
+
+package router
+
+import (
+	"math/rand"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/CodisLabs/codis/pkg/utils/atomic2"
+	"github.com/CodisLabs/codis/pkg/utils/topk"
+)
+
+const (
+	hotKeyGlobalK  = 32 // 整个proxy保存的热点key数
+	hotKeySlotK    = 4  // 每个slot保存的热点key数
+	hotSlotTopN    = 16 // 返回的热点slot数
+	hotKeyDecay    = time.Minute
+	hotKeyShards   = 16 // 按slot分片，采样时只锁key所在的分片
+	hotKeyCMSWidth = 1 << 12
+	hotKeyCMSDepth = 4
+)
+
+// 热点key统计，按比例采样请求中的key，后台每分钟所有计数减半，整个proxy共享
+var hotkeys struct {
+	rate  atomic2.Int64 // 采样的请求百分比，0表示不统计
+	decay sync.Once
+
+	shards [hotKeyShards]hotKeyShard
+}
+
+// slot i 的统计保存在第 i%hotKeyShards 个分片中，不同分片的key互不相同
+type hotKeyShard struct {
+	sync.Mutex
+	sketch *topk.Sketch
+	global *topk.TopK // 分片中的热点key，合并后得到整个proxy的热点key
+	slots  map[int]*topk.TopK
+	counts map[int]uint64 // 每个slot采样到的key数
+}
+
+func (s *hotKeyShard) reset() {
+	s.Lock()
+	defer s.Unlock()
+	s.sketch = topk.NewSketch(hotKeyCMSWidth, hotKeyCMSDepth)
+	s.global = topk.NewTopK(hotKeyGlobalK)
+	s.slots = make(map[int]*topk.TopK)
+	s.counts = make(map[int]uint64)
+}
+
+func (s *hotKeyShard) decay() {
+	s.Lock()
+	defer s.Unlock()
+	s.sketch.Decay()
+	s.global.Decay()
+	for i, t := range s.slots {
+		t.Decay()
+		if s.counts[i] >>= 1; s.counts[i] == 0 {
+			delete(s.slots, i)
+			delete(s.counts, i)
+		}
+	}
+}
+
+func init() {
+	SetHotKeySampleRate(0)
+}
+
+// 设置采样的请求百分比，已有的统计会被清空
+func SetHotKeySampleRate(rate int) {
+	hotkeys.rate.Set(int64(rate))
+	for i := range hotkeys.shards {
+		hotkeys.shards[i].reset()
+	}
+	if rate != 0 {
+		hotkeys.decay.Do(func() {
+			go loopDecayHotKeys()
+		})
+	}
+}
+
+// 定期将所有计数减半，让旧的访问逐渐失去影响
+func loopDecayHotKeys() {
+	for range time.Tick(hotKeyDecay) {
+		decayHotKeys()
+	}
+}
+
+func decayHotKeys() {
+	for i := range hotkeys.shards {
+		hotkeys.shards[i].decay()
+	}
+}
+
+// 按比例采样请求中的key
+func sampleHotKeys(r *Request) {
+	rate := hotkeys.rate.Get()
+	if rate == 0 || rand.Int63n(100) >= rate {
+		return
+	}
+	for _, i := range getKeyIndexes(r.Resp, r.OpStr) {
+		key := r.Resp.Array[i].Value
+		slot := hashSlot(key)
+		s := &hotkeys.shards[slot%hotKeyShards]
+		s.Lock()
+		count := s.sketch.Add(key, 1)
+		s.global.Update(key, count)
+		if s.slots[slot] == nil {
+			s.slots[slot] = topk.NewTopK(hotKeySlotK)
+		}
+		s.slots[slot].Update(key, count)
+		s.counts[slot]++
+		s.Unlock()
+	}
+}
+
+type HotKey struct {
+	Key   string `json:"key"`
+	Slot  int    `json:"slot"`
+	Count uint64 `json:"count"`
+}
+
+type HotSlot struct {
+	Slot  int      `json:"slot"`
+	Count uint64   `json:"count"`
+	Keys  []HotKey `json:"keys"` // slot中的热点key
+}
+
+// 热点key和热点slot，次数是按采样比例换算后的估计值
+type HotKeys struct {
+	Rate  int       `json:"rate"`
+	Keys  []HotKey  `json:"keys"`
+	Slots []HotSlot `json:"slots"`
+}
+
+func GetHotKeys() *HotKeys {
+	rate := hotkeys.rate.Get()
+	ret := &HotKeys{Rate: int(rate), Keys: []HotKey{}, Slots: []HotSlot{}}
+	if rate == 0 {
+		return ret
+	}
+	scale := func(n uint64) uint64 {
+		return n * 100 / uint64(rate)
+	}
+	var keys []topk.Item
+	var slots = make(map[int][]topk.Item)
+	for i := range hotkeys.shards {
+		s := &hotkeys.shards[i]
+		s.Lock()
+		keys = append(keys, s.global.List()...)
+		for slot, n := range s.counts {
+			ret.Slots = append(ret.Slots, HotSlot{Slot: slot, Count: scale(n)})
+			slots[slot] = s.slots[slot].List()
+		}
+		s.Unlock()
+	}
+	topk.SortItems(keys)
+	if len(keys) > hotKeyGlobalK {
+		keys = keys[:hotKeyGlobalK]
+	}
+	for _, x := range keys {
+		ret.Keys = append(ret.Keys, HotKey{Key: x.Key, Slot: hashSlot([]byte(x.Key)), Count: scale(x.Count)})
+	}
+	sort.Sort(hotSlotsByCount(ret.Slots))
+	if len(ret.Slots) > hotSlotTopN {
+		ret.Slots = ret.Slots[:hotSlotTopN]
+	}
+	for i := range ret.Slots {
+		x := &ret.Slots[i]
+		x.Keys = []HotKey{}
+		for _, k := range slots[x.Slot] {
+			x.Keys = append(x.Keys, HotKey{Key: k.Key, Slot: x.Slot, Count: scale(k.Count)})
+		}
+	}
+	return ret
+}
+
+type hotSlotsByCount []HotSlot
+
+func (b hotSlotsByCount) Len() int {
+	return len(b)
+}
+
+func (b hotSlotsByCount) Less(i, j int) bool {
+	if b[i].Count != b[j].Count {
+		return b[i].Count > b[j].Count
+	}
+	return b[i].Slot < b[j].Slot
+}
+
+func (b hotSlotsByCount) Swap(i, j int) {
+	b[i], b[j] = b[j], b[i]
+}
@@ -0,0 +1,77 @@
+// Copyright 2016 CodisLabs. All Rights Reserved.
+// Licensed under the MIT (MIT-LICENSE.txt) license.
+
+package router
+
+import (
+	"strconv"
+	"sync"
+	"testing"
+
+	"github.com/CodisLabs/codis/pkg/utils/assert"
+)
+
+func TestHotKeys(t *testing.T) {
+	defer SetHotKeySampleRate(0)
+
+	SetHotKeySampleRate(0)
+	sampleHotKeys(newTestRequest("GET", "hot"))
+	assert.Must(len(GetHotKeys().Keys) == 0)
+
+	SetHotKeySampleRate(100)
+	for i := 0; i < 100; i++ {
+		sampleHotKeys(newTestRequest("GET", "hot"))
+		sampleHotKeys(newTestRequest("MGET", "warm", "cold"+strconv.Itoa(i)))
+		if i%2 == 0 {
+			sampleHotKeys(newTestRequest("SET", "warm", "v"))
+		}
+		sampleHotKeys(newTestRequest("PING"))
+	}
+	h := GetHotKeys()
+	assert.Must(h.Rate == 100 && len(h.Keys) == hotKeyGlobalK)
+	assert.Must(h.Keys[0].Key == "warm" && h.Keys[0].Count >= 150)
+	assert.Must(h.Keys[1].Key == "hot" && h.Keys[1].Count >= 100)
+	assert.Must(h.Keys[1].Slot == hashSlot([]byte("hot")))
+
+	assert.Must(len(h.Slots) != 0 && len(h.Slots) <= hotSlotTopN)
+	for i := 1; i < len(h.Slots); i++ {
+		assert.Must(h.Slots[i-1].Count >= h.Slots[i].Count)
+	}
+	var found = false
+	for _, x := range h.Slots {
+		if x.Slot == hashSlot([]byte("warm")) {
+			found = x.Count >= 150 && x.Keys[0].Key == "warm"
+		}
+	}
+	assert.Must(found)
+}
+
+func TestHotKeysDecay(t *testing.T) {
+	defer SetHotKeySampleRate(0)
+
+	SetHotKeySampleRate(100)
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				sampleHotKeys(newTestRequest("GET", "hot"))
+			}
+		}()
+	}
+	wg.Wait()
+	h := GetHotKeys()
+	assert.Must(len(h.Keys) == 1 && h.Keys[0].Count == 800)
+	assert.Must(len(h.Slots) == 1 && h.Slots[0].Count == 800)
+
+	// 计数减半，减到0的slot不再返回
+	decayHotKeys()
+	h = GetHotKeys()
+	assert.Must(len(h.Keys) == 1 && h.Keys[0].Count == 400)
+	for i := 0; i < 10; i++ {
+		decayHotKeys()
+	}
+	h = GetHotKeys()
+	assert.Must(len(h.Keys) == 0 && len(h.Slots) == 0)
+}
@@ -0,0 +1,114 @@
+// Copyright 2016 CodisLabs. All Rights Reserved.
+// Licensed under the MIT (MIT-LICENSE.txt) license.
+
+package router
+
+import (
+	"math"
+
+	"github.com/CodisLabs/codis/pkg/utils/errors"
+)
+
+// redis中HyperLogLog的存储格式，用于在proxy中合并不同slot的key
+const (
+	hllP         = 14
+	hllQ         = 64 - hllP
+	hllRegisters = 1 << hllP
+	hllBits      = 6
+	hllHdrSize   = 16 // "HYLL" + 编码 + 3字节保留 + 8字节缓存的基数
+	hllDenseSize = (hllRegisters*hllBits + 7) / 8
+	hllDense     = 0
+	hllSparse    = 1
+)
+
+var errInvalidHLL = errors.New("WRONGTYPE Key is not a valid HyperLogLog string value.")
+
+// 解析redis中HyperLogLog的值，每个寄存器取最大值合并到registers中
+func hllMerge(registers []uint8, b []byte) error {
+	if len(b) < hllHdrSize || string(b[:4]) != "HYLL" {
+		return errInvalidHLL
+	}
+	data := b[hllHdrSize:]
+	switch b[4] {
+	case hllDense:
+		if len(data) != hllDenseSize {
+			return errInvalidHLL
+		}
+		for i := 0; i < hllRegisters; i++ {
+			pos := i * hllBits
+			v := uint16(data[pos/8])
+			if pos/8+1 < len(data) {
+				v |= uint16(data[pos/8+1]) << 8
+			}
+			if x := uint8(v>>uint(pos&7)) & 63; x > registers[i] {
+				registers[i] = x
+			}
+		}
+	case hllSparse:
+		var idx = 0
+		for p := 0; p < len(data); p++ {
+			op := data[p]
+			switch {
+			case op&0xc0 == 0x00:
+				// ZERO: 00xxxxxx，连续 xxxxxx+1 个寄存器为0
+				idx += int(op&0x3f) + 1
+			case op&0xc0 == 0x40:
+				// XZERO: 01xxxxxx yyyyyyyy，连续 xxxxxxyyyyyyyy+1 个寄存器为0
+				if p++; p == len(data) {
+					return errInvalidHLL
+				}
+				idx += (int(op&0x3f)<<8 | int(data[p])) + 1
+			default:
+				// VAL: 1vvvvvxx，连续 xx+1 个寄存器的值为 vvvvv+1
+				x, n := (op>>2)&0x1f+1, int(op&3)+1
+				if idx+n > hllRegisters {
+					return errInvalidHLL
+				}
+				for j := idx; j < idx+n; j++ {
+					if x > registers[j] {
+						registers[j] = x
+					}
+				}
+				idx += n
+			}
+			if idx > hllRegisters {
+				return errInvalidHLL
+			}
+		}
+		if idx != hllRegisters {
+			return errInvalidHLL
+		}
+	default:
+		return errInvalidHLL
+	}
+	return nil
+}
+
+// 根据寄存器估计基数，和 codis-server 基于的 redis 2.8 使用的算法相同
+// 基数较小时使用线性计数，2.5m 到 72000 之间按多项式拟合的偏差修正
+func hllCount(registers []uint8) uint64 {
+	m := float64(hllRegisters)
+	alpha := 0.7213 / (1 + 1.079/m)
+
+	var e float64
+	var ez int // 值为0的寄存器数
+	for _, x := range registers {
+		if x == 0 {
+			ez++
+		}
+		e += 1.0 / float64(uint64(1)<<(x&63))
+	}
+	e = (1 / e) * alpha * m * m
+
+	if e < m*2.5 && ez != 0 {
+		e = m * math.Log(m/float64(ez))
+	} else if e < 72000 {
+		bias := 5.9119*1.0e-18*(e*e*e*e) -
+			1.4253*1.0e-12*(e*e*e) +
+			1.2940*1.0e-7*(e*e) -
+			5.2921*1.0e-3*e +
+			83.3216
+		e -= e * (bias / 100)
+	}
+	return uint64(e)
+}
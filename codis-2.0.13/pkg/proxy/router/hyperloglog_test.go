@@ -0,0 +1,104 @@
+// Copyright 2016 CodisLabs. All Rights Reserved.
+// Licensed under the MIT (MIT-LICENSE.txt) license.
+
+package router
+
+import (
+	"math/rand"
+	"testing"
+
+	"github.com/CodisLabs/codis/pkg/utils/assert"
+)
+
+// 模拟redis的PFADD，随机的hash值决定寄存器和它的值
+func hllAddRandom(registers []uint8, n int) {
+	for i := 0; i < n; i++ {
+		h := uint64(rand.Int63())
+		idx := h & (hllRegisters - 1)
+		bits := h>>hllP | 1<<hllQ
+		x := uint8(1)
+		for bits&1 == 0 {
+			bits >>= 1
+			x++
+		}
+		if x > registers[idx] {
+			registers[idx] = x
+		}
+	}
+}
+
+func hllEncodeDense(registers []uint8) []byte {
+	b := append([]byte("HYLL"), make([]byte, hllHdrSize-4+hllDenseSize)...)
+	data := b[hllHdrSize:]
+	for i, x := range registers {
+		pos := i * hllBits
+		data[pos/8] |= x << uint(pos&7)
+		if pos&7 > 2 {
+			data[pos/8+1] |= x >> uint(8-pos&7)
+		}
+	}
+	return b
+}
+
+func hllEncodeSparse(registers []uint8) []byte {
+	b := append([]byte("HYLL"), hllSparse, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0)
+	for i := 0; i < len(registers); {
+		j := i
+		for j < len(registers) && registers[j] == registers[i] {
+			j++
+		}
+		for n := j - i; n != 0; {
+			x := registers[i]
+			switch {
+			case x != 0:
+				k := n
+				if k > 4 {
+					k = 4
+				}
+				b = append(b, 0x80|(x-1)<<2|uint8(k-1))
+				n -= k
+			case n > 64:
+				k := n
+				if k > 16384 {
+					k = 16384
+				}
+				b = append(b, 0x40|uint8((k-1)>>8), uint8(k-1))
+				n -= k
+			default:
+				b = append(b, uint8(n-1))
+				n = 0
+			}
+		}
+		i = j
+	}
+	return b
+}
+
+func TestHyperLogLog(t *testing.T) {
+	assert.Must(hllCount(make([]uint8, hllRegisters)) == 0)
+
+	for _, n := range []int{100, 1000, 20000, 100000} {
+		a := make([]uint8, hllRegisters)
+		b := make([]uint8, hllRegisters)
+		hllAddRandom(a, n)
+		hllAddRandom(b, n)
+
+		registers := make([]uint8, hllRegisters)
+		assert.MustNoError(hllMerge(registers, hllEncodeDense(a)))
+		assert.MustNoError(hllMerge(registers, hllEncodeSparse(b)))
+		for i := range registers {
+			x := a[i]
+			if b[i] > x {
+				x = b[i]
+			}
+			assert.Must(registers[i] == x)
+		}
+		count := float64(hllCount(registers))
+		assert.Must(count > float64(n*2)*0.97 && count < float64(n*2)*1.03)
+	}
+
+	registers := make([]uint8, hllRegisters)
+	assert.Must(hllMerge(registers, []byte("HYLL")) != nil)
+	assert.Must(hllMerge(registers, []byte("not a hyperloglog value")) != nil)
+	assert.Must(hllMerge(registers, append([]byte("HYLL"), hllSparse, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0x7f)) != nil)
+}
@@ -0,0 +1,238 @@
+// Copyright 2016 CodisLabs. All Rights Reserved.
+// Licensed under the MIT (MIT-LICENSE.txt) license.
+
+package router
+
+import (
+	"fmt"
+	"math/rand"
+	"strconv"
+
+	"github.com/CodisLabs/codis/pkg/proxy/redis"
+	"github.com/CodisLabs/codis/pkg/utils/errors"
+)
+
+// SCAN 的游标由两部分组成，低位是后端redis-server的序号，高位是该redis-server返回的游标
+// 序号是按地址排序后的位置，增删group或者迁移slot之后可能漏掉或者重复返回一些key
+const scanIndexBits = 16
+
+func encodeScanCursor(index int, cursor uint64) uint64 {
+	return cursor<<scanIndexBits | uint64(index)
+}
+
+func decodeScanCursor(v uint64) (int, uint64) {
+	return int(v & (1<<scanIndexBits - 1)), v >> scanIndexBits
+}
+
+// 需要遍历所有redis-server的命令
+func isKeyspaceCommand(opstr string) bool {
+	switch opstr {
+	case "SCAN", "KEYS", "DBSIZE", "RANDOMKEY":
+		return true
+	}
+	return false
+}
+
+// 将请求发往所有的redis-server，每个redis-server对应一个子请求
+func (s *Session) fanout(r *Request, d Dispatcher) ([]*Request, error) {
+	addrs := d.Backends()
+	var sub = make([]*Request, len(addrs))
+	for i, addr := range addrs {
+		sub[i] = &Request{
+			OpStr:  r.OpStr,
+			Start:  r.Start,
+			Resp:   r.Resp,
+			Wait:   r.Wait,
+			Failed: r.Failed,
+
+			affinity: r.affinity,
+		}
+		if err := d.DispatchAddr(sub[i], addr); err != nil {
+			return nil, err
+		}
+	}
+	return sub, nil
+}
+
+// 检查子请求的返回结果
+func checkSubResponse(x *Request) (*redis.Resp, error) {
+	if err := x.Response.Err; err != nil {
+		return nil, err
+	}
+	resp := x.Response.Resp
+	if resp == nil {
+		return nil, ErrRespIsRequired
+	}
+	return resp, nil
+}
+
+// SCAN 命令依次遍历每个redis-server，通过游标记录遍历到了哪个redis-server
+func (s *Session) handleRequestScan(r *Request, d Dispatcher) (*Request, error) {
+	if len(r.Resp.Array) < 2 {
+		r.Response.Resp = redis.NewError([]byte("ERR wrong number of arguments for 'scan' command"))
+		return r, nil
+	}
+	v, err := strconv.ParseUint(string(r.Resp.Array[1].Value), 10, 64)
+	if err != nil {
+		r.Response.Resp = redis.NewError([]byte("ERR invalid cursor"))
+		return r, nil
+	}
+	index, cursor := decodeScanCursor(v)
+
+	addrs := d.Backends()
+	if index >= len(addrs) {
+		r.Response.Resp = redis.NewArray([]*redis.Resp{
+			redis.NewBulkBytes([]byte("0")),
+			redis.NewArray([]*redis.Resp{}),
+		})
+		return r, nil
+	}
+
+	var array = make([]*redis.Resp, len(r.Resp.Array))
+	copy(array, r.Resp.Array)
+	array[1] = redis.NewBulkBytes([]byte(strconv.FormatUint(cursor, 10)))
+	sub := &Request{
+		OpStr:  r.OpStr,
+		Start:  r.Start,
+		Resp:   redis.NewArray(array),
+		Wait:   r.Wait,
+		Failed: r.Failed,
+
+		affinity: r.affinity,
+	}
+	if err := d.DispatchAddr(sub, addrs[index]); err != nil {
+		return nil, err
+	}
+	r.Coalesce = func() error {
+		resp, err := checkSubResponse(sub)
+		if err != nil {
+			return err
+		}
+		if resp.IsError() {
+			r.Response.Resp = resp
+			return nil
+		}
+		if !resp.IsArray() || len(resp.Array) != 2 {
+			return errors.New(fmt.Sprintf("bad scan resp: %s array.len = %d", resp.Type, len(resp.Array)))
+		}
+		next, err := strconv.ParseUint(string(resp.Array[0].Value), 10, 64)
+		if err != nil {
+			return errors.New(fmt.Sprintf("bad scan resp: cursor = %s", resp.Array[0].Value))
+		}
+		// 当前redis-server遍历结束，下次从下一个redis-server开始
+		if next == 0 {
+			if index+1 < len(addrs) {
+				next = encodeScanCursor(index+1, 0)
+			}
+		} else {
+			next = encodeScanCursor(index, next)
+		}
+		r.Response.Resp = redis.NewArray([]*redis.Resp{
+			redis.NewBulkBytes([]byte(strconv.FormatUint(next, 10))),
+			resp.Array[1],
+		})
+		return nil
+	}
+	return r, nil
+}
+
+// KEYS 命令发往所有的redis-server，合并返回结果
+func (s *Session) handleRequestKeys(r *Request, d Dispatcher) (*Request, error) {
+	if len(r.Resp.Array) != 2 {
+		r.Response.Resp = redis.NewError([]byte("ERR wrong number of arguments for 'keys' command"))
+		return r, nil
+	}
+	sub, err := s.fanout(r, d)
+	if err != nil {
+		return nil, err
+	}
+	r.Coalesce = func() error {
+		var array = make([]*redis.Resp, 0, 64)
+		for _, x := range sub {
+			resp, err := checkSubResponse(x)
+			if err != nil {
+				return err
+			}
+			if resp.IsError() {
+				r.Response.Resp = resp
+				return nil
+			}
+			if !resp.IsArray() {
+				return errors.New(fmt.Sprintf("bad keys resp: %s", resp.Type))
+			}
+			array = append(array, resp.Array...)
+		}
+		r.Response.Resp = redis.NewArray(array)
+		return nil
+	}
+	return r, nil
+}
+
+// DBSIZE 命令发往所有的redis-server，返回结果之和
+func (s *Session) handleRequestDBSize(r *Request, d Dispatcher) (*Request, error) {
+	if len(r.Resp.Array) != 1 {
+		r.Response.Resp = redis.NewError([]byte("ERR wrong number of arguments for 'dbsize' command"))
+		return r, nil
+	}
+	sub, err := s.fanout(r, d)
+	if err != nil {
+		return nil, err
+	}
+	r.Coalesce = func() error {
+		var n int64
+		for _, x := range sub {
+			resp, err := checkSubResponse(x)
+			if err != nil {
+				return err
+			}
+			if resp.IsError() {
+				r.Response.Resp = resp
+				return nil
+			}
+			v, err := strconv.ParseInt(string(resp.Value), 10, 64)
+			if !resp.IsInt() || err != nil {
+				return errors.New(fmt.Sprintf("bad dbsize resp: %s value = %s", resp.Type, resp.Value))
+			}
+			n += v
+		}
+		r.Response.Resp = redis.NewInt([]byte(strconv.FormatInt(n, 10)))
+		return nil
+	}
+	return r, nil
+}
+
+// RANDOMKEY 命令发往所有的redis-server，从不为空的redis-server中随机选择一个结果
+// 直接随机选择一个redis-server的话，如果恰好选中空的redis-server会错误地返回nil
+func (s *Session) handleRequestRandomKey(r *Request, d Dispatcher) (*Request, error) {
+	if len(r.Resp.Array) != 1 {
+		r.Response.Resp = redis.NewError([]byte("ERR wrong number of arguments for 'randomkey' command"))
+		return r, nil
+	}
+	sub, err := s.fanout(r, d)
+	if err != nil {
+		return nil, err
+	}
+	r.Coalesce = func() error {
+		var keys = make([]*redis.Resp, 0, len(sub))
+		for _, x := range sub {
+			resp, err := checkSubResponse(x)
+			if err != nil {
+				return err
+			}
+			if resp.IsError() {
+				r.Response.Resp = resp
+				return nil
+			}
+			if resp.Value != nil {
+				keys = append(keys, resp)
+			}
+		}
+		if len(keys) == 0 {
+			r.Response.Resp = redis.NewBulkBytes(nil)
+		} else {
+			r.Response.Resp = keys[rand.Intn(len(keys))]
+		}
+		return nil
+	}
+	return r, nil
+}
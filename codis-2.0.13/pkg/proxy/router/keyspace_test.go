@@ -0,0 +1,46 @@
+// Copyright 2016 CodisLabs. All Rights Reserved.
+// Licensed under the MIT (MIT-LICENSE.txt) license.
+
+package router
+
+import (
+	"testing"
+
+	"github.com/CodisLabs/codis/pkg/utils/assert"
+)
+
+func TestScanCursor(t *testing.T) {
+	var m = map[uint64]int{
+		0:          0,
+		1:          3,
+		1234567:    15,
+		1<<47 - 1:  0,
+		1 << 40:    1<<16 - 1,
+		9876543210: 128,
+	}
+	for cursor, index := range m {
+		v := encodeScanCursor(index, cursor)
+		i, c := decodeScanCursor(v)
+		assert.Must(i == index && c == cursor)
+	}
+	assert.Must(encodeScanCursor(0, 0) == 0)
+}
+
+func TestScanBackends(t *testing.T) {
+	s := New()
+	defer s.Close()
+	for i, addr := range []string{"10.0.0.3:6379", "10.0.0.1:6379", "10.0.0.2:6379"} {
+		s.slots[i].backend.addr = addr
+	}
+	addrs := s.Backends()
+	assert.Must(len(addrs) == 3 && addrs[0] == "10.0.0.1:6379" && addrs[2] == "10.0.0.3:6379")
+
+	// slot在已有的redis-server之间迁移，序号不变
+	s.slots[0].backend.addr, s.slots[0].migrate.from = "10.0.0.1:6379", "10.0.0.3:6379"
+	s.slots[1].backend.addr = "10.0.0.3:6379"
+	next := s.Backends()
+	assert.Must(len(next) == len(addrs))
+	for i := range addrs {
+		assert.Must(next[i] == addrs[i])
+	}
+}
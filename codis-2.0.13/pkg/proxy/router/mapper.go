@@ -6,6 +6,7 @@ package router
 import (
 	"bytes"
 	"hash/crc32"
+	"strconv"
 	"strings"
 
 	"github.com/CodisLabs/codis/pkg/proxy/redis"
@@ -31,17 +32,39 @@ var (
 func init() {
 	// 不支持的命令列表
 	for _, s := range []string{
-		"KEYS", "MOVE", "OBJECT", "RENAME", "RENAMENX", "SCAN", "BITOP", "MSETNX", "MIGRATE", "RESTORE",
-		"BLPOP", "BRPOP", "BRPOPLPUSH", "PSUBSCRIBE", "PUBLISH", "PUNSUBSCRIBE", "SUBSCRIBE", "RANDOMKEY",
-		"UNSUBSCRIBE", "DISCARD", "EXEC", "MULTI", "UNWATCH", "WATCH", "SCRIPT",
-		"BGREWRITEAOF", "BGSAVE", "CLIENT", "CONFIG", "DBSIZE", "DEBUG", "FLUSHALL", "FLUSHDB",
-		"LASTSAVE", "MONITOR", "SAVE", "SHUTDOWN", "SLAVEOF", "SLOWLOG", "SYNC", "TIME",
+		"MOVE", "OBJECT", "RENAME", "RENAMENX", "BITOP", "MIGRATE", "RESTORE",
+		"BGREWRITEAOF", "BGSAVE", "CLIENT", "CONFIG", "DEBUG", "FLUSHALL", "FLUSHDB",
+		"LASTSAVE", "MONITOR", "SAVE", "SHUTDOWN", "SLAVEOF", "SYNC", "TIME",
 		"SLOTSINFO", "SLOTSDEL", "SLOTSMGRTSLOT", "SLOTSMGRTONE", "SLOTSMGRTTAGSLOT", "SLOTSMGRTTAGONE", "SLOTSCHECK",
 	} {
 		blacklist[s] = true
 	}
 }
 
+var (
+	readonly = make(map[string]bool)
+)
+
+func init() {
+	// 只读命令列表，开启读写分离时可以发往slave
+	for _, s := range []string{
+		"DUMP", "EXISTS", "PTTL", "TTL", "TYPE",
+		"BITCOUNT", "GET", "GETBIT", "GETRANGE", "MGET", "STRLEN", "SUBSTR",
+		"HEXISTS", "HGET", "HGETALL", "HKEYS", "HLEN", "HMGET", "HVALS", "HSCAN",
+		"LINDEX", "LLEN", "LRANGE",
+		"SCARD", "SDIFF", "SINTER", "SISMEMBER", "SMEMBERS", "SRANDMEMBER", "SUNION", "SSCAN",
+		"ZCARD", "ZCOUNT", "ZLEXCOUNT", "ZRANGE", "ZRANGEBYLEX", "ZRANGEBYSCORE", "ZRANK",
+		"ZREVRANGE", "ZREVRANGEBYLEX", "ZREVRANGEBYSCORE", "ZREVRANK", "ZSCORE", "ZSCAN",
+	} {
+		readonly[s] = true
+	}
+}
+
+// 检查redis命令是否是只读命令
+func isReadOnly(opstr string) bool {
+	return readonly[opstr]
+}
+
 // 检查redis命令是否不支持
 func isNotAllowed(opstr string) bool {
 	return blacklist[opstr]
@@ -81,6 +104,11 @@ func getOpStr(resp *redis.Resp) (string, error) {
 	return string(upper[:len(op)]), nil
 }
 
+// key所在的slot，codis-config 导入数据时也按照这个规则路由
+func HashSlot(key []byte) int {
+	return hashSlot(key)
+}
+
 func hashSlot(key []byte) int {
 	const (
 		TagBeg = '{'
@@ -97,11 +125,111 @@ func hashSlot(key []byte) int {
 func getHashKey(resp *redis.Resp, opstr string) []byte {
 	var index = 1
 	switch opstr {
-	case "ZINTERSTORE", "ZUNIONSTORE", "EVAL", "EVALSHA":
+	case "ZINTERSTORE", "ZUNIONSTORE":
 		index = 3
+	case "EVAL", "EVALSHA":
+		// numkeys为0时脚本不访问任何key
+		if keys := getScriptKeys(resp); len(keys) != 0 {
+			return keys[0]
+		}
+		return nil
 	}
 	if index < len(resp.Array) {
 		return resp.Array[index].Value
 	}
 	return nil
 }
+
+// 获取命令涉及的所有key，用于检查这些key是否属于同一个slot
+// 和 getKeyIndexes 使用同一张表，参数个数不够时只返回存在的key
+func getHashKeys(resp *redis.Resp, opstr string) [][]byte {
+	if opstr == "EVAL" || opstr == "EVALSHA" {
+		return getScriptKeys(resp)
+	}
+	var keys [][]byte
+	for _, i := range getKeyIndexes(resp, opstr) {
+		keys = append(keys, resp.Array[i].Value)
+	}
+	return keys
+}
+
+// 获取命令中所有key参数的位置
+func getKeyIndexes(resp *redis.Resp, opstr string) []int {
+	var n = len(resp.Array)
+	var indexes []int
+	switch opstr {
+	case "MGET", "DEL", "WATCH", "EXISTS", "UNLINK", "TOUCH",
+		"SDIFF", "SINTER", "SUNION", "SDIFFSTORE", "SINTERSTORE", "SUNIONSTORE", "PFCOUNT", "PFMERGE":
+		for i := 1; i < n; i++ {
+			indexes = append(indexes, i)
+		}
+	case "MSET", "MSETNX":
+		for i := 1; i < n; i += 2 {
+			indexes = append(indexes, i)
+		}
+	case "BLPOP", "BRPOP":
+		for i := 1; i < n-1; i++ {
+			indexes = append(indexes, i)
+		}
+	case "RPOPLPUSH", "BRPOPLPUSH", "SMOVE":
+		for i := 1; i < n && i <= 2; i++ {
+			indexes = append(indexes, i)
+		}
+	case "ZINTERSTORE", "ZUNIONSTORE":
+		if n > 1 {
+			indexes = append(indexes, 1)
+		}
+		if n > 2 {
+			numkeys, err := strconv.Atoi(string(resp.Array[2].Value))
+			for i := 3; err == nil && i < n && i < 3+numkeys; i++ {
+				indexes = append(indexes, i)
+			}
+		}
+	case "EVAL", "EVALSHA":
+		if n > 2 {
+			numkeys, err := strconv.Atoi(string(resp.Array[2].Value))
+			for i := 3; err == nil && i < n && i < 3+numkeys; i++ {
+				indexes = append(indexes, i)
+			}
+		}
+	case "CLUSTER":
+		if n == 3 && strings.ToUpper(string(resp.Array[1].Value)) == "KEYSLOT" {
+			indexes = append(indexes, 2)
+		}
+	default:
+		if n > 1 && !keyless[opstr] {
+			indexes = append(indexes, 1)
+		}
+	}
+	return indexes
+}
+
+// 不带key的命令
+var keyless = make(map[string]bool)
+
+func init() {
+	for _, s := range []string{
+		"PING", "ECHO", "SELECT", "INFO", "COMMAND", "SLOWLOG",
+		"MULTI", "EXEC", "DISCARD", "UNWATCH",
+		"SUBSCRIBE", "PSUBSCRIBE", "UNSUBSCRIBE", "PUNSUBSCRIBE", "PUBLISH", "PUBSUB",
+		"SCAN", "KEYS", "DBSIZE", "RANDOMKEY", "SCRIPT",
+	} {
+		keyless[s] = true
+	}
+}
+
+// 获取 EVAL/EVALSHA 声明的key，即 numkeys 之后的 numkeys 个参数，numkeys 不合法时返回nil
+func getScriptKeys(resp *redis.Resp) [][]byte {
+	if len(resp.Array) < 3 {
+		return nil
+	}
+	n, err := strconv.Atoi(string(resp.Array[2].Value))
+	if err != nil || n <= 0 || n > len(resp.Array)-3 {
+		return nil
+	}
+	var keys = make([][]byte, n)
+	for i := range keys {
+		keys[i] = resp.Array[3+i].Value
+	}
+	return keys
+}
@@ -4,6 +4,7 @@
 package router
 
 import (
+	"strings"
 	"testing"
 
 	"github.com/CodisLabs/codis/pkg/proxy/redis"
@@ -163,3 +164,58 @@ func TestHashSlot(t *testing.T) {
 		assert.Must(i == j)
 	}
 }
+
+func TestGetScriptKeys(t *testing.T) {
+	var m = map[string][]string{
+		"EVAL script 0":           nil,
+		"EVAL script 0 arg":       nil,
+		"EVAL script 2 k1 k2 arg": []string{"k1", "k2"},
+		"EVALSHA sha 1 k1":        []string{"k1"},
+		"EVAL script 3 k1 k2":     nil,
+		"EVAL script x k1":        nil,
+	}
+	for cmd, keys := range m {
+		var array []*redis.Resp
+		for _, s := range strings.Fields(cmd) {
+			array = append(array, redis.NewBulkBytes([]byte(s)))
+		}
+		resp := redis.NewArray(array)
+		hkeys := getHashKeys(resp, string(array[0].Value))
+		assert.Must(len(hkeys) == len(keys))
+		for i, key := range keys {
+			assert.Must(string(hkeys[i]) == key)
+		}
+		if len(keys) != 0 {
+			assert.Must(string(getHashKey(resp, string(array[0].Value))) == keys[0])
+		} else {
+			assert.Must(getHashKey(resp, string(array[0].Value)) == nil)
+		}
+	}
+}
+
+func TestGetHashKeys(t *testing.T) {
+	var m = map[string][]string{
+		"BLPOP":                 nil,
+		"BLPOP k1":              nil,
+		"BLPOP k1 k2 0":         []string{"k1", "k2"},
+		"BRPOPLPUSH k1":         []string{"k1"},
+		"BRPOPLPUSH k1 k2 0":    []string{"k1", "k2"},
+		"MSET k1":               []string{"k1"},
+		"MSET k1 v1 k2 v2":      []string{"k1", "k2"},
+		"ZUNIONSTORE d 2 k1 k2": []string{"d", "k1", "k2"},
+		"ZUNIONSTORE d 3 k1":    []string{"d", "k1"},
+		"GET":                   nil,
+		"ECHO hello":            nil,
+	}
+	for cmd, keys := range m {
+		var array []*redis.Resp
+		for _, s := range strings.Fields(cmd) {
+			array = append(array, redis.NewBulkBytes([]byte(s)))
+		}
+		hkeys := getHashKeys(redis.NewArray(array), string(array[0].Value))
+		assert.Must(len(hkeys) == len(keys))
+		for i, key := range keys {
+			assert.Must(string(hkeys[i]) == key)
+		}
+	}
+}
@@ -0,0 +1,238 @@
+// Copyright 2016 CodisLabs. All Rights Reserved.
+// Licensed under the MIT (MIT-LICENSE.txt) license.
+
+package router
+
+import (
+	"bytes"
+	"math/rand"
+	"sync"
+
+	"github.com/CodisLabs/codis/pkg/proxy/redis"
+	"github.com/CodisLabs/codis/pkg/utils/atomic2"
+	"github.com/CodisLabs/codis/pkg/utils/errors"
+	"github.com/CodisLabs/codis/pkg/utils/log"
+)
+
+// 流量复制的命令范围
+type MirrorMode int
+
+const (
+	MirrorAll       MirrorMode = iota // 所有命令
+	MirrorReadOnly                    // 只复制只读命令
+	MirrorWriteOnly                   // 只复制写命令
+)
+
+func ParseMirrorMode(s string) (MirrorMode, error) {
+	switch s {
+	case "all":
+		return MirrorAll, nil
+	case "read":
+		return MirrorReadOnly, nil
+	case "write":
+		return MirrorWriteOnly, nil
+	}
+	return MirrorAll, errors.Errorf("invalid mirror mode: %s", s)
+}
+
+// 一个被复制的请求，shadow是发往影子集群的副本
+type mirrorTask struct {
+	primary *Request
+	shadow  *Request
+	slot    int // 原请求的slot，决定发往影子集群的哪个地址
+}
+
+// 把一部分请求异步地复制到影子集群，丢弃影子集群的返回，只统计和原请求返回不一致的次数
+type mirror struct {
+	conns []*SharedBackendConn
+	mode  MirrorMode
+	rate  int // 复制的请求比例，百分比
+
+	tasks   chan *mirrorTask // 等待发往影子集群的请求
+	pending chan *mirrorTask // 等待比较返回结果的请求
+	done    sync.WaitGroup
+}
+
+func newMirror(addrs []string, auth string, mode MirrorMode, rate int) *mirror {
+	m := &mirror{
+		mode: mode, rate: rate,
+		tasks:   make(chan *mirrorTask, 4096),
+		pending: make(chan *mirrorTask, 4096),
+	}
+	for _, addr := range addrs {
+		m.conns = append(m.conns, NewSharedBackendConn(addr, auth, 1))
+	}
+	m.done.Add(2)
+	go m.loopPush()
+	go m.loopCheck()
+	return m
+}
+
+func (m *mirror) close() {
+	close(m.tasks)
+	m.done.Wait()
+	for _, bc := range m.conns {
+		bc.Close()
+	}
+}
+
+// 是否需要复制这个请求，不会阻塞
+func (m *mirror) sample(r *Request) bool {
+	switch m.mode {
+	case MirrorReadOnly:
+		if !isReadOnly(r.OpStr) {
+			return false
+		}
+	case MirrorWriteOnly:
+		if isReadOnly(r.OpStr) {
+			return false
+		}
+	}
+	return r.Wait != nil && rand.Intn(100) < m.rate
+}
+
+// 复制请求，队列满了就丢弃，不影响原请求的处理
+func (m *mirror) copy(r *Request, hkey []byte) {
+	if !m.sample(r) {
+		return
+	}
+	t := &mirrorTask{
+		primary: r,
+		shadow: &Request{
+			OpStr: r.OpStr,
+			Start: r.Start,
+			Resp:  r.Resp,
+			Wait:  &sync.WaitGroup{},
+		},
+		slot: hashSlot(hkey),
+	}
+	select {
+	case m.tasks <- t:
+		mirrorstats.total.Incr()
+	default:
+		mirrorstats.dropped.Incr()
+	}
+}
+
+func (m *mirror) loopPush() {
+	defer m.done.Done()
+	defer close(m.pending)
+	for t := range m.tasks {
+		// 同一个key总是发往同一个地址
+		bc := m.conns[t.slot%len(m.conns)]
+		bc.PushBack(t.shadow)
+		m.pending <- t
+	}
+}
+
+func (m *mirror) loopCheck() {
+	defer m.done.Done()
+	for t := range m.pending {
+		t.shadow.Wait.Wait()
+		t.primary.Wait.Wait()
+		if t.shadow.Response.Err != nil || t.primary.Response.Err != nil {
+			mirrorstats.errors.Incr()
+			continue
+		}
+		if !equalResp(t.primary.Response.Resp, t.shadow.Response.Resp) {
+			incrMirrorDivergent(t.primary.OpStr)
+		}
+	}
+}
+
+// 比较两个返回结果是否相同
+func equalResp(a, b *redis.Resp) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	if a.Type != b.Type || !bytes.Equal(a.Value, b.Value) || len(a.Array) != len(b.Array) {
+		return false
+	}
+	for i := range a.Array {
+		if !equalResp(a.Array[i], b.Array[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// 设置流量复制，addrs为空表示关闭，rate为复制的请求百分比
+func (s *Router) SetMirror(addrs []string, mode MirrorMode, rate int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return errClosedRouter
+	}
+	var m *mirror
+	if len(addrs) != 0 && rate > 0 {
+		m = newMirror(addrs, s.auth, mode, rate)
+		log.Infof("mirror %d%% of requests to %v", rate, addrs)
+	}
+	s.setMirror(m)
+	return nil
+}
+
+func (s *Router) setMirror(m *mirror) {
+	s.shadow.Lock()
+	old := s.shadow.m
+	s.shadow.m = m
+	s.shadow.Unlock()
+	if old != nil {
+		old.close()
+	}
+}
+
+// 复制请求到影子集群，持有读锁保证复制时mirror没有被关闭
+func (s *Router) copyToMirror(r *Request, hkey []byte) {
+	s.shadow.RLock()
+	defer s.shadow.RUnlock()
+	if m := s.shadow.m; m != nil {
+		m.copy(r, hkey)
+	}
+}
+
+// 流量复制的统计信息
+type MirrorStats struct {
+	Total     int64            `json:"total"`     // 复制的请求数
+	Dropped   int64            `json:"dropped"`   // 队列满了被丢弃的请求数
+	Errors    int64            `json:"errors"`    // 原请求或者副本出错的请求数
+	Divergent int64            `json:"divergent"` // 返回结果不一致的请求数
+	Commands  map[string]int64 `json:"commands"`  // 每个命令返回结果不一致的次数
+}
+
+var mirrorstats struct {
+	total     atomic2.Int64
+	dropped   atomic2.Int64
+	errors    atomic2.Int64
+	divergent atomic2.Int64
+
+	mu       sync.Mutex
+	commands map[string]int64
+}
+
+func init() {
+	mirrorstats.commands = make(map[string]int64)
+}
+
+func incrMirrorDivergent(opstr string) {
+	mirrorstats.divergent.Incr()
+	mirrorstats.mu.Lock()
+	mirrorstats.commands[opstr]++
+	mirrorstats.mu.Unlock()
+}
+
+func GetMirrorStats() *MirrorStats {
+	s := &MirrorStats{
+		Total:     mirrorstats.total.Get(),
+		Dropped:   mirrorstats.dropped.Get(),
+		Errors:    mirrorstats.errors.Get(),
+		Divergent: mirrorstats.divergent.Get(),
+		Commands:  make(map[string]int64),
+	}
+	mirrorstats.mu.Lock()
+	defer mirrorstats.mu.Unlock()
+	for opstr, n := range mirrorstats.commands {
+		s.Commands[opstr] = n
+	}
+	return s
+}
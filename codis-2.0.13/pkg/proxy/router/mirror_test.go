@@ -0,0 +1,46 @@
+// Copyright 2016 CodisLabs. All Rights Reserved.
+// Licensed under the MIT (MIT-LICENSE.txt) license.
+
+package router
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/CodisLabs/codis/pkg/proxy/redis"
+	"github.com/CodisLabs/codis/pkg/utils/assert"
+)
+
+func TestEqualResp(t *testing.T) {
+	a := redis.NewArray([]*redis.Resp{redis.NewBulkBytes([]byte("a")), redis.NewInt([]byte("1"))})
+	b := redis.NewArray([]*redis.Resp{redis.NewBulkBytes([]byte("a")), redis.NewInt([]byte("1"))})
+	assert.Must(equalResp(a, b))
+	b.Array[1] = redis.NewBulkBytes([]byte("1"))
+	assert.Must(!equalResp(a, b))
+	assert.Must(!equalResp(a, redis.NewArray(a.Array[:1])))
+	assert.Must(equalResp(redis.NewBulkBytes(nil), redis.NewBulkBytes(nil)))
+	assert.Must(!equalResp(redis.NewBulkBytes(nil), nil))
+}
+
+func TestMirrorSample(t *testing.T) {
+	for _, s := range []string{"all", "read", "write"} {
+		_, err := ParseMirrorMode(s)
+		assert.MustNoError(err)
+	}
+	_, err := ParseMirrorMode("none")
+	assert.Must(err != nil)
+
+	req := func(args ...string) *Request {
+		r := newTestRequest(args...)
+		r.Wait = &sync.WaitGroup{}
+		return r
+	}
+	m := &mirror{mode: MirrorWriteOnly, rate: 100}
+	assert.Must(m.sample(req("SET", "k", "v")))
+	assert.Must(!m.sample(req("GET", "k")))
+	m.mode = MirrorReadOnly
+	assert.Must(!m.sample(req("SET", "k", "v")))
+	assert.Must(m.sample(req("GET", "k")))
+	m.rate = 0
+	assert.Must(!m.sample(req("GET", "k")))
+}
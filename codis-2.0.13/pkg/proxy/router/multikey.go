@@ -0,0 +1,238 @@
+// Copyright 2016 CodisLabs. All Rights Reserved.
+// Licensed under the MIT (MIT-LICENSE.txt) license.
+
+package router
+
+import (
+	"fmt"
+	"strconv"
+	"sync"
+
+	"github.com/CodisLabs/codis/pkg/proxy/redis"
+	"github.com/CodisLabs/codis/pkg/utils/errors"
+)
+
+// 所有key是否属于同一个slot，属于同一个slot时可以直接转发给redis
+func inSameSlot(keys []*redis.Resp) bool {
+	for _, key := range keys[1:] {
+		if hashSlot(key.Value) != hashSlot(keys[0].Value) {
+			return false
+		}
+	}
+	return true
+}
+
+// 构造一个发往单个key所在redis的子请求
+func newSubRequest(r *Request, wait *sync.WaitGroup, opstr string, args ...*redis.Resp) *Request {
+	array := append([]*redis.Resp{redis.NewBulkBytes([]byte(opstr))}, args...)
+	return &Request{
+		OpStr:  opstr,
+		Start:  r.Start,
+		Resp:   redis.NewArray(array),
+		Wait:   wait,
+		Failed: r.Failed,
+
+		affinity: r.affinity,
+	}
+}
+
+// SINTER、SUNION、SDIFF 的key不在同一个slot时，取出每个集合在proxy中计算
+func (s *Session) handleRequestSetOp(r *Request, d Dispatcher) (*Request, error) {
+	if len(r.Resp.Array) <= 2 || inSameSlot(r.Resp.Array[1:]) {
+		return r, d.Dispatch(r)
+	}
+	keys := r.Resp.Array[1:]
+	var sub = make([]*Request, len(keys))
+	for i, key := range keys {
+		sub[i] = newSubRequest(r, r.Wait, "SMEMBERS", key)
+		if err := d.Dispatch(sub[i]); err != nil {
+			return nil, err
+		}
+	}
+	r.Coalesce = func() error {
+		var sets = make([][]*redis.Resp, len(sub))
+		for i, x := range sub {
+			resp, err := checkSubResponse(x)
+			if err != nil {
+				return err
+			}
+			if resp.IsError() {
+				r.Response.Resp = resp
+				return nil
+			}
+			if !resp.IsArray() {
+				return errors.New(fmt.Sprintf("bad smembers resp: %s", resp.Type))
+			}
+			sets[i] = resp.Array
+		}
+		r.Response.Resp = redis.NewArray(computeSetOp(r.OpStr, sets))
+		return nil
+	}
+	return r, nil
+}
+
+// 计算集合的交集、并集或者差集，结果按成员在集合中出现的顺序排列
+func computeSetOp(opstr string, sets [][]*redis.Resp) []*redis.Resp {
+	var members = func(set []*redis.Resp) map[string]bool {
+		m := make(map[string]bool, len(set))
+		for _, x := range set {
+			m[string(x.Value)] = true
+		}
+		return m
+	}
+	var result = make([]*redis.Resp, 0, len(sets[0]))
+	switch opstr {
+	case "SUNION":
+		seen := make(map[string]bool)
+		for _, set := range sets {
+			for _, x := range set {
+				if !seen[string(x.Value)] {
+					seen[string(x.Value)] = true
+					result = append(result, x)
+				}
+			}
+		}
+	case "SINTER":
+		var others = make([]map[string]bool, 0, len(sets)-1)
+		for _, set := range sets[1:] {
+			others = append(others, members(set))
+		}
+		for _, x := range sets[0] {
+			var ok = true
+			for _, m := range others {
+				if !m[string(x.Value)] {
+					ok = false
+					break
+				}
+			}
+			if ok {
+				result = append(result, x)
+			}
+		}
+	case "SDIFF":
+		var others = make(map[string]bool)
+		for _, set := range sets[1:] {
+			for _, x := range set {
+				others[string(x.Value)] = true
+			}
+		}
+		for _, x := range sets[0] {
+			if !others[string(x.Value)] {
+				result = append(result, x)
+			}
+		}
+	}
+	return result
+}
+
+// PFCOUNT 的key不在同一个slot时，取出每个HyperLogLog在proxy中合并后估计基数
+func (s *Session) handleRequestPFCount(r *Request, d Dispatcher) (*Request, error) {
+	if len(r.Resp.Array) <= 2 || inSameSlot(r.Resp.Array[1:]) {
+		return r, d.Dispatch(r)
+	}
+	keys := r.Resp.Array[1:]
+	var sub = make([]*Request, len(keys))
+	for i, key := range keys {
+		sub[i] = newSubRequest(r, r.Wait, "GET", key)
+		if err := d.Dispatch(sub[i]); err != nil {
+			return nil, err
+		}
+	}
+	r.Coalesce = func() error {
+		var registers = make([]uint8, hllRegisters)
+		for _, x := range sub {
+			resp, err := checkSubResponse(x)
+			if err != nil {
+				return err
+			}
+			if resp.IsError() {
+				r.Response.Resp = resp
+				return nil
+			}
+			// 不存在的key
+			if resp.Value == nil {
+				continue
+			}
+			if err := hllMerge(registers, resp.Value); err != nil {
+				r.Response.Resp = redis.NewError([]byte(err.Error()))
+				return nil
+			}
+		}
+		r.Response.Resp = redis.NewInt([]byte(strconv.FormatUint(hllCount(registers), 10)))
+		return nil
+	}
+	return r, nil
+}
+
+// MSETNX 的key不在同一个slot时，先检查所有key都不存在，然后再逐个设置
+// 检查和设置之间不是原子的，期间其他客户端设置的key会被覆盖
+// 检查总是发往master，slave上的数据可能落后，会误以为key不存在
+func (s *Session) handleRequestMSetNX(r *Request, d Dispatcher) (*Request, error) {
+	nblks := len(r.Resp.Array) - 1
+	if nblks <= 2 || nblks%2 != 0 {
+		return r, d.Dispatch(r)
+	}
+	var keys = make([]*redis.Resp, 0, nblks/2)
+	for i := 1; i < len(r.Resp.Array); i += 2 {
+		keys = append(keys, r.Resp.Array[i])
+	}
+	if inSameSlot(keys) {
+		return r, d.Dispatch(r)
+	}
+
+	// 在处理请求的协程中等待检查的结果，保证之后的请求在设置之后才执行
+	var wait sync.WaitGroup
+	var check = make([]*Request, len(keys))
+	for i, key := range keys {
+		check[i] = newSubRequest(r, &wait, "EXISTS", key)
+		check[i].master = true
+		if err := d.Dispatch(check[i]); err != nil {
+			return nil, err
+		}
+	}
+	wait.Wait()
+	for _, x := range check {
+		resp, err := checkSubResponse(x)
+		if err != nil {
+			return nil, err
+		}
+		if resp.IsError() {
+			r.Response.Resp = resp
+			return r, nil
+		}
+		if !resp.IsInt() {
+			return nil, errors.New(fmt.Sprintf("bad exists resp: %s", resp.Type))
+		}
+		// 有key已经存在，不设置任何key
+		if string(resp.Value) != "0" {
+			r.Response.Resp = redis.NewInt([]byte("0"))
+			return r, nil
+		}
+	}
+
+	var sub = make([]*Request, len(keys))
+	for i, key := range keys {
+		sub[i] = newSubRequest(r, r.Wait, "SET", key, r.Resp.Array[i*2+2])
+		if err := d.Dispatch(sub[i]); err != nil {
+			return nil, err
+		}
+	}
+	r.Coalesce = func() error {
+		for _, x := range sub {
+			resp, err := checkSubResponse(x)
+			if err != nil {
+				return err
+			}
+			if resp.IsError() {
+				r.Response.Resp = resp
+				return nil
+			}
+			if !resp.IsString() {
+				return errors.New(fmt.Sprintf("bad set resp: %s", resp.Type))
+			}
+		}
+		r.Response.Resp = redis.NewInt([]byte("1"))
+		return nil
+	}
+	return r, nil
+}
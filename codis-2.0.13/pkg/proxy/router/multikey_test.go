@@ -0,0 +1,41 @@
+// Copyright 2016 CodisLabs. All Rights Reserved.
+// Licensed under the MIT (MIT-LICENSE.txt) license.
+
+package router
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/CodisLabs/codis/pkg/proxy/redis"
+	"github.com/CodisLabs/codis/pkg/utils/assert"
+)
+
+func TestComputeSetOp(t *testing.T) {
+	newSet := func(s string) []*redis.Resp {
+		var set []*redis.Resp
+		for _, x := range strings.Fields(s) {
+			set = append(set, redis.NewBulkBytes([]byte(x)))
+		}
+		return set
+	}
+	join := func(set []*redis.Resp) string {
+		var list []string
+		for _, x := range set {
+			list = append(list, string(x.Value))
+		}
+		return strings.Join(list, " ")
+	}
+	sets := [][]*redis.Resp{newSet("a b c d"), newSet("c d e"), newSet("d a f")}
+	assert.Must(join(computeSetOp("SINTER", sets)) == "d")
+	assert.Must(join(computeSetOp("SUNION", sets)) == "a b c d e f")
+	assert.Must(join(computeSetOp("SDIFF", sets)) == "b")
+
+	sets = [][]*redis.Resp{newSet(""), newSet("a")}
+	r := computeSetOp("SINTER", sets)
+	assert.Must(r != nil && len(r) == 0)
+	assert.Must(join(computeSetOp("SUNION", sets)) == "a")
+
+	assert.Must(inSameSlot(newSet("{t}a {t}b {t}")))
+	assert.Must(!inSameSlot(newSet("a b")))
+}
@@ -0,0 +1,125 @@
+// Copyright 2016 CodisLabs. All Rights Reserved.
+// Licensed under the MIT (MIT-LICENSE.txt) license.
+
+package router
+
+import (
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/CodisLabs/codis/pkg/proxy/redis"
+	"github.com/CodisLabs/codis/pkg/utils/atomic2"
+	"github.com/CodisLabs/codis/pkg/utils/log"
+)
+
+// 订阅模式下独占的后端连接，后端推送的消息会通过 tasks 通道返回给redis-client
+type subscriber struct {
+	*redis.Conn
+
+	mode atomic2.Bool // 是否处于订阅模式，订阅数量降为0时退出
+	quit atomic2.Bool
+	wait sync.WaitGroup
+}
+
+func isPubSubCommand(opstr string) bool {
+	switch opstr {
+	case "SUBSCRIBE", "PSUBSCRIBE", "UNSUBSCRIBE", "PUNSUBSCRIBE", "PUBLISH", "PUBSUB":
+		return true
+	}
+	return false
+}
+
+// 订阅模式下只允许这些命令
+func isSubscribeCommand(opstr string) bool {
+	switch opstr {
+	case "SUBSCRIBE", "PSUBSCRIBE", "UNSUBSCRIBE", "PUNSUBSCRIBE", "PING":
+		return true
+	}
+	return false
+}
+
+func (s *Session) inSubscribeMode() bool {
+	return s.sub != nil && s.sub.mode.Get()
+}
+
+// 订阅相关的命令发往独占连接，返回结果由 loopSubscriber 推送给redis-client，这里不返回 request
+func (s *Session) handleSubscribe(r *Request, d Dispatcher) (*Request, error) {
+	switch r.OpStr {
+	case "SUBSCRIBE", "PSUBSCRIBE":
+		if len(r.Resp.Array) < 2 {
+			r.Response.Resp = redis.NewError([]byte("ERR wrong number of arguments for '" + strings.ToLower(r.OpStr) + "' command"))
+			return r, nil
+		}
+	case "UNSUBSCRIBE", "PUNSUBSCRIBE":
+		// 没有订阅过，直接返回订阅数量为0
+		if s.sub == nil {
+			r.Response.Resp = redis.NewArray([]*redis.Resp{
+				redis.NewBulkBytes([]byte(strings.ToLower(r.OpStr))),
+				redis.NewBulkBytes(nil),
+				redis.NewInt([]byte("0")),
+			})
+			return r, nil
+		}
+	}
+	if s.sub == nil {
+		c, err := d.DialPubSub()
+		if err != nil {
+			return nil, err
+		}
+		s.sub = &subscriber{Conn: c}
+		s.sub.wait.Add(1)
+		go s.loopSubscriber(s.sub)
+	}
+	if r.OpStr == "SUBSCRIBE" || r.OpStr == "PSUBSCRIBE" {
+		s.sub.mode.Set(true)
+		// 订阅的客户端可能长时间没有请求，不再检查超时
+		s.Conn.ReaderTimeout = 0
+	}
+	if err := s.sub.Writer.Encode(r.Resp, true); err != nil {
+		return nil, err
+	}
+	incrOpStats(r.OpStr, microseconds()-r.Start)
+	return nil, nil
+}
+
+// 循环读取后端推送的消息，通过 tasks 通道返回给redis-client
+func (s *Session) loopSubscriber(sub *subscriber) {
+	defer sub.wait.Done()
+	for {
+		resp, err := sub.Reader.Decode()
+		if err != nil {
+			if !sub.quit.Get() {
+				log.WarnErrorf(err, "session [%p] subscriber closed", s)
+				s.Close()
+			}
+			return
+		}
+		// 订阅、退订的确认消息中带有当前的订阅数量
+		if resp.IsArray() && len(resp.Array) == 3 && resp.Array[2].IsInt() {
+			switch strings.ToLower(string(resp.Array[0].Value)) {
+			case "subscribe", "psubscribe", "unsubscribe", "punsubscribe":
+				n, _ := strconv.Atoi(string(resp.Array[2].Value))
+				// 退出订阅模式时处理请求的协程可能正在没有超时地等待下一个请求，需要在这里重新设置超时
+				if n == 0 && sub.mode.Get() && s.timeout != 0 {
+					s.Conn.Sock.SetReadDeadline(time.Now().Add(s.timeout))
+				}
+				sub.mode.Set(n != 0)
+			}
+		}
+		r := &Request{Start: microseconds(), Wait: &sync.WaitGroup{}}
+		r.Response.Resp = resp
+		s.tasks <- r
+	}
+}
+
+// 关闭订阅连接，需要在关闭 tasks 通道之前调用
+func (s *Session) closeSubscriber() {
+	if s.sub == nil {
+		return
+	}
+	s.sub.quit.Set(true)
+	s.sub.Close()
+	s.sub.wait.Wait()
+}
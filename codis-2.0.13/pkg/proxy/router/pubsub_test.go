@@ -0,0 +1,83 @@
+// Copyright 2016 CodisLabs. All Rights Reserved.
+// Licensed under the MIT (MIT-LICENSE.txt) license.
+
+package router
+
+import (
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/CodisLabs/codis/pkg/proxy/redis"
+	"github.com/CodisLabs/codis/pkg/utils/assert"
+)
+
+// 只支持订阅、退订的后端，退订时订阅数量降为0
+type pubsubDispatcher struct {
+	Dispatcher
+	addr string
+}
+
+func (d *pubsubDispatcher) DialPubSub() (*redis.Conn, error) {
+	return redis.DialTimeout(d.addr, 1024, time.Second)
+}
+
+func TestSubscribeTimeout(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.MustNoError(err)
+	defer l.Close()
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func(c *redis.Conn) {
+				defer c.Close()
+				for {
+					req, err := c.Reader.Decode()
+					if err != nil {
+						return
+					}
+					op := strings.ToLower(string(req.Array[0].Value))
+					n := "1"
+					if op == "unsubscribe" {
+						n = "0"
+					}
+					c.Writer.Encode(redis.NewArray([]*redis.Resp{
+						redis.NewBulkBytes([]byte(op)), req.Array[1], redis.NewInt([]byte(n)),
+					}), true)
+				}
+			}(redis.NewConn(c))
+		}
+	}()
+
+	c1, c2 := net.Pipe()
+	defer c2.Close()
+	s := NewSessionSize(c1, "", 1024, 1)
+	go s.Serve(&pubsubDispatcher{addr: l.Addr().String()}, 16)
+
+	c := redis.NewConn(c2)
+	for _, op := range []string{"SUBSCRIBE", "UNSUBSCRIBE"} {
+		assert.MustNoError(c.Writer.Encode(redis.NewArray([]*redis.Resp{
+			redis.NewBulkBytes([]byte(op)), redis.NewBulkBytes([]byte("news")),
+		}), true))
+		resp, err := c.Reader.Decode()
+		assert.MustNoError(err)
+		assert.Must(resp.IsArray() && strings.ToUpper(string(resp.Array[0].Value)) == op)
+	}
+
+	// 退出订阅模式之后空闲的会话因为超时被关闭
+	done := make(chan error)
+	go func() {
+		_, err := c.Reader.Decode()
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		assert.Must(err != nil)
+	case <-time.After(3 * time.Second):
+		t.Fatal("session should be closed after timeout")
+	}
+}
@@ -0,0 +1,215 @@
+// Copyright 2016 CodisLabs. All Rights Reserved.
+// Licensed under the MIT (MIT-LICENSE.txt) license.
+
+package router
+
+import (
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/CodisLabs/codis/pkg/utils/atomic2"
+	"github.com/CodisLabs/codis/pkg/utils/errors"
+)
+
+// 限流配置，单位都是每秒的请求数，0表示不限制
+type RateLimitConfig struct {
+	PerClient int            // 每个客户端ip
+	PerAuth   int            // 每个通过AUTH认证的用户
+	PerClass  map[string]int // 每一类命令，整个proxy共享
+}
+
+// 命令类别，用于按类别限流
+var commandClasses = []string{"read", "write", "keyspace", "script", "pubsub", "blocking"}
+
+func getCommandClass(opstr string) string {
+	switch {
+	case isKeyspaceCommand(opstr):
+		return "keyspace"
+	case isScriptCommand(opstr) || opstr == "SCRIPT":
+		return "script"
+	case isPubSubCommand(opstr):
+		return "pubsub"
+	case opstr == "BLPOP" || opstr == "BRPOP" || opstr == "BRPOPLPUSH":
+		return "blocking"
+	case isReadOnly(opstr):
+		return "read"
+	}
+	return "write"
+}
+
+// 解析按命令类别的限流配置，格式为 class:rate,class:rate
+func ParseClassLimits(s string) (map[string]int, error) {
+	var m = make(map[string]int)
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item == "" {
+			continue
+		}
+		kv := strings.SplitN(item, ":", 2)
+		if len(kv) != 2 {
+			return nil, errors.Errorf("invalid class limit: %s", item)
+		}
+		class := strings.TrimSpace(kv[0])
+		valid := false
+		for _, c := range commandClasses {
+			valid = valid || c == class
+		}
+		if !valid {
+			return nil, errors.Errorf("invalid command class: %s", class)
+		}
+		rate, err := strconv.Atoi(strings.TrimSpace(kv[1]))
+		if err != nil || rate < 0 {
+			return nil, errors.Errorf("invalid rate of class %s: %s", class, kv[1])
+		}
+		m[class] = rate
+	}
+	return m, nil
+}
+
+// 令牌桶，每秒补充rate个令牌，最多积攒1秒的令牌
+type tokenBucket struct {
+	mu     sync.Mutex
+	rate   float64
+	tokens float64
+	last   int64 // 上次补充令牌的时间，单位us
+
+	refcnt int // 使用这个令牌桶的会话数，只用于客户端ip的令牌桶
+	stats  LimitStats
+}
+
+func newTokenBucket(key string, rate int) *tokenBucket {
+	b := &tokenBucket{rate: float64(rate), tokens: float64(rate), last: microseconds()}
+	b.stats.key = key
+	return b
+}
+
+// 取一个令牌，没有令牌时返回false
+func (b *tokenBucket) take(now int64) bool {
+	b.stats.calls.Incr()
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if now > b.last {
+		b.tokens += float64(now-b.last) * b.rate / 1e6
+		if b.tokens > b.rate {
+			b.tokens = b.rate
+		}
+		b.last = now
+	}
+	if b.tokens < 1 {
+		b.stats.rejected.Incr()
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+// 限流状态，整个proxy共享
+var ratelimit struct {
+	sync.RWMutex
+	enabled atomic2.Bool
+
+	conf    RateLimitConfig
+	clients map[string]*tokenBucket // 客户端ip -> 令牌桶，没有会话使用时删除
+	auths   map[string]*tokenBucket // 用户 -> 令牌桶
+	classes map[string]*tokenBucket // 命令类别 -> 令牌桶
+}
+
+func init() {
+	SetRateLimit(RateLimitConfig{})
+}
+
+// 设置限流配置，已有的令牌桶会被丢弃
+func SetRateLimit(conf RateLimitConfig) {
+	ratelimit.Lock()
+	defer ratelimit.Unlock()
+	ratelimit.conf = conf
+	ratelimit.clients = make(map[string]*tokenBucket)
+	ratelimit.auths = make(map[string]*tokenBucket)
+	ratelimit.classes = make(map[string]*tokenBucket)
+	enabled := conf.PerClient != 0 || conf.PerAuth != 0
+	for class, rate := range conf.PerClass {
+		if rate != 0 {
+			ratelimit.classes[class] = newTokenBucket(class, rate)
+			enabled = true
+		}
+	}
+	ratelimit.enabled.Set(enabled)
+}
+
+// 获取客户端ip对应的令牌桶，引用计数加1，不限制时返回nil
+func getClientBucket(addr string) *tokenBucket {
+	if !ratelimit.enabled.Get() {
+		return nil
+	}
+	ratelimit.Lock()
+	defer ratelimit.Unlock()
+	if ratelimit.conf.PerClient == 0 {
+		return nil
+	}
+	if host, _, err := net.SplitHostPort(addr); err == nil {
+		addr = host
+	}
+	b := ratelimit.clients[addr]
+	if b == nil {
+		b = newTokenBucket(addr, ratelimit.conf.PerClient)
+		ratelimit.clients[addr] = b
+	}
+	b.refcnt++
+	return b
+}
+
+func putClientBucket(b *tokenBucket) {
+	if b == nil {
+		return
+	}
+	ratelimit.Lock()
+	defer ratelimit.Unlock()
+	if b.refcnt--; b.refcnt == 0 && ratelimit.clients[b.stats.key] == b {
+		delete(ratelimit.clients, b.stats.key)
+	}
+}
+
+// 获取用户对应的令牌桶，不限制时返回nil
+func getAuthBucket(identity string) *tokenBucket {
+	if !ratelimit.enabled.Get() || identity == "" {
+		return nil
+	}
+	ratelimit.RLock()
+	b, rate := ratelimit.auths[identity], ratelimit.conf.PerAuth
+	ratelimit.RUnlock()
+	if b != nil || rate == 0 {
+		return b
+	}
+	ratelimit.Lock()
+	defer ratelimit.Unlock()
+	if b = ratelimit.auths[identity]; b == nil {
+		b = newTokenBucket(identity, rate)
+		ratelimit.auths[identity] = b
+	}
+	return b
+}
+
+// 检查请求是否超过限制，超过时返回错误信息
+func (s *Session) checkRateLimit(r *Request) string {
+	if !ratelimit.enabled.Get() {
+		return ""
+	}
+	if s.limit.client != nil && !s.limit.client.take(r.Start) {
+		return "ERR rate limit exceeded for client " + s.limit.client.stats.key
+	}
+	if s.limit.auth == nil {
+		s.limit.auth = getAuthBucket(s.identity)
+	}
+	if s.limit.auth != nil && !s.limit.auth.take(r.Start) {
+		return "ERR rate limit exceeded for user " + s.identity
+	}
+	class := getCommandClass(r.OpStr)
+	ratelimit.RLock()
+	b := ratelimit.classes[class]
+	ratelimit.RUnlock()
+	if b != nil && !b.take(r.Start) {
+		return "ERR rate limit exceeded for " + class + " commands"
+	}
+	return ""
+}
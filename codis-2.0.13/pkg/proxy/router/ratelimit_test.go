@@ -0,0 +1,78 @@
+// Copyright 2016 CodisLabs. All Rights Reserved.
+// Licensed under the MIT (MIT-LICENSE.txt) license.
+
+package router
+
+import (
+	"testing"
+
+	"github.com/CodisLabs/codis/pkg/utils/assert"
+)
+
+func TestTokenBucket(t *testing.T) {
+	b := newTokenBucket("test", 10)
+	now := b.last
+	for i := 0; i < 10; i++ {
+		assert.Must(b.take(now))
+	}
+	assert.Must(!b.take(now))
+
+	// 100ms 补充1个令牌
+	now += 1e5
+	assert.Must(b.take(now))
+	assert.Must(!b.take(now))
+
+	// 最多积攒1秒的令牌
+	now += 1e7
+	for i := 0; i < 10; i++ {
+		assert.Must(b.take(now))
+	}
+	assert.Must(!b.take(now))
+	assert.Must(b.stats.Calls() == 24 && b.stats.Rejected() == 3)
+}
+
+func TestParseClassLimits(t *testing.T) {
+	m, err := ParseClassLimits(" keyspace:10, write:5000 ")
+	assert.MustNoError(err)
+	assert.Must(len(m) == 2 && m["keyspace"] == 10 && m["write"] == 5000)
+
+	m, err = ParseClassLimits("")
+	assert.MustNoError(err)
+	assert.Must(len(m) == 0)
+
+	for _, s := range []string{"keyspace", "admin:10", "read:x", "read:-1"} {
+		_, err := ParseClassLimits(s)
+		assert.Must(err != nil)
+	}
+}
+
+func TestCommandClass(t *testing.T) {
+	var m = map[string]string{
+		"GET":     "read",
+		"SET":     "write",
+		"KEYS":    "keyspace",
+		"EVALSHA": "script",
+		"PUBLISH": "pubsub",
+		"BLPOP":   "blocking",
+	}
+	for opstr, class := range m {
+		assert.Must(getCommandClass(opstr) == class)
+	}
+}
+
+func TestClientBucket(t *testing.T) {
+	defer SetRateLimit(RateLimitConfig{})
+
+	assert.Must(getClientBucket("127.0.0.1:1000") == nil)
+
+	SetRateLimit(RateLimitConfig{PerClient: 10})
+	b1 := getClientBucket("127.0.0.1:1000")
+	b2 := getClientBucket("127.0.0.1:2000")
+	assert.Must(b1 != nil && b1 == b2 && b1.stats.Key() == "127.0.0.1")
+	assert.Must(len(GetAllLimitStats()["client"]) == 1)
+
+	putClientBucket(b1)
+	assert.Must(len(GetAllLimitStats()["client"]) == 1)
+	putClientBucket(b2)
+	assert.Must(len(GetAllLimitStats()["client"]) == 0)
+}
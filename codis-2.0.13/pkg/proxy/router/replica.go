@@ -0,0 +1,184 @@
+// Copyright 2016 CodisLabs. All Rights Reserved.
+// Licensed under the MIT (MIT-LICENSE.txt) license.
+
+package router
+
+import (
+	"bufio"
+	"bytes"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/CodisLabs/codis/pkg/proxy/redis"
+	"github.com/CodisLabs/codis/pkg/utils/atomic2"
+	"github.com/CodisLabs/codis/pkg/utils/errors"
+	"github.com/CodisLabs/codis/pkg/utils/log"
+)
+
+// 读写分离策略
+type ReadPolicy int
+
+const (
+	ReadMasterOnly  ReadPolicy = iota // 只读master
+	ReadPreferSlave                   // 优先读slave，没有可用的slave时读master
+	ReadRoundRobin                    // master和slave轮流读
+)
+
+func (p ReadPolicy) String() string {
+	switch p {
+	case ReadPreferSlave:
+		return "prefer-slave"
+	case ReadRoundRobin:
+		return "round-robin"
+	default:
+		return "master-only"
+	}
+}
+
+func ParseReadPolicy(s string) (ReadPolicy, error) {
+	switch s {
+	case "", "master-only":
+		return ReadMasterOnly, nil
+	case "prefer-slave":
+		return ReadPreferSlave, nil
+	case "round-robin":
+		return ReadRoundRobin, nil
+	}
+	return ReadMasterOnly, errors.Errorf("invalid read policy: %s", s)
+}
+
+// slave的连接，只有通过复制状态检查的slave才会处理读请求
+type replica struct {
+	*SharedBackendConn
+
+	healthy  atomic2.Bool
+	checking atomic2.Bool
+}
+
+// 根据读写分离策略选择处理请求的连接，只读命令才可能发往slave
+// slot迁移中时key可能还没有同步到slave，只能读master
+func (s *Slot) pick(r *Request) *SharedBackendConn {
+	slaves := s.backend.slaves
+	if len(slaves) == 0 || s.migrate.bc != nil || r.master || !isReadOnly(r.OpStr) {
+		return s.backend.bc
+	}
+	n := uint32(len(slaves))
+	if s.backend.policy == ReadRoundRobin {
+		// master也参与轮询
+		n++
+	}
+	start := atomic.AddUint32(&s.backend.next, 1)
+	for k := uint32(0); k < n; k++ {
+		i := (start + k) % n
+		if int(i) == len(slaves) {
+			return s.backend.bc
+		}
+		if slaves[i].healthy.Get() {
+			return slaves[i].SharedBackendConn
+		}
+	}
+	return s.backend.bc
+}
+
+// 设置读写分离策略，对之后填充的slot生效
+func (s *Router) SetReadPolicy(policy ReadPolicy, maxLag int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.policy, s.maxLag = policy, maxLag
+}
+
+// 检查所有slave的复制状态，连接断开、正在同步或者落后太多的slave不再处理读请求
+func (s *Router) CheckReplicas() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return errClosedRouter
+	}
+	for _, x := range s.replicas {
+		// 上一次检查还没有结束
+		if x.checking.Get() {
+			continue
+		}
+		x.checking.Set(true)
+		r := &Request{
+			Resp: redis.NewArray([]*redis.Resp{
+				redis.NewBulkBytes([]byte("INFO")),
+				redis.NewBulkBytes([]byte("replication")),
+			}),
+			Wait: &sync.WaitGroup{},
+		}
+		x.PushBack(r)
+		go func(x *replica, maxLag int) {
+			defer x.checking.Set(false)
+			r.Wait.Wait()
+			err := checkReplication(r, maxLag)
+			if healthy := err == nil; healthy != x.healthy.Get() {
+				if healthy {
+					log.Infof("replica %s is available for reading", x.Addr())
+				} else {
+					log.WarnErrorf(err, "replica %s is not available for reading", x.Addr())
+				}
+				x.healthy.Set(healthy)
+			}
+		}(x, s.maxLag)
+	}
+	return nil
+}
+
+// 解析 INFO replication 的结果
+func checkReplication(r *Request, maxLag int) error {
+	if err := r.Response.Err; err != nil {
+		return err
+	}
+	resp := r.Response.Resp
+	if resp == nil {
+		return ErrRespIsRequired
+	}
+	if !resp.IsBulkBytes() {
+		return errors.Errorf("bad info resp: %s", resp.Type)
+	}
+	var info = make(map[string]string)
+	scanner := bufio.NewScanner(bytes.NewReader(resp.Value))
+	for scanner.Scan() {
+		if kv := strings.SplitN(strings.TrimSpace(scanner.Text()), ":", 2); len(kv) == 2 {
+			info[kv[0]] = kv[1]
+		}
+	}
+	if info["role"] != "slave" {
+		return errors.Errorf("role is %s", info["role"])
+	}
+	if info["master_link_status"] != "up" {
+		return errors.Errorf("master link is %s", info["master_link_status"])
+	}
+	if info["master_sync_in_progress"] == "1" {
+		return errors.New("sync is in progress")
+	}
+	lag, err := strconv.Atoi(info["master_last_io_seconds_ago"])
+	if err != nil {
+		return errors.Errorf("bad master_last_io_seconds_ago = %s", info["master_last_io_seconds_ago"])
+	}
+	if maxLag != 0 && lag > maxLag {
+		return errors.Errorf("lagging %d seconds", lag)
+	}
+	return nil
+}
+
+// 获取slave的连接，引用计数加1，没有就新建一个
+func (s *Router) getReplica(addr string) *replica {
+	x := s.replicas[addr]
+	if x != nil {
+		x.IncrRefcnt()
+	} else {
+		x = &replica{SharedBackendConn: NewSharedBackendConn(addr, s.auth, s.poolSize)}
+		s.replicas[addr] = x
+	}
+	return x
+}
+
+func (s *Router) putReplica(x *replica) {
+	if x != nil && x.Close() {
+		delete(s.replicas, x.Addr())
+	}
+}
@@ -0,0 +1,63 @@
+// Copyright 2016 CodisLabs. All Rights Reserved.
+// Licensed under the MIT (MIT-LICENSE.txt) license.
+
+package router
+
+import (
+	"testing"
+
+	"github.com/CodisLabs/codis/pkg/proxy/redis"
+	"github.com/CodisLabs/codis/pkg/utils/assert"
+)
+
+func TestCheckReplication(t *testing.T) {
+	var m = map[string]bool{
+		"role:slave\r\nmaster_link_status:up\r\nmaster_last_io_seconds_ago:1\r\nmaster_sync_in_progress:0\r\n":    true,
+		"role:slave\r\nmaster_link_status:up\r\nmaster_last_io_seconds_ago:30\r\nmaster_sync_in_progress:0\r\n":   false,
+		"role:slave\r\nmaster_link_status:down\r\nmaster_last_io_seconds_ago:-1\r\nmaster_sync_in_progress:0\r\n": false,
+		"role:slave\r\nmaster_link_status:up\r\nmaster_last_io_seconds_ago:0\r\nmaster_sync_in_progress:1\r\n":    false,
+		"role:master\r\nconnected_slaves:0\r\n": false,
+	}
+	for info, healthy := range m {
+		r := &Request{}
+		r.Response.Resp = redis.NewBulkBytes([]byte("# Replication\r\n" + info))
+		assert.Must((checkReplication(r, 10) == nil) == healthy)
+	}
+}
+
+func TestPickReplica(t *testing.T) {
+	master := &SharedBackendConn{}
+	slaves := []*replica{
+		&replica{SharedBackendConn: &SharedBackendConn{}},
+		&replica{SharedBackendConn: &SharedBackendConn{}},
+	}
+	slot := &Slot{}
+	slot.backend.bc = master
+	slot.backend.slaves = slaves
+
+	get := &Request{OpStr: "GET"}
+	set := &Request{OpStr: "SET"}
+	exists := &Request{OpStr: "EXISTS", master: true}
+
+	slot.backend.policy = ReadPreferSlave
+	assert.Must(slot.pick(get) == master)
+	slaves[1].healthy.Set(true)
+	for i := 0; i < 10; i++ {
+		assert.Must(slot.pick(get) == slaves[1].SharedBackendConn)
+		assert.Must(slot.pick(set) == master)
+		assert.Must(slot.pick(exists) == master)
+	}
+
+	slot.backend.policy = ReadRoundRobin
+	var n = make(map[*SharedBackendConn]int)
+	for i := 0; i < 10; i++ {
+		n[slot.pick(get)]++
+	}
+	assert.Must(n[master] != 0 && n[slaves[1].SharedBackendConn] != 0 && n[slaves[0].SharedBackendConn] == 0)
+	for i := 0; i < 10; i++ {
+		assert.Must(slot.pick(exists) == master)
+	}
+
+	slot.migrate.bc = &SharedBackendConn{}
+	assert.Must(slot.pick(get) == master)
+}
@@ -5,6 +5,7 @@ package router
 
 import (
 	"sync"
+	"time"
 
 	"github.com/CodisLabs/codis/pkg/proxy/redis"
 	"github.com/CodisLabs/codis/pkg/utils/atomic2"
@@ -13,6 +14,24 @@ import (
 // 封装的redis的请求
 type Dispatcher interface {
 	Dispatch(r *Request) error
+
+	// 独占连接，用于需要在同一个连接上连续执行的命令
+	DialSlot(i int) (*BackendConn, error)
+	DispatchPinned(i int, bc *BackendConn, keys [][]byte, rs ...*Request) error
+
+	// pub/sub 命令固定转发到配置的group
+	DispatchPubSub(r *Request) error
+	DialPubSub() (*redis.Conn, error)
+
+	// 阻塞命令使用会话独占的连接
+	DispatchBlocking(r *Request, keys [][]byte, bc *blockingConn, timeout time.Duration) (*blockingConn, error)
+
+	// 不属于某个slot的命令，需要发往所有或者指定的redis-server
+	Backends() []string
+	DispatchAddr(r *Request, addr string) error
+
+	// CLUSTER 命令展示的proxy地址和当前proxy的地址
+	ClusterNodes() ([]string, string)
 }
 
 type Request struct {
@@ -30,5 +49,10 @@ type Request struct {
 	Wait *sync.WaitGroup // 请求是否完成
 	slot *sync.WaitGroup // 命令可能涉及到多个slot，等待所有slot完成操作
 
+	backend  string  // 处理请求的redis-server地址，用于记录慢查询
+	affinity uint    // 所属会话的编号，同一个会话的请求使用同一个后端连接
+	tenant   *Tenant // 所属会话认证的租户，用于去掉返回结果中key的前缀
+	master   bool    // 只读命令也必须发往master，不能读slave上落后的数据
+
 	Failed *atomic2.Bool // 请求是否失败
 }
@@ -4,10 +4,13 @@
 package router
 
 import (
+	"sort"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/CodisLabs/codis/pkg/models"
+	"github.com/CodisLabs/codis/pkg/proxy/redis"
 	"github.com/CodisLabs/codis/pkg/utils/errors"
 	"github.com/CodisLabs/codis/pkg/utils/log"
 )
@@ -21,8 +24,34 @@ type Router struct {
 	auth string                        // 访问redis密码
 	pool map[string]*SharedBackendConn // 访问redis的共享连接池
 
+	poolSize int // 每个redis-server建立的连接数
+
+	policy   ReadPolicy          // 读写分离策略
+	maxLag   int                 // slave允许落后master的秒数
+	replicas map[string]*replica // slave的共享连接
+
 	slots [MaxSlotNum]*Slot // slot信息
 
+	// pub/sub 所在group的master连接
+	pubsub struct {
+		addr string
+		bc   *SharedBackendConn
+		sync.RWMutex
+	}
+
+	// 流量复制到的影子集群
+	shadow struct {
+		m *mirror
+		sync.RWMutex
+	}
+
+	// CLUSTER 命令中展示的所有proxy
+	cluster struct {
+		nodes []string
+		self  string
+		sync.RWMutex
+	}
+
 	closed bool // 结束标志
 }
 
@@ -35,6 +64,10 @@ func NewWithAuth(auth string) *Router {
 	s := &Router{
 		auth: auth,
 		pool: make(map[string]*SharedBackendConn),
+
+		poolSize: 1,
+
+		replicas: make(map[string]*replica),
 	}
 	for i := 0; i < len(s.slots); i++ {
 		s.slots[i] = &Slot{id: i}
@@ -51,6 +84,8 @@ func (s *Router) Close() error {
 	for i := 0; i < len(s.slots); i++ {
 		s.resetSlot(i)
 	}
+	s.fillPubSub("")
+	s.setMirror(nil)
 	s.closed = true
 	return nil
 }
@@ -67,14 +102,25 @@ func (s *Router) ResetSlot(i int) error {
 	return nil
 }
 
-// 填充指定slot的信息，建立与所在redis-server的连接
-func (s *Router) FillSlot(i int, addr, from string, lock bool) error {
+// 填充指定slot的信息，建立与所在redis-server的连接，slaves为所在group的slave地址
+func (s *Router) FillSlot(i int, addr, from string, slaves []string, lock bool) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return errClosedRouter
+	}
+	s.fillSlot(i, addr, from, slaves, lock)
+	return nil
+}
+
+// 设置 pub/sub 所在group的master地址，addr为空表示不支持 pub/sub
+func (s *Router) FillPubSub(addr string) error {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 	if s.closed {
 		return errClosedRouter
 	}
-	s.fillSlot(i, addr, from, lock)
+	s.fillPubSub(addr)
 	return nil
 }
 
@@ -95,7 +141,107 @@ func (s *Router) KeepAlive() error {
 func (s *Router) Dispatch(r *Request) error {
 	hkey := getHashKey(r.Resp, r.OpStr)
 	slot := s.slots[hashSlot(hkey)]
-	return slot.forward(r, hkey)
+	if err := slot.forward(r, hkey); err != nil {
+		return err
+	}
+	// 在原请求发出之后再复制，不增加原请求的延迟
+	s.copyToMirror(r, hkey)
+	return nil
+}
+
+// 建立一个到指定slot所在redis-server的独占连接，不放入连接池，由调用者负责关闭
+func (s *Router) DialSlot(i int) (*BackendConn, error) {
+	if !s.isValidSlot(i) {
+		return nil, errors.Errorf("invalid slot %d", i)
+	}
+	slot := s.slots[i]
+	slot.lock.RLock()
+	defer slot.lock.RUnlock()
+	if slot.backend.bc == nil {
+		return nil, ErrSlotIsNotReady
+	}
+	return NewBackendConn(slot.backend.addr, s.auth), nil
+}
+
+// 通过独占连接将一组请求转发给指定的slot，转发前会先迁移keys中正在迁移的key
+func (s *Router) DispatchPinned(i int, bc *BackendConn, keys [][]byte, rs ...*Request) error {
+	if !s.isValidSlot(i) {
+		return errors.Errorf("invalid slot %d", i)
+	}
+	return s.slots[i].forwardPinned(bc, keys, rs)
+}
+
+var ErrPubSubIsNotReady = errors.New("pubsub is not ready, pubsub_group may be missing")
+
+// 将 PUBLISH 等命令转发给 pub/sub 所在group的master
+func (s *Router) DispatchPubSub(r *Request) error {
+	s.pubsub.RLock()
+	bc := s.pubsub.bc
+	s.pubsub.RUnlock()
+	if bc == nil {
+		return ErrPubSubIsNotReady
+	}
+	bc.PushBack(r)
+	return nil
+}
+
+// 建立到 pub/sub 所在group的master的独占连接，用于 SUBSCRIBE 等订阅命令
+func (s *Router) DialPubSub() (*redis.Conn, error) {
+	s.pubsub.RLock()
+	addr := s.pubsub.addr
+	s.pubsub.RUnlock()
+	if addr == "" {
+		return nil, ErrPubSubIsNotReady
+	}
+	c, err := dialBackend(addr, 1024*64)
+	if err != nil {
+		return nil, err
+	}
+	c.WriterTimeout = time.Minute
+	if err := verifyAuth(c, s.auth); err != nil {
+		c.Close()
+		return nil, err
+	}
+	return c, nil
+}
+
+// 通过会话独占的连接转发阻塞命令，返回实际使用的连接
+func (s *Router) DispatchBlocking(r *Request, keys [][]byte, bc *blockingConn, timeout time.Duration) (*blockingConn, error) {
+	slot := s.slots[hashSlot(keys[0])]
+	return slot.forwardBlocking(r, keys, bc, s.auth, timeout)
+}
+
+// 获取所有slot所在的redis-server地址，包括迁移中的原redis-server，按照slot的顺序排列
+func (s *Router) Backends() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var addrs []string
+	var exists = make(map[string]bool)
+	for _, slot := range s.slots {
+		for _, addr := range []string{slot.backend.addr, slot.migrate.from} {
+			if addr != "" && !exists[addr] {
+				exists[addr] = true
+				addrs = append(addrs, addr)
+			}
+		}
+	}
+	// SCAN 的游标中记录的是序号，按地址排序，slot在已有的redis-server之间切换时序号不变
+	sort.Strings(addrs)
+	return addrs
+}
+
+var ErrBackendIsNotReady = errors.New("backend is not ready, may be removed")
+
+// 将请求转发给指定地址的redis-server
+func (s *Router) DispatchAddr(r *Request, addr string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	bc := s.pool[addr]
+	if bc == nil {
+		return ErrBackendIsNotReady
+	}
+	bc.PushBack(r)
+	return nil
 }
 
 // 从连接池中获取地址为 addr 的连接，引用计数加1，没有就新建一个并加入连接池中
@@ -104,7 +250,7 @@ func (s *Router) getBackendConn(addr string) *SharedBackendConn {
 	if bc != nil {
 		bc.IncrRefcnt()
 	} else {
-		bc = NewSharedBackendConn(addr, s.auth)
+		bc = NewSharedBackendConn(addr, s.auth, s.poolSize)
 		s.pool[addr] = bc
 	}
 	return bc
@@ -117,6 +263,13 @@ func (s *Router) putBackendConn(bc *SharedBackendConn) {
 	}
 }
 
+// 设置每个redis-server建立的连接数，只对之后新建的连接生效
+func (s *Router) SetPoolSize(size int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.poolSize = size
+}
+
 func (s *Router) isValidSlot(i int) bool {
 	return i >= 0 && i < len(s.slots)
 }
@@ -128,25 +281,37 @@ func (s *Router) resetSlot(i int) {
 	}
 	slot := s.slots[i]
 	slot.blockAndWait()
+	slot.kickBlocking()
 
 	s.putBackendConn(slot.backend.bc)
 	s.putBackendConn(slot.migrate.bc)
+	for _, x := range slot.backend.slaves {
+		s.putReplica(x)
+	}
 	slot.reset()
 
 	slot.unblock()
 }
 
 // 填充指定slot的信息，建立与所在redis-server的连接
-func (s *Router) fillSlot(i int, addr, from string, lock bool) {
+func (s *Router) fillSlot(i int, addr, from string, slaves []string, lock bool) {
 	if !s.isValidSlot(i) {
 		return
 	}
 	slot := s.slots[i]
 	slot.blockAndWait()
 
+	// 后端地址变化或者开始迁移，阻塞中的命令需要立即返回
+	if slot.backend.addr != addr || len(from) != 0 {
+		slot.kickBlocking()
+	}
+
 	// 将原来的连接放回连接池
 	s.putBackendConn(slot.backend.bc)
 	s.putBackendConn(slot.migrate.bc)
+	for _, x := range slot.backend.slaves {
+		s.putReplica(x)
+	}
 	// 清空原先的数据
 	slot.reset()
 
@@ -161,6 +326,14 @@ func (s *Router) fillSlot(i int, addr, from string, lock bool) {
 		}
 		slot.backend.addr = addr
 		slot.backend.bc = s.getBackendConn(addr)
+
+		// 开启读写分离时才需要建立与slave的连接
+		slot.backend.policy = s.policy
+		if s.policy != ReadMasterOnly {
+			for _, x := range slaves {
+				slot.backend.slaves = append(slot.backend.slaves, s.getReplica(x))
+			}
+		}
 	}
 	// 如果正处于迁移中的状态，需要记录下与原来所在redis-server的连接
 	if len(from) != 0 {
@@ -175,8 +348,24 @@ func (s *Router) fillSlot(i int, addr, from string, lock bool) {
 	if slot.migrate.bc != nil {
 		log.Infof("fill slot %04d, backend.addr = %s, migrate.from = %s",
 			i, slot.backend.addr, slot.migrate.from)
+	} else if len(slot.backend.slaves) != 0 {
+		log.Infof("fill slot %04d, backend.addr = %s, slaves = %v",
+			i, slot.backend.addr, slaves)
 	} else {
 		log.Infof("fill slot %04d, backend.addr = %s",
 			i, slot.backend.addr)
 	}
 }
+
+// 更新 pub/sub 所在group的master连接
+func (s *Router) fillPubSub(addr string) {
+	s.pubsub.Lock()
+	defer s.pubsub.Unlock()
+	s.putBackendConn(s.pubsub.bc)
+	s.pubsub.addr, s.pubsub.bc = "", nil
+	if len(addr) != 0 {
+		s.pubsub.addr = addr
+		s.pubsub.bc = s.getBackendConn(addr)
+		log.Infof("fill pubsub, backend.addr = %s", addr)
+	}
+}
@@ -0,0 +1,167 @@
+// Copyright 2016 CodisLabs. All Rights Reserved.
+// Licensed under the MIT (MIT-LICENSE.txt) license.
+
+package router
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/CodisLabs/codis/pkg/proxy/redis"
+	"github.com/CodisLabs/codis/pkg/utils/errors"
+)
+
+func isScriptCommand(opstr string) bool {
+	switch opstr {
+	case "EVAL", "EVALSHA":
+		return true
+	}
+	return false
+}
+
+// EVAL/EVALSHA 命令，声明的key必须属于同一个slot，发往该slot所在的redis-server
+func (s *Session) handleRequestEval(r *Request, d Dispatcher) (*Request, error) {
+	var nargs = len(r.Resp.Array)
+	if nargs < 3 {
+		r.Response.Resp = redis.NewError([]byte("ERR wrong number of arguments for '" + strings.ToLower(r.OpStr) + "' command"))
+		return r, nil
+	}
+	n, err := strconv.Atoi(string(r.Resp.Array[2].Value))
+	switch {
+	case err != nil:
+		r.Response.Resp = redis.NewError([]byte("ERR value is not an integer or out of range"))
+		return r, nil
+	case n < 0:
+		r.Response.Resp = redis.NewError([]byte("ERR Number of keys can't be negative"))
+		return r, nil
+	case n > nargs-3:
+		r.Response.Resp = redis.NewError([]byte("ERR Number of keys can't be greater than number of args"))
+		return r, nil
+	}
+	keys := getScriptKeys(r.Resp)
+	for _, key := range keys {
+		if hashSlot(key) != hashSlot(keys[0]) {
+			r.Response.Resp = errCrossSlot
+			return r, nil
+		}
+	}
+	return r, d.Dispatch(r)
+}
+
+// SCRIPT 命令发往所有的redis-server，保证 EVALSHA 无论路由到哪个redis-server都能找到脚本
+func (s *Session) handleRequestScript(r *Request, d Dispatcher) (*Request, error) {
+	var subcmd string
+	if len(r.Resp.Array) >= 2 {
+		subcmd = strings.ToUpper(string(r.Resp.Array[1].Value))
+	}
+	var nargs = len(r.Resp.Array)
+	switch {
+	case subcmd == "LOAD" && nargs == 3:
+	case subcmd == "EXISTS" && nargs >= 3:
+	case subcmd == "FLUSH" && nargs == 2:
+	case subcmd == "KILL" && nargs == 2:
+	default:
+		r.Response.Resp = redis.NewError([]byte("ERR Unknown SCRIPT subcommand or wrong # of args."))
+		return r, nil
+	}
+	sub, err := s.fanout(r, d)
+	if err != nil {
+		return nil, err
+	}
+	if len(sub) == 0 {
+		r.Response.Resp = redis.NewError([]byte("ERR no redis-server is available"))
+		return r, nil
+	}
+	r.Coalesce = func() error {
+		var resps = make([]*redis.Resp, len(sub))
+		for i, x := range sub {
+			resp, err := checkSubResponse(x)
+			if err != nil {
+				return err
+			}
+			resps[i] = resp
+		}
+		switch subcmd {
+		case "LOAD":
+			return coalesceScriptLoad(r, resps)
+		case "EXISTS":
+			return coalesceScriptExists(r, resps)
+		case "KILL":
+			return coalesceScriptKill(r, resps)
+		default:
+			return coalesceScriptFlush(r, resps)
+		}
+	}
+	return r, nil
+}
+
+// SCRIPT LOAD 所有redis-server返回的sha1应该是一样的
+func coalesceScriptLoad(r *Request, resps []*redis.Resp) error {
+	for _, resp := range resps {
+		if resp.IsError() {
+			r.Response.Resp = resp
+			return nil
+		}
+		if !resp.IsBulkBytes() {
+			return errors.New(fmt.Sprintf("bad script load resp: %s", resp.Type))
+		}
+		if !bytes.Equal(resp.Value, resps[0].Value) {
+			return errors.New(fmt.Sprintf("bad script load resp: sha1 = %s, expect %s", resp.Value, resps[0].Value))
+		}
+	}
+	r.Response.Resp = resps[0]
+	return nil
+}
+
+// SCRIPT EXISTS 只有所有redis-server上都存在的脚本才返回1
+func coalesceScriptExists(r *Request, resps []*redis.Resp) error {
+	var n = len(r.Resp.Array) - 2
+	var array = make([]*redis.Resp, n)
+	for i := range array {
+		array[i] = redis.NewInt([]byte("1"))
+	}
+	for _, resp := range resps {
+		if resp.IsError() {
+			r.Response.Resp = resp
+			return nil
+		}
+		if !resp.IsArray() || len(resp.Array) != n {
+			return errors.New(fmt.Sprintf("bad script exists resp: %s array.len = %d", resp.Type, len(resp.Array)))
+		}
+		for i, x := range resp.Array {
+			if !x.IsInt() {
+				return errors.New(fmt.Sprintf("bad script exists resp: array[%d] = %s", i, x.Type))
+			}
+			if string(x.Value) != "1" {
+				array[i] = x
+			}
+		}
+	}
+	r.Response.Resp = redis.NewArray(array)
+	return nil
+}
+
+// SCRIPT KILL 只要有一个redis-server终止了脚本就算成功，否则返回第一个错误，通常是 NOTBUSY
+func coalesceScriptKill(r *Request, resps []*redis.Resp) error {
+	for _, resp := range resps {
+		if resp.IsString() {
+			r.Response.Resp = resp
+			return nil
+		}
+	}
+	r.Response.Resp = resps[0]
+	return nil
+}
+
+func coalesceScriptFlush(r *Request, resps []*redis.Resp) error {
+	for _, resp := range resps {
+		if resp.IsError() {
+			r.Response.Resp = resp
+			return nil
+		}
+	}
+	r.Response.Resp = resps[0]
+	return nil
+}
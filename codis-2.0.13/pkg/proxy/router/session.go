@@ -28,9 +28,35 @@ type Session struct {
 
 	auth       string
 	authorized bool
+	identity   string  // 通过AUTH认证的用户，用于按用户限流
+	tenant     *Tenant // 通过租户密码认证时对应的租户
 
 	quit   bool // 退出标志
 	failed atomic2.Bool
+
+	timeout time.Duration // 会话的超时时间，订阅模式下不检查
+
+	txn transaction // MULTI/EXEC 事务状态
+	sub *subscriber // 订阅模式下的独占连接
+
+	blocking *blockingConn // 阻塞命令使用的独占连接
+
+	affinity uint // 会话编号，决定使用后端连接池中的哪个连接
+
+	// 优雅关闭的状态，请求数的检查和增减都在锁内，避免关闭时丢掉刚读取的请求
+	drain struct {
+		sync.Mutex
+		inflight int  // 已经接受但还没有返回给客户端的请求数
+		draining bool // 不再接受新的请求，已经接受的请求都返回后关闭会话
+	}
+
+	// 限流使用的令牌桶
+	limit struct {
+		client *tokenBucket
+		auth   *tokenBucket
+	}
+
+	tasks chan<- *Request
 }
 
 // 返回string格式session信息
@@ -48,6 +74,9 @@ func (s *Session) String() string {
 	return string(b)
 }
 
+// 创建过的会话数，用于给会话编号
+var sessionCount atomic2.Int64
+
 func NewSession(c net.Conn, auth string) *Session {
 	return NewSessionSize(c, auth, 1024*32, 1800)
 }
@@ -56,8 +85,12 @@ func NewSession(c net.Conn, auth string) *Session {
 func NewSessionSize(c net.Conn, auth string, bufsize int, timeout int) *Session {
 	s := &Session{CreateUnix: time.Now().Unix(), auth: auth}
 	s.Conn = redis.NewConnSize(c, bufsize)
-	s.Conn.ReaderTimeout = time.Second * time.Duration(timeout)
+	s.timeout = time.Second * time.Duration(timeout)
+	s.Conn.ReaderTimeout = s.timeout
 	s.Conn.WriterTimeout = time.Second * 30
+	s.txn.reset()
+	s.limit.client = getClientBucket(c.RemoteAddr().String())
+	s.affinity = uint(sessionCount.Incr())
 	log.Infof("session [%p] create: %s", s, s)
 	return s
 }
@@ -66,9 +99,45 @@ func (s *Session) Close() error {
 	return s.Conn.Close()
 }
 
+// 优雅关闭会话，空闲的会话立即关闭，否则等已经接受的请求都返回给客户端后再关闭
+func (s *Session) Drain() {
+	s.drain.Lock()
+	defer s.drain.Unlock()
+	s.drain.draining = true
+	if s.drain.inflight == 0 {
+		s.Close()
+	}
+}
+
+func (s *Session) isDraining() bool {
+	s.drain.Lock()
+	defer s.drain.Unlock()
+	return s.drain.draining
+}
+
+// 读取到一个请求，开始优雅关闭之后不再接受，这些请求没有执行，客户端可以重试
+func (s *Session) acceptRequest() bool {
+	s.drain.Lock()
+	defer s.drain.Unlock()
+	if s.drain.draining {
+		return false
+	}
+	s.drain.inflight++
+	return true
+}
+
+// 一个请求已经返回，返回 true 表示正在优雅关闭并且所有请求都已经返回
+func (s *Session) releaseRequest() bool {
+	s.drain.Lock()
+	defer s.drain.Unlock()
+	s.drain.inflight--
+	return s.drain.inflight == 0 && s.drain.draining
+}
+
 // 针对一个redis-client连接的处理函数
 func (s *Session) Serve(d Dispatcher, maxPipeline int) {
 	var errlist errors.ErrorList
+	var writerDone = make(chan struct{})
 	defer func() {
 		// 非正常结束
 		if err := errlist.First(); err != nil {
@@ -77,12 +146,17 @@ func (s *Session) Serve(d Dispatcher, maxPipeline int) {
 			// 连接正常结束
 			log.Infof("session [%p] closed: %s, quit", s, s)
 		}
+		// 优雅关闭时等已经接受的请求都发送给客户端之后再关闭连接
+		if s.isDraining() {
+			<-writerDone
+		}
 		s.Close()
 	}()
 
 	// 利用通道的缓冲区实现对pipeline上限的限制
 	tasks := make(chan *Request, maxPipeline)
 	go func() {
+		defer close(writerDone)
 		defer func() {
 			for _ = range tasks {
 			}
@@ -95,6 +169,13 @@ func (s *Session) Serve(d Dispatcher, maxPipeline int) {
 	}()
 
 	defer close(tasks)
+	defer s.closeSubscriber()
+	// 只能在处理请求的协程中释放事务的连接
+	defer s.txn.reset()
+	defer s.closeBlocking()
+	defer putClientBucket(s.limit.client)
+
+	s.tasks = tasks
 	// 循环从 redis-client 读取请求命令，转发给后端 redis-server，获取返回后通过 tasks 通道返回给client
 	if err := s.loopReader(tasks, d); err != nil {
 		errlist.PushBack(err)
@@ -107,18 +188,27 @@ func (s *Session) loopReader(tasks chan<- *Request, d Dispatcher) error {
 		return errors.New("nil dispatcher")
 	}
 	for !s.quit {
+		// 退出订阅模式之后恢复会话的超时时间
+		if s.sub != nil && !s.inSubscribeMode() {
+			s.Conn.ReaderTimeout = s.timeout
+		}
 		// 从redis-client读取请求，并解析成 Resp 格式的对象
 		resp, err := s.Reader.Decode()
 		if err != nil {
 			return err
 		}
+		if !s.acceptRequest() {
+			return nil
+		}
 		// 处理一条redis-client的请求
 		r, err := s.handleRequest(resp, d)
 		if err != nil {
 			return err
-		} else {
+		} else if r != nil {
 			// 将请求处理结果通过task通道返回
 			tasks <- r
+		} else if s.releaseRequest() {
+			return nil
 		}
 	}
 	return nil
@@ -141,6 +231,11 @@ func (s *Session) loopWriter(tasks <-chan *Request) error {
 		if err := p.Encode(resp, len(tasks) == 0); err != nil {
 			return err
 		}
+		// 订阅推送的消息不是客户端的请求
+		if r.OpStr != "" && s.releaseRequest() {
+			// 正在优雅关闭，所有请求都已经返回，结束会话
+			return p.Flush(true)
+		}
 	}
 	return nil
 }
@@ -163,8 +258,16 @@ func (s *Session) handleResponse(r *Request) (*redis.Resp, error) {
 	if resp == nil {
 		return nil, ErrRespIsRequired
 	}
-	// 更新统计信息
-	incrOpStats(r.OpStr, microseconds()-r.Start)
+	// 返回给租户的key需要去掉前缀
+	if r.tenant != nil {
+		r.tenant.stripResponse(r.OpStr, resp)
+	}
+	// 更新统计信息，订阅推送的消息不计入
+	if r.OpStr != "" {
+		usecs := microseconds() - r.Start
+		incrOpStats(r.OpStr, usecs)
+		s.addSlowLog(r, usecs)
+	}
 	return resp, nil
 }
 
@@ -191,6 +294,9 @@ func (s *Session) handleRequest(resp *redis.Resp, d Dispatcher) (*Request, error
 		Resp:   resp,
 		Wait:   &sync.WaitGroup{},
 		Failed: &s.failed,
+
+		affinity: s.affinity,
+		tenant:   s.tenant,
 	}
 
 	// 特殊命令的处理
@@ -203,14 +309,88 @@ func (s *Session) handleRequest(resp *redis.Resp, d Dispatcher) (*Request, error
 	}
 
 	if !s.authorized {
-		if s.auth != "" {
+		if s.auth != "" || hasTenants() {
 			r.Response.Resp = redis.NewError([]byte("NOAUTH Authentication required."))
 			return r, nil
 		}
 		s.authorized = true
 	}
 
+	// 租户只能执行允许的命令，key加上租户的前缀
+	if s.tenant != nil {
+		if !s.tenant.isAllowed(opstr) {
+			// 和排队时出错一样，EXEC 时放弃整个事务
+			if s.txn.multi {
+				s.txn.failed = true
+			}
+			r.Response.Resp = redis.NewError([]byte("NOPERM command <" + opstr + "> is not allowed for tenant " + s.tenant.Name))
+			return r, nil
+		}
+		s.tenant.rewriteRequest(r)
+	}
+
+	// 超过限流的请求直接返回错误，MULTI、EXEC、DISCARD 只在proxy中改变事务状态，不限流
+	// 事务中被限流的命令同样使 EXEC 放弃整个事务
+	if opstr != "MULTI" && opstr != "EXEC" && opstr != "DISCARD" {
+		if msg := s.checkRateLimit(r); msg != "" {
+			if s.txn.multi {
+				s.txn.failed = true
+			}
+			r.Response.Resp = redis.NewError([]byte(msg))
+			return r, nil
+		}
+	}
+
+	// 采样统计热点key
+	sampleHotKeys(r)
+
+	// 订阅模式下只能执行订阅相关的命令
+	if s.inSubscribeMode() {
+		if isSubscribeCommand(opstr) {
+			return s.handleSubscribe(r, d)
+		}
+		r.Response.Resp = redis.NewError([]byte("ERR only (P)SUBSCRIBE / (P)UNSUBSCRIBE / PING / QUIT allowed in this context"))
+		return r, nil
+	}
+
+	// 事务中的命令先在proxy中排队，等待EXEC
+	if s.txn.multi && !isTxnCommand(opstr) {
+		return s.handleQueued(r)
+	}
+
 	switch opstr {
+	case "MULTI":
+		return s.handleMulti(r)
+	case "EXEC":
+		return s.handleExec(r, d)
+	case "DISCARD":
+		return s.handleDiscard(r)
+	case "WATCH":
+		return s.handleWatch(r, d)
+	case "UNWATCH":
+		return s.handleUnwatch(r)
+	case "SUBSCRIBE", "PSUBSCRIBE", "UNSUBSCRIBE", "PUNSUBSCRIBE":
+		return s.handleSubscribe(r, d)
+	case "PUBLISH", "PUBSUB":
+		return r, d.DispatchPubSub(r)
+	case "BLPOP", "BRPOP", "BRPOPLPUSH":
+		return s.handleBlocking(r, d)
+	case "SCAN":
+		return s.handleRequestScan(r, d)
+	case "KEYS":
+		return s.handleRequestKeys(r, d)
+	case "DBSIZE":
+		return s.handleRequestDBSize(r, d)
+	case "RANDOMKEY":
+		return s.handleRequestRandomKey(r, d)
+	case "EVAL", "EVALSHA":
+		return s.handleRequestEval(r, d)
+	case "SCRIPT":
+		return s.handleRequestScript(r, d)
+	case "SLOWLOG":
+		return s.handleSlowLog(r)
+	case "CLUSTER":
+		return s.handleCluster(r, d)
 	case "SELECT":
 		return s.handleSelect(r)
 	case "PING":
@@ -219,8 +399,14 @@ func (s *Session) handleRequest(resp *redis.Resp, d Dispatcher) (*Request, error
 		return s.handleRequestMGet(r, d)
 	case "MSET":
 		return s.handleRequestMSet(r, d)
-	case "DEL":
+	case "DEL", "EXISTS", "UNLINK", "TOUCH":
 		return s.handleRequestMDel(r, d)
+	case "SINTER", "SUNION", "SDIFF":
+		return s.handleRequestSetOp(r, d)
+	case "PFCOUNT":
+		return s.handleRequestPFCount(r, d)
+	case "MSETNX":
+		return s.handleRequestMSetNX(r, d)
 	}
 	// 基于路由规则，将指定的redis-client发过来的请求，转发给这个key所在slot对应的redis-server的连接
 	return r, d.Dispatch(r)
@@ -239,16 +425,24 @@ func (s *Session) handleAuth(r *Request) (*Request, error) {
 		r.Response.Resp = redis.NewError([]byte("ERR wrong number of arguments for 'AUTH' command"))
 		return r, nil
 	}
-	if s.auth == "" {
+	if s.auth == "" && !hasTenants() {
 		r.Response.Resp = redis.NewError([]byte("ERR Client sent AUTH, but no password is set"))
 		return r, nil
 	}
-	if s.auth != string(r.Resp.Array[1].Value) {
-		s.authorized = false
+	// 认证的用户变化后需要重新获取用户的令牌桶
+	s.limit.auth = nil
+	passwd := string(r.Resp.Array[1].Value)
+	if t := getTenant(passwd); t != nil {
+		s.authorized, s.identity, s.tenant = true, t.Name, t
+		r.Response.Resp = redis.NewString([]byte("OK"))
+		return r, nil
+	}
+	if s.auth == "" || s.auth != passwd {
+		s.authorized, s.identity, s.tenant = false, "", nil
 		r.Response.Resp = redis.NewError([]byte("ERR invalid password"))
 		return r, nil
 	} else {
-		s.authorized = true
+		s.authorized, s.identity, s.tenant = true, "default", nil
 		r.Response.Resp = redis.NewString([]byte("OK"))
 		return r, nil
 	}
@@ -293,8 +487,9 @@ func (s *Session) handleRequestMGet(r *Request, d Dispatcher) (*Request, error)
 	var sub = make([]*Request, nkeys)
 	for i := 0; i < len(sub); i++ {
 		sub[i] = &Request{
-			OpStr: r.OpStr,
-			Start: r.Start,
+			OpStr:    r.OpStr,
+			Start:    r.Start,
+			affinity: r.affinity,
 			Resp: redis.NewArray([]*redis.Resp{
 				r.Resp.Array[0],
 				r.Resp.Array[i+1],
@@ -317,6 +512,11 @@ func (s *Session) handleRequestMGet(r *Request, d Dispatcher) (*Request, error)
 			if resp == nil {
 				return ErrRespIsRequired
 			}
+			// 后端返回的错误直接返回给客户端，例如熔断时的错误
+			if resp.IsError() {
+				r.Response.Resp = resp
+				return nil
+			}
 			if !resp.IsArray() || len(resp.Array) != 1 {
 				return errors.New(fmt.Sprintf("bad mget resp: %s array.len = %d", resp.Type, len(resp.Array)))
 			}
@@ -341,8 +541,9 @@ func (s *Session) handleRequestMSet(r *Request, d Dispatcher) (*Request, error)
 	var sub = make([]*Request, nblks/2)
 	for i := 0; i < len(sub); i++ {
 		sub[i] = &Request{
-			OpStr: r.OpStr,
-			Start: r.Start,
+			OpStr:    r.OpStr,
+			Start:    r.Start,
+			affinity: r.affinity,
 			Resp: redis.NewArray([]*redis.Resp{
 				r.Resp.Array[0],
 				r.Resp.Array[i*2+1],
@@ -364,6 +565,10 @@ func (s *Session) handleRequestMSet(r *Request, d Dispatcher) (*Request, error)
 			if resp == nil {
 				return ErrRespIsRequired
 			}
+			if resp.IsError() {
+				r.Response.Resp = resp
+				return nil
+			}
 			if !resp.IsString() {
 				return errors.New(fmt.Sprintf("bad mset resp: %s value.len = %d", resp.Type, len(resp.Value)))
 			}
@@ -374,7 +579,8 @@ func (s *Session) handleRequestMSet(r *Request, d Dispatcher) (*Request, error)
 	return r, nil
 }
 
-// 同 Mget，会拆分成多个单个key的任务
+// 同 Mget，会拆分成多个单个key的任务，DEL、EXISTS、UNLINK、TOUCH 返回每个key的结果之和
+// UNLINK、TOUCH 需要后端redis支持，基于2.8.21的codis-server会返回错误
 func (s *Session) handleRequestMDel(r *Request, d Dispatcher) (*Request, error) {
 	nkeys := len(r.Resp.Array) - 1
 	if nkeys <= 1 {
@@ -383,8 +589,9 @@ func (s *Session) handleRequestMDel(r *Request, d Dispatcher) (*Request, error)
 	var sub = make([]*Request, nkeys)
 	for i := 0; i < len(sub); i++ {
 		sub[i] = &Request{
-			OpStr: r.OpStr,
-			Start: r.Start,
+			OpStr:    r.OpStr,
+			Start:    r.Start,
+			affinity: r.affinity,
 			Resp: redis.NewArray([]*redis.Resp{
 				r.Resp.Array[0],
 				r.Resp.Array[i+1],
@@ -406,8 +613,12 @@ func (s *Session) handleRequestMDel(r *Request, d Dispatcher) (*Request, error)
 			if resp == nil {
 				return ErrRespIsRequired
 			}
+			if resp.IsError() {
+				r.Response.Resp = resp
+				return nil
+			}
 			if !resp.IsInt() || len(resp.Value) != 1 {
-				return errors.New(fmt.Sprintf("bad mdel resp: %s value.len = %d", resp.Type, len(resp.Value)))
+				return errors.New(fmt.Sprintf("bad %s resp: %s value.len = %d", r.OpStr, resp.Type, len(resp.Value)))
 			}
 			if resp.Value[0] != '0' {
 				n++
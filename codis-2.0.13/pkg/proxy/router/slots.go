@@ -22,6 +22,11 @@ type Slot struct {
 		host []byte
 		port []byte
 		bc   *SharedBackendConn
+
+		// 所在group的slave连接，用于读写分离
+		slaves []*replica
+		policy ReadPolicy
+		next   uint32
 	}
 	// slot迁移时，原redis-server的连接
 	migrate struct {
@@ -29,6 +34,12 @@ type Slot struct {
 		bc   *SharedBackendConn
 	}
 
+	// 阻塞命令使用的独占连接
+	blocking struct {
+		sync.Mutex
+		conns map[*blockingConn]bool
+	}
+
 	wait sync.WaitGroup
 	lock struct {
 		hold bool
@@ -57,6 +68,7 @@ func (s *Slot) reset() {
 	s.backend.host = nil
 	s.backend.port = nil
 	s.backend.bc = nil
+	s.backend.slaves = nil
 	s.migrate.from = ""
 	s.migrate.bc = nil
 }
@@ -76,7 +88,47 @@ func (s *Slot) forward(r *Request, key []byte) error {
 	}
 }
 
-var ErrSlotIsNotReady = errors.New("slot is not ready, may be offline")
+// 通过独占的后端连接转发一组请求，事务等场景要求这组请求连续地发往同一个redis-server
+func (s *Slot) forwardPinned(bc *BackendConn, keys [][]byte, rs []*Request) error {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	if s.backend.bc == nil {
+		log.Infof("slot-%04d is not ready: pinned to %s", s.id, bc.Addr())
+		return ErrSlotIsNotReady
+	}
+	// 独占连接建立之后slot已经切换到了其他redis-server
+	if s.backend.addr != bc.Addr() {
+		return ErrSlotBackendChanged
+	}
+	// 熔断器对整组请求只检查一次，要么全部拒绝要么全部转发
+	// 否则冷却时间恰好在中间结束时，事务中的某个命令会被当作探测请求单独执行
+	if !bc.breaker.allow() {
+		for _, r := range rs {
+			r.slot = &s.wait
+			r.slot.Add(1)
+			bc.reject(r)
+		}
+		return nil
+	}
+	for _, key := range keys {
+		if err := s.slotsmgrt(rs[0], key); err != nil {
+			log.Warnf("slot-%04d migrate from = %s to %s failed: key = %s, error = %s",
+				s.id, s.migrate.from, s.backend.addr, key, err)
+			return err
+		}
+	}
+	for _, r := range rs {
+		r.slot = &s.wait
+		r.slot.Add(1)
+		bc.push(r)
+	}
+	return nil
+}
+
+var (
+	ErrSlotIsNotReady     = errors.New("slot is not ready, may be offline")
+	ErrSlotBackendChanged = errors.New("slot backend has been changed")
+)
 
 // 执行redis命令前的准备工作，检查和后端redis连接是否存在，检查slot是否处于迁移状态中，如果是，强制迁移指定key到新的redis-server
 func (s *Slot) prepare(r *Request, key []byte) (*SharedBackendConn, error) {
@@ -84,16 +136,22 @@ func (s *Slot) prepare(r *Request, key []byte) (*SharedBackendConn, error) {
 		log.Infof("slot-%04d is not ready: key = %s", s.id, key)
 		return nil, ErrSlotIsNotReady
 	}
-	if err := s.slotsmgrt(r, key); err != nil {
-		log.Warnf("slot-%04d migrate from = %s to %s failed: key = %s, error = %s",
-			s.id, s.migrate.from, s.backend.addr, key, err)
-		return nil, err
-	} else {
-		// 操作可能涉及多个slot，需要等待所有slot完成操作
-		r.slot = &s.wait
-		r.slot.Add(1)
-		return s.backend.bc, nil
+	var keys = [][]byte{key}
+	if s.migrate.bc != nil && isScriptCommand(r.OpStr) {
+		// 脚本可能访问声明的所有key，需要全部迁移完成之后再执行
+		keys = getScriptKeys(r.Resp)
+	}
+	for _, key := range keys {
+		if err := s.slotsmgrt(r, key); err != nil {
+			log.Warnf("slot-%04d migrate from = %s to %s failed: key = %s, error = %s",
+				s.id, s.migrate.from, s.backend.addr, key, err)
+			return nil, err
+		}
 	}
+	// 操作可能涉及多个slot，需要等待所有slot完成操作
+	r.slot = &s.wait
+	r.slot.Add(1)
+	return s.pick(r), nil
 }
 
 // 执行命令前需要先检查当前slot是否处于迁移中
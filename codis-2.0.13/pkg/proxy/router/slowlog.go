@@ -0,0 +1,175 @@
+// Copyright 2016 CodisLabs. All Rights Reserved.
+// Licensed under the MIT (MIT-LICENSE.txt) license.
+
+package router
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/CodisLabs/codis/pkg/proxy/redis"
+	"github.com/CodisLabs/codis/pkg/utils/atomic2"
+)
+
+// 和redis一样，只记录命令的前32个参数，每个参数最多128字节
+const (
+	slowLogMaxArgc   = 32
+	slowLogMaxArgLen = 128
+)
+
+// 一条慢查询记录
+type SlowLogEntry struct {
+	Id      int64    `json:"id"`
+	Unix    int64    `json:"time"`  // 请求开始的时间戳
+	USecs   int64    `json:"usecs"` // 耗时
+	Cmd     []string `json:"cmd"`
+	Key     string   `json:"key"`
+	Backend string   `json:"backend"` // 处理请求的redis-server，多个redis-server时为空
+	Remote  string   `json:"remote"`  // redis客户端的地址
+}
+
+// 慢查询记录的环形缓冲区，整个proxy共享
+var slowlog struct {
+	sync.Mutex
+	slowerThan atomic2.Int64 // 耗时超过这个值的请求才记录，单位us，0表示不记录
+
+	entries []*SlowLogEntry
+	head    int // 下一条记录写入的位置
+	size    int
+	nextId  int64
+}
+
+// 设置慢查询的阈值和最多保存的记录数，已有的记录会被清空
+func SetSlowLog(slowerThan int64, maxLen int) {
+	slowlog.Lock()
+	defer slowlog.Unlock()
+	if maxLen <= 0 {
+		slowerThan = 0
+	}
+	slowlog.slowerThan.Set(slowerThan)
+	slowlog.entries = make([]*SlowLogEntry, maxLen)
+	slowlog.head, slowlog.size = 0, 0
+}
+
+// 获取最近的n条慢查询记录，最新的在前面，n小于0时返回全部
+func GetSlowLog(n int) []*SlowLogEntry {
+	slowlog.Lock()
+	defer slowlog.Unlock()
+	if n < 0 || n > slowlog.size {
+		n = slowlog.size
+	}
+	var list = make([]*SlowLogEntry, n)
+	for i := range list {
+		k := slowlog.head - 1 - i
+		if k < 0 {
+			k += len(slowlog.entries)
+		}
+		list[i] = slowlog.entries[k]
+	}
+	return list
+}
+
+func SlowLogLen() int {
+	slowlog.Lock()
+	defer slowlog.Unlock()
+	return slowlog.size
+}
+
+func ResetSlowLog() {
+	slowlog.Lock()
+	defer slowlog.Unlock()
+	for i := range slowlog.entries {
+		slowlog.entries[i] = nil
+	}
+	slowlog.head, slowlog.size = 0, 0
+}
+
+// 请求耗时超过阈值时记录下来
+func (s *Session) addSlowLog(r *Request, usecs int64) {
+	if v := slowlog.slowerThan.Get(); v == 0 || usecs < v {
+		return
+	}
+	e := &SlowLogEntry{
+		Unix:    r.Start / 1e6,
+		USecs:   usecs,
+		Key:     string(getHashKey(r.Resp, r.OpStr)),
+		Backend: r.backend,
+		Remote:  s.Conn.Sock.RemoteAddr().String(),
+	}
+	var argc = len(r.Resp.Array)
+	if argc > slowLogMaxArgc {
+		argc = slowLogMaxArgc
+	}
+	for i, x := range r.Resp.Array[:argc] {
+		if i == argc-1 && argc != len(r.Resp.Array) {
+			e.Cmd = append(e.Cmd, fmt.Sprintf("... (%d more arguments)", len(r.Resp.Array)-argc+1))
+			break
+		}
+		if len(x.Value) > slowLogMaxArgLen {
+			e.Cmd = append(e.Cmd, fmt.Sprintf("%s... (%d more bytes)", x.Value[:slowLogMaxArgLen], len(x.Value)-slowLogMaxArgLen))
+		} else {
+			e.Cmd = append(e.Cmd, string(x.Value))
+		}
+	}
+
+	slowlog.Lock()
+	defer slowlog.Unlock()
+	if len(slowlog.entries) == 0 {
+		return
+	}
+	e.Id = slowlog.nextId
+	slowlog.nextId++
+	slowlog.entries[slowlog.head] = e
+	slowlog.head = (slowlog.head + 1) % len(slowlog.entries)
+	if slowlog.size < len(slowlog.entries) {
+		slowlog.size++
+	}
+}
+
+// SLOWLOG 命令，返回的是proxy记录的慢查询，而不是后端redis-server的
+// 每条记录依次是 id、时间戳、耗时、命令参数、客户端地址、redis-server地址
+func (s *Session) handleSlowLog(r *Request) (*Request, error) {
+	var subcmd string
+	if len(r.Resp.Array) >= 2 {
+		subcmd = strings.ToUpper(string(r.Resp.Array[1].Value))
+	}
+	var nargs = len(r.Resp.Array)
+	switch {
+	case subcmd == "GET" && nargs <= 3:
+		var n = 10
+		if nargs == 3 {
+			v, err := strconv.Atoi(string(r.Resp.Array[2].Value))
+			if err != nil {
+				r.Response.Resp = redis.NewError([]byte("ERR value is not an integer or out of range"))
+				return r, nil
+			}
+			n = v
+		}
+		var array = make([]*redis.Resp, 0, 16)
+		for _, e := range GetSlowLog(n) {
+			var args = make([]*redis.Resp, len(e.Cmd))
+			for i, arg := range e.Cmd {
+				args[i] = redis.NewBulkBytes([]byte(arg))
+			}
+			array = append(array, redis.NewArray([]*redis.Resp{
+				redis.NewInt([]byte(strconv.FormatInt(e.Id, 10))),
+				redis.NewInt([]byte(strconv.FormatInt(e.Unix, 10))),
+				redis.NewInt([]byte(strconv.FormatInt(e.USecs, 10))),
+				redis.NewArray(args),
+				redis.NewBulkBytes([]byte(e.Remote)),
+				redis.NewBulkBytes([]byte(e.Backend)),
+			}))
+		}
+		r.Response.Resp = redis.NewArray(array)
+	case subcmd == "LEN" && nargs == 2:
+		r.Response.Resp = redis.NewInt([]byte(strconv.Itoa(SlowLogLen())))
+	case subcmd == "RESET" && nargs == 2:
+		ResetSlowLog()
+		r.Response.Resp = redis.NewString([]byte("OK"))
+	default:
+		r.Response.Resp = redis.NewError([]byte("ERR Unknown SLOWLOG subcommand or wrong # of args. Try GET, RESET, LEN."))
+	}
+	return r, nil
+}
@@ -0,0 +1,61 @@
+// Copyright 2016 CodisLabs. All Rights Reserved.
+// Licensed under the MIT (MIT-LICENSE.txt) license.
+
+package router
+
+import (
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/CodisLabs/codis/pkg/proxy/redis"
+	"github.com/CodisLabs/codis/pkg/utils/assert"
+)
+
+func newTestRequest(args ...string) *Request {
+	var array = make([]*redis.Resp, len(args))
+	for i, arg := range args {
+		array[i] = redis.NewBulkBytes([]byte(arg))
+	}
+	resp := redis.NewArray(array)
+	opstr, _ := getOpStr(resp)
+	return &Request{OpStr: opstr, Start: microseconds(), Resp: resp}
+}
+
+func TestSlowLog(t *testing.T) {
+	defer SetSlowLog(0, 0)
+
+	c1, c2 := net.Pipe()
+	defer c1.Close()
+	defer c2.Close()
+	s := NewSession(c1, "")
+
+	SetSlowLog(100, 4)
+	s.addSlowLog(newTestRequest("GET", "fast"), 99)
+	assert.Must(SlowLogLen() == 0)
+
+	for i := 0; i < 6; i++ {
+		s.addSlowLog(newTestRequest("GET", "key"+strconv.Itoa(i)), 100)
+	}
+	assert.Must(SlowLogLen() == 4)
+	list := GetSlowLog(-1)
+	assert.Must(len(list) == 4)
+	for i, e := range list {
+		assert.Must(e.Id == int64(5-i) && e.Key == "key"+strconv.Itoa(5-i))
+	}
+	assert.Must(len(GetSlowLog(2)) == 2)
+
+	var args = []string{"MSET"}
+	for i := 0; i < 50; i++ {
+		args = append(args, strings.Repeat("x", 200))
+	}
+	s.addSlowLog(newTestRequest(args...), 1000)
+	e := GetSlowLog(1)[0]
+	assert.Must(len(e.Cmd) == slowLogMaxArgc)
+	assert.Must(e.Cmd[slowLogMaxArgc-1] == "... (20 more arguments)")
+	assert.Must(e.Cmd[1] == strings.Repeat("x", 128)+"... (72 more bytes)")
+
+	ResetSlowLog()
+	assert.Must(SlowLogLen() == 0 && len(GetSlowLog(-1)) == 0)
+}
@@ -8,6 +8,7 @@ import (
 	"sync"
 
 	"github.com/CodisLabs/codis/pkg/utils/atomic2"
+	"github.com/CodisLabs/codis/pkg/utils/histogram"
 )
 
 // 操作统计信息
@@ -15,6 +16,8 @@ type OpStats struct {
 	opstr string        // 操作命令
 	calls atomic2.Int64 // 请求次数
 	usecs atomic2.Int64 // 总耗时
+
+	hist histogram.Histogram // 耗时分布
 }
 
 func (s *OpStats) OpStr() string {
@@ -29,6 +32,10 @@ func (s *OpStats) USecs() int64 {
 	return s.usecs.Get()
 }
 
+func (s *OpStats) Latency() *histogram.Snapshot {
+	return s.hist.Snapshot()
+}
+
 func (s *OpStats) MarshalJSON() ([]byte, error) {
 	var m = make(map[string]interface{})
 	var calls = s.calls.Get()
@@ -43,6 +50,7 @@ func (s *OpStats) MarshalJSON() ([]byte, error) {
 	m["calls"] = calls
 	m["usecs"] = usecs
 	m["usecs_percall"] = perusecs
+	m["latency"] = s.hist.Snapshot()
 	return json.Marshal(m)
 }
 
@@ -100,5 +108,137 @@ func incrOpStats(opstr string, usecs int64) {
 	// 调用次数
 	s.calls.Incr()
 	s.usecs.Add(usecs)
+	s.hist.Record(usecs)
 	cmdstats.requests.Incr()
 }
+
+// 后端redis-server的统计信息，耗时从proxy收到请求开始计算，包括slot迁移等待的时间
+type BackendStats struct {
+	addr  string
+	calls atomic2.Int64
+
+	hist histogram.Histogram
+
+	breaker breaker // 熔断器
+}
+
+func (s *BackendStats) Addr() string {
+	return s.addr
+}
+
+func (s *BackendStats) Calls() int64 {
+	return s.calls.Get()
+}
+
+func (s *BackendStats) Latency() *histogram.Snapshot {
+	return s.hist.Snapshot()
+}
+
+func (s *BackendStats) BreakerState() BreakerState {
+	return s.breaker.State()
+}
+
+func (s *BackendStats) MarshalJSON() ([]byte, error) {
+	var m = make(map[string]interface{})
+	m["addr"] = s.addr
+	m["calls"] = s.calls.Get()
+	m["latency"] = s.hist.Snapshot()
+	m["breaker"] = &s.breaker
+	return json.Marshal(m)
+}
+
+var backendstats struct {
+	addrmap map[string]*BackendStats
+	rwlck   sync.RWMutex
+}
+
+func init() {
+	backendstats.addrmap = make(map[string]*BackendStats)
+}
+
+// 获取指定redis-server的统计信息
+func GetBackendStats(addr string, create bool) *BackendStats {
+	backendstats.rwlck.RLock()
+	s := backendstats.addrmap[addr]
+	backendstats.rwlck.RUnlock()
+
+	if s != nil || !create {
+		return s
+	}
+
+	backendstats.rwlck.Lock()
+	s = backendstats.addrmap[addr]
+	if s == nil {
+		s = &BackendStats{addr: addr}
+		s.breaker.addr = addr
+		backendstats.addrmap[addr] = s
+	}
+	backendstats.rwlck.Unlock()
+	return s
+}
+
+// 获取全部redis-server的统计信息
+func GetAllBackendStats() []*BackendStats {
+	var all = make([]*BackendStats, 0, 16)
+	backendstats.rwlck.RLock()
+	for _, s := range backendstats.addrmap {
+		all = append(all, s)
+	}
+	backendstats.rwlck.RUnlock()
+	return all
+}
+
+func incrBackendStats(addr string, usecs int64) {
+	s := GetBackendStats(addr, true)
+	s.calls.Incr()
+	s.hist.Record(usecs)
+}
+
+// 限流统计信息，每个限流的令牌桶对应一个
+type LimitStats struct {
+	key      string        // 客户端ip、用户或者命令类别
+	calls    atomic2.Int64 // 请求次数
+	rejected atomic2.Int64 // 超过限制被拒绝的次数
+}
+
+func (s *LimitStats) Key() string {
+	return s.key
+}
+
+func (s *LimitStats) Calls() int64 {
+	return s.calls.Get()
+}
+
+func (s *LimitStats) Rejected() int64 {
+	return s.rejected.Get()
+}
+
+func (s *LimitStats) MarshalJSON() ([]byte, error) {
+	var m = make(map[string]interface{})
+	m["key"] = s.key
+	m["calls"] = s.calls.Get()
+	m["rejected"] = s.rejected.Get()
+	return json.Marshal(m)
+}
+
+// 获取全部限流的统计信息，按限流的维度分组
+func GetAllLimitStats() map[string][]*LimitStats {
+	ratelimit.RLock()
+	defer ratelimit.RUnlock()
+	var all = make(map[string][]*LimitStats)
+	for _, x := range []struct {
+		name    string
+		buckets map[string]*tokenBucket
+	}{
+		{"client", ratelimit.clients},
+		{"auth", ratelimit.auths},
+		{"class", ratelimit.classes},
+	} {
+		var list = make([]*LimitStats, 0, len(x.buckets))
+		for _, b := range x.buckets {
+			list = append(list, &b.stats)
+		}
+		all[x.name] = list
+	}
+	return all
+}
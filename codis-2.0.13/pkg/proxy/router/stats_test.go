@@ -0,0 +1,35 @@
+// Copyright 2016 CodisLabs. All Rights Reserved.
+// Licensed under the MIT (MIT-LICENSE.txt) license.
+
+package router
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/CodisLabs/codis/pkg/utils/assert"
+)
+
+func TestLatencyStats(t *testing.T) {
+	for i := int64(1); i <= 100; i++ {
+		incrOpStats("TEST_LATENCY", i*100)
+		incrBackendStats("test:1000", i*100)
+	}
+	s := GetOpStats("TEST_LATENCY", false)
+	assert.Must(s != nil && s.Calls() == 100)
+	p99 := s.Latency().Percentile(0.99)
+	assert.Must(p99 >= 9900 && p99 <= 9900*9/8)
+
+	b := GetBackendStats("test:1000", false)
+	assert.Must(b != nil && b.Calls() == 100 && b.Latency().Count() == 100)
+
+	var m struct {
+		Latency struct {
+			P99 int64 `json:"p99"`
+		} `json:"latency"`
+	}
+	data, err := json.Marshal(b)
+	assert.MustNoError(err)
+	assert.MustNoError(json.Unmarshal(data, &m))
+	assert.Must(m.Latency.P99 == b.Latency().Percentile(0.99))
+}
@@ -0,0 +1,202 @@
+// Copyright 2016 CodisLabs. All Rights Reserved.
+// Licensed under the MIT (MIT-LICENSE.txt) license.
+
+package router
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"sync"
+
+	"github.com/CodisLabs/codis/pkg/proxy/redis"
+	"github.com/CodisLabs/codis/pkg/utils/errors"
+)
+
+// 租户，通过自己的密码认证，所有key自动加上前缀，不同租户之间的key互相不可见
+type Tenant struct {
+	Name     string   `json:"name"`
+	Password string   `json:"password"`
+	Prefix   string   `json:"prefix"`
+	Allow    []string `json:"allow,omitempty"` // 允许执行的命令，为空时允许所有命令
+	Deny     []string `json:"deny,omitempty"`  // 禁止执行的命令
+
+	allow map[string]bool
+	deny  map[string]bool
+}
+
+// 无法限制在租户的key范围内的命令，租户不能执行
+var tenantUnsupported = map[string]bool{
+	"DBSIZE": true, "RANDOMKEY": true, // 会看到其他租户的key
+	"EVAL": true, "EVALSHA": true, "SCRIPT": true, // 脚本可以访问任意key
+	"SLOWLOG": true, // 慢查询是整个proxy共享的，会看到其他租户的key，RESET 也会清除其他租户的记录
+}
+
+// 解析JSON格式的租户列表
+func ParseTenants(b []byte) ([]*Tenant, error) {
+	var list []*Tenant
+	if err := json.Unmarshal(b, &list); err != nil {
+		return nil, errors.Trace(err)
+	}
+	var names = make(map[string]bool)
+	var passwords = make(map[string]bool)
+	for _, t := range list {
+		switch {
+		case t.Name == "" || t.Name == "default":
+			return nil, errors.Errorf("invalid tenant name: '%s'", t.Name)
+		case names[t.Name]:
+			return nil, errors.Errorf("duplicated tenant: %s", t.Name)
+		case t.Password == "":
+			return nil, errors.Errorf("password of tenant %s is empty", t.Name)
+		case passwords[t.Password]:
+			return nil, errors.Errorf("password of tenant %s is used by another tenant", t.Name)
+		case t.Prefix == "" || strings.ContainsAny(t.Prefix, "{}"):
+			// 前缀中不能有 {}，否则会改变key的hash tag
+			return nil, errors.Errorf("invalid prefix of tenant %s: '%s'", t.Name, t.Prefix)
+		}
+		for _, p := range list {
+			if p != t && strings.HasPrefix(p.Prefix, t.Prefix) {
+				return nil, errors.Errorf("prefix of tenant %s overlaps with tenant %s", t.Name, p.Name)
+			}
+		}
+		names[t.Name], passwords[t.Password] = true, true
+		t.allow, t.deny = make(map[string]bool), make(map[string]bool)
+		for _, s := range t.Allow {
+			t.allow[strings.ToUpper(s)] = true
+		}
+		for _, s := range t.Deny {
+			t.deny[strings.ToUpper(s)] = true
+		}
+	}
+	return list, nil
+}
+
+// 所有租户，整个proxy共享
+var tenants struct {
+	sync.RWMutex
+	m map[string]*Tenant // 密码 -> 租户
+}
+
+// 设置租户列表，已经认证的会话不受影响
+func SetTenants(list []*Tenant) {
+	var m = make(map[string]*Tenant)
+	for _, t := range list {
+		m[t.Password] = t
+	}
+	tenants.Lock()
+	defer tenants.Unlock()
+	tenants.m = m
+}
+
+func getTenant(password string) *Tenant {
+	tenants.RLock()
+	defer tenants.RUnlock()
+	return tenants.m[password]
+}
+
+func hasTenants() bool {
+	tenants.RLock()
+	defer tenants.RUnlock()
+	return len(tenants.m) != 0
+}
+
+// 检查租户是否可以执行命令，连接相关的命令总是允许
+func (t *Tenant) isAllowed(opstr string) bool {
+	switch opstr {
+	case "PING", "SELECT", "ECHO":
+		return true
+	}
+	if tenantUnsupported[opstr] || t.deny[opstr] {
+		return false
+	}
+	return len(t.allow) == 0 || t.allow[opstr]
+}
+
+func (t *Tenant) addPrefix(r *redis.Resp) *redis.Resp {
+	return redis.NewBulkBytes(append([]byte(t.Prefix), r.Value...))
+}
+
+// 模式匹配时前缀中的特殊字符需要转义
+func (t *Tenant) patternPrefix() []byte {
+	var b bytes.Buffer
+	for i := 0; i < len(t.Prefix); i++ {
+		switch c := t.Prefix[i]; c {
+		case '*', '?', '[', ']', '\\':
+			b.WriteByte('\\')
+			b.WriteByte(c)
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return b.Bytes()
+}
+
+func (t *Tenant) addPatternPrefix(r *redis.Resp) *redis.Resp {
+	return redis.NewBulkBytes(append(t.patternPrefix(), r.Value...))
+}
+
+// 给请求中的key加上租户的前缀，模式匹配的参数也加上前缀
+func (t *Tenant) rewriteRequest(r *Request) {
+	var array = r.Resp.Array
+	for _, i := range getKeyIndexes(r.Resp, r.OpStr) {
+		array[i] = t.addPrefix(array[i])
+	}
+	switch r.OpStr {
+	case "KEYS":
+		if len(array) == 2 {
+			array[1] = t.addPatternPrefix(array[1])
+		}
+	case "SCAN":
+		// 没有 MATCH 参数时只遍历租户的key
+		var match = false
+		for i := 2; i+1 < len(array); i++ {
+			if strings.ToUpper(string(array[i].Value)) == "MATCH" {
+				array[i+1] = t.addPatternPrefix(array[i+1])
+				match = true
+				i++
+			}
+		}
+		if !match && len(array) >= 2 {
+			array = append(array,
+				redis.NewBulkBytes([]byte("MATCH")),
+				redis.NewBulkBytes(append(t.patternPrefix(), '*')))
+			r.Resp.Array = array
+		}
+	case "SORT":
+		// BY、GET 的参数是其他key的模式，STORE 的参数是key
+		for i := 2; i+1 < len(array); i++ {
+			switch strings.ToUpper(string(array[i].Value)) {
+			case "BY", "GET", "STORE":
+				if v := array[i+1].Value; string(v) != "#" && strings.ToUpper(string(v)) != "NOSORT" {
+					array[i+1] = t.addPrefix(array[i+1])
+				}
+				i++
+			case "LIMIT":
+				i += 2
+			}
+		}
+	}
+}
+
+// 去掉返回结果中key的租户前缀
+func (t *Tenant) stripResponse(opstr string, resp *redis.Resp) {
+	if resp == nil || !resp.IsArray() {
+		return
+	}
+	var keys []*redis.Resp
+	switch opstr {
+	case "KEYS":
+		keys = resp.Array
+	case "SCAN":
+		if len(resp.Array) == 2 && resp.Array[1].IsArray() {
+			keys = resp.Array[1].Array
+		}
+	case "BLPOP", "BRPOP":
+		if len(resp.Array) == 2 {
+			keys = resp.Array[:1]
+		}
+	}
+	for _, x := range keys {
+		x.Value = bytes.TrimPrefix(x.Value, []byte(t.Prefix))
+	}
+}
@@ -0,0 +1,88 @@
+// Copyright 2016 CodisLabs. All Rights Reserved.
+// Licensed under the MIT (MIT-LICENSE.txt) license.
+
+package router
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/CodisLabs/codis/pkg/proxy/redis"
+	"github.com/CodisLabs/codis/pkg/utils/assert"
+)
+
+func TestParseTenants(t *testing.T) {
+	list, err := ParseTenants([]byte(`[
+		{"name": "a", "password": "pa", "prefix": "a:", "deny": ["keys"]},
+		{"name": "b", "password": "pb", "prefix": "b:", "allow": ["get", "set"]}
+	]`))
+	assert.MustNoError(err)
+	assert.Must(len(list) == 2)
+
+	a, b := list[0], list[1]
+	assert.Must(a.isAllowed("GET") && a.isAllowed("SCAN") && !a.isAllowed("KEYS"))
+	assert.Must(b.isAllowed("GET") && b.isAllowed("PING") && !b.isAllowed("DEL"))
+	assert.Must(!a.isAllowed("EVAL") && !a.isAllowed("DBSIZE"))
+	assert.Must(!a.isAllowed("SLOWLOG"))
+
+	for _, s := range []string{
+		`[{"name": "", "password": "p", "prefix": "x:"}]`,
+		`[{"name": "default", "password": "p", "prefix": "x:"}]`,
+		`[{"name": "a", "password": "", "prefix": "x:"}]`,
+		`[{"name": "a", "password": "p", "prefix": ""}]`,
+		`[{"name": "a", "password": "p", "prefix": "{x}"}]`,
+		`[{"name": "a", "password": "p", "prefix": "a:"}, {"name": "a", "password": "q", "prefix": "b:"}]`,
+		`[{"name": "a", "password": "p", "prefix": "a:"}, {"name": "b", "password": "p", "prefix": "b:"}]`,
+		`[{"name": "a", "password": "p", "prefix": "a:"}, {"name": "b", "password": "q", "prefix": "a:b:"}]`,
+	} {
+		_, err := ParseTenants([]byte(s))
+		assert.Must(err != nil)
+	}
+}
+
+func TestTenantRewrite(t *testing.T) {
+	tenant := &Tenant{Name: "a", Prefix: "a*:"}
+	args := func(r *Request) string {
+		var list []string
+		for _, x := range r.Resp.Array {
+			list = append(list, string(x.Value))
+		}
+		return strings.Join(list, " ")
+	}
+	for _, c := range [][2]string{
+		{"GET k", "GET a*:k"},
+		{"MSET k1 v1 k2 v2", "MSET a*:k1 v1 a*:k2 v2"},
+		{"BLPOP k1 k2 0", "BLPOP a*:k1 a*:k2 0"},
+		{"RPOPLPUSH k1 k2", "RPOPLPUSH a*:k1 a*:k2"},
+		{"ZUNIONSTORE d 2 k1 k2 WEIGHTS 1 2", "ZUNIONSTORE a*:d 2 a*:k1 a*:k2 WEIGHTS 1 2"},
+		{"SORT k BY w_* GET # GET o_* LIMIT 0 10 STORE d", "SORT a*:k BY a*:w_* GET # GET a*:o_* LIMIT 0 10 STORE a*:d"},
+		{"KEYS k*", "KEYS a\\*:k*"},
+		{"SCAN 0", "SCAN 0 MATCH a\\*:*"},
+		{"SCAN 0 match k* COUNT 10", "SCAN 0 match a\\*:k* COUNT 10"},
+		{"PING", "PING"},
+		{"PUBLISH ch msg", "PUBLISH ch msg"},
+		{"CLUSTER KEYSLOT k", "CLUSTER KEYSLOT a*:k"},
+	} {
+		r := newTestRequest(strings.Split(c[0], " ")...)
+		tenant.rewriteRequest(r)
+		assert.Must(args(r) == c[1])
+	}
+
+	resp := redis.NewArray([]*redis.Resp{
+		redis.NewBulkBytes([]byte("12")),
+		redis.NewArray([]*redis.Resp{
+			redis.NewBulkBytes([]byte("a*:k1")),
+			redis.NewBulkBytes([]byte("a*:k2")),
+		}),
+	})
+	tenant.stripResponse("SCAN", resp)
+	assert.Must(string(resp.Array[0].Value) == "12")
+	assert.Must(string(resp.Array[1].Array[0].Value) == "k1" && string(resp.Array[1].Array[1].Value) == "k2")
+
+	resp = redis.NewArray([]*redis.Resp{
+		redis.NewBulkBytes([]byte("a*:k1")),
+		redis.NewBulkBytes([]byte("a*:v")),
+	})
+	tenant.stripResponse("BLPOP", resp)
+	assert.Must(string(resp.Array[0].Value) == "k1" && string(resp.Array[1].Value) == "a*:v")
+}
@@ -0,0 +1,214 @@
+// Copyright 2016 CodisLabs. All Rights Reserved.
+// Licensed under the MIT (MIT-LICENSE.txt) license.
+
+package router
+
+import (
+	"github.com/CodisLabs/codis/pkg/proxy/redis"
+)
+
+// 事务状态，MULTI...EXEC 之间的命令先在proxy中排队，EXEC时通过独占连接一次性发往同一个slot
+type transaction struct {
+	multi  bool // 是否处于 MULTI 状态
+	failed bool // 排队时出现错误，EXEC时放弃整个事务
+	dirty  bool // WATCH之后slot被切换，EXEC时直接返回失败
+	slot   int  // 事务固定的slot，-1表示还未确定
+
+	queue []*redis.Resp // 排队中的命令
+	keys  [][]byte      // 事务涉及的key，slot迁移中时需要先迁移这些key
+
+	bc *BackendConn // WATCH或者EXEC时建立的独占连接
+}
+
+// 检查keys是否都属于事务固定的slot，事务还没有固定slot时以这些key所在的slot为准
+func (t *transaction) sameSlot(keys [][]byte) bool {
+	var slot = t.slot
+	for _, key := range keys {
+		i := hashSlot(key)
+		if slot < 0 {
+			slot = i
+		} else if slot != i {
+			return false
+		}
+	}
+	t.slot = slot
+	return true
+}
+
+// 结束事务，关闭独占连接，后端redis会随连接关闭清除WATCH状态
+func (t *transaction) reset() {
+	if t.bc != nil {
+		t.bc.Close()
+	}
+	*t = transaction{slot: -1}
+}
+
+var (
+	errCrossSlot = redis.NewError([]byte("CROSSSLOT Keys in request don't hash to the same slot"))
+	errExecAbort = redis.NewError([]byte("EXECABORT Transaction discarded because of previous errors."))
+)
+
+func isTxnCommand(opstr string) bool {
+	switch opstr {
+	case "MULTI", "EXEC", "DISCARD", "WATCH":
+		return true
+	}
+	return false
+}
+
+// MULTI 命令，只在proxy中记录状态，不转发给后端redis
+func (s *Session) handleMulti(r *Request) (*Request, error) {
+	if s.txn.multi {
+		r.Response.Resp = redis.NewError([]byte("ERR MULTI calls can not be nested"))
+		return r, nil
+	}
+	s.txn.multi = true
+	r.Response.Resp = redis.NewString([]byte("OK"))
+	return r, nil
+}
+
+// MULTI 之后的命令在proxy中排队，所有key必须属于同一个slot
+func (s *Session) handleQueued(r *Request) (*Request, error) {
+	// pub/sub 命令需要发往单独的group，SCAN、SCRIPT 等命令需要发往所有group，SLOWLOG、CLUSTER 由proxy处理，都不能放在事务中
+	if isPubSubCommand(r.OpStr) || isKeyspaceCommand(r.OpStr) || r.OpStr == "SCRIPT" || r.OpStr == "SLOWLOG" || r.OpStr == "CLUSTER" {
+		s.txn.failed = true
+		r.Response.Resp = redis.NewError([]byte("ERR command <" + r.OpStr + "> is not allowed in transaction"))
+		return r, nil
+	}
+	keys := getHashKeys(r.Resp, r.OpStr)
+	if !s.txn.sameSlot(keys) {
+		s.txn.failed = true
+		r.Response.Resp = errCrossSlot
+		return r, nil
+	}
+	s.txn.queue = append(s.txn.queue, r.Resp)
+	s.txn.keys = append(s.txn.keys, keys...)
+	r.Response.Resp = redis.NewString([]byte("QUEUED"))
+	return r, nil
+}
+
+// WATCH 命令需要在独占连接上执行，之后的事务也固定在这个连接上
+func (s *Session) handleWatch(r *Request, d Dispatcher) (*Request, error) {
+	if s.txn.multi {
+		r.Response.Resp = redis.NewError([]byte("ERR WATCH inside MULTI is not allowed"))
+		return r, nil
+	}
+	if len(r.Resp.Array) < 2 {
+		r.Response.Resp = redis.NewError([]byte("ERR wrong number of arguments for 'WATCH' command"))
+		return r, nil
+	}
+	keys := getHashKeys(r.Resp, r.OpStr)
+	if !s.txn.sameSlot(keys) {
+		r.Response.Resp = errCrossSlot
+		return r, nil
+	}
+	if s.txn.bc == nil {
+		bc, err := d.DialSlot(s.txn.slot)
+		if err != nil {
+			return nil, err
+		}
+		s.txn.bc = bc
+	}
+	if err := d.DispatchPinned(s.txn.slot, s.txn.bc, keys, r); err != nil {
+		if err != ErrSlotBackendChanged {
+			return nil, err
+		}
+		// slot已经切换，之前的WATCH失效，让EXEC返回失败
+		s.txn.dirty = true
+		r.Response.Resp = redis.NewString([]byte("OK"))
+	}
+	return r, nil
+}
+
+// UNWATCH 命令，直接关闭独占连接即可
+func (s *Session) handleUnwatch(r *Request) (*Request, error) {
+	s.txn.reset()
+	r.Response.Resp = redis.NewString([]byte("OK"))
+	return r, nil
+}
+
+// DISCARD 命令，丢弃排队中的命令
+func (s *Session) handleDiscard(r *Request) (*Request, error) {
+	if !s.txn.multi {
+		r.Response.Resp = redis.NewError([]byte("ERR DISCARD without MULTI"))
+		return r, nil
+	}
+	s.txn.reset()
+	r.Response.Resp = redis.NewString([]byte("OK"))
+	return r, nil
+}
+
+// EXEC 命令，将 MULTI、排队的命令以及 EXEC 连续地发往事务固定的slot，返回 EXEC 的结果
+func (s *Session) handleExec(r *Request, d Dispatcher) (*Request, error) {
+	if !s.txn.multi {
+		r.Response.Resp = redis.NewError([]byte("ERR EXEC without MULTI"))
+		return r, nil
+	}
+	defer s.txn.reset()
+
+	if s.txn.failed {
+		r.Response.Resp = errExecAbort
+		return r, nil
+	}
+	if s.txn.dirty {
+		r.Response.Resp = redis.NewArray(nil)
+		return r, nil
+	}
+	if len(s.txn.queue) == 0 && s.txn.bc == nil {
+		r.Response.Resp = redis.NewArray([]*redis.Resp{})
+		return r, nil
+	}
+
+	var slot = s.txn.slot
+	if slot < 0 {
+		slot = hashSlot(nil)
+	}
+	if s.txn.bc == nil {
+		bc, err := d.DialSlot(slot)
+		if err != nil {
+			return nil, err
+		}
+		s.txn.bc = bc
+	}
+
+	var cmds = make([]*redis.Resp, 0, len(s.txn.queue)+2)
+	cmds = append(cmds, redis.NewArray([]*redis.Resp{
+		redis.NewBulkBytes([]byte("MULTI")),
+	}))
+	cmds = append(cmds, s.txn.queue...)
+	cmds = append(cmds, r.Resp)
+
+	var sub = make([]*Request, len(cmds))
+	for i, resp := range cmds {
+		opstr, _ := getOpStr(resp)
+		sub[i] = &Request{
+			OpStr:  opstr,
+			Start:  r.Start,
+			Resp:   resp,
+			Wait:   r.Wait,
+			Failed: r.Failed,
+		}
+	}
+
+	if err := d.DispatchPinned(slot, s.txn.bc, s.txn.keys, sub...); err != nil {
+		if err != ErrSlotBackendChanged {
+			return nil, err
+		}
+		// WATCH 之后slot被切换到了其他redis-server，等同于被WATCH的key被修改
+		r.Response.Resp = redis.NewArray(nil)
+		return r, nil
+	}
+	r.Coalesce = func() error {
+		for _, x := range sub {
+			if err := x.Response.Err; err != nil {
+				return err
+			}
+			if x.Response.Resp == nil {
+				return ErrRespIsRequired
+			}
+		}
+		r.Response.Resp = sub[len(sub)-1].Response.Resp
+		return nil
+	}
+	return r, nil
+}
@@ -0,0 +1,160 @@
+// Copyright 2016 CodisLabs. All Rights Reserved.
+// Licensed under the MIT (MIT-LICENSE.txt) license.
+
+package proxy
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/CodisLabs/codis/pkg/proxy/redis"
+	"github.com/CodisLabs/codis/pkg/proxy/router"
+	"github.com/CodisLabs/codis/pkg/utils/assert"
+)
+
+// 生成证书和私钥文件，parent为nil时生成自签名的CA证书
+func newTestCert(dir, name string, parent *x509.Certificate, parentKey *rsa.PrivateKey) (*x509.Certificate, *rsa.PrivateKey) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.MustNoError(err)
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+	}
+	if parent == nil {
+		tmpl.IsCA = true
+		tmpl.BasicConstraintsValid = true
+		tmpl.KeyUsage |= x509.KeyUsageCertSign
+		parent, parentKey = tmpl, key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, parent, &key.PublicKey, parentKey)
+	assert.MustNoError(err)
+	cert, err := x509.ParseCertificate(der)
+	assert.MustNoError(err)
+
+	b := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	assert.MustNoError(ioutil.WriteFile(filepath.Join(dir, name+".crt"), b, 0600))
+	b = pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)})
+	assert.MustNoError(ioutil.WriteFile(filepath.Join(dir, name+".key"), b, 0600))
+	return cert, key
+}
+
+// 只回复 +PONG 的redis
+func serveTestPong(l net.Listener) {
+	for {
+		c, err := l.Accept()
+		if err != nil {
+			return
+		}
+		go func(c net.Conn) {
+			defer c.Close()
+			conn := redis.NewConn(c)
+			for {
+				if _, err := conn.Reader.Decode(); err != nil {
+					return
+				}
+				if err := conn.Writer.Encode(redis.NewString([]byte("PONG")), true); err != nil {
+					return
+				}
+			}
+		}(c)
+	}
+}
+
+func TestTLS(t *testing.T) {
+	dir, err := ioutil.TempDir("", "codis-tls")
+	assert.MustNoError(err)
+	defer os.RemoveAll(dir)
+
+	ca, cakey := newTestCert(dir, "ca", nil, nil)
+	newTestCert(dir, "server", ca, cakey)
+	newTestCert(dir, "client", ca, cakey)
+	path := func(name string) string {
+		return filepath.Join(dir, name)
+	}
+
+	// 要求客户端证书的proxy监听端口
+	serverTLS, err := loadServerTLS(path("server.crt"), path("server.key"), path("ca.crt"))
+	assert.MustNoError(err)
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.MustNoError(err)
+	l = tls.NewListener(l, serverTLS)
+	defer l.Close()
+	go serveTestPong(l)
+
+	clientTLS, err := loadBackendTLS(path("ca.crt"), path("client.crt"), path("client.key"))
+	assert.MustNoError(err)
+	c, err := redis.DialTLS(l.Addr().String(), 1024, time.Second, clientTLS)
+	assert.MustNoError(err)
+	defer c.Close()
+	assert.MustNoError(c.Writer.Encode(redis.NewArray([]*redis.Resp{redis.NewBulkBytes([]byte("PING"))}), true))
+	resp, err := c.Reader.Decode()
+	assert.MustNoError(err)
+	assert.Must(string(resp.Value) == "PONG")
+
+	// 没有客户端证书时握手失败
+	noCert, err := loadBackendTLS(path("ca.crt"), "", "")
+	assert.MustNoError(err)
+	x, err := redis.DialTLS(l.Addr().String(), 1024, time.Second, noCert)
+	if err == nil {
+		x.Writer.Encode(redis.NewArray([]*redis.Resp{redis.NewBulkBytes([]byte("PING"))}), true)
+		_, err = x.Reader.Decode()
+		x.Close()
+	}
+	assert.Must(err != nil)
+
+	// 不信任的CA
+	_, err = redis.DialTLS(l.Addr().String(), 1024, time.Second, &tls.Config{})
+	assert.Must(err != nil)
+
+	_, err = loadServerTLS(path("server.crt"), path("server.key"), path("server.key"))
+	assert.Must(err != nil)
+}
+
+func TestBackendTLS(t *testing.T) {
+	dir, err := ioutil.TempDir("", "codis-tls")
+	assert.MustNoError(err)
+	defer os.RemoveAll(dir)
+
+	ca, cakey := newTestCert(dir, "ca", nil, nil)
+	newTestCert(dir, "server", ca, cakey)
+
+	serverTLS, err := loadServerTLS(filepath.Join(dir, "server.crt"), filepath.Join(dir, "server.key"), "")
+	assert.MustNoError(err)
+	l, err := tls.Listen("tcp", "127.0.0.1:0", serverTLS)
+	assert.MustNoError(err)
+	defer l.Close()
+	go serveTestPong(l)
+
+	backendTLS, err := loadBackendTLS(filepath.Join(dir, "ca.crt"), "", "")
+	assert.MustNoError(err)
+	router.SetBackendTLS(backendTLS)
+	defer router.SetBackendTLS(nil)
+
+	bc := router.NewBackendConn(l.Addr().String(), "")
+	defer bc.Close()
+	r := &router.Request{
+		Resp: redis.NewArray([]*redis.Resp{redis.NewBulkBytes([]byte("PING"))}),
+		Wait: &sync.WaitGroup{},
+	}
+	bc.PushBack(r)
+	r.Wait.Wait()
+	assert.MustNoError(r.Response.Err)
+	assert.Must(string(r.Response.Resp.Value) == "PONG")
+}
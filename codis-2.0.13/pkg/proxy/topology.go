@@ -10,6 +10,7 @@ import (
 	topo "github.com/wandoulabs/go-zookeeper/zk"
 
 	"github.com/CodisLabs/codis/pkg/models"
+	"github.com/CodisLabs/codis/pkg/utils/coordinator"
 	"github.com/CodisLabs/codis/pkg/utils/errors"
 	"github.com/CodisLabs/codis/pkg/utils/log"
 	"github.com/wandoulabs/zkhelper"
@@ -30,7 +31,7 @@ type Topology struct {
 	zkAddr           string        // zk地址
 	zkConn           zkhelper.Conn // zk连接
 	fact             ZkFactory     // 通过此函数返回一个zk连接（考虑到兼容etcd）
-	provider         string        // zk or etcd
+	provider         string        // zookeeper、etcd 或者 embedded
 	zkSessionTimeout int           // zk会话超时时间
 }
 
@@ -61,14 +62,11 @@ func (top *Topology) GetSlotByIndex(i int) (*models.Slot, *models.ServerGroup, e
 func NewTopo(ProductName string, zkAddr string, f ZkFactory, provider string, zkSessionTimeout int) *Topology {
 	t := &Topology{zkAddr: zkAddr, ProductName: ProductName, fact: f, provider: provider, zkSessionTimeout: zkSessionTimeout}
 	if t.fact == nil {
-		switch t.provider {
-		case "etcd":
-			t.fact = zkhelper.NewEtcdConn
-		case "zookeeper":
-			t.fact = zkhelper.ConnectToZk
-		default:
-			log.Panicf("coordinator not found in config")
+		f, ok := coordinator.Lookup(t.provider)
+		if !ok {
+			log.Panicf("coordinator %q not found, should be one of %v", t.provider, coordinator.Providers())
 		}
+		t.fact = ZkFactory(f)
 	}
 	t.InitZkConn()
 	return t
@@ -121,6 +119,11 @@ func (top *Topology) GetActionResponsePath(seq int) string {
 	return path.Join(models.GetActionResponsePath(top.ProductName), top.zkConn.Seq2Str(int64(seq)))
 }
 
+// 获取所有proxy的信息
+func (top *Topology) ProxyList(filter func(*models.ProxyInfo) bool) ([]models.ProxyInfo, error) {
+	return models.ProxyList(top.zkConn, top.ProductName, filter)
+}
+
 func (top *Topology) SetProxyStatus(proxyName string, status string) error {
 	return models.SetProxyStatus(top.zkConn, top.ProductName, proxyName, status)
 }
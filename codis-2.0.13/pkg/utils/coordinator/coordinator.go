@@ -0,0 +1,73 @@
+// Copyright 2016 CodisLabs. All Rights Reserved.
+// Licensed under the MIT (MIT-LICENSE.txt) license.
+
+// 协调服务的连接，配置文件中的 coordinator 决定使用哪一种实现
+// 所有实现都满足 zkhelper.Conn 接口：watch、临时节点、顺序节点，锁通过 zkhelper.CreateMutex 在这些功能之上实现
+package coordinator
+
+import (
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/wandoulabs/zkhelper"
+
+	"github.com/CodisLabs/codis/pkg/utils/errors"
+)
+
+// 创建连接的函数，sessionTimeout 的含义由具体的实现决定，和 zkhelper 保持一致
+type Factory func(addr string, sessionTimeout int) (zkhelper.Conn, error)
+
+var factories = struct {
+	sync.Mutex
+	m map[string]Factory
+}{m: make(map[string]Factory)}
+
+func init() {
+	Register("zookeeper", zkhelper.ConnectToZk)
+	Register("etcd", newEtcdConn)
+	Register("embedded", newEmbeddedConn)
+}
+
+// 注册一种协调服务，名字和配置文件中的 coordinator 对应
+func Register(name string, f Factory) {
+	factories.Lock()
+	defer factories.Unlock()
+	factories.m[name] = f
+}
+
+func Lookup(name string) (Factory, bool) {
+	factories.Lock()
+	defer factories.Unlock()
+	f, ok := factories.m[name]
+	return f, ok
+}
+
+// 已经注册的协调服务
+func Providers() []string {
+	factories.Lock()
+	defer factories.Unlock()
+	var names []string
+	for name := range factories.m {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func NewConn(name, addr string, sessionTimeout int) (zkhelper.Conn, error) {
+	f, ok := Lookup(name)
+	if !ok {
+		return nil, errors.Errorf("unknown coordinator %q, should be one of %v", name, Providers())
+	}
+	return f(addr, sessionTimeout)
+}
+
+// etcd 的地址需要带上 http://
+func newEtcdConn(addr string, sessionTimeout int) (zkhelper.Conn, error) {
+	addr = strings.TrimSpace(addr)
+	if !strings.HasPrefix(addr, "http://") {
+		addr = "http://" + addr
+	}
+	return zkhelper.NewEtcdConn(addr, sessionTimeout)
+}
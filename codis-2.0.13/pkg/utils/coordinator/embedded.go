@@ -0,0 +1,552 @@
+// Copyright 2016 CodisLabs. All Rights Reserved.
+// Licensed under the MIT (MIT-LICENSE.txt) license.
+
+package coordinator
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/wandoulabs/go-zookeeper/zk"
+	"github.com/wandoulabs/zkhelper"
+
+	"github.com/CodisLabs/codis/pkg/utils/errors"
+)
+
+// 内嵌在进程中的协调服务，语义和 zookeeper 相同，每个连接是一个会话
+// 会话关闭时删除它创建的临时节点，这样 proxy、dashboard 和迁移的流程可以在同一个测试进程中运行
+type Store struct {
+	mu   sync.Mutex
+	root *enode
+	file string // 不为空时持久节点保存在这个文件中
+
+	zxid int64
+	sid  int64
+
+	dataW  map[string][]*ewatch // GetW、ExistsW 设置的 watch
+	childW map[string][]*ewatch // ChildrenW 设置的 watch
+}
+
+type enode struct {
+	data     []byte
+	version  int32
+	cversion int32 // 子节点的变化次数，和 zookeeper 一样作为顺序节点的序号
+	owner    int64 // 临时节点所属的会话，0 表示持久节点
+
+	czxid, mzxid, pzxid int64
+	ctime, mtime        time.Time
+
+	children map[string]*enode
+}
+
+type ewatch struct {
+	sid int64
+	c   chan zk.Event
+}
+
+func NewStore() *Store {
+	now := time.Now()
+	return &Store{
+		root:   &enode{children: make(map[string]*enode), ctime: now, mtime: now},
+		dataW:  make(map[string][]*ewatch),
+		childW: make(map[string][]*ewatch),
+	}
+}
+
+// 持久节点保存在文件中的格式
+type storedNode struct {
+	Path     string `json:"path"`
+	Data     []byte `json:"data"`
+	Version  int32  `json:"version"`
+	CVersion int32  `json:"cversion"`
+}
+
+// 从文件中恢复持久节点，文件不存在时创建一个空的存储
+func NewFileStore(file string) (*Store, error) {
+	s := NewStore()
+	s.file = file
+	b, err := ioutil.ReadFile(file)
+	if os.IsNotExist(err) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	var nodes []*storedNode
+	if err := json.Unmarshal(b, &nodes); err != nil {
+		return nil, errors.Trace(err)
+	}
+	// 按路径排序之后父节点总是在子节点之前
+	sort.Sort(storedNodes(nodes))
+	for _, x := range nodes {
+		var n *enode
+		if x.Path == "/" {
+			n = s.root
+		} else {
+			parent, name, err := s.lookupParent(x.Path)
+			if err != nil {
+				return nil, errors.Errorf("bad node %s in %s: %s", x.Path, file, err)
+			}
+			n = s.newNode(nil, 0)
+			parent.children[name] = n
+		}
+		n.data, n.version, n.cversion = x.Data, x.Version, x.CVersion
+	}
+	return s, nil
+}
+
+type storedNodes []*storedNode
+
+func (p storedNodes) Len() int           { return len(p) }
+func (p storedNodes) Less(i, j int) bool { return p[i].Path < p[j].Path }
+func (p storedNodes) Swap(i, j int)      { p[i], p[j] = p[j], p[i] }
+
+var stores = struct {
+	sync.Mutex
+	m map[string]*Store
+}{m: make(map[string]*Store)}
+
+// 地址相同的连接共享同一个存储，以 file:// 开头的地址把持久节点保存在文件中
+func OpenStore(addr string) (*Store, error) {
+	addr = strings.TrimSpace(addr)
+	stores.Lock()
+	defer stores.Unlock()
+	if s := stores.m[addr]; s != nil {
+		return s, nil
+	}
+	s := NewStore()
+	if strings.HasPrefix(addr, "file://") {
+		var err error
+		if s, err = NewFileStore(strings.TrimPrefix(addr, "file://")); err != nil {
+			return nil, err
+		}
+	}
+	stores.m[addr] = s
+	return s, nil
+}
+
+func newEmbeddedConn(addr string, sessionTimeout int) (zkhelper.Conn, error) {
+	s, err := OpenStore(addr)
+	if err != nil {
+		return nil, err
+	}
+	return s.NewConn(), nil
+}
+
+// 创建一个新的会话
+func (s *Store) NewConn() zkhelper.Conn {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.sid++
+	return &embeddedConn{store: s, sid: s.sid}
+}
+
+func (s *Store) newNode(data []byte, owner int64) *enode {
+	s.zxid++
+	now := time.Now()
+	return &enode{
+		data: append([]byte(nil), data...), owner: owner,
+		czxid: s.zxid, mzxid: s.zxid, pzxid: s.zxid,
+		ctime: now, mtime: now,
+		children: make(map[string]*enode),
+	}
+}
+
+func (s *Store) lookup(p string) (*enode, error) {
+	if !strings.HasPrefix(p, "/") {
+		return nil, errors.Errorf("invalid path %q", p)
+	}
+	n := s.root
+	for _, name := range strings.Split(p, "/") {
+		if name == "" {
+			continue
+		}
+		if n = n.children[name]; n == nil {
+			return nil, zk.ErrNoNode
+		}
+	}
+	return n, nil
+}
+
+func (s *Store) lookupParent(p string) (*enode, string, error) {
+	dir, name := path.Split(p)
+	if name == "" {
+		return nil, "", errors.Errorf("invalid path %q", p)
+	}
+	parent, err := s.lookup(dir)
+	if err != nil {
+		return nil, "", err
+	}
+	return parent, name, nil
+}
+
+func (s *Store) watch(m map[string][]*ewatch, p string, sid int64) <-chan zk.Event {
+	w := &ewatch{sid: sid, c: make(chan zk.Event, 1)}
+	m[p] = append(m[p], w)
+	return w.c
+}
+
+// watch 只触发一次
+func (s *Store) fire(m map[string][]*ewatch, p string, t zk.EventType) {
+	for _, w := range m[p] {
+		w.c <- zk.Event{Type: t, State: zk.StateConnected, Path: p}
+	}
+	delete(m, p)
+}
+
+func (s *Store) create(sid int64, p string, data []byte, flags int32) (string, error) {
+	dir, name := path.Split(p)
+	parent, err := s.lookup(dir)
+	if err != nil {
+		return "", err
+	}
+	if parent.owner != 0 {
+		return "", zk.ErrNoChildrenForEphemerals
+	}
+	if flags&zk.FlagSequence != 0 {
+		name += fmt.Sprintf("%0.10d", parent.cversion)
+	}
+	if name == "" || strings.Contains(name, "/") {
+		return "", errors.Errorf("invalid path %q", p)
+	}
+	if parent.children[name] != nil {
+		return "", zk.ErrNodeExists
+	}
+	var owner int64
+	if flags&zk.FlagEphemeral != 0 {
+		owner = sid
+	}
+	n := s.newNode(data, owner)
+	parent.children[name] = n
+	parent.cversion++
+	parent.pzxid = s.zxid
+
+	p = path.Join(dir, name)
+	s.fire(s.dataW, p, zk.EventNodeCreated)
+	s.fire(s.childW, path.Clean(dir), zk.EventNodeChildrenChanged)
+	return p, nil
+}
+
+func (s *Store) set(p string, data []byte, version int32) (zk.Stat, error) {
+	n, err := s.lookup(p)
+	if err != nil {
+		return nil, err
+	}
+	if version != -1 && version != n.version {
+		return nil, zk.ErrBadVersion
+	}
+	s.zxid++
+	n.data = append([]byte(nil), data...)
+	n.version++
+	n.mzxid, n.mtime = s.zxid, time.Now()
+	s.fire(s.dataW, path.Clean(p), zk.EventNodeDataChanged)
+	return n.stat(), nil
+}
+
+func (s *Store) delete(p string, version int32) error {
+	parent, name, err := s.lookupParent(path.Clean(p))
+	if err != nil {
+		return err
+	}
+	n := parent.children[name]
+	if n == nil {
+		return zk.ErrNoNode
+	}
+	if version != -1 && version != n.version {
+		return zk.ErrBadVersion
+	}
+	if len(n.children) != 0 {
+		return zk.ErrNotEmpty
+	}
+	s.zxid++
+	delete(parent.children, name)
+	parent.cversion++
+	parent.pzxid = s.zxid
+
+	p = path.Clean(p)
+	s.fire(s.dataW, p, zk.EventNodeDeleted)
+	s.fire(s.childW, p, zk.EventNodeDeleted)
+	s.fire(s.childW, path.Dir(p), zk.EventNodeChildrenChanged)
+	return nil
+}
+
+// 会话关闭时删除它的临时节点和 watch
+func (s *Store) closeSession(sid int64) {
+	var ephemerals []string
+	var walk func(p string, n *enode)
+	walk = func(p string, n *enode) {
+		for name, child := range n.children {
+			if child.owner == sid {
+				ephemerals = append(ephemerals, path.Join(p, name))
+			} else {
+				walk(path.Join(p, name), child)
+			}
+		}
+	}
+	walk("/", s.root)
+	for _, m := range []map[string][]*ewatch{s.dataW, s.childW} {
+		for p, list := range m {
+			var alive []*ewatch
+			for _, w := range list {
+				if w.sid != sid {
+					alive = append(alive, w)
+				}
+			}
+			if len(alive) != 0 {
+				m[p] = alive
+			} else {
+				delete(m, p)
+			}
+		}
+	}
+	for _, p := range ephemerals {
+		s.delete(p, -1)
+	}
+}
+
+// 持久节点有变化之后写入文件，先写临时文件再改名
+func (s *Store) persist() error {
+	if s.file == "" {
+		return nil
+	}
+	var nodes []*storedNode
+	var walk func(p string, n *enode)
+	walk = func(p string, n *enode) {
+		nodes = append(nodes, &storedNode{Path: p, Data: n.data, Version: n.version, CVersion: n.cversion})
+		for name, child := range n.children {
+			if child.owner == 0 {
+				walk(path.Join(p, name), child)
+			}
+		}
+	}
+	walk("/", s.root)
+	sort.Sort(storedNodes(nodes))
+	b, err := json.MarshalIndent(nodes, "", "  ")
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if err := ioutil.WriteFile(s.file+".tmp", b, 0644); err != nil {
+		return errors.Trace(err)
+	}
+	return errors.Trace(os.Rename(s.file+".tmp", s.file))
+}
+
+type estat struct {
+	czxid, mzxid, pzxid int64
+	ctime, mtime        time.Time
+	version, cversion   int
+	owner               int64
+	dlen, children      int
+}
+
+func (n *enode) stat() zk.Stat {
+	return &estat{
+		czxid: n.czxid, mzxid: n.mzxid, pzxid: n.pzxid,
+		ctime: n.ctime, mtime: n.mtime,
+		version: int(n.version), cversion: int(n.cversion),
+		owner: n.owner,
+		dlen:  len(n.data), children: len(n.children),
+	}
+}
+
+func (s *estat) Czxid() int64          { return s.czxid }
+func (s *estat) Mzxid() int64          { return s.mzxid }
+func (s *estat) CTime() time.Time      { return s.ctime }
+func (s *estat) MTime() time.Time      { return s.mtime }
+func (s *estat) Version() int          { return s.version }
+func (s *estat) CVersion() int         { return s.cversion }
+func (s *estat) AVersion() int         { return 0 }
+func (s *estat) EphemeralOwner() int64 { return s.owner }
+func (s *estat) DataLength() int       { return s.dlen }
+func (s *estat) NumChildren() int      { return s.children }
+func (s *estat) Pzxid() int64          { return s.pzxid }
+
+// 内嵌存储的一个会话
+type embeddedConn struct {
+	store  *Store
+	sid    int64
+	closed bool
+}
+
+func (c *embeddedConn) lock() (*Store, error) {
+	c.store.mu.Lock()
+	if c.closed {
+		c.store.mu.Unlock()
+		return nil, zk.ErrConnectionClosed
+	}
+	return c.store, nil
+}
+
+func (c *embeddedConn) Get(p string) ([]byte, zk.Stat, error) {
+	data, stat, _, err := c.get(p, false)
+	return data, stat, err
+}
+
+func (c *embeddedConn) GetW(p string) ([]byte, zk.Stat, <-chan zk.Event, error) {
+	return c.get(p, true)
+}
+
+func (c *embeddedConn) get(p string, watch bool) ([]byte, zk.Stat, <-chan zk.Event, error) {
+	s, err := c.lock()
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	defer s.mu.Unlock()
+	n, err := s.lookup(p)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	var w <-chan zk.Event
+	if watch {
+		w = s.watch(s.dataW, path.Clean(p), c.sid)
+	}
+	return append([]byte(nil), n.data...), n.stat(), w, nil
+}
+
+func (c *embeddedConn) Children(p string) ([]string, zk.Stat, error) {
+	children, stat, _, err := c.children(p, false)
+	return children, stat, err
+}
+
+func (c *embeddedConn) ChildrenW(p string) ([]string, zk.Stat, <-chan zk.Event, error) {
+	return c.children(p, true)
+}
+
+func (c *embeddedConn) children(p string, watch bool) ([]string, zk.Stat, <-chan zk.Event, error) {
+	s, err := c.lock()
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	defer s.mu.Unlock()
+	n, err := s.lookup(p)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	var children = make([]string, 0, len(n.children))
+	for name := range n.children {
+		children = append(children, name)
+	}
+	sort.Strings(children)
+	var w <-chan zk.Event
+	if watch {
+		w = s.watch(s.childW, path.Clean(p), c.sid)
+	}
+	return children, n.stat(), w, nil
+}
+
+func (c *embeddedConn) Exists(p string) (bool, zk.Stat, error) {
+	exist, stat, _, err := c.exists(p, false)
+	return exist, stat, err
+}
+
+// 节点不存在时也可以设置 watch，等待节点被创建
+func (c *embeddedConn) ExistsW(p string) (bool, zk.Stat, <-chan zk.Event, error) {
+	return c.exists(p, true)
+}
+
+func (c *embeddedConn) exists(p string, watch bool) (bool, zk.Stat, <-chan zk.Event, error) {
+	s, err := c.lock()
+	if err != nil {
+		return false, nil, nil, err
+	}
+	defer s.mu.Unlock()
+	n, err := s.lookup(p)
+	if err != nil && err != zk.ErrNoNode {
+		return false, nil, nil, err
+	}
+	var w <-chan zk.Event
+	if watch {
+		w = s.watch(s.dataW, path.Clean(p), c.sid)
+	}
+	if n == nil {
+		return false, nil, w, nil
+	}
+	return true, n.stat(), w, nil
+}
+
+func (c *embeddedConn) Create(p string, value []byte, flags int32, aclv []zk.ACL) (string, error) {
+	s, err := c.lock()
+	if err != nil {
+		return "", err
+	}
+	defer s.mu.Unlock()
+	p, err = s.create(c.sid, p, value, flags)
+	if err != nil {
+		return "", err
+	}
+	return p, s.persist()
+}
+
+func (c *embeddedConn) Set(p string, value []byte, version int32) (zk.Stat, error) {
+	s, err := c.lock()
+	if err != nil {
+		return nil, err
+	}
+	defer s.mu.Unlock()
+	stat, err := s.set(p, value, version)
+	if err != nil {
+		return nil, err
+	}
+	return stat, s.persist()
+}
+
+func (c *embeddedConn) Delete(p string, version int32) error {
+	s, err := c.lock()
+	if err != nil {
+		return err
+	}
+	defer s.mu.Unlock()
+	if err := s.delete(p, version); err != nil {
+		return err
+	}
+	return s.persist()
+}
+
+func (c *embeddedConn) Close() {
+	s, err := c.lock()
+	if err != nil {
+		return
+	}
+	defer s.mu.Unlock()
+	c.closed = true
+	s.closeSession(c.sid)
+	s.persist()
+}
+
+func (c *embeddedConn) GetACL(p string) ([]zk.ACL, zk.Stat, error) {
+	s, err := c.lock()
+	if err != nil {
+		return nil, nil, err
+	}
+	defer s.mu.Unlock()
+	n, err := s.lookup(p)
+	if err != nil {
+		return nil, nil, err
+	}
+	return zkhelper.DefaultACLs(), n.stat(), nil
+}
+
+// 内嵌存储不检查权限
+func (c *embeddedConn) SetACL(p string, aclv []zk.ACL, version int32) (zk.Stat, error) {
+	s, err := c.lock()
+	if err != nil {
+		return nil, err
+	}
+	defer s.mu.Unlock()
+	n, err := s.lookup(p)
+	if err != nil {
+		return nil, err
+	}
+	return n.stat(), nil
+}
+
+func (c *embeddedConn) Seq2Str(seq int64) string {
+	return fmt.Sprintf("%0.10d", seq)
+}
@@ -0,0 +1,151 @@
+// Copyright 2016 CodisLabs. All Rights Reserved.
+// Licensed under the MIT (MIT-LICENSE.txt) license.
+
+package coordinator
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/wandoulabs/go-zookeeper/zk"
+	"github.com/wandoulabs/zkhelper"
+
+	"github.com/CodisLabs/codis/pkg/utils/assert"
+)
+
+func mustEvent(c <-chan zk.Event, t zk.EventType, p string) {
+	select {
+	case e := <-c:
+		assert.Must(e.Type == t && e.Path == p)
+	case <-time.After(time.Second):
+		assert.Must(false)
+	}
+}
+
+func mustNoEvent(c <-chan zk.Event) {
+	select {
+	case <-c:
+		assert.Must(false)
+	default:
+	}
+}
+
+func TestEmbedded(t *testing.T) {
+	s := NewStore()
+	c1, c2 := s.NewConn(), s.NewConn()
+
+	_, err := c1.Create("/a/b", nil, 0, zkhelper.DefaultFileACLs())
+	assert.Must(err == zk.ErrNoNode)
+	_, err = zkhelper.CreateRecursive(c1, "/a/b", "x", 0, zkhelper.DefaultFileACLs())
+	assert.MustNoError(err)
+	_, err = c2.Create("/a/b", nil, 0, zkhelper.DefaultFileACLs())
+	assert.Must(err == zk.ErrNodeExists)
+
+	data, stat, w1, err := c2.GetW("/a/b")
+	assert.MustNoError(err)
+	assert.Must(string(data) == "x" && stat.Version() == 0)
+	children, _, w2, err := c2.ChildrenW("/a")
+	assert.MustNoError(err)
+	assert.Must(len(children) == 1 && children[0] == "b")
+	exists, _, w3, err := c2.ExistsW("/a/c")
+	assert.MustNoError(err)
+	assert.Must(!exists)
+
+	_, err = c1.Set("/a/b", []byte("y"), 1)
+	assert.Must(err == zk.ErrBadVersion)
+	stat, err = c1.Set("/a/b", []byte("y"), 0)
+	assert.MustNoError(err)
+	assert.Must(stat.Version() == 1)
+	mustEvent(w1, zk.EventNodeDataChanged, "/a/b")
+	mustNoEvent(w2)
+
+	// 临时节点在会话关闭时删除，顺序节点的序号和 zookeeper 一样来自父节点的 cversion
+	p1, err := c1.Create("/a/c", nil, zk.FlagEphemeral, zkhelper.DefaultFileACLs())
+	assert.MustNoError(err)
+	assert.Must(p1 == "/a/c")
+	mustEvent(w3, zk.EventNodeCreated, "/a/c")
+	mustEvent(w2, zk.EventNodeChildrenChanged, "/a")
+	_, err = c1.Create("/a/c/d", nil, 0, zkhelper.DefaultFileACLs())
+	assert.Must(err == zk.ErrNoChildrenForEphemerals)
+
+	p2, err := c2.Create("/a/", nil, zk.FlagSequence|zk.FlagEphemeral, zkhelper.DefaultFileACLs())
+	assert.MustNoError(err)
+	assert.Must(p2 == "/a/"+c2.Seq2Str(2))
+
+	assert.Must(c1.Delete("/a", -1) == zk.ErrNotEmpty)
+
+	_, _, w4, err := c2.ExistsW("/a/c")
+	assert.MustNoError(err)
+	_, _, w5, err := c1.ChildrenW("/a")
+	assert.MustNoError(err)
+	c1.Close()
+	mustEvent(w4, zk.EventNodeDeleted, "/a/c")
+	mustNoEvent(w5)
+	_, _, err = c1.Get("/a/b")
+	assert.Must(err == zk.ErrConnectionClosed)
+
+	children, _, err = c2.Children("/a")
+	assert.MustNoError(err)
+	assert.Must(len(children) == 2 && children[0] == c2.Seq2Str(2) && children[1] == "b")
+
+	// 锁建立在顺序节点和临时节点之上
+	lock := zkhelper.CreateMutex(c2, "/lock")
+	assert.MustNoError(lock.LockWithTimeout(time.Second, "test"))
+	assert.MustNoError(lock.Unlock())
+
+	c2.Close()
+	children, _, err = s.NewConn().Children("/a")
+	assert.MustNoError(err)
+	assert.Must(len(children) == 1 && children[0] == "b")
+}
+
+func TestEmbeddedFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "coordinator")
+	assert.MustNoError(err)
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "store.json")
+
+	s, err := NewFileStore(file)
+	assert.MustNoError(err)
+	c := s.NewConn()
+	_, err = zkhelper.CreateRecursive(c, "/zk/codis/x", "data", 0, zkhelper.DefaultFileACLs())
+	assert.MustNoError(err)
+	_, err = c.Create("/zk/codis/", []byte("seq"), zk.FlagSequence, zkhelper.DefaultFileACLs())
+	assert.MustNoError(err)
+	_, err = c.Create("/zk/codis/eph", nil, zk.FlagEphemeral, zkhelper.DefaultFileACLs())
+	assert.MustNoError(err)
+
+	// 重新打开之后只剩下持久节点
+	s, err = NewFileStore(file)
+	assert.MustNoError(err)
+	c = s.NewConn()
+	children, _, err := c.Children("/zk/codis")
+	assert.MustNoError(err)
+	assert.Must(len(children) == 2 && children[0] == c.Seq2Str(1) && children[1] == "x")
+	data, _, err := c.Get("/zk/codis/x")
+	assert.MustNoError(err)
+	assert.Must(string(data) == "data")
+	p, err := c.Create("/zk/codis/", nil, zk.FlagSequence, zkhelper.DefaultFileACLs())
+	assert.MustNoError(err)
+	assert.Must(p == "/zk/codis/"+c.Seq2Str(3))
+}
+
+func TestProviders(t *testing.T) {
+	c1, err := NewConn("embedded", "test_providers", 0)
+	assert.MustNoError(err)
+	c2, err := NewConn("embedded", "test_providers", 0)
+	assert.MustNoError(err)
+	_, err = c1.Create("/x", nil, 0, zkhelper.DefaultFileACLs())
+	assert.MustNoError(err)
+	exists, _, err := c2.Exists("/x")
+	assert.MustNoError(err)
+	assert.Must(exists)
+
+	_, err = NewConn("unknown", "", 0)
+	assert.Must(err != nil)
+	_, ok := Lookup("zookeeper")
+	assert.Must(ok)
+}
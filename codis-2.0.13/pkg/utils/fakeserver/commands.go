@@ -0,0 +1,873 @@
+// Copyright 2016 CodisLabs. All Rights Reserved.
+// Licensed under the MIT (MIT-LICENSE.txt) license.
+
+package fakeserver
+
+import (
+	"fmt"
+	"net"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/CodisLabs/codis/pkg/proxy/redis"
+)
+
+type command struct {
+	f func(s *Server, opstr string, args [][]byte) *redis.Resp
+	// 包括命令名在内的参数个数，负数表示至少需要的个数
+	// 一个函数可以处理多个命令，opstr 是大写的命令名，args 不包括命令名
+	arity int
+}
+
+var commands map[string]command
+
+func init() {
+	commands = map[string]command{
+		"PING":    {cmdPing, -1},
+		"ECHO":    {cmdEcho, 2},
+		"SELECT":  {cmdSelect, 2},
+		"INFO":    {cmdInfo, -1},
+		"CONFIG":  {cmdConfig, -2},
+		"SLAVEOF": {cmdSlaveOf, 3},
+
+		"DEL":       {cmdDel, -2},
+		"UNLINK":    {cmdDel, -2},
+		"EXISTS":    {cmdExists, -2},
+		"TOUCH":     {cmdExists, -2},
+		"TYPE":      {cmdType, 2},
+		"EXPIRE":    {cmdExpire, 3},
+		"PEXPIRE":   {cmdExpire, 3},
+		"EXPIREAT":  {cmdExpire, 3},
+		"PEXPIREAT": {cmdExpire, 3},
+		"TTL":       {cmdTTL, 2},
+		"PTTL":      {cmdTTL, 2},
+		"PERSIST":   {cmdPersist, 2},
+		"KEYS":      {cmdKeys, 2},
+		"DBSIZE":    {cmdDBSize, 1},
+		"FLUSHDB":   {cmdFlush, 1},
+		"FLUSHALL":  {cmdFlush, 1},
+
+		"GET":    {cmdGet, 2},
+		"SET":    {cmdSet, -3},
+		"SETNX":  {cmdSet, 3},
+		"SETEX":  {cmdSet, 4},
+		"PSETEX": {cmdSet, 4},
+		"GETSET": {cmdGetSet, 3},
+		"MGET":   {cmdMGet, -2},
+		"MSET":   {cmdMSet, -3},
+		"MSETNX": {cmdMSet, -3},
+		"INCR":   {cmdIncr, 2},
+		"DECR":   {cmdIncr, 2},
+		"INCRBY": {cmdIncr, 3},
+		"DECRBY": {cmdIncr, 3},
+		"APPEND": {cmdAppend, 3},
+		"STRLEN": {cmdStrlen, 2},
+
+		"HSET":    {cmdHSet, -4},
+		"HMSET":   {cmdHSet, -4},
+		"HSETNX":  {cmdHSetNX, 4},
+		"HGET":    {cmdHGet, 3},
+		"HMGET":   {cmdHMGet, -3},
+		"HGETALL": {cmdHGetAll, 2},
+		"HKEYS":   {cmdHGetAll, 2},
+		"HVALS":   {cmdHGetAll, 2},
+		"HDEL":    {cmdHDel, -3},
+		"HEXISTS": {cmdHExists, 3},
+		"HLEN":    {cmdHLen, 2},
+		"HINCRBY": {cmdHIncrBy, 4},
+
+		"LPUSH":  {cmdPush, -3},
+		"RPUSH":  {cmdPush, -3},
+		"LPOP":   {cmdPop, 2},
+		"RPOP":   {cmdPop, 2},
+		"LLEN":   {cmdLLen, 2},
+		"LRANGE": {cmdLRange, 4},
+		"LINDEX": {cmdLIndex, 3},
+
+		"SADD":      {cmdSAdd, -3},
+		"SREM":      {cmdSRem, -3},
+		"SMEMBERS":  {cmdSMembers, 2},
+		"SISMEMBER": {cmdSIsMember, 3},
+		"SCARD":     {cmdSCard, 2},
+		"SINTER":    {cmdSetOp, -2},
+		"SUNION":    {cmdSetOp, -2},
+		"SDIFF":     {cmdSetOp, -2},
+
+		"SLOTSINFO":        {cmdSlotsInfo, -1},
+		"SLOTSHASHKEY":     {cmdSlotsHashKey, -1},
+		"SLOTSMGRTSLOT":    {cmdSlotsMgrtSlot, 5},
+		"SLOTSMGRTTAGSLOT": {cmdSlotsMgrtSlot, 5},
+		"SLOTSMGRTONE":     {cmdSlotsMgrtOne, 5},
+		"SLOTSMGRTTAGONE":  {cmdSlotsMgrtOne, 5},
+	}
+}
+
+var errWrongType = errorf("WRONGTYPE Operation against a key holding the wrong kind of value")
+
+func ok() *redis.Resp {
+	return redis.NewString([]byte("OK"))
+}
+
+func errorf(format string, args ...interface{}) *redis.Resp {
+	return redis.NewError([]byte(fmt.Sprintf(format, args...)))
+}
+
+func errArgs(opstr string) *redis.Resp {
+	return errorf("ERR wrong number of arguments for '%s' command", strings.ToLower(opstr))
+}
+
+func integer(n int64) *redis.Resp {
+	return redis.NewInt([]byte(strconv.FormatInt(n, 10)))
+}
+
+func bulk(b []byte) *redis.Resp {
+	return redis.NewBulkBytes(b)
+}
+
+// 元素为 nil 时返回 null
+func array(values [][]byte) *redis.Resp {
+	var a = make([]*redis.Resp, len(values))
+	for i, v := range values {
+		a[i] = bulk(v)
+	}
+	return redis.NewArray(a)
+}
+
+func parseInt(b []byte) (int64, *redis.Resp) {
+	n, err := strconv.ParseInt(string(b), 10, 64)
+	if err != nil {
+		return 0, errorf("ERR value is not an integer or out of range")
+	}
+	return n, nil
+}
+
+// 返回指定类型的 value，key 不存在时返回 nil，类型不对时返回错误
+func (s *Server) lookup(key []byte, k kind) (*value, *redis.Resp) {
+	v := s.ks.lookup(string(key))
+	if v == nil {
+		return nil, nil
+	}
+	if v.kind != k {
+		return nil, errWrongType
+	}
+	return v, nil
+}
+
+// 和 lookup 一样，key 不存在时创建一个新的 value
+func (s *Server) lookupOrCreate(key []byte, k kind) (*value, *redis.Resp) {
+	v, e := s.lookup(key, k)
+	if e != nil {
+		return nil, e
+	}
+	if v == nil {
+		v = newValue(k)
+		s.ks.put(string(key), v)
+	}
+	return v, nil
+}
+
+// 集合类型删除元素之后可能变成空的
+func (s *Server) removeIfEmpty(key []byte, v *value) {
+	if v.empty() {
+		s.ks.remove(string(key))
+	}
+}
+
+func cmdPing(s *Server, opstr string, args [][]byte) *redis.Resp {
+	if len(args) != 0 {
+		return bulk(args[0])
+	}
+	return redis.NewString([]byte("PONG"))
+}
+
+func cmdEcho(s *Server, opstr string, args [][]byte) *redis.Resp {
+	return bulk(args[0])
+}
+
+// 只有一个 db
+func cmdSelect(s *Server, opstr string, args [][]byte) *redis.Resp {
+	if string(args[0]) != "0" {
+		return errorf("ERR invalid DB index")
+	}
+	return ok()
+}
+
+func cmdInfo(s *Server, opstr string, args [][]byte) *redis.Resp {
+	return bulk([]byte(s.info()))
+}
+
+// CONFIG GET 返回默认值，CONFIG SET 不做任何事情
+func cmdConfig(s *Server, opstr string, args [][]byte) *redis.Resp {
+	switch strings.ToUpper(string(args[0])) {
+	case "GET":
+		if len(args) != 2 {
+			return errArgs("config")
+		}
+		name := strings.ToLower(string(args[1]))
+		switch name {
+		case "maxmemory", "databases":
+			v := "0"
+			if name == "databases" {
+				v = "1"
+			}
+			return array([][]byte{[]byte(name), []byte(v)})
+		}
+		return array(nil)
+	case "SET":
+		if len(args) != 3 {
+			return errArgs("config")
+		}
+		return ok()
+	}
+	return errorf("ERR CONFIG subcommand must be one of GET, SET")
+}
+
+func cmdSlaveOf(s *Server, opstr string, args [][]byte) *redis.Resp {
+	if strings.ToUpper(string(args[0])) == "NO" && strings.ToUpper(string(args[1])) == "ONE" {
+		s.master = ""
+		return ok()
+	}
+	if _, err := strconv.Atoi(string(args[1])); err != nil {
+		return errorf("ERR invalid master port")
+	}
+	s.master = net.JoinHostPort(string(args[0]), string(args[1]))
+	return ok()
+}
+
+func cmdDel(s *Server, opstr string, args [][]byte) *redis.Resp {
+	var n int64
+	for _, key := range args {
+		if s.ks.lookup(string(key)) != nil && s.ks.remove(string(key)) {
+			n++
+		}
+	}
+	return integer(n)
+}
+
+func cmdExists(s *Server, opstr string, args [][]byte) *redis.Resp {
+	var n int64
+	for _, key := range args {
+		if s.ks.lookup(string(key)) != nil {
+			n++
+		}
+	}
+	return integer(n)
+}
+
+func cmdType(s *Server, opstr string, args [][]byte) *redis.Resp {
+	v := s.ks.lookup(string(args[0]))
+	if v == nil {
+		return redis.NewString([]byte("none"))
+	}
+	return redis.NewString([]byte(v.kind.String()))
+}
+
+func cmdExpire(s *Server, opstr string, args [][]byte) *redis.Resp {
+	n, e := parseInt(args[1])
+	if e != nil {
+		return e
+	}
+	v := s.ks.lookup(string(args[0]))
+	if v == nil {
+		return integer(0)
+	}
+	if opstr == "EXPIRE" || opstr == "EXPIREAT" {
+		n *= 1000
+	}
+	if opstr == "EXPIRE" || opstr == "PEXPIRE" {
+		n += nowMs()
+	}
+	// 过期时间已经过去，直接删除
+	if n <= nowMs() {
+		s.ks.remove(string(args[0]))
+	} else {
+		v.expireAt = n
+	}
+	return integer(1)
+}
+
+func cmdTTL(s *Server, opstr string, args [][]byte) *redis.Resp {
+	v := s.ks.lookup(string(args[0]))
+	switch {
+	case v == nil:
+		return integer(-2)
+	case v.expireAt == 0:
+		return integer(-1)
+	}
+	ms := v.expireAt - nowMs()
+	if opstr == "TTL" {
+		return integer((ms + 500) / 1000)
+	}
+	return integer(ms)
+}
+
+func cmdPersist(s *Server, opstr string, args [][]byte) *redis.Resp {
+	v := s.ks.lookup(string(args[0]))
+	if v == nil || v.expireAt == 0 {
+		return integer(0)
+	}
+	v.expireAt = 0
+	return integer(1)
+}
+
+func cmdKeys(s *Server, opstr string, args [][]byte) *redis.Resp {
+	var keys [][]byte
+	for _, key := range s.ks.allKeys() {
+		if ok, _ := path.Match(string(args[0]), key); ok {
+			keys = append(keys, []byte(key))
+		}
+	}
+	return array(keys)
+}
+
+func cmdDBSize(s *Server, opstr string, args [][]byte) *redis.Resp {
+	return integer(int64(len(s.ks.allKeys())))
+}
+
+func cmdFlush(s *Server, opstr string, args [][]byte) *redis.Resp {
+	s.ks = newKeyspace()
+	return ok()
+}
+
+func cmdGet(s *Server, opstr string, args [][]byte) *redis.Resp {
+	v, e := s.lookup(args[0], kindString)
+	if e != nil {
+		return e
+	}
+	if v == nil {
+		return bulk(nil)
+	}
+	return bulk(v.str)
+}
+
+func cmdSet(s *Server, opstr string, args [][]byte) *redis.Resp {
+	var key, val = args[0], args[1]
+	var expireAt int64
+	var nx, xx bool
+	switch opstr {
+	case "SETNX":
+		nx = true
+	case "SETEX", "PSETEX":
+		n, e := parseInt(args[1])
+		if e != nil {
+			return e
+		}
+		if n <= 0 {
+			return errorf("ERR invalid expire time in %s", strings.ToLower(opstr))
+		}
+		if opstr == "SETEX" {
+			n *= 1000
+		}
+		val, expireAt = args[2], nowMs()+n
+	default:
+		for i := 2; i < len(args); i++ {
+			switch o := strings.ToUpper(string(args[i])); {
+			case o == "NX":
+				nx = true
+			case o == "XX":
+				xx = true
+			case (o == "EX" || o == "PX") && i+1 < len(args):
+				i++
+				n, e := parseInt(args[i])
+				if e != nil {
+					return e
+				}
+				if n <= 0 {
+					return errorf("ERR invalid expire time in set")
+				}
+				if o == "EX" {
+					n *= 1000
+				}
+				expireAt = nowMs() + n
+			default:
+				return errorf("ERR syntax error")
+			}
+		}
+	}
+	exists := s.ks.lookup(string(key)) != nil
+	if (nx && exists) || (xx && !exists) {
+		if opstr == "SETNX" {
+			return integer(0)
+		}
+		return bulk(nil)
+	}
+	v := newValue(kindString)
+	v.str, v.expireAt = val, expireAt
+	s.ks.put(string(key), v)
+	if opstr == "SETNX" {
+		return integer(1)
+	}
+	return ok()
+}
+
+func cmdGetSet(s *Server, opstr string, args [][]byte) *redis.Resp {
+	v, e := s.lookup(args[0], kindString)
+	if e != nil {
+		return e
+	}
+	var old []byte
+	if v != nil {
+		old = v.str
+	}
+	n := newValue(kindString)
+	n.str = args[1]
+	s.ks.put(string(args[0]), n)
+	return bulk(old)
+}
+
+func cmdMGet(s *Server, opstr string, args [][]byte) *redis.Resp {
+	var values = make([][]byte, len(args))
+	for i, key := range args {
+		if v := s.ks.lookup(string(key)); v != nil && v.kind == kindString {
+			values[i] = v.str
+		}
+	}
+	return array(values)
+}
+
+func cmdMSet(s *Server, opstr string, args [][]byte) *redis.Resp {
+	if len(args)%2 != 0 {
+		return errArgs(opstr)
+	}
+	if opstr == "MSETNX" {
+		for i := 0; i < len(args); i += 2 {
+			if s.ks.lookup(string(args[i])) != nil {
+				return integer(0)
+			}
+		}
+	}
+	for i := 0; i < len(args); i += 2 {
+		v := newValue(kindString)
+		v.str = args[i+1]
+		s.ks.put(string(args[i]), v)
+	}
+	if opstr == "MSETNX" {
+		return integer(1)
+	}
+	return ok()
+}
+
+func cmdIncr(s *Server, opstr string, args [][]byte) *redis.Resp {
+	var delta int64 = 1
+	if len(args) == 2 {
+		n, e := parseInt(args[1])
+		if e != nil {
+			return e
+		}
+		delta = n
+	}
+	if opstr == "DECR" || opstr == "DECRBY" {
+		delta = -delta
+	}
+	v, e := s.lookup(args[0], kindString)
+	if e != nil {
+		return e
+	}
+	var n int64
+	if v != nil {
+		if n, e = parseInt(v.str); e != nil {
+			return e
+		}
+	} else {
+		v = newValue(kindString)
+		s.ks.put(string(args[0]), v)
+	}
+	n += delta
+	v.str = []byte(strconv.FormatInt(n, 10))
+	return integer(n)
+}
+
+func cmdAppend(s *Server, opstr string, args [][]byte) *redis.Resp {
+	v, e := s.lookupOrCreate(args[0], kindString)
+	if e != nil {
+		return e
+	}
+	v.str = append(append([]byte{}, v.str...), args[1]...)
+	return integer(int64(len(v.str)))
+}
+
+func cmdStrlen(s *Server, opstr string, args [][]byte) *redis.Resp {
+	v, e := s.lookup(args[0], kindString)
+	if e != nil {
+		return e
+	}
+	if v == nil {
+		return integer(0)
+	}
+	return integer(int64(len(v.str)))
+}
+
+func cmdHSet(s *Server, opstr string, args [][]byte) *redis.Resp {
+	if len(args)%2 != 1 {
+		return errArgs(opstr)
+	}
+	v, e := s.lookupOrCreate(args[0], kindHash)
+	if e != nil {
+		return e
+	}
+	var n int64
+	for i := 1; i < len(args); i += 2 {
+		if _, ok := v.hash[string(args[i])]; !ok {
+			n++
+		}
+		v.hash[string(args[i])] = args[i+1]
+	}
+	if opstr == "HMSET" {
+		return ok()
+	}
+	return integer(n)
+}
+
+func cmdHSetNX(s *Server, opstr string, args [][]byte) *redis.Resp {
+	v, e := s.lookupOrCreate(args[0], kindHash)
+	if e != nil {
+		return e
+	}
+	if _, ok := v.hash[string(args[1])]; ok {
+		return integer(0)
+	}
+	v.hash[string(args[1])] = args[2]
+	return integer(1)
+}
+
+func cmdHGet(s *Server, opstr string, args [][]byte) *redis.Resp {
+	v, e := s.lookup(args[0], kindHash)
+	if e != nil {
+		return e
+	}
+	if v == nil {
+		return bulk(nil)
+	}
+	return bulk(v.hash[string(args[1])])
+}
+
+func cmdHMGet(s *Server, opstr string, args [][]byte) *redis.Resp {
+	v, e := s.lookup(args[0], kindHash)
+	if e != nil {
+		return e
+	}
+	var values = make([][]byte, len(args)-1)
+	if v != nil {
+		for i, f := range args[1:] {
+			values[i] = v.hash[string(f)]
+		}
+	}
+	return array(values)
+}
+
+// 按照 field 的字典序返回
+func sortedFields(v *value) []string {
+	var fields []string
+	for f := range v.hash {
+		fields = append(fields, f)
+	}
+	sort.Strings(fields)
+	return fields
+}
+
+func cmdHGetAll(s *Server, opstr string, args [][]byte) *redis.Resp {
+	v, e := s.lookup(args[0], kindHash)
+	if e != nil {
+		return e
+	}
+	if v == nil {
+		return array(nil)
+	}
+	var values [][]byte
+	for _, f := range sortedFields(v) {
+		switch opstr {
+		case "HGETALL":
+			values = append(values, []byte(f), v.hash[f])
+		case "HKEYS":
+			values = append(values, []byte(f))
+		case "HVALS":
+			values = append(values, v.hash[f])
+		}
+	}
+	return array(values)
+}
+
+func cmdHDel(s *Server, opstr string, args [][]byte) *redis.Resp {
+	v, e := s.lookup(args[0], kindHash)
+	if e != nil || v == nil {
+		if e != nil {
+			return e
+		}
+		return integer(0)
+	}
+	var n int64
+	for _, f := range args[1:] {
+		if _, ok := v.hash[string(f)]; ok {
+			delete(v.hash, string(f))
+			n++
+		}
+	}
+	s.removeIfEmpty(args[0], v)
+	return integer(n)
+}
+
+func cmdHExists(s *Server, opstr string, args [][]byte) *redis.Resp {
+	v, e := s.lookup(args[0], kindHash)
+	if e != nil {
+		return e
+	}
+	if v == nil {
+		return integer(0)
+	}
+	if _, ok := v.hash[string(args[1])]; ok {
+		return integer(1)
+	}
+	return integer(0)
+}
+
+func cmdHLen(s *Server, opstr string, args [][]byte) *redis.Resp {
+	v, e := s.lookup(args[0], kindHash)
+	if e != nil {
+		return e
+	}
+	if v == nil {
+		return integer(0)
+	}
+	return integer(int64(len(v.hash)))
+}
+
+func cmdHIncrBy(s *Server, opstr string, args [][]byte) *redis.Resp {
+	delta, e := parseInt(args[2])
+	if e != nil {
+		return e
+	}
+	v, e := s.lookupOrCreate(args[0], kindHash)
+	if e != nil {
+		return e
+	}
+	var n int64
+	if old, ok := v.hash[string(args[1])]; ok {
+		if n, e = parseInt(old); e != nil {
+			s.removeIfEmpty(args[0], v)
+			return errorf("ERR hash value is not an integer")
+		}
+	}
+	n += delta
+	v.hash[string(args[1])] = []byte(strconv.FormatInt(n, 10))
+	return integer(n)
+}
+
+func cmdPush(s *Server, opstr string, args [][]byte) *redis.Resp {
+	v, e := s.lookupOrCreate(args[0], kindList)
+	if e != nil {
+		return e
+	}
+	for _, x := range args[1:] {
+		if opstr == "LPUSH" {
+			v.list = append([][]byte{x}, v.list...)
+		} else {
+			v.list = append(v.list, x)
+		}
+	}
+	return integer(int64(len(v.list)))
+}
+
+func cmdPop(s *Server, opstr string, args [][]byte) *redis.Resp {
+	v, e := s.lookup(args[0], kindList)
+	if e != nil {
+		return e
+	}
+	if v == nil {
+		return bulk(nil)
+	}
+	var x []byte
+	if opstr == "LPOP" {
+		x, v.list = v.list[0], v.list[1:]
+	} else {
+		x, v.list = v.list[len(v.list)-1], v.list[:len(v.list)-1]
+	}
+	s.removeIfEmpty(args[0], v)
+	return bulk(x)
+}
+
+func cmdLLen(s *Server, opstr string, args [][]byte) *redis.Resp {
+	v, e := s.lookup(args[0], kindList)
+	if e != nil {
+		return e
+	}
+	if v == nil {
+		return integer(0)
+	}
+	return integer(int64(len(v.list)))
+}
+
+// 把 redis 风格的下标（负数从尾部开始）转换成 [0, n) 之间的下标
+func listIndex(i, n int64) int64 {
+	if i < 0 {
+		i += n
+	}
+	return i
+}
+
+func cmdLRange(s *Server, opstr string, args [][]byte) *redis.Resp {
+	start, e := parseInt(args[1])
+	if e != nil {
+		return e
+	}
+	stop, e := parseInt(args[2])
+	if e != nil {
+		return e
+	}
+	v, e := s.lookup(args[0], kindList)
+	if e != nil {
+		return e
+	}
+	if v == nil {
+		return array(nil)
+	}
+	n := int64(len(v.list))
+	start, stop = listIndex(start, n), listIndex(stop, n)
+	if start < 0 {
+		start = 0
+	}
+	if stop >= n {
+		stop = n - 1
+	}
+	if start > stop {
+		return array(nil)
+	}
+	return array(v.list[start : stop+1])
+}
+
+func cmdLIndex(s *Server, opstr string, args [][]byte) *redis.Resp {
+	i, e := parseInt(args[1])
+	if e != nil {
+		return e
+	}
+	v, e := s.lookup(args[0], kindList)
+	if e != nil {
+		return e
+	}
+	if v == nil {
+		return bulk(nil)
+	}
+	i = listIndex(i, int64(len(v.list)))
+	if i < 0 || i >= int64(len(v.list)) {
+		return bulk(nil)
+	}
+	return bulk(v.list[i])
+}
+
+func cmdSAdd(s *Server, opstr string, args [][]byte) *redis.Resp {
+	v, e := s.lookupOrCreate(args[0], kindSet)
+	if e != nil {
+		return e
+	}
+	var n int64
+	for _, m := range args[1:] {
+		if !v.set[string(m)] {
+			v.set[string(m)] = true
+			n++
+		}
+	}
+	return integer(n)
+}
+
+func cmdSRem(s *Server, opstr string, args [][]byte) *redis.Resp {
+	v, e := s.lookup(args[0], kindSet)
+	if e != nil {
+		return e
+	}
+	if v == nil {
+		return integer(0)
+	}
+	var n int64
+	for _, m := range args[1:] {
+		if v.set[string(m)] {
+			delete(v.set, string(m))
+			n++
+		}
+	}
+	s.removeIfEmpty(args[0], v)
+	return integer(n)
+}
+
+// 按照字典序返回集合的元素
+func sortedMembers(set map[string]bool) [][]byte {
+	var members []string
+	for m := range set {
+		members = append(members, m)
+	}
+	sort.Strings(members)
+	var values = make([][]byte, len(members))
+	for i, m := range members {
+		values[i] = []byte(m)
+	}
+	return values
+}
+
+func cmdSMembers(s *Server, opstr string, args [][]byte) *redis.Resp {
+	v, e := s.lookup(args[0], kindSet)
+	if e != nil {
+		return e
+	}
+	if v == nil {
+		return array(nil)
+	}
+	return array(sortedMembers(v.set))
+}
+
+func cmdSIsMember(s *Server, opstr string, args [][]byte) *redis.Resp {
+	v, e := s.lookup(args[0], kindSet)
+	if e != nil {
+		return e
+	}
+	if v != nil && v.set[string(args[1])] {
+		return integer(1)
+	}
+	return integer(0)
+}
+
+func cmdSCard(s *Server, opstr string, args [][]byte) *redis.Resp {
+	v, e := s.lookup(args[0], kindSet)
+	if e != nil {
+		return e
+	}
+	if v == nil {
+		return integer(0)
+	}
+	return integer(int64(len(v.set)))
+}
+
+func cmdSetOp(s *Server, opstr string, args [][]byte) *redis.Resp {
+	var sets = make([]map[string]bool, len(args))
+	for i, key := range args {
+		v, e := s.lookup(key, kindSet)
+		if e != nil {
+			return e
+		}
+		if v != nil {
+			sets[i] = v.set
+		}
+	}
+	var result = make(map[string]bool)
+	switch opstr {
+	case "SUNION":
+		for _, set := range sets {
+			for m := range set {
+				result[m] = true
+			}
+		}
+	case "SINTER", "SDIFF":
+		for m := range sets[0] {
+			var in = true
+			for _, set := range sets[1:] {
+				if set[m] != (opstr == "SINTER") {
+					in = false
+					break
+				}
+			}
+			if in {
+				result[m] = true
+			}
+		}
+	}
+	return array(sortedMembers(result))
+}
@@ -0,0 +1,155 @@
+// Copyright 2016 CodisLabs. All Rights Reserved.
+// Licensed under the MIT (MIT-LICENSE.txt) license.
+
+package fakeserver
+
+import (
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis"
+	"github.com/garyburd/redigo/redis"
+
+	"github.com/CodisLabs/codis/pkg/proxy/router"
+	"github.com/CodisLabs/codis/pkg/utils"
+	"github.com/CodisLabs/codis/pkg/utils/assert"
+)
+
+func mustRun() *Server {
+	s, err := Run()
+	assert.MustNoError(err)
+	return s
+}
+
+func TestCommands(t *testing.T) {
+	s := mustRun()
+	defer s.Close()
+	s.RequireAuth("secret")
+
+	_, err := utils.DialTo(s.Addr(), "bad")
+	assert.Must(err != nil)
+	c, err := utils.DialTo(s.Addr(), "secret")
+	assert.MustNoError(err)
+	defer c.Close()
+
+	v, err := redis.String(c.Do("SET", "a", "1", "EX", 100))
+	assert.Must(err == nil && v == "OK")
+	n, err := redis.Int(c.Do("INCRBY", "a", 9))
+	assert.Must(err == nil && n == 10)
+	n, err = redis.Int(c.Do("TTL", "a"))
+	assert.Must(err == nil && n == 100)
+	_, err = redis.String(c.Do("SET", "a", "x", "NX"))
+	assert.Must(err == redis.ErrNil)
+	v, err = redis.String(c.Do("GET", "a"))
+	assert.Must(err == nil && v == "10")
+
+	n, err = redis.Int(c.Do("HSET", "h", "f1", "v1", "f2", "v2"))
+	assert.Must(err == nil && n == 2)
+	m, err := redis.StringMap(c.Do("HGETALL", "h"))
+	assert.Must(err == nil && len(m) == 2 && m["f2"] == "v2")
+	_, err = c.Do("GET", "h")
+	assert.Must(err != nil)
+
+	n, err = redis.Int(c.Do("RPUSH", "l", "b", "c"))
+	assert.Must(err == nil && n == 2)
+	n, err = redis.Int(c.Do("LPUSH", "l", "a"))
+	assert.Must(err == nil && n == 3)
+	l, err := redis.Strings(c.Do("LRANGE", "l", 1, -1))
+	assert.Must(err == nil && len(l) == 2 && l[0] == "b" && l[1] == "c")
+
+	n, err = redis.Int(c.Do("SADD", "s1", "x", "y", "x"))
+	assert.Must(err == nil && n == 2)
+	_, err = c.Do("SADD", "s2", "y", "z")
+	assert.MustNoError(err)
+	l, err = redis.Strings(c.Do("SINTER", "s1", "s2"))
+	assert.Must(err == nil && len(l) == 1 && l[0] == "y")
+	l, err = redis.Strings(c.Do("SDIFF", "s1", "s2"))
+	assert.Must(err == nil && len(l) == 1 && l[0] == "x")
+
+	// 集合的元素删光之后 key 也被删除
+	n, err = redis.Int(c.Do("SREM", "s2", "y", "z"))
+	assert.Must(err == nil && n == 2)
+	assert.Must(!s.Exists("s2"))
+
+	_, err = c.Do("PEXPIRE", "l", 50)
+	assert.MustNoError(err)
+	time.Sleep(time.Millisecond * 100)
+	n, err = redis.Int(c.Do("EXISTS", "l", "h"))
+	assert.Must(err == nil && n == 1)
+
+	slots, err := redis.Ints(c.Do("SLOTSHASHKEY", "a", "{a}b"))
+	assert.Must(err == nil && len(slots) == 2)
+	assert.Must(slots[0] == router.HashSlot([]byte("a")) && slots[1] == slots[0])
+}
+
+func TestSlotsMigrate(t *testing.T) {
+	s1, s2 := mustRun(), mustRun()
+	defer s1.Close()
+	defer s2.Close()
+
+	c, err := utils.DialTo(s1.Addr(), "")
+	assert.MustNoError(err)
+	defer c.Close()
+	for _, cmd := range [][]interface{}{
+		{"SET", "{t}str", "v", "PX", 100000},
+		{"RPUSH", "{t}list", "a", "b"},
+		{"SADD", "{t}set", "a", "b"},
+		{"HMSET", "{t}hash", "f", "v"},
+		{"SET", "t", "in the same slot without tag"},
+		{"SET", "other", "v"},
+	} {
+		_, err := c.Do(cmd[0].(string), cmd[1:]...)
+		assert.MustNoError(err)
+	}
+	slot := router.HashSlot([]byte("t"))
+	assert.Must(router.HashSlot([]byte("{t}str")) == slot)
+
+	infos, err := utils.SlotsInfo(s1.Addr(), "", slot, slot)
+	assert.Must(err == nil && len(infos) == 1 && infos[slot] == 5)
+
+	// 按照字典序先迁移没有 tag 的 key，带 tag 的 key 一起迁移
+	succ, remain, err := utils.SlotsMgrtTagSlot(c, slot, s2.Addr())
+	assert.MustNoError(err)
+	assert.Must(succ == 1 && remain == 4)
+	succ, remain, err = utils.SlotsMgrtTagSlot(c, slot, s2.Addr())
+	assert.Must(err == nil && succ == 4 && remain == 0)
+	succ, remain, err = utils.SlotsMgrtTagSlot(c, slot, s2.Addr())
+	assert.Must(err == nil && succ == 0 && remain == 0)
+
+	assert.Must(len(s1.SlotKeys(slot)) == 0 && len(s2.SlotKeys(slot)) == 5)
+	assert.Must(len(s1.Keys()) == 1)
+
+	d, err := utils.DialTo(s2.Addr(), "")
+	assert.MustNoError(err)
+	defer d.Close()
+	ttl, err := redis.Int(d.Do("PTTL", "{t}str"))
+	assert.Must(err == nil && ttl > 0 && ttl <= 100000)
+	l, err := redis.Strings(d.Do("LRANGE", "{t}list", 0, -1))
+	assert.Must(err == nil && len(l) == 2 && l[0] == "a")
+	v, err := redis.String(d.Do("HGET", "{t}hash", "f"))
+	assert.Must(err == nil && v == "v")
+}
+
+// 迁移的目标可以是普通的 redis
+func TestSlotsMgrtToRedis(t *testing.T) {
+	s := mustRun()
+	defer s.Close()
+	r, err := miniredis.Run()
+	assert.MustNoError(err)
+	defer r.Close()
+
+	s.Set("{k}1", "a")
+	s.Set("{k}2", "b")
+	c, err := utils.DialTo(s.Addr(), "")
+	assert.MustNoError(err)
+	defer c.Close()
+
+	host, port := r.Host(), r.Port()
+	n, err := redis.Int(c.Do("SLOTSMGRTTAGONE", host, port, 3000, "{k}1"))
+	assert.Must(err == nil && n == 2)
+	n, err = redis.Int(c.Do("SLOTSMGRTTAGONE", host, port, 3000, "{k}1"))
+	assert.Must(err == nil && n == 0)
+	v, err := r.Get("{k}2")
+	assert.Must(err == nil && v == "b")
+	assert.Must(len(s.Keys()) == 0)
+}
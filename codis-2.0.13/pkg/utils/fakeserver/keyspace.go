@@ -0,0 +1,163 @@
+// Copyright 2016 CodisLabs. All Rights Reserved.
+// Licensed under the MIT (MIT-LICENSE.txt) license.
+
+package fakeserver
+
+import (
+	"bytes"
+	"sort"
+	"time"
+
+	"github.com/CodisLabs/codis/pkg/proxy/router"
+)
+
+type kind int
+
+const (
+	kindString kind = iota
+	kindList
+	kindSet
+	kindHash
+)
+
+func (k kind) String() string {
+	switch k {
+	case kindString:
+		return "string"
+	case kindList:
+		return "list"
+	case kindSet:
+		return "set"
+	case kindHash:
+		return "hash"
+	default:
+		return "none"
+	}
+}
+
+type value struct {
+	kind kind
+
+	str  []byte
+	list [][]byte
+	set  map[string]bool
+	hash map[string][]byte
+
+	expireAt int64 // 过期的时间，单位是毫秒，0 表示没有设置过期时间
+}
+
+func newValue(k kind) *value {
+	v := &value{kind: k}
+	switch k {
+	case kindSet:
+		v.set = make(map[string]bool)
+	case kindHash:
+		v.hash = make(map[string][]byte)
+	}
+	return v
+}
+
+// 集合类型的元素为空时 key 会被删除，和 redis 一样
+func (v *value) empty() bool {
+	switch v.kind {
+	case kindList:
+		return len(v.list) == 0
+	case kindSet:
+		return len(v.set) == 0
+	case kindHash:
+		return len(v.hash) == 0
+	}
+	return false
+}
+
+func nowMs() int64 {
+	return time.Now().UnixNano() / int64(time.Millisecond)
+}
+
+// 所有的 key，同时按照 slot 建立索引，用于 SLOTSINFO 和迁移
+type keyspace struct {
+	keys  map[string]*value
+	slots [router.MaxSlotNum]map[string]bool
+}
+
+func newKeyspace() *keyspace {
+	return &keyspace{keys: make(map[string]*value)}
+}
+
+// 过期的 key 在访问时删除
+func (ks *keyspace) lookup(key string) *value {
+	v := ks.keys[key]
+	if v != nil && v.expireAt != 0 && v.expireAt <= nowMs() {
+		ks.remove(key)
+		return nil
+	}
+	return v
+}
+
+func (ks *keyspace) put(key string, v *value) {
+	if _, ok := ks.keys[key]; !ok {
+		i := router.HashSlot([]byte(key))
+		if ks.slots[i] == nil {
+			ks.slots[i] = make(map[string]bool)
+		}
+		ks.slots[i][key] = true
+	}
+	ks.keys[key] = v
+}
+
+func (ks *keyspace) remove(key string) bool {
+	if _, ok := ks.keys[key]; !ok {
+		return false
+	}
+	delete(ks.keys, key)
+	delete(ks.slots[router.HashSlot([]byte(key))], key)
+	return true
+}
+
+// 指定 slot 中没有过期的 key，按照字典序排列
+func (ks *keyspace) slotKeys(i int) []string {
+	var keys []string
+	for key := range ks.slots[i] {
+		if ks.lookup(key) != nil {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func (ks *keyspace) allKeys() []string {
+	var keys []string
+	for key := range ks.keys {
+		if ks.lookup(key) != nil {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// key 中 {} 之间的部分，没有 hash tag 时返回 nil
+func hashTag(key []byte) []byte {
+	if beg := bytes.IndexByte(key, '{'); beg >= 0 {
+		if end := bytes.IndexByte(key[beg+1:], '}'); end >= 0 {
+			return key[beg+1 : beg+1+end]
+		}
+	}
+	return nil
+}
+
+// 和 key 在同一个 slot 中并且 hash tag 相同的 key，包括 key 本身
+func (ks *keyspace) tagKeys(key string) []string {
+	tag := hashTag([]byte(key))
+	if tag == nil {
+		return []string{key}
+	}
+	var keys []string
+	for _, k := range ks.slotKeys(router.HashSlot([]byte(key))) {
+		if t := hashTag([]byte(k)); t != nil && bytes.Equal(t, tag) {
+			keys = append(keys, k)
+		}
+	}
+	return keys
+}
@@ -0,0 +1,291 @@
+// Copyright 2016 CodisLabs. All Rights Reserved.
+// Licensed under the MIT (MIT-LICENSE.txt) license.
+
+// 模拟 codis-server，用于在一个测试进程中启动整个集群
+// 数据保存在内存中，支持 string、hash、list、set、过期时间，以及 SLOTS* 命令
+// 迁移时用普通的写命令在目标上重建 key，所以目标可以是另一个 fakeserver，也可以是任意 redis
+package fakeserver
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/CodisLabs/codis/pkg/proxy/redis"
+	"github.com/CodisLabs/codis/pkg/utils/errors"
+	"github.com/CodisLabs/codis/pkg/utils/log"
+)
+
+type Server struct {
+	mu sync.Mutex
+
+	l      net.Listener
+	ks     *keyspace
+	passwd string
+	master string // SLAVEOF 设置的 master，为空表示自己是 master
+
+	conns  map[*redis.Conn]bool
+	mgrt   map[string]*redis.Conn // 迁移时和目标之间的连接
+	closed bool
+	wg     sync.WaitGroup
+}
+
+func NewServer() *Server {
+	return &Server{
+		ks:    newKeyspace(),
+		conns: make(map[*redis.Conn]bool),
+		mgrt:  make(map[string]*redis.Conn),
+	}
+}
+
+// 在随机端口上启动一个 server
+func Run() (*Server, error) {
+	s := NewServer()
+	if err := s.Start("127.0.0.1:0"); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *Server) Start(addr string) error {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	s.mu.Lock()
+	s.l = l
+	s.mu.Unlock()
+
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			s.wg.Add(1)
+			go func() {
+				defer s.wg.Done()
+				s.serve(c)
+			}()
+		}
+	}()
+	return nil
+}
+
+func (s *Server) Addr() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.l == nil {
+		return ""
+	}
+	return s.l.Addr().String()
+}
+
+// 关闭监听和所有连接，内存中的数据保留
+func (s *Server) Close() {
+	s.mu.Lock()
+	s.closed = true
+	if s.l != nil {
+		s.l.Close()
+	}
+	for c := range s.conns {
+		c.Close()
+	}
+	for addr, c := range s.mgrt {
+		c.Close()
+		delete(s.mgrt, addr)
+	}
+	s.mu.Unlock()
+	s.wg.Wait()
+}
+
+// 客户端需要先通过 AUTH 认证
+func (s *Server) RequireAuth(passwd string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.passwd = passwd
+}
+
+func (s *Server) Get(key string) (string, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	v := s.ks.lookup(key)
+	if v == nil || v.kind != kindString {
+		return "", false
+	}
+	return string(v.str), true
+}
+
+func (s *Server) Set(key, value string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	v := newValue(kindString)
+	v.str = []byte(value)
+	s.ks.put(key, v)
+}
+
+func (s *Server) Exists(key string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.ks.lookup(key) != nil
+}
+
+// 所有没有过期的 key，按照字典序排列
+func (s *Server) Keys() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.ks.allKeys()
+}
+
+// 指定 slot 中的 key
+func (s *Server) SlotKeys(slot int) []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.ks.slotKeys(slot)
+}
+
+func (s *Server) FlushAll() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.ks = newKeyspace()
+}
+
+type session struct {
+	*redis.Conn
+	authorized bool
+	quit       bool
+}
+
+func (s *Server) serve(c net.Conn) {
+	sess := &session{Conn: redis.NewConn(c)}
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		c.Close()
+		return
+	}
+	s.conns[sess.Conn] = true
+	s.mu.Unlock()
+
+	defer func() {
+		s.mu.Lock()
+		delete(s.conns, sess.Conn)
+		s.mu.Unlock()
+		sess.Close()
+	}()
+
+	for !sess.quit {
+		req, err := sess.Reader.Decode()
+		if err != nil {
+			return
+		}
+		resp := s.handle(sess, req)
+		// pipeline 中还有请求时不需要立即 flush
+		flush := sess.Reader.Buffered() == 0
+		if err := sess.Writer.Encode(resp, flush); err != nil {
+			log.WarnErrorf(err, "fakeserver %s: write reply failed", s.Addr())
+			return
+		}
+	}
+}
+
+func (s *Server) handle(sess *session, req *redis.Resp) *redis.Resp {
+	if !req.IsArray() || len(req.Array) == 0 {
+		return errorf("ERR bad request")
+	}
+	var args = make([][]byte, len(req.Array))
+	for i, r := range req.Array {
+		if !r.IsBulkBytes() {
+			return errorf("ERR bad request")
+		}
+		args[i] = r.Value
+	}
+	opstr := strings.ToUpper(string(args[0]))
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	switch opstr {
+	case "AUTH":
+		if len(args) != 2 {
+			return errArgs(opstr)
+		}
+		if s.passwd == "" {
+			return errorf("ERR Client sent AUTH, but no password is set")
+		}
+		if string(args[1]) != s.passwd {
+			sess.authorized = false
+			return errorf("ERR invalid password")
+		}
+		sess.authorized = true
+		return ok()
+	case "QUIT":
+		sess.quit = true
+		return ok()
+	}
+	if s.passwd != "" && !sess.authorized {
+		return errorf("NOAUTH Authentication required.")
+	}
+
+	c, found := commands[opstr]
+	if !found {
+		return errorf("ERR unknown command '%s'", strings.ToLower(opstr))
+	}
+	if (c.arity > 0 && len(args) != c.arity) || (c.arity < 0 && len(args) < -c.arity) {
+		return errArgs(opstr)
+	}
+	return c.f(s, opstr, args[1:])
+}
+
+// 没有真正的复制，只是在 INFO 中返回对应的角色
+func (s *Server) info() string {
+	var lines []string
+	lines = append(lines, "# Server", "redis_version:2.8.21", "")
+	if s.master == "" {
+		lines = append(lines, "# Replication", "role:master", "connected_slaves:0", "master_repl_offset:0", "")
+	} else {
+		host, port, _ := net.SplitHostPort(s.master)
+		lines = append(lines, "# Replication", "role:slave",
+			"master_host:"+host, "master_port:"+port,
+			"master_link_status:up", "master_last_io_seconds_ago:0",
+			"master_sync_in_progress:0", "slave_repl_offset:0", "")
+	}
+	var keys, expires int
+	for _, key := range s.ks.allKeys() {
+		keys++
+		if s.ks.keys[key].expireAt != 0 {
+			expires++
+		}
+	}
+	lines = append(lines, "# Keyspace")
+	if keys != 0 {
+		lines = append(lines, fmt.Sprintf("db0:keys=%d,expires=%d,avg_ttl=0", keys, expires))
+	}
+	return strings.Join(lines, "\r\n") + "\r\n"
+}
+
+// 迁移时到目标的连接，出错后关闭，下一次重新建立
+func (s *Server) mgrtConn(addr string, timeout time.Duration) (*redis.Conn, error) {
+	if c := s.mgrt[addr]; c != nil {
+		return c, nil
+	}
+	c, err := redis.DialTimeout(addr, 1024*64, timeout)
+	if err != nil {
+		return nil, err
+	}
+	c.ReaderTimeout = timeout
+	c.WriterTimeout = timeout
+	s.mgrt[addr] = c
+	return c, nil
+}
+
+func (s *Server) closeMgrtConn(addr string) {
+	if c := s.mgrt[addr]; c != nil {
+		c.Close()
+		delete(s.mgrt, addr)
+	}
+}
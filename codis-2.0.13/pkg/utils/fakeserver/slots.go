@@ -0,0 +1,188 @@
+// Copyright 2016 CodisLabs. All Rights Reserved.
+// Licensed under the MIT (MIT-LICENSE.txt) license.
+
+package fakeserver
+
+import (
+	"net"
+	"time"
+
+	"github.com/CodisLabs/codis/pkg/proxy/redis"
+	"github.com/CodisLabs/codis/pkg/proxy/router"
+	"github.com/CodisLabs/codis/pkg/utils/errors"
+	"github.com/CodisLabs/codis/pkg/utils/rdb"
+)
+
+// SLOTSINFO [start] [count]，返回范围内不为空的 slot 和其中 key 的数量
+func cmdSlotsInfo(s *Server, opstr string, args [][]byte) *redis.Resp {
+	var start, count int64 = 0, router.MaxSlotNum
+	if len(args) > 2 {
+		return errArgs(opstr)
+	}
+	if len(args) >= 1 {
+		n, e := parseInt(args[0])
+		if e != nil {
+			return e
+		}
+		start = n
+	}
+	if len(args) == 2 {
+		n, e := parseInt(args[1])
+		if e != nil {
+			return e
+		}
+		count = n
+	}
+	if start < 0 || count < 0 {
+		return errorf("ERR invalid slot range")
+	}
+	var infos []*redis.Resp
+	for i := start; i < start+count && i < router.MaxSlotNum; i++ {
+		if n := len(s.ks.slotKeys(int(i))); n != 0 {
+			infos = append(infos, redis.NewArray([]*redis.Resp{integer(i), integer(int64(n))}))
+		}
+	}
+	if infos == nil {
+		infos = []*redis.Resp{}
+	}
+	return redis.NewArray(infos)
+}
+
+// SLOTSHASHKEY key [key ...]，返回每个 key 所在的 slot
+func cmdSlotsHashKey(s *Server, opstr string, args [][]byte) *redis.Resp {
+	var slots = make([]*redis.Resp, len(args))
+	for i, key := range args {
+		slots[i] = integer(int64(router.HashSlot(key)))
+	}
+	return redis.NewArray(slots)
+}
+
+// SLOTSMGRTSLOT/SLOTSMGRTTAGSLOT host port timeout slot
+// 迁移 slot 中的一个 key，带 TAG 的版本同时迁移 hash tag 相同的 key，返回迁移的 key 数和 slot 中剩下的 key 数
+func cmdSlotsMgrtSlot(s *Server, opstr string, args [][]byte) *redis.Resp {
+	addr, timeout, e := parseMgrtArgs(args)
+	if e != nil {
+		return e
+	}
+	slot, e := parseInt(args[3])
+	if e != nil {
+		return e
+	}
+	if slot < 0 || slot >= router.MaxSlotNum {
+		return errorf("ERR invalid slot number")
+	}
+	keys := s.ks.slotKeys(int(slot))
+	if len(keys) == 0 {
+		return redis.NewArray([]*redis.Resp{integer(0), integer(0)})
+	}
+	if opstr == "SLOTSMGRTTAGSLOT" {
+		keys = s.ks.tagKeys(keys[0])
+	} else {
+		keys = keys[:1]
+	}
+	if err := s.migrate(addr, timeout, keys); err != nil {
+		return errorf("ERR migrate to %s failed: %s", addr, err)
+	}
+	remain := len(s.ks.slotKeys(int(slot)))
+	return redis.NewArray([]*redis.Resp{integer(int64(len(keys))), integer(int64(remain))})
+}
+
+// SLOTSMGRTONE/SLOTSMGRTTAGONE host port timeout key
+// 迁移指定的 key，返回迁移的 key 数，key 不存在时返回 0
+func cmdSlotsMgrtOne(s *Server, opstr string, args [][]byte) *redis.Resp {
+	addr, timeout, e := parseMgrtArgs(args)
+	if e != nil {
+		return e
+	}
+	key := string(args[3])
+	if s.ks.lookup(key) == nil {
+		return integer(0)
+	}
+	var keys = []string{key}
+	if opstr == "SLOTSMGRTTAGONE" {
+		keys = s.ks.tagKeys(key)
+	}
+	if err := s.migrate(addr, timeout, keys); err != nil {
+		return errorf("ERR migrate to %s failed: %s", addr, err)
+	}
+	return integer(int64(len(keys)))
+}
+
+func parseMgrtArgs(args [][]byte) (string, time.Duration, *redis.Resp) {
+	ms, e := parseInt(args[2])
+	if e != nil {
+		return "", 0, e
+	}
+	if ms <= 0 {
+		ms = 1000
+	}
+	addr := net.JoinHostPort(string(args[0]), string(args[1]))
+	return addr, time.Millisecond * time.Duration(ms), nil
+}
+
+// 转换成 rdb.Entry，用相同的命令在目标上重建
+func (v *value) entry(key string) *rdb.Entry {
+	e := &rdb.Entry{Key: []byte(key), ExpireAt: v.expireAt}
+	switch v.kind {
+	case kindString:
+		e.Kind, e.Value = rdb.KindString, v.str
+	case kindList:
+		e.Kind, e.Values = rdb.KindList, v.list
+	case kindSet:
+		e.Kind, e.Values = rdb.KindSet, sortedMembers(v.set)
+	case kindHash:
+		e.Kind = rdb.KindHash
+		for _, f := range sortedFields(v) {
+			e.Fields = append(e.Fields, rdb.Field{Name: []byte(f), Value: v.hash[f]})
+		}
+	}
+	return e
+}
+
+// 在目标上重建这些 key，全部成功之后才从本地删除
+func (s *Server) migrate(addr string, timeout time.Duration, keys []string) error {
+	if addr == s.l.Addr().String() {
+		return errors.New("can not migrate to itself")
+	}
+	var cmds [][][]byte
+	for _, key := range keys {
+		if v := s.ks.lookup(key); v != nil {
+			cmds = append(cmds, v.entry(key).Commands(64)...)
+		}
+	}
+	c, err := s.mgrtConn(addr, timeout)
+	if err != nil {
+		return err
+	}
+	if err := sendCommands(c, cmds); err != nil {
+		s.closeMgrtConn(addr)
+		return err
+	}
+	for _, key := range keys {
+		s.ks.remove(key)
+	}
+	return nil
+}
+
+// 以 pipeline 的方式发送，再依次读取回复
+func sendCommands(c *redis.Conn, cmds [][][]byte) error {
+	for i, cmd := range cmds {
+		var multi = make([]*redis.Resp, len(cmd))
+		for j, arg := range cmd {
+			multi[j] = redis.NewBulkBytes(arg)
+		}
+		if err := c.Writer.Encode(redis.NewArray(multi), i == len(cmds)-1); err != nil {
+			return err
+		}
+	}
+	for range cmds {
+		resp, err := c.Reader.Decode()
+		if err != nil {
+			return err
+		}
+		if resp.IsError() {
+			return errors.Errorf("error resp: %s", resp.Value)
+		}
+	}
+	return nil
+}
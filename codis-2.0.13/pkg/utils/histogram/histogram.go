@@ -0,0 +1,143 @@
+// Copyright 2016 CodisLabs. All Rights Reserved.
+// Licensed under the MIT (MIT-LICENSE.txt) license.
+
+package histogram
+
+import (
+	"encoding/json"
+	"strconv"
+
+	"github.com/CodisLabs/codis/pkg/utils/atomic2"
+)
+
+// 对数分桶，每个2的幂区间再均分成8个桶，相对误差不超过12.5%
+// 小于8的值每个值一个桶，最大可以记录到 2^40 左右，更大的值计入最后一个桶
+const (
+	subBits    = 3
+	subBuckets = 1 << subBits
+	maxExp     = 40
+
+	NumBuckets = (maxExp - subBits + 2) * subBuckets
+)
+
+// 值所在的桶
+func bucketIndex(v int64) int {
+	if v < subBuckets {
+		if v < 0 {
+			return 0
+		}
+		return int(v)
+	}
+	var exp uint
+	for x := v; x >= subBuckets*2; x >>= 1 {
+		exp++
+	}
+	i := int(exp+1)*subBuckets + int(v>>exp) - subBuckets
+	if i >= NumBuckets {
+		return NumBuckets - 1
+	}
+	return i
+}
+
+// 桶能记录的最大值，统计的百分位数即为所在桶的上界
+func bucketUpper(i int) int64 {
+	if i < subBuckets {
+		return int64(i)
+	}
+	exp := uint(i/subBuckets - 1)
+	low := int64(i%subBuckets+subBuckets) << exp
+	return low + (int64(1) << exp) - 1
+}
+
+// 延迟分布，可以并发地记录
+type Histogram struct {
+	counts [NumBuckets]atomic2.Int64
+}
+
+func (h *Histogram) Record(v int64) {
+	h.counts[bucketIndex(v)].Incr()
+}
+
+// 获取当前的分布
+func (h *Histogram) Snapshot() *Snapshot {
+	s := &Snapshot{}
+	for i := range h.counts {
+		if n := h.counts[i].Get(); n != 0 {
+			s.counts[i] = n
+			s.total += n
+		}
+	}
+	return s
+}
+
+// 某一时刻的延迟分布，可以合并多个proxy的分布后再计算百分位数
+type Snapshot struct {
+	counts [NumBuckets]int64
+	total  int64
+}
+
+func (s *Snapshot) Count() int64 {
+	return s.total
+}
+
+// 获取百分位数，q的范围是 [0, 1]
+func (s *Snapshot) Percentile(q float64) int64 {
+	if s.total == 0 {
+		return 0
+	}
+	target := int64(q*float64(s.total) + 0.5)
+	if target < 1 {
+		target = 1
+	}
+	var sum int64
+	for i, n := range s.counts {
+		if sum += n; sum >= target {
+			return bucketUpper(i)
+		}
+	}
+	return bucketUpper(NumBuckets - 1)
+}
+
+func (s *Snapshot) Merge(o *Snapshot) {
+	for i, n := range o.counts {
+		s.counts[i] += n
+	}
+	s.total += o.total
+}
+
+// 除了常用的百分位数之外，还包含不为空的桶，key是桶的上界，便于合并
+func (s *Snapshot) MarshalJSON() ([]byte, error) {
+	var buckets = make(map[string]int64)
+	for i, n := range s.counts {
+		if n != 0 {
+			buckets[strconv.FormatInt(bucketUpper(i), 10)] = n
+		}
+	}
+	return json.Marshal(map[string]interface{}{
+		"count":   s.total,
+		"p50":     s.Percentile(0.50),
+		"p95":     s.Percentile(0.95),
+		"p99":     s.Percentile(0.99),
+		"p999":    s.Percentile(0.999),
+		"buckets": buckets,
+	})
+}
+
+func (s *Snapshot) UnmarshalJSON(b []byte) error {
+	var m struct {
+		Buckets map[string]int64 `json:"buckets"`
+	}
+	if err := json.Unmarshal(b, &m); err != nil {
+		return err
+	}
+	*s = Snapshot{}
+	for k, n := range m.Buckets {
+		v, err := strconv.ParseInt(k, 10, 64)
+		if err != nil {
+			return err
+		}
+		s.counts[bucketIndex(v)] += n
+		s.total += n
+	}
+	return nil
+}
@@ -0,0 +1,62 @@
+// Copyright 2016 CodisLabs. All Rights Reserved.
+// Licensed under the MIT (MIT-LICENSE.txt) license.
+
+package histogram
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/CodisLabs/codis/pkg/utils/assert"
+)
+
+func TestBucket(t *testing.T) {
+	for i := 0; i < NumBuckets; i++ {
+		assert.Must(bucketIndex(bucketUpper(i)) == i)
+		if i != 0 {
+			assert.Must(bucketIndex(bucketUpper(i-1)+1) == i)
+		}
+	}
+	for _, v := range []int64{0, 1, 7, 8, 100, 1000, 12345, 1e6, 1e9} {
+		upper := bucketUpper(bucketIndex(v))
+		assert.Must(upper >= v && upper-v <= v/8)
+	}
+	assert.Must(bucketIndex(-1) == 0)
+	assert.Must(bucketIndex(1<<62) == NumBuckets-1)
+}
+
+func TestPercentile(t *testing.T) {
+	h := &Histogram{}
+	for i := int64(1); i <= 1000; i++ {
+		h.Record(i)
+	}
+	s := h.Snapshot()
+	assert.Must(s.Count() == 1000)
+	for _, x := range []struct {
+		q float64
+		v int64
+	}{{0.5, 500}, {0.99, 990}, {1, 1000}} {
+		p := s.Percentile(x.q)
+		assert.Must(p >= x.v && p-x.v <= x.v/8)
+	}
+	assert.Must((&Snapshot{}).Percentile(0.99) == 0)
+}
+
+func TestSnapshotJSON(t *testing.T) {
+	h1, h2 := &Histogram{}, &Histogram{}
+	for i := int64(0); i < 100; i++ {
+		h1.Record(10)
+		h2.Record(10000)
+	}
+	b, err := json.Marshal(h1.Snapshot())
+	assert.MustNoError(err)
+
+	s := &Snapshot{}
+	assert.MustNoError(json.Unmarshal(b, s))
+	assert.Must(*s == *h1.Snapshot())
+
+	s.Merge(h2.Snapshot())
+	assert.Must(s.Count() == 200)
+	assert.Must(s.Percentile(0.5) == bucketUpper(bucketIndex(10)))
+	assert.Must(s.Percentile(0.99) == bucketUpper(bucketIndex(10000)))
+}
@@ -0,0 +1,76 @@
+// Copyright 2016 CodisLabs. All Rights Reserved.
+// Licensed under the MIT (MIT-LICENSE.txt) license.
+
+package rdb
+
+import (
+	"io"
+	"strconv"
+
+	"github.com/CodisLabs/codis/pkg/utils/errors"
+)
+
+// 按顺序读取AOF文件中的命令，AOF中的命令都是 multi bulk 格式
+type AOFReader struct {
+	r *reader
+}
+
+func NewAOFReader(r io.Reader) *AOFReader {
+	return NewAOFReaderAt(r, 0)
+}
+
+// 从上次记录的位置继续读取，r 需要已经定位到 offset
+func NewAOFReaderAt(r io.Reader, offset int64) *AOFReader {
+	return &AOFReader{r: newReader(r, offset)}
+}
+
+// 已经读取的字节数，每次 Next 返回之后都停在两条命令之间
+func (a *AOFReader) Offset() int64 {
+	return a.r.offset
+}
+
+// 读取下一条命令，文件结束时返回 io.EOF，最后一条命令不完整时返回 io.ErrUnexpectedEOF
+func (a *AOFReader) Next() ([][]byte, error) {
+	line, err := a.r.readLine()
+	if err == io.EOF {
+		return nil, io.EOF
+	}
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	n, err := a.readCount('*', line)
+	if err != nil {
+		return nil, err
+	}
+	var args = make([][]byte, 0, n)
+	for i := 0; i < n; i++ {
+		line, err := a.r.readLine()
+		if err != nil {
+			return nil, errors.Trace(unexpectedEOF(err))
+		}
+		size, err := a.readCount('$', line)
+		if err != nil {
+			return nil, err
+		}
+		b, err := a.r.readFull(size + 2)
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		if b[size] != '\r' || b[size+1] != '\n' {
+			return nil, errors.Errorf("bad bulk string end at offset %d", a.r.offset)
+		}
+		args = append(args, b[:size])
+	}
+	return args, nil
+}
+
+func (a *AOFReader) readCount(prefix byte, line []byte) (int, error) {
+	if len(line) < 2 || line[0] != prefix {
+		return 0, errors.Errorf("expect '%c' at offset %d, got %q", prefix, a.r.offset, line)
+	}
+	n, err := strconv.Atoi(string(line[1:]))
+	if err != nil || n < 1 && prefix == '*' || n < 0 || n > maxStringLen {
+		return 0, errors.Errorf("bad length %q at offset %d", line, a.r.offset)
+	}
+	return n, nil
+}
@@ -0,0 +1,56 @@
+// Copyright 2016 CodisLabs. All Rights Reserved.
+// Licensed under the MIT (MIT-LICENSE.txt) license.
+
+package rdb
+
+import "strconv"
+
+// 在redis中重建这个key的命令，集合类型每条命令最多带 batch 个元素
+// 先删除已经存在的key，最后设置过期时间，重复执行的结果是一样的
+func (e *Entry) Commands(batch int) [][][]byte {
+	if batch <= 0 {
+		batch = 1
+	}
+	var cmds [][][]byte
+	var bulk = func(op string, args [][]byte, step int) {
+		for len(args) != 0 {
+			n := batch * step
+			if n > len(args) {
+				n = len(args)
+			}
+			cmd := append([][]byte{[]byte(op), e.Key}, args[:n]...)
+			cmds = append(cmds, cmd)
+			args = args[n:]
+		}
+	}
+	switch e.Kind {
+	case KindString:
+		cmds = append(cmds, [][]byte{[]byte("SET"), e.Key, e.Value})
+	case KindList, KindSet:
+		cmds = append(cmds, [][]byte{[]byte("DEL"), e.Key})
+		if e.Kind == KindList {
+			bulk("RPUSH", e.Values, 1)
+		} else {
+			bulk("SADD", e.Values, 1)
+		}
+	case KindZSet, KindHash:
+		cmds = append(cmds, [][]byte{[]byte("DEL"), e.Key})
+		var args = make([][]byte, 0, len(e.Fields)*2)
+		for _, f := range e.Fields {
+			if e.Kind == KindZSet {
+				args = append(args, f.Value, f.Name)
+			} else {
+				args = append(args, f.Name, f.Value)
+			}
+		}
+		if e.Kind == KindZSet {
+			bulk("ZADD", args, 2)
+		} else {
+			bulk("HMSET", args, 2)
+		}
+	}
+	if e.ExpireAt != 0 {
+		cmds = append(cmds, [][]byte{[]byte("PEXPIREAT"), e.Key, []byte(strconv.FormatInt(e.ExpireAt, 10))})
+	}
+	return cmds
+}
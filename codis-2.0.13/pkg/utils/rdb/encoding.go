@@ -0,0 +1,236 @@
+// Copyright 2016 CodisLabs. All Rights Reserved.
+// Licensed under the MIT (MIT-LICENSE.txt) license.
+
+package rdb
+
+import (
+	"encoding/binary"
+	"strconv"
+
+	"github.com/CodisLabs/codis/pkg/utils/errors"
+)
+
+var errBadEncoding = errors.New("bad encoded value")
+
+// 两两一组转换成 field 和 value
+func pairs(list [][]byte) ([]Field, error) {
+	if len(list)%2 != 0 {
+		return nil, errBadEncoding
+	}
+	var fields = make([]Field, 0, len(list)/2)
+	for i := 0; i < len(list); i += 2 {
+		fields = append(fields, Field{Name: list[i], Value: list[i+1]})
+	}
+	return fields, nil
+}
+
+// 解析ziplist，格式为 zlbytes(4) zltail(4) zllen(2) entry... 0xff
+func decodeZiplist(b []byte) ([][]byte, error) {
+	if len(b) < 11 {
+		return nil, errBadEncoding
+	}
+	var list [][]byte
+	var p = 10
+	for {
+		if p >= len(b) {
+			return nil, errBadEncoding
+		}
+		if b[p] == 0xff {
+			return list, nil
+		}
+		// 前一个entry的长度，小于254时占1字节，否则是254加上4字节的长度
+		if b[p] == 254 {
+			p += 5
+		} else {
+			p++
+		}
+		if p >= len(b) {
+			return nil, errBadEncoding
+		}
+		var n = 0
+		var enc = b[p]
+		switch enc >> 6 {
+		case 0:
+			n, p = int(enc&0x3f), p+1
+		case 1:
+			if p+2 > len(b) {
+				return nil, errBadEncoding
+			}
+			n, p = int(enc&0x3f)<<8|int(b[p+1]), p+2
+		case 2:
+			if p+5 > len(b) {
+				return nil, errBadEncoding
+			}
+			n, p = int(binary.BigEndian.Uint32(b[p+1:p+5])), p+5
+		default:
+			v, size, err := decodeZiplistInt(b[p:])
+			if err != nil {
+				return nil, err
+			}
+			list = append(list, []byte(strconv.FormatInt(v, 10)))
+			p += size
+			continue
+		}
+		if n < 0 || p+n > len(b) {
+			return nil, errBadEncoding
+		}
+		list = append(list, b[p:p+n])
+		p += n
+	}
+}
+
+// 解析ziplist中的整数，返回整数的值和占用的字节数，包括编码的1字节
+func decodeZiplistInt(b []byte) (int64, int, error) {
+	var size int
+	switch b[0] {
+	case 0xc0:
+		size = 2
+	case 0xd0:
+		size = 4
+	case 0xe0:
+		size = 8
+	case 0xf0:
+		size = 3
+	case 0xfe:
+		size = 1
+	default:
+		// 1111xxxx，xxxx-1 就是 0 到 12 的整数
+		if b[0] >= 0xf1 && b[0] <= 0xfd {
+			return int64(b[0]&0x0f) - 1, 1, nil
+		}
+		return 0, 0, errBadEncoding
+	}
+	if len(b) < size+1 {
+		return 0, 0, errBadEncoding
+	}
+	p := b[1 : size+1]
+	switch size {
+	case 1:
+		return int64(int8(p[0])), 2, nil
+	case 2:
+		return int64(int16(binary.LittleEndian.Uint16(p))), 3, nil
+	case 3:
+		return int64(int32(uint32(p[0])<<8|uint32(p[1])<<16|uint32(p[2])<<24) >> 8), 4, nil
+	case 4:
+		return int64(int32(binary.LittleEndian.Uint32(p))), 5, nil
+	default:
+		return int64(binary.LittleEndian.Uint64(p)), 9, nil
+	}
+}
+
+// 解析intset，格式为 encoding(4) length(4) 之后是按 encoding 字节保存的整数
+func decodeIntset(b []byte) ([][]byte, error) {
+	if len(b) < 8 {
+		return nil, errBadEncoding
+	}
+	size := int(binary.LittleEndian.Uint32(b[0:4]))
+	n := int(binary.LittleEndian.Uint32(b[4:8]))
+	if (size != 2 && size != 4 && size != 8) || n < 0 || len(b) != 8+n*size {
+		return nil, errBadEncoding
+	}
+	var list = make([][]byte, 0, n)
+	for i := 0; i < n; i++ {
+		p := b[8+i*size : 8+(i+1)*size]
+		var v int64
+		switch size {
+		case 2:
+			v = int64(int16(binary.LittleEndian.Uint16(p)))
+		case 4:
+			v = int64(int32(binary.LittleEndian.Uint32(p)))
+		case 8:
+			v = int64(binary.LittleEndian.Uint64(p))
+		}
+		list = append(list, []byte(strconv.FormatInt(v, 10)))
+	}
+	return list, nil
+}
+
+// 解析zipmap，格式为 zmlen(1) (len key len free value free_bytes)... 0xff
+func decodeZipmap(b []byte) ([]Field, error) {
+	var p = 1
+	var readLen = func() (int, bool) {
+		if p >= len(b) {
+			return 0, false
+		}
+		switch n := int(b[p]); n {
+		case 254:
+			if p+5 > len(b) {
+				return 0, false
+			}
+			p += 5
+			return int(binary.LittleEndian.Uint32(b[p-4 : p])), true
+		case 255:
+			return 0, false
+		default:
+			p++
+			return n, true
+		}
+	}
+	var fields []Field
+	for {
+		if p >= len(b) {
+			return nil, errBadEncoding
+		}
+		if b[p] == 0xff {
+			return fields, nil
+		}
+		klen, ok := readLen()
+		if !ok || klen < 0 || p+klen > len(b) {
+			return nil, errBadEncoding
+		}
+		key := b[p : p+klen]
+		p += klen
+		vlen, ok := readLen()
+		if !ok || vlen < 0 || p+1+vlen > len(b) {
+			return nil, errBadEncoding
+		}
+		// value之后有 free 个未使用的字节
+		free := int(b[p])
+		p++
+		value := b[p : p+vlen]
+		p += vlen + free
+		fields = append(fields, Field{Name: key, Value: value})
+	}
+}
+
+// LZF解压缩，控制字节小于32时之后是 ctrl+1 字节的原文，否则是对之前输出的引用
+func lzfDecompress(in []byte, outlen int) ([]byte, error) {
+	var out = make([]byte, 0, outlen)
+	for i := 0; i < len(in); {
+		ctrl := int(in[i])
+		i++
+		if ctrl < 32 {
+			n := ctrl + 1
+			if i+n > len(in) {
+				return nil, errBadEncoding
+			}
+			out = append(out, in[i:i+n]...)
+			i += n
+			continue
+		}
+		n := ctrl >> 5
+		if n == 7 {
+			if i >= len(in) {
+				return nil, errBadEncoding
+			}
+			n += int(in[i])
+			i++
+		}
+		if i >= len(in) {
+			return nil, errBadEncoding
+		}
+		ref := len(out) - (ctrl&0x1f)<<8 - int(in[i]) - 1
+		i++
+		if ref < 0 {
+			return nil, errBadEncoding
+		}
+		// 引用的内容可能和正在输出的内容重叠，只能逐个字节复制
+		for j := 0; j < n+2; j++ {
+			out = append(out, out[ref+j])
+		}
+	}
+	if len(out) != outlen {
+		return nil, errBadEncoding
+	}
+	return out, nil
+}
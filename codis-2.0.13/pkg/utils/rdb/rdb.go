@@ -0,0 +1,455 @@
+// Copyright 2016 CodisLabs. All Rights Reserved.
+// Licensed under the MIT (MIT-LICENSE.txt) license.
+
+package rdb
+
+import (
+	"encoding/binary"
+	"io"
+	"math"
+	"strconv"
+
+	"github.com/CodisLabs/codis/pkg/utils/errors"
+)
+
+// RDB文件中value的编码方式
+const (
+	typeString        = 0
+	typeList          = 1
+	typeSet           = 2
+	typeZSet          = 3
+	typeHash          = 4
+	typeZSet2         = 5
+	typeHashZipmap    = 9
+	typeListZiplist   = 10
+	typeSetIntset     = 11
+	typeZSetZiplist   = 12
+	typeHashZiplist   = 13
+	typeListQuicklist = 14
+)
+
+// RDB文件中的操作码
+const (
+	opIdle     = 0xf8
+	opFreq     = 0xf9
+	opAux      = 0xfa
+	opResizeDB = 0xfb
+	opExpireMs = 0xfc
+	opExpire   = 0xfd
+	opSelectDB = 0xfe
+	opEOF      = 0xff
+)
+
+// 支持的最高版本，redis 5.0 的 stream 和 module 类型不支持
+const MaxVersion = 9
+
+type Kind int
+
+const (
+	KindString Kind = iota
+	KindList
+	KindSet
+	KindZSet
+	KindHash
+)
+
+func (k Kind) String() string {
+	switch k {
+	case KindString:
+		return "string"
+	case KindList:
+		return "list"
+	case KindSet:
+		return "set"
+	case KindZSet:
+		return "zset"
+	case KindHash:
+		return "hash"
+	}
+	return "unknown"
+}
+
+// hash 的 field 和 value，或者 zset 的 member 和 score
+type Field struct {
+	Name  []byte
+	Value []byte
+}
+
+// RDB文件中的一个key
+type Entry struct {
+	DB       int
+	Key      []byte
+	ExpireAt int64 // 过期的时间，单位是毫秒，0 表示没有设置过期时间
+	Kind     Kind
+
+	Value  []byte   // string
+	Values [][]byte // list、set
+	Fields []Field  // hash、zset
+}
+
+type Decoder struct {
+	r       *reader
+	version int
+	db      int
+}
+
+func NewDecoder(r io.Reader) *Decoder {
+	return &Decoder{r: newReader(r, 0)}
+}
+
+// 从上次记录的位置继续读取，r 需要已经定位到 offset
+func NewDecoderAt(r io.Reader, offset int64, version, db int) *Decoder {
+	return &Decoder{r: newReader(r, offset), version: version, db: db}
+}
+
+// 读取文件头 "REDIS" + 4位版本号
+func (d *Decoder) ReadHeader() error {
+	b, err := d.r.readFull(9)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if string(b[:5]) != "REDIS" {
+		return errors.New("not a rdb file")
+	}
+	version, err := strconv.Atoi(string(b[5:]))
+	if err != nil {
+		return errors.Errorf("bad rdb version %q", b[5:])
+	}
+	if version < 1 || version > MaxVersion {
+		return errors.Errorf("unsupported rdb version %d", version)
+	}
+	d.version = version
+	return nil
+}
+
+func (d *Decoder) Version() int {
+	return d.version
+}
+
+// 当前所在的db
+func (d *Decoder) DB() int {
+	return d.db
+}
+
+// 已经读取的字节数，每次 Next 返回之后都停在两个key之间
+func (d *Decoder) Offset() int64 {
+	return d.r.offset
+}
+
+// 读取下一个key，读到文件结束标记时返回 io.EOF
+func (d *Decoder) Next() (*Entry, error) {
+	var expireAt int64
+	for {
+		t, err := d.r.readByte()
+		if err != nil {
+			return nil, errors.Trace(unexpectedEOF(err))
+		}
+		switch t {
+		case opEOF:
+			// 版本5之后文件末尾有8字节的校验和
+			if d.version >= 5 {
+				if _, err := d.r.readFull(8); err != nil {
+					return nil, errors.Trace(err)
+				}
+			}
+			return nil, io.EOF
+		case opSelectDB:
+			n, _, err := d.readLength()
+			if err != nil {
+				return nil, err
+			}
+			d.db = int(n)
+		case opResizeDB:
+			for i := 0; i < 2; i++ {
+				if _, _, err := d.readLength(); err != nil {
+					return nil, err
+				}
+			}
+		case opAux:
+			for i := 0; i < 2; i++ {
+				if _, err := d.readString(); err != nil {
+					return nil, err
+				}
+			}
+		case opExpireMs:
+			b, err := d.r.readFull(8)
+			if err != nil {
+				return nil, errors.Trace(err)
+			}
+			expireAt = int64(binary.LittleEndian.Uint64(b))
+		case opExpire:
+			b, err := d.r.readFull(4)
+			if err != nil {
+				return nil, errors.Trace(err)
+			}
+			expireAt = int64(binary.LittleEndian.Uint32(b)) * 1000
+		case opFreq:
+			if _, err := d.r.readByte(); err != nil {
+				return nil, errors.Trace(unexpectedEOF(err))
+			}
+		case opIdle:
+			if _, _, err := d.readLength(); err != nil {
+				return nil, err
+			}
+		default:
+			key, err := d.readString()
+			if err != nil {
+				return nil, err
+			}
+			e := &Entry{DB: d.db, Key: key, ExpireAt: expireAt}
+			if err := d.readValue(t, e); err != nil {
+				return nil, err
+			}
+			return e, nil
+		}
+	}
+}
+
+func (d *Decoder) readValue(t byte, e *Entry) error {
+	var err error
+	switch t {
+	case typeString:
+		e.Kind = KindString
+		e.Value, err = d.readString()
+	case typeList, typeSet:
+		e.Kind = KindList
+		if t == typeSet {
+			e.Kind = KindSet
+		}
+		e.Values, err = d.readStrings(1)
+	case typeHash:
+		e.Kind = KindHash
+		var list [][]byte
+		if list, err = d.readStrings(2); err == nil {
+			e.Fields, err = pairs(list)
+		}
+	case typeZSet, typeZSet2:
+		e.Kind = KindZSet
+		e.Fields, err = d.readZSet(t == typeZSet2)
+	case typeHashZipmap:
+		e.Kind = KindHash
+		var b []byte
+		if b, err = d.readString(); err == nil {
+			e.Fields, err = decodeZipmap(b)
+		}
+	case typeListZiplist:
+		e.Kind = KindList
+		var b []byte
+		if b, err = d.readString(); err == nil {
+			e.Values, err = decodeZiplist(b)
+		}
+	case typeSetIntset:
+		e.Kind = KindSet
+		var b []byte
+		if b, err = d.readString(); err == nil {
+			e.Values, err = decodeIntset(b)
+		}
+	case typeZSetZiplist, typeHashZiplist:
+		e.Kind = KindHash
+		if t == typeZSetZiplist {
+			e.Kind = KindZSet
+		}
+		var b []byte
+		var list [][]byte
+		if b, err = d.readString(); err == nil {
+			if list, err = decodeZiplist(b); err == nil {
+				e.Fields, err = pairs(list)
+			}
+		}
+	case typeListQuicklist:
+		e.Kind = KindList
+		var nodes [][]byte
+		if nodes, err = d.readStrings(1); err == nil {
+			for _, b := range nodes {
+				list, err := decodeZiplist(b)
+				if err != nil {
+					return errors.Trace(err)
+				}
+				e.Values = append(e.Values, list...)
+			}
+		}
+	default:
+		return errors.Errorf("unsupported value type %d of key %q", t, e.Key)
+	}
+	if err != nil {
+		return errors.Trace(err)
+	}
+	return nil
+}
+
+// 读取长度，encoded 为 true 时表示字符串使用了特殊的编码
+func (d *Decoder) readLength() (uint64, bool, error) {
+	b, err := d.r.readByte()
+	if err != nil {
+		return 0, false, errors.Trace(unexpectedEOF(err))
+	}
+	switch b >> 6 {
+	case 0:
+		return uint64(b & 0x3f), false, nil
+	case 1:
+		b2, err := d.r.readByte()
+		if err != nil {
+			return 0, false, errors.Trace(unexpectedEOF(err))
+		}
+		return uint64(b&0x3f)<<8 | uint64(b2), false, nil
+	case 2:
+		switch b {
+		case 0x80:
+			p, err := d.r.readFull(4)
+			if err != nil {
+				return 0, false, errors.Trace(err)
+			}
+			return uint64(binary.BigEndian.Uint32(p)), false, nil
+		case 0x81:
+			p, err := d.r.readFull(8)
+			if err != nil {
+				return 0, false, errors.Trace(err)
+			}
+			return binary.BigEndian.Uint64(p), false, nil
+		}
+		return 0, false, errors.Errorf("bad length encoding 0x%02x at offset %d", b, d.r.offset)
+	default:
+		return uint64(b & 0x3f), true, nil
+	}
+}
+
+func (d *Decoder) readString() ([]byte, error) {
+	n, encoded, err := d.readLength()
+	if err != nil {
+		return nil, err
+	}
+	if !encoded {
+		if n > maxStringLen {
+			return nil, errors.Errorf("invalid length %d at offset %d", n, d.r.offset)
+		}
+		b, err := d.r.readFull(int(n))
+		return b, errors.Trace(err)
+	}
+	switch n {
+	case 0, 1, 2:
+		p, err := d.r.readFull(1 << n)
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		var v int64
+		switch n {
+		case 0:
+			v = int64(int8(p[0]))
+		case 1:
+			v = int64(int16(binary.LittleEndian.Uint16(p)))
+		case 2:
+			v = int64(int32(binary.LittleEndian.Uint32(p)))
+		}
+		return []byte(strconv.FormatInt(v, 10)), nil
+	case 3:
+		clen, _, err := d.readLength()
+		if err != nil {
+			return nil, err
+		}
+		ulen, _, err := d.readLength()
+		if err != nil {
+			return nil, err
+		}
+		if clen > maxStringLen || ulen > maxStringLen {
+			return nil, errors.Errorf("invalid lzf length %d/%d at offset %d", clen, ulen, d.r.offset)
+		}
+		p, err := d.r.readFull(int(clen))
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		b, err := lzfDecompress(p, int(ulen))
+		return b, errors.Trace(err)
+	}
+	return nil, errors.Errorf("bad string encoding %d at offset %d", n, d.r.offset)
+}
+
+// 读取长度和之后的 n*size 个字符串
+func (d *Decoder) readStrings(size int) ([][]byte, error) {
+	n, _, err := d.readLength()
+	if err != nil {
+		return nil, err
+	}
+	if n > maxStringLen {
+		return nil, errors.Errorf("invalid length %d at offset %d", n, d.r.offset)
+	}
+	var list = make([][]byte, 0, int(n)*size)
+	for i := 0; i < int(n)*size; i++ {
+		b, err := d.readString()
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, b)
+	}
+	return list, nil
+}
+
+// 读取 zset，版本8之后 score 保存为8字节的 double，之前保存为字符串
+func (d *Decoder) readZSet(binaryScore bool) ([]Field, error) {
+	n, _, err := d.readLength()
+	if err != nil {
+		return nil, err
+	}
+	if n > maxStringLen {
+		return nil, errors.Errorf("invalid length %d at offset %d", n, d.r.offset)
+	}
+	var fields = make([]Field, 0, int(n))
+	for i := 0; i < int(n); i++ {
+		member, err := d.readString()
+		if err != nil {
+			return nil, err
+		}
+		var score float64
+		if binaryScore {
+			p, err := d.r.readFull(8)
+			if err != nil {
+				return nil, errors.Trace(err)
+			}
+			score = math.Float64frombits(binary.LittleEndian.Uint64(p))
+		} else {
+			if score, err = d.readScore(); err != nil {
+				return nil, err
+			}
+		}
+		if math.IsNaN(score) {
+			return nil, errors.Errorf("score of member %q is nan", member)
+		}
+		fields = append(fields, Field{Name: member, Value: formatScore(score)})
+	}
+	return fields, nil
+}
+
+// 字符串格式的 score，第一个字节是长度，253/254/255 分别表示 nan/inf/-inf
+func (d *Decoder) readScore() (float64, error) {
+	n, err := d.r.readByte()
+	if err != nil {
+		return 0, errors.Trace(unexpectedEOF(err))
+	}
+	switch n {
+	case 253:
+		return math.NaN(), nil
+	case 254:
+		return math.Inf(1), nil
+	case 255:
+		return math.Inf(-1), nil
+	}
+	p, err := d.r.readFull(int(n))
+	if err != nil {
+		return 0, errors.Trace(err)
+	}
+	score, err := strconv.ParseFloat(string(p), 64)
+	if err != nil {
+		return 0, errors.Errorf("bad score %q at offset %d", p, d.r.offset)
+	}
+	return score, nil
+}
+
+// ZADD 能够解析的 score
+func formatScore(score float64) []byte {
+	switch {
+	case math.IsInf(score, 1):
+		return []byte("inf")
+	case math.IsInf(score, -1):
+		return []byte("-inf")
+	}
+	return []byte(strconv.FormatFloat(score, 'g', 17, 64))
+}
@@ -0,0 +1,233 @@
+// Copyright 2016 CodisLabs. All Rights Reserved.
+// Licensed under the MIT (MIT-LICENSE.txt) license.
+
+package rdb
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"math"
+	"strings"
+	"testing"
+
+	"github.com/CodisLabs/codis/pkg/utils/assert"
+	"github.com/CodisLabs/codis/pkg/utils/errors"
+)
+
+func rdbString(s string) []byte {
+	return append([]byte{byte(len(s))}, s...)
+}
+
+func rdbZiplist(entries ...[]byte) []byte {
+	b := make([]byte, 10)
+	binary.LittleEndian.PutUint16(b[8:], uint16(len(entries)))
+	for _, x := range entries {
+		b = append(b, 0)
+		b = append(b, x...)
+	}
+	return append(b, 0xff)
+}
+
+func rdbBlob(b []byte) []byte {
+	return append([]byte{byte(len(b))}, b...)
+}
+
+func testRDB() []byte {
+	var b = []byte("REDIS0008")
+	var add = func(p ...[]byte) {
+		for _, x := range p {
+			b = append(b, x...)
+		}
+	}
+	add([]byte{opAux}, rdbString("redis-ver"), rdbString("3.2.0"))
+	add([]byte{opSelectDB, 0, opResizeDB, 8, 1})
+
+	// string，带有毫秒的过期时间
+	expire := make([]byte, 8)
+	binary.LittleEndian.PutUint64(expire, 1500000000123)
+	add([]byte{opExpireMs}, expire, []byte{typeString}, rdbString("str"), rdbString("hello"))
+	// 整数编码和LZF压缩的string
+	add([]byte{typeString}, rdbString("int"), []byte{0xc1, 0x39, 0x30})
+	add([]byte{typeString}, rdbString("lzf"), []byte{0xc3, 5, 10, 0x00, 'a', 0xe0, 0x00, 0x00})
+
+	add([]byte{typeList}, rdbString("list"), []byte{2}, rdbString("a"), rdbString("b"))
+	add([]byte{typeSet}, rdbString("set"), []byte{1}, rdbString("m"))
+	add([]byte{typeHash}, rdbString("hash"), []byte{1}, rdbString("f"), rdbString("v"))
+	add([]byte{typeZSet}, rdbString("zset"), []byte{2}, rdbString("a"), rdbString("1.5"), rdbString("b"), []byte{254})
+	score := make([]byte, 8)
+	binary.LittleEndian.PutUint64(score, math.Float64bits(-2))
+	add([]byte{typeZSet2}, rdbString("zset2"), []byte{1}, rdbString("c"), score)
+
+	add([]byte{opSelectDB, 1})
+	ziplist := rdbZiplist([]byte{0x03, 'a', 'b', 'c'}, []byte{0xf6}, []byte{0xc0, 0x18, 0xfc})
+	add([]byte{typeListZiplist}, rdbString("ziplist"), rdbBlob(ziplist))
+	add([]byte{typeListQuicklist}, rdbString("quicklist"), []byte{2}, rdbBlob(ziplist), rdbBlob(ziplist))
+	intset := []byte{2, 0, 0, 0, 2, 0, 0, 0, 0xff, 0xff, 7, 0}
+	add([]byte{typeSetIntset}, rdbString("intset"), rdbBlob(intset))
+	zipmap := []byte{1, 3, 'f', 'o', 'o', 3, 1, 'b', 'a', 'r', 0, 0xff}
+	add([]byte{typeHashZipmap}, rdbString("zipmap"), rdbBlob(zipmap))
+	add([]byte{typeZSetZiplist}, rdbString("zsetzl"), rdbBlob(rdbZiplist([]byte{0x01, 'x'}, []byte{0xf4})))
+
+	add([]byte{opEOF}, make([]byte, 8))
+	return b
+}
+
+func TestDecoder(t *testing.T) {
+	data := testRDB()
+	d := NewDecoder(bytes.NewReader(data))
+	assert.MustNoError(d.ReadHeader())
+	assert.Must(d.Version() == 8)
+
+	var entries []*Entry
+	var offsets []int64
+	for {
+		e, err := d.Next()
+		if err == io.EOF {
+			break
+		}
+		assert.MustNoError(err)
+		entries = append(entries, e)
+		offsets = append(offsets, d.Offset())
+	}
+	assert.Must(d.Offset() == int64(len(data)))
+	assert.Must(len(entries) == 13)
+
+	var get = func(key string) *Entry {
+		for _, e := range entries {
+			if string(e.Key) == key {
+				return e
+			}
+		}
+		t.Fatalf("key %s not found", key)
+		return nil
+	}
+	var join = func(list [][]byte) string {
+		return string(bytes.Join(list, []byte(",")))
+	}
+	var fields = func(list []Field) string {
+		var s []string
+		for _, f := range list {
+			s = append(s, string(f.Name)+"="+string(f.Value))
+		}
+		return strings.Join(s, ",")
+	}
+
+	e := get("str")
+	assert.Must(e.Kind == KindString && string(e.Value) == "hello" && e.ExpireAt == 1500000000123 && e.DB == 0)
+	assert.Must(string(get("int").Value) == "12345" && get("int").ExpireAt == 0)
+	assert.Must(string(get("lzf").Value) == "aaaaaaaaaa")
+	assert.Must(get("list").Kind == KindList && join(get("list").Values) == "a,b")
+	assert.Must(get("set").Kind == KindSet && join(get("set").Values) == "m")
+	assert.Must(get("hash").Kind == KindHash && fields(get("hash").Fields) == "f=v")
+	assert.Must(get("zset").Kind == KindZSet && fields(get("zset").Fields) == "a=1.5,b=inf")
+	assert.Must(fields(get("zset2").Fields) == "c=-2")
+	assert.Must(get("ziplist").DB == 1 && join(get("ziplist").Values) == "abc,5,-1000")
+	assert.Must(join(get("quicklist").Values) == "abc,5,-1000,abc,5,-1000")
+	assert.Must(get("intset").Kind == KindSet && join(get("intset").Values) == "-1,7")
+	assert.Must(get("zipmap").Kind == KindHash && fields(get("zipmap").Fields) == "foo=bar")
+	assert.Must(get("zsetzl").Kind == KindZSet && fields(get("zsetzl").Fields) == "x=3")
+
+	// 从记录的位置继续读取，得到剩下的key
+	for i := range entries[:len(entries)-1] {
+		off := offsets[i]
+		r := NewDecoderAt(bytes.NewReader(data[off:]), off, d.Version(), entries[i].DB)
+		e, err := r.Next()
+		assert.MustNoError(err)
+		assert.Must(string(e.Key) == string(entries[i+1].Key) && e.DB == entries[i+1].DB)
+		assert.Must(r.Offset() == offsets[i+1])
+	}
+
+	// 文件被截断
+	d = NewDecoder(bytes.NewReader(data[:len(data)-20]))
+	assert.MustNoError(d.ReadHeader())
+	for {
+		_, err := d.Next()
+		if err != nil {
+			assert.Must(errors.Cause(err) == io.ErrUnexpectedEOF)
+			break
+		}
+	}
+
+	d = NewDecoder(bytes.NewReader([]byte("REDIS0010")))
+	assert.Must(d.ReadHeader() != nil)
+	d = NewDecoder(bytes.NewReader([]byte("*1\r\n$4\r\nPING\r\n")))
+	assert.Must(d.ReadHeader() != nil)
+}
+
+func TestEncoding(t *testing.T) {
+	_, err := decodeZiplist([]byte{0, 0, 0, 0, 0, 0, 0, 0, 1, 0, 0, 0x05, 'a'})
+	assert.Must(err != nil)
+	_, err = decodeIntset([]byte{3, 0, 0, 0, 1, 0, 0, 0, 1, 2, 3})
+	assert.Must(err != nil)
+	_, err = lzfDecompress([]byte{0x00, 'a', 0xe0, 0x00, 0x05}, 10)
+	assert.Must(err != nil)
+
+	// 24位的整数
+	v, n, err := decodeZiplistInt([]byte{0xf0, 0xff, 0xff, 0x7f})
+	assert.MustNoError(err)
+	assert.Must(v == 1<<23-1 && n == 4)
+	v, n, err = decodeZiplistInt([]byte{0xf0, 0x00, 0x00, 0x80})
+	assert.MustNoError(err)
+	assert.Must(v == -1<<23 && n == 4)
+}
+
+func TestCommands(t *testing.T) {
+	var join = func(cmds [][][]byte) []string {
+		var s []string
+		for _, cmd := range cmds {
+			s = append(s, string(bytes.Join(cmd, []byte(" "))))
+		}
+		return s
+	}
+	var equal = func(a, b []string) bool {
+		if len(a) != len(b) {
+			return false
+		}
+		for i := range a {
+			if a[i] != b[i] {
+				return false
+			}
+		}
+		return true
+	}
+
+	e := &Entry{Key: []byte("k"), Kind: KindString, Value: []byte("v")}
+	assert.Must(equal(join(e.Commands(100)), []string{"SET k v"}))
+
+	e = &Entry{Key: []byte("k"), Kind: KindList, Values: [][]byte{[]byte("a"), []byte("b"), []byte("c")}, ExpireAt: 1000}
+	assert.Must(equal(join(e.Commands(2)), []string{"DEL k", "RPUSH k a b", "RPUSH k c", "PEXPIREAT k 1000"}))
+
+	e = &Entry{Key: []byte("k"), Kind: KindZSet, Fields: []Field{{[]byte("a"), []byte("1")}, {[]byte("b"), []byte("2")}}}
+	assert.Must(equal(join(e.Commands(1)), []string{"DEL k", "ZADD k 1 a", "ZADD k 2 b"}))
+
+	e = &Entry{Key: []byte("k"), Kind: KindHash, Fields: []Field{{[]byte("f"), []byte("v")}}}
+	assert.Must(equal(join(e.Commands(10)), []string{"DEL k", "HMSET k f v"}))
+}
+
+func TestAOFReader(t *testing.T) {
+	cmd := "*3\r\n$3\r\nSET\r\n$1\r\na\r\n$2\r\nbc\r\n"
+	data := []byte(cmd + cmd + "*2\r\n$3\r\nDEL\r\n$1")
+
+	a := NewAOFReader(bytes.NewReader(data))
+	for i := 0; i < 2; i++ {
+		args, err := a.Next()
+		assert.MustNoError(err)
+		assert.Must(len(args) == 3 && string(args[0]) == "SET" && string(args[2]) == "bc")
+		assert.Must(a.Offset() == int64(len(cmd)*(i+1)))
+	}
+	_, err := a.Next()
+	assert.Must(errors.Cause(err) == io.ErrUnexpectedEOF)
+
+	off := int64(len(cmd))
+	a = NewAOFReaderAt(bytes.NewReader(data[off:len(cmd)*2]), off)
+	args, err := a.Next()
+	assert.MustNoError(err)
+	assert.Must(len(args) == 3 && a.Offset() == int64(len(cmd)*2))
+	_, err = a.Next()
+	assert.Must(err == io.EOF)
+
+	a = NewAOFReader(bytes.NewReader([]byte("+OK\r\n")))
+	_, err = a.Next()
+	assert.Must(err != nil && err != io.EOF)
+}
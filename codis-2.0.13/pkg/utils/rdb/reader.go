@@ -0,0 +1,69 @@
+// Copyright 2016 CodisLabs. All Rights Reserved.
+// Licensed under the MIT (MIT-LICENSE.txt) license.
+
+package rdb
+
+import (
+	"bufio"
+	"io"
+
+	"github.com/CodisLabs/codis/pkg/utils/errors"
+)
+
+// 单个字符串最大 512MB，和 redis 的限制相同，避免损坏的文件申请过多内存
+const maxStringLen = 512 * 1024 * 1024
+
+// 记录已经读取的字节数，断点续传时从这个位置继续读取
+type reader struct {
+	br     *bufio.Reader
+	offset int64
+}
+
+func newReader(r io.Reader, offset int64) *reader {
+	return &reader{br: bufio.NewReaderSize(r, 1024*64), offset: offset}
+}
+
+func (r *reader) readByte() (byte, error) {
+	b, err := r.br.ReadByte()
+	if err != nil {
+		return 0, err
+	}
+	r.offset++
+	return b, nil
+}
+
+func (r *reader) readFull(n int) ([]byte, error) {
+	if n < 0 || n > maxStringLen {
+		return nil, errors.Errorf("invalid length %d at offset %d", n, r.offset)
+	}
+	b := make([]byte, n)
+	if _, err := io.ReadFull(r.br, b); err != nil {
+		return nil, unexpectedEOF(err)
+	}
+	r.offset += int64(n)
+	return b, nil
+}
+
+// 读取以 \r\n 结尾的一行，返回的内容不包括 \r\n
+func (r *reader) readLine() ([]byte, error) {
+	b, err := r.br.ReadBytes('\n')
+	if err != nil {
+		if len(b) != 0 {
+			return nil, unexpectedEOF(err)
+		}
+		return nil, err
+	}
+	r.offset += int64(len(b))
+	if len(b) < 2 || b[len(b)-2] != '\r' {
+		return nil, errors.Errorf("bad line end at offset %d", r.offset)
+	}
+	return b[:len(b)-2], nil
+}
+
+// 读到一半时遇到文件末尾，说明文件被截断了
+func unexpectedEOF(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
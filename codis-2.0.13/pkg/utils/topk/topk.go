@@ -0,0 +1,146 @@
+// Copyright 2016 CodisLabs. All Rights Reserved.
+// Licensed under the MIT (MIT-LICENSE.txt) license.
+
+package topk
+
+import (
+	"hash/fnv"
+	"sort"
+)
+
+// 出现次数最多的key
+type Item struct {
+	Key   string `json:"key"`
+	Count uint64 `json:"count"`
+}
+
+// Count-Min Sketch，用固定大小的内存估计每个key的出现次数，估计值只会偏大
+// 不是并发安全的
+type Sketch struct {
+	width  uint64
+	counts [][]uint64
+}
+
+func NewSketch(width, depth int) *Sketch {
+	s := &Sketch{width: uint64(width), counts: make([][]uint64, depth)}
+	for i := range s.counts {
+		s.counts[i] = make([]uint64, width)
+	}
+	return s
+}
+
+// 每一行的位置由两个hash值组合得到
+func hash(key []byte) (uint64, uint64) {
+	h := fnv.New64a()
+	h.Write(key)
+	v := h.Sum64()
+	return v, v>>32 | 1
+}
+
+// 增加key的次数，返回增加之后的估计值
+func (s *Sketch) Add(key []byte, n uint64) uint64 {
+	h1, h2 := hash(key)
+	var min uint64
+	for i, row := range s.counts {
+		j := (h1 + uint64(i)*h2) % s.width
+		row[j] += n
+		if i == 0 || row[j] < min {
+			min = row[j]
+		}
+	}
+	return min
+}
+
+// 估计key的出现次数
+func (s *Sketch) Count(key []byte) uint64 {
+	h1, h2 := hash(key)
+	var min uint64
+	for i, row := range s.counts {
+		j := (h1 + uint64(i)*h2) % s.width
+		if i == 0 || row[j] < min {
+			min = row[j]
+		}
+	}
+	return min
+}
+
+// 所有计数减半，让旧的访问逐渐失去影响
+func (s *Sketch) Decay() {
+	for _, row := range s.counts {
+		for j := range row {
+			row[j] >>= 1
+		}
+	}
+}
+
+// 保存估计次数最多的k个key，不是并发安全的
+type TopK struct {
+	k     int
+	items map[string]uint64
+}
+
+func NewTopK(k int) *TopK {
+	return &TopK{k: k, items: make(map[string]uint64, k+1)}
+}
+
+// 更新key的估计次数，超过k个时淘汰次数最少的key
+func (t *TopK) Update(key []byte, count uint64) {
+	if _, ok := t.items[string(key)]; ok || len(t.items) < t.k {
+		t.items[string(key)] = count
+		return
+	}
+	var minKey string
+	var minCount uint64
+	var first = true
+	for k, c := range t.items {
+		if first || c < minCount {
+			minKey, minCount, first = k, c, false
+		}
+	}
+	if count > minCount {
+		delete(t.items, minKey)
+		t.items[string(key)] = count
+	}
+}
+
+func (t *TopK) Decay() {
+	for k, c := range t.items {
+		if c >>= 1; c == 0 {
+			delete(t.items, k)
+		} else {
+			t.items[k] = c
+		}
+	}
+}
+
+// 按次数从多到少返回所有key
+func (t *TopK) List() []Item {
+	var list = make([]Item, 0, len(t.items))
+	for k, c := range t.items {
+		list = append(list, Item{Key: k, Count: c})
+	}
+	SortItems(list)
+	return list
+}
+
+// 按次数从多到少排序，次数相同时按key排序
+func SortItems(list []Item) {
+	sort.Sort(byCount(list))
+}
+
+type byCount []Item
+
+func (b byCount) Len() int {
+	return len(b)
+}
+
+func (b byCount) Less(i, j int) bool {
+	if b[i].Count != b[j].Count {
+		return b[i].Count > b[j].Count
+	}
+	return b[i].Key < b[j].Key
+}
+
+func (b byCount) Swap(i, j int) {
+	b[i], b[j] = b[j], b[i]
+}
@@ -0,0 +1,53 @@
+// Copyright 2016 CodisLabs. All Rights Reserved.
+// Licensed under the MIT (MIT-LICENSE.txt) license.
+
+package topk
+
+import (
+	"strconv"
+	"testing"
+
+	"github.com/CodisLabs/codis/pkg/utils/assert"
+)
+
+func TestSketch(t *testing.T) {
+	s := NewSketch(1024, 4)
+	for i := 0; i < 1000; i++ {
+		s.Add([]byte("key"+strconv.Itoa(i)), 1)
+	}
+	assert.Must(s.Add([]byte("hot"), 500) >= 500)
+	assert.Must(s.Count([]byte("hot")) >= 500 && s.Count([]byte("hot")) < 520)
+	for i := 0; i < 1000; i++ {
+		assert.Must(s.Count([]byte("key"+strconv.Itoa(i))) >= 1)
+	}
+	s.Decay()
+	assert.Must(s.Count([]byte("hot")) >= 250 && s.Count([]byte("hot")) < 260)
+}
+
+func TestTopK(t *testing.T) {
+	s := NewSketch(1024, 4)
+	k := NewTopK(3)
+	add := func(key string, n int) {
+		for i := 0; i < n; i++ {
+			k.Update([]byte(key), s.Add([]byte(key), 1))
+		}
+	}
+	add("a", 10)
+	add("b", 20)
+	add("c", 5)
+	add("d", 1)
+	add("e", 30)
+
+	list := k.List()
+	assert.Must(len(list) == 3)
+	assert.Must(list[0].Key == "e" && list[0].Count >= 30)
+	assert.Must(list[1].Key == "b" && list[2].Key == "a")
+
+	k.Decay()
+	list = k.List()
+	assert.Must(list[0].Count >= 15 && list[0].Count < 30)
+
+	items := []Item{{"x", 1}, {"z", 2}, {"y", 2}}
+	SortItems(items)
+	assert.Must(items[0].Key == "y" && items[1].Key == "z" && items[2].Key == "x")
+}